@@ -0,0 +1,276 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"subframe/structs/node"
+	"sync"
+	"time"
+)
+
+//Client sends requests to the StorageNodes and CoordinatorNodes of a network. It starts from a list of seed Nodes and
+//learns about further Nodes with Discover. A Client is safe for concurrent use, and reuses connections to the same Node
+type Client struct {
+	//Token is sent as Bearer token, if Nodes are configured with an auth-token
+	Token string
+	//Scheme is used for addresses without a scheme, "http" by default. Nodes serving TLS require "https"
+	Scheme string
+	//Attempts is the number of times a request to one Node is sent before trying the next Node
+	Attempts int
+	//RetryDelay is the delay before the first retry of a request, doubled for every further retry
+	RetryDelay time.Duration
+	//HTTPClient sends the requests. It is shared by all requests, so connections are reused
+	HTTPClient *http.Client
+
+	mutex            sync.RWMutex
+	storageNodes     []string
+	coordinatorNodes []string
+}
+
+//New returns a Client starting from the StorageNodes at seeds. Seeds are addresses of the form host:port,
+//optionally prefixed with a scheme like https://
+func New(seeds ...string) (*Client, error) {
+	if len(seeds) == 0 {
+		return nil, errors.New("no seed nodes given")
+	}
+	c := &Client{
+		Scheme:     "http",
+		Attempts:   3,
+		RetryDelay: 200 * time.Millisecond,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, seed := range seeds {
+		address, err := node.NormalizeAddress(seed)
+		if err != nil {
+			return nil, errors.New("invalid seed node " + seed + ": " + err.Error())
+		}
+		c.storageNodes = appendAddress(c.storageNodes, address)
+	}
+	return c, nil
+}
+
+//Error is returned for requests a Node rejected, with the error envelope it responded with
+type Error struct {
+	//Status is the HTTP status code of the response
+	Status int
+	//Code is the machine-readable error code, like NOT_FOUND. It is empty if the Node sent no error envelope
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return "request failed with status " + strconv.Itoa(e.Status) + ": " + e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+//Error codes of the error envelope, as listed in PROTOCOL.md
+const (
+	ErrorNotFound           = "NOT_FOUND"
+	ErrorConflict           = "CONFLICT"
+	ErrorPreconditionFailed = "PRECONDITION_FAILED"
+	ErrorChecksumMismatch   = "CHECKSUM_MISMATCH"
+	ErrorExpired            = "EXPIRED"
+)
+
+//IsNotFound returns whether err reports a message which is not stored, or expired
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && (e.Code == ErrorNotFound || e.Code == ErrorExpired)
+}
+
+//IsConflict returns whether err reports a message ID which is already stored
+func IsConflict(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && (e.Code == ErrorConflict || e.Code == ErrorPreconditionFailed)
+}
+
+type errorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+//responseError returns the Error for a non-2xx response with body
+func responseError(status int, body []byte) *Error {
+	var envelope errorResponse
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error.Code != "" {
+		return &Error{Status: status, Code: envelope.Error.Code, Message: envelope.Error.Message}
+	}
+	return &Error{Status: status, Message: strings.TrimSpace(string(body))}
+}
+
+//retryable returns whether a request failing with err may succeed if sent again, or sent to another Node
+func retryable(err error) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		//Connection errors
+		return true
+	}
+	return e.Status >= 500 || e.Status == http.StatusTooManyRequests
+}
+
+//StorageNodes returns the addresses of the StorageNodes the Client knows
+func (c *Client) StorageNodes() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return append([]string(nil), c.storageNodes...)
+}
+
+//CoordinatorNodes returns the addresses of the CoordinatorNodes the Client knows
+func (c *Client) CoordinatorNodes() []string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return append([]string(nil), c.coordinatorNodes...)
+}
+
+//Discover asks the known StorageNodes for the Nodes they know, and adds these to the Client.
+//It returns an error only if no StorageNode responded
+func (c *Client) Discover(ctx context.Context) error {
+	var lastErr error
+	responded := false
+	for _, address := range c.StorageNodes() {
+		storageNodes, err := c.exportNodes(ctx, address, "get-storage-nodes")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		coordinatorNodes, err := c.exportNodes(ctx, address, "get-coordinator-nodes")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		responded = true
+
+		c.mutex.Lock()
+		for _, storageNode := range storageNodes {
+			c.storageNodes = appendAddress(c.storageNodes, storageNode)
+		}
+		for _, coordinatorNode := range coordinatorNodes {
+			c.coordinatorNodes = appendAddress(c.coordinatorNodes, coordinatorNode)
+		}
+		c.mutex.Unlock()
+	}
+	if !responded {
+		return errors.New("no StorageNode exported its Nodes: " + lastErr.Error())
+	}
+	return nil
+}
+
+//exportNodes returns the addresses of the Nodes the StorageNode at address exports with action, except dead ones
+func (c *Client) exportNodes(ctx context.Context, address string, action string) (addresses []string, err error) {
+	body, err := c.send(ctx, address, http.MethodGet, "/storage/control/"+action, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []node.Node
+	if err := json.Unmarshal(body, &nodes); err != nil {
+		return nil, errors.New("invalid node list from " + address + ": " + err.Error())
+	}
+	for _, exported := range nodes {
+		normalized, err := node.NormalizeAddress(exported.Address)
+		if err != nil || exported.Liveness == node.LivenessDead {
+			continue
+		}
+		addresses = append(addresses, normalized)
+	}
+	return addresses, nil
+}
+
+//appendAddress appends address to addresses, unless it is listed already
+func appendAddress(addresses []string, address string) []string {
+	for _, known := range addresses {
+		if known == address {
+			return addresses
+		}
+	}
+	return append(addresses, address)
+}
+
+//shuffled returns addresses in random order, so requests are spread over all Nodes
+func shuffled(addresses []string) []string {
+	addresses = append([]string(nil), addresses...)
+	rand.Shuffle(len(addresses), func(i, j int) { addresses[i], addresses[j] = addresses[j], addresses[i] })
+	return addresses
+}
+
+//nodeURL returns the base URL of the Node at address
+func (c *Client) nodeURL(address string) string {
+	//Zones of IPv6 addresses have to be escaped in URLs
+	address = strings.Replace(address, "%", "%25", 1)
+	if strings.Contains(address, "://") {
+		return address
+	}
+	scheme := c.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return scheme + "://" + address
+}
+
+//send sends a request to the Node at address and returns the body of a 2xx response. Connection errors, 5xx and
+//429 responses are retried up to c.Attempts times with exponential backoff
+func (c *Client) send(ctx context.Context, address string, method string, path string, body []byte, header http.Header) (response []byte, err error) {
+	attempts := c.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := c.RetryDelay
+	for attempt := 1; ; attempt++ {
+		response, err = c.sendOnce(ctx, address, method, path, body, header)
+		if err == nil || !retryable(err) || attempt >= attempts {
+			return response, err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (c *Client) sendOnce(ctx context.Context, address string, method string, path string, body []byte, header http.Header) (response []byte, err error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.nodeURL(address)+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	response, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, responseError(resp.StatusCode, response)
+	}
+	return response, nil
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"subframe/structs/message"
+)
+
+//Put stores content as message with id on one of the known StorageNodes, which redistributes it to further
+//StorageNodes. Stored messages are never replaced, putting an ID again fails with an error IsConflict reports
+func (c *Client) Put(id string, content []byte) error {
+	return c.PutContext(context.Background(), id, content)
+}
+
+//PutContext is Put, aborted once ctx is done
+func (c *Client) PutContext(ctx context.Context, id string, content []byte) error {
+	if len(content) == 0 {
+		return errors.New("empty message")
+	}
+	var err error = errors.New("no StorageNodes known")
+	for _, address := range shuffled(c.StorageNodes()) {
+		_, err = c.send(ctx, address, http.MethodPost, "/storage/put/"+url.PathEscape(id), content, nil)
+		//Nodes which are full or draining reject the put with a 5xx status, so another Node is tried
+		if err == nil || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+//Get returns the message with id from one of its locations. The checksum recorded on put is verified, and other
+//locations are tried if a copy is corrupt. Missing messages fail with an error IsNotFound reports
+func (c *Client) Get(id string) (msg message.Message, err error) {
+	return c.GetContext(context.Background(), id)
+}
+
+//GetContext is Get, aborted once ctx is done
+func (c *Client) GetContext(ctx context.Context, id string) (msg message.Message, err error) {
+	err = &Error{Status: http.StatusNotFound, Code: ErrorNotFound, Message: "Message " + id + " not found"}
+	for _, address := range c.candidates(ctx, id) {
+		body, sendErr := c.send(ctx, address, http.MethodGet, "/storage/get/"+url.PathEscape(id), nil, nil)
+		if sendErr == nil {
+			msg = message.Message{}
+			if decodeErr := json.Unmarshal(body, &msg); decodeErr != nil {
+				sendErr = errors.New("invalid message from " + address + ": " + decodeErr.Error())
+			} else if !checksumValid(msg) {
+				sendErr = &Error{Status: http.StatusInternalServerError, Code: ErrorChecksumMismatch, Message: "Checksum of message " + id + " from " + address + " does not match"}
+			} else {
+				return msg, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return message.Message{}, sendErr
+		}
+		//A NOT_FOUND of one location must not hide the error of another
+		if !IsNotFound(sendErr) || IsNotFound(err) {
+			err = sendErr
+		}
+	}
+	return message.Message{}, err
+}
+
+//checksumValid returns whether msg matches its checksum. Messages stored without checksum are not verified.
+//Checksums of other functions than SHA-256 are prefixed with their name, e.g. sha512-<hex>
+func checksumValid(msg message.Message) bool {
+	switch {
+	case msg.Checksum == "":
+		return true
+	case strings.HasPrefix(msg.Checksum, "sha512-"):
+		sum := sha512.Sum512([]byte(msg.Content))
+		return "sha512-"+hex.EncodeToString(sum[:]) == msg.Checksum
+	}
+	sum := sha256.Sum256([]byte(msg.Content))
+	return hex.EncodeToString(sum[:]) == msg.Checksum
+}
+
+//Delete removes the message with id from all its locations. Missing messages fail with an error IsNotFound reports
+func (c *Client) Delete(id string) error {
+	return c.DeleteContext(context.Background(), id)
+}
+
+//DeleteContext is Delete, aborted once ctx is done
+func (c *Client) DeleteContext(ctx context.Context, id string) error {
+	var err error = &Error{Status: http.StatusNotFound, Code: ErrorNotFound, Message: "Message " + id + " not found"}
+	deleted := false
+	for _, address := range c.candidates(ctx, id) {
+		_, sendErr := c.send(ctx, address, http.MethodDelete, "/storage/delete/"+url.PathEscape(id), nil, nil)
+		if sendErr == nil {
+			deleted = true
+			continue
+		}
+		if ctx.Err() != nil {
+			return sendErr
+		}
+		if !IsNotFound(sendErr) {
+			err = sendErr
+		}
+	}
+	if deleted && IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+//Locate returns the addresses of the StorageNodes holding the message with id, as known to the CoordinatorNetwork.
+//It requires a known CoordinatorNode, see Discover
+func (c *Client) Locate(id string) (addresses []string, err error) {
+	return c.LocateContext(context.Background(), id)
+}
+
+//LocateContext is Locate, aborted once ctx is done
+func (c *Client) LocateContext(ctx context.Context, id string) (addresses []string, err error) {
+	err = errors.New("no CoordinatorNodes known")
+	for _, address := range shuffled(c.CoordinatorNodes()) {
+		var body []byte
+		body, err = c.send(ctx, address, http.MethodGet, "/coordinator/control/locate/"+url.PathEscape(id), nil, nil)
+		if err == nil {
+			if err = json.Unmarshal(body, &addresses); err == nil {
+				return addresses, nil
+			}
+		}
+		if ctx.Err() != nil || !retryable(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+//candidates returns the StorageNodes to request the message with id from: its locations, or all known
+//StorageNodes if it cannot be located
+func (c *Client) candidates(ctx context.Context, id string) []string {
+	if locations, err := c.LocateContext(ctx, id); err == nil && len(locations) > 0 {
+		return shuffled(locations)
+	}
+	return shuffled(c.StorageNodes())
+}
@@ -1,7 +1,9 @@
 package bootstrapper
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"strconv"
 	"subframe/server/database"
 	"subframe/server/jobqueue"
@@ -14,17 +16,19 @@ import (
 
 var log = logger.Logger{Prefix: "bootstrapper/Main"}
 
-//Bootstrap bootstraps the local node, if settings.BootstrapNode is set
+//Bootstrap bootstraps the local node, if settings.BootstrapNode is set. Otherwise, a local node which does not know
+//any Nodes yet joins the network through settings.SeedNodes
 func Bootstrap() {
-	bootstrapNode := settings.BootstrapNode
+	bootstrapNode := settings.Current().BootstrapNode
 	if bootstrapNode == "" {
 		log.Info(OK, "No BootstrapNode set. Skipping Bootstrapping.")
+		joinSeedNodes()
 		return
 	}
 
-	log.Info(InProgress, "Bootstrapping with Node "+settings.BootstrapNode+"...")
+	log.Info(InProgress, "Bootstrapping with Node "+settings.Current().BootstrapNode+"...")
 
-	if database.ClearNodeTables() != 200 {
+	if database.ClearNodeTables() != OK {
 		log.Fatal(DBWriteError, "Could not clear databases before bootstrapping.")
 	}
 	pullStorageNodes()
@@ -33,74 +37,93 @@ func Bootstrap() {
 
 func pullStorageNodes() {
 	log.Info(InProgress, "Pulling StorageNodes...")
-	status, response := networking.SendNodeRequest(networking.NODE_STORAGE, settings.BootstrapNode, "/control/get-storage-nodes", "")
-	var storageNodes []node.Node
-	err := json.Unmarshal([]byte(response), &storageNodes)
-
+	status, storageNodes := fetchNodes(context.Background(), settings.Current().BootstrapNode, "get-storage-nodes")
 	if status != OK {
 		log.Fatal(status, "Error getting StorageNodes")
 	}
 
-	if err != nil {
-		log.Fatal(GenericInternalError, "Error getting StorageNodes: "+err.Error())
-	}
-
-	task := func(data interface{}) {
-		log := logger.Logger{Prefix: "bootstrapper/DatabaseThread-StorageNodes"}
-		storageNodes, ok := data.([]node.Node)
-		if !ok {
-			log.Error(GenericInternalError, "Failed to add StorageNodes to Database")
-			return
-		}
-		for _, node := range storageNodes {
-			node.Ping = networking.Ping(node.Address)
-			database.AddStorageNode(node)
-			log.Info(OK, "Added StorageNode "+node.Address+" with Ping "+strconv.Itoa(node.Ping)+" to Database")
-		}
-	}
 	job := jobqueue.Job{
-		Task: task,
+		Task: addStorageNodes,
 		Data: storageNodes,
 	}
-	select {
-	case jobqueue.Queue <- job:
-	}
+	jobqueue.Enqueue(job)
 	log.Info(OK, "Pulled StorageNodes.")
 }
 
 func pullCoordinatorNodes() {
 	log.Info(InProgress, "Pulling CoordinatorNodes...")
-	status, response := networking.SendNodeRequest(networking.NODE_STORAGE, settings.BootstrapNode, "/control/get-coordinator-nodes", "")
-	var coordinatorNodes []node.Node
-	err := json.Unmarshal([]byte(response), &coordinatorNodes)
-
+	status, coordinatorNodes := fetchNodes(context.Background(), settings.Current().BootstrapNode, "get-coordinator-nodes")
 	if status != OK {
 		log.Fatal(status, "Error getting Coordinator Nodes")
 	}
 
-	if err != nil {
-		log.Fatal(GenericInternalError, "Error getting CoordinatorNodes: "+err.Error())
+	job := jobqueue.Job{
+		Task: addCoordinatorNodes,
+		Data: coordinatorNodes,
 	}
+	jobqueue.Enqueue(job)
+	log.Info(OK, "Pulled CoordinatorNodes.")
+}
 
-	task := func(data interface{}) {
-		log := logger.Logger{Prefix: "bootstrapper/DatabaseThread-CoordinatorNodes"}
-		coordinatorNodes, ok := data.([]node.Node)
-		if !ok {
-			log.Error(DBWriteError, "Failed to add CoordinatorNodes to Database")
-			return
-		}
-		for _, node := range coordinatorNodes {
-			node.Ping = networking.Ping(node.Address)
-			database.AddCoordinatorNode(node)
-			log.Info(OK, "Added CoordinatorNode "+node.Address+" with Ping "+strconv.Itoa(node.Ping)+" to Database")
+//fetchNodes returns the Nodes the StorageNode at address exports with the control action
+func fetchNodes(ctx context.Context, address string, action string) (status int, nodes []node.Node) {
+	status, response := networking.SendNodeRequestContext(ctx, networking.NODE_STORAGE, address, "/control/"+action, "")
+	if status != OK {
+		return status, nil
+	}
+	if err := json.Unmarshal(response, &nodes); err != nil {
+		log.Error(GenericInternalError, "Error reading Nodes exported by "+address+": "+err.Error())
+		return GenericInternalError, nil
+	}
+	return OK, nodes
+}
+
+//addStorageNodes adds the StorageNodes of data to the database
+func addStorageNodes(_ context.Context, data interface{}) error {
+	log := logger.Logger{Prefix: "bootstrapper/DatabaseThread-StorageNodes"}
+	storageNodes, ok := data.([]node.Node)
+	if !ok {
+		log.Error(GenericInternalError, "Failed to add StorageNodes to Database")
+		return errors.New("bootstrap job without StorageNodes")
+	}
+	for _, node := range storageNodes {
+		if !normalizeNodeAddress(&node) {
+			continue
 		}
+		node.Ping = networking.Ping(node.Address)
+		database.AddStorageNode(node)
+		log.Info(OK, "Added StorageNode "+node.Address+" with Ping "+strconv.Itoa(node.Ping)+" to Database")
 	}
-	job := jobqueue.Job{
-		Task: task,
-		Data: coordinatorNodes,
+	return nil
+}
+
+//addCoordinatorNodes adds the CoordinatorNodes of data to the database
+func addCoordinatorNodes(_ context.Context, data interface{}) error {
+	log := logger.Logger{Prefix: "bootstrapper/DatabaseThread-CoordinatorNodes"}
+	coordinatorNodes, ok := data.([]node.Node)
+	if !ok {
+		log.Error(DBWriteError, "Failed to add CoordinatorNodes to Database")
+		return errors.New("bootstrap job without CoordinatorNodes")
 	}
-	select {
-	case jobqueue.Queue <- job:
+	for _, node := range coordinatorNodes {
+		if !normalizeNodeAddress(&node) {
+			continue
+		}
+		node.Ping = networking.Ping(node.Address)
+		database.AddCoordinatorNode(node)
+		log.Info(OK, "Added CoordinatorNode "+node.Address+" with Ping "+strconv.Itoa(node.Ping)+" to Database")
 	}
-	log.Info(OK, "Pulled CoordinatorNodes.")
+	return nil
+}
+
+//normalizeNodeAddress normalizes the address of n as received from another Node, returning false if it is malformed
+//or the address of the local Node
+func normalizeNodeAddress(n *node.Node) bool {
+	address, err := node.NormalizeAddress(n.Address)
+	if err != nil {
+		log.Warn(GenericInputError, "Skipping Node with invalid address "+n.Address+": "+err.Error())
+		return false
+	}
+	n.Address = address
+	return address != settings.Current().RemoteAddress
 }
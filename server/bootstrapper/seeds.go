@@ -0,0 +1,70 @@
+package bootstrapper
+
+import (
+	"context"
+	"errors"
+	"subframe/server/database"
+	"subframe/server/jobqueue"
+	"subframe/server/settings"
+	. "subframe/status"
+	"subframe/structs/node"
+	"time"
+)
+
+//joinRetryPolicy retries joining while no SeedNode responds, e.g. if all Nodes of a new network start at once
+var joinRetryPolicy = &jobqueue.RetryPolicy{MaxAttempts: 10, Backoff: 30 * time.Second}
+
+//joinSeedNodes adds settings.SeedNodes to the database and queues joining the network through them, if the database
+//does not know any Nodes yet. Nodes which know the network already keep their Nodes
+func joinSeedNodes() {
+	seedNodes := settings.Current().SeedNodeAddresses()
+	if len(seedNodes) == 0 {
+		return
+	}
+	_, storageNodes := database.GetStorageNodes(1)
+	_, coordinatorNodes := database.GetCoordinatorNodes()
+	if len(storageNodes) > 0 || len(coordinatorNodes) > 0 {
+		log.Info(OK, "Database knows Nodes already. Skipping joining through SeedNodes.")
+		return
+	}
+
+	log.Info(InProgress, "Joining network through "+settings.Current().SeedNodes+"...")
+	//SeedNodes are StorageNodes, which every Node is
+	for _, address := range seedNodes {
+		if address != settings.Current().RemoteAddress {
+			database.AddStorageNode(node.Node{Address: address, LastPing: time.Now()})
+		}
+	}
+	jobqueue.Enqueue(jobqueue.Job{Task: join, Data: seedNodes, Retry: joinRetryPolicy})
+}
+
+//join adds the StorageNodes and CoordinatorNodes known to the first of the SeedNodes in data which responds
+func join(ctx context.Context, data interface{}) error {
+	seedNodes, ok := data.([]string)
+	if !ok {
+		log.Error(GenericInternalError, "Error starting Join Thread")
+		return errors.New("join job without SeedNodes")
+	}
+	log := log.WithContext(ctx)
+
+	for _, address := range seedNodes {
+		if address == settings.Current().RemoteAddress {
+			continue
+		}
+		status, storageNodes := fetchNodes(ctx, address, "get-storage-nodes")
+		if status != OK {
+			log.Warn(status, "Could not get StorageNodes from SeedNode "+address)
+			continue
+		}
+		status, coordinatorNodes := fetchNodes(ctx, address, "get-coordinator-nodes")
+		if status != OK {
+			log.Warn(status, "Could not get CoordinatorNodes from SeedNode "+address)
+			continue
+		}
+		addStorageNodes(ctx, storageNodes)
+		addCoordinatorNodes(ctx, coordinatorNodes)
+		log.Info(OK, "Joined network through SeedNode "+address+".")
+		return nil
+	}
+	return errors.New("no SeedNode responded")
+}
@@ -0,0 +1,49 @@
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+//Version and Commit are injected at build time, e.g. with
+//go build -ldflags "-X subframe/server/buildinfo.Version=1.2.0 -X subframe/server/buildinfo.Commit=$(git rev-parse HEAD)".
+//Builds without them fall back to the information the Go toolchain embeds, see Get
+var (
+	Version = ""
+	Commit  = ""
+)
+
+//Info describes the build of the running Node
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"goVersion"`
+}
+
+//Get returns the Info of the running binary. Values not injected with -ldflags are taken from the module version and
+//VCS revision the Go toolchain embeds, or reported as unknown
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, GoVersion: runtime.Version()}
+	if embedded, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && embedded.Main.Version != "" && embedded.Main.Version != "(devel)" {
+			info.Version = embedded.Main.Version
+		}
+		for _, setting := range embedded.Settings {
+			if info.Commit == "" && setting.Key == "vcs.revision" {
+				info.Commit = setting.Value
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "unknown"
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	return info
+}
+
+//String returns the Info as logged on startup
+func (i Info) String() string {
+	return i.Version + " (commit " + i.Commit + ", " + i.GoVersion + ")"
+}
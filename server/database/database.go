@@ -7,6 +7,7 @@ import (
 	"subframe/server/settings"
 	. "subframe/status"
 	"subframe/structs/node"
+	"sync/atomic"
 	"time"
 
 	//Importing SQLite Driver
@@ -21,17 +22,14 @@ var log = logger.Logger{Prefix: "database/Main"}
 func Init() {
 	//Initialize and / or open sqlite databases
 	log.Info(InProgress, "Opening Database Files...")
-	storageDBPath := settings.DataPath + "/databases/storage.db"
-	coordinatorDBPath := settings.DataPath + "/databases/coordinator.db"
-
 	var err error
-	storageDB, err = sql.Open("sqlite3", storageDBPath)
+	storageDB, err = sql.Open("sqlite3", databaseSource("storage"))
 	if err != nil {
 		log.Fatal(DBOpenError, "Error opening StorageDatabase: "+err.Error())
 		return
 	}
 
-	coordinatorDB, err = sql.Open("sqlite3", coordinatorDBPath)
+	coordinatorDB, err = sql.Open("sqlite3", databaseSource("coordinator"))
 	if err != nil {
 		log.Fatal(DBOpenError, "Error opening CoordinatorDatabase: "+err.Error())
 		return
@@ -48,6 +46,10 @@ func Init() {
 		expiresOn timestamp not null, 
 		lastCheck timestamp
 	);
+	CREATE TABLE IF NOT EXISTS deletedMessages(
+		id varchar(255) not null primary key,
+		deletedOn timestamp not null
+	);
 	`
 	_, err = storageDB.Exec(statement)
 	if err != nil {
@@ -55,6 +57,13 @@ func Init() {
 		return
 	}
 
+	//Tables created before messages were versioned lack the column
+	err = addColumnIfNotExists(storageDB, "messages", "version", "integer not null default 0")
+	if err != nil {
+		log.Fatal(DBStructureError, "Failed to add version to messages: "+err.Error())
+		return
+	}
+
 	log.Info(OK, "Created Tables for StorageDatabase.")
 
 	//Create Tables for coordinatorDatabase
@@ -63,18 +72,23 @@ func Init() {
 	CREATE TABLE IF NOT EXISTS storageNodes(
 		address varchar(255) not null primary key, 
 		lastPing timestamp not null,
-		ping int not null
+		ping int not null,
+		liveness tinyint not null default 0,
+		freeBytes integer not null default -1,
+		loadScore real not null default 0
 	);
 	CREATE TABLE IF NOT EXISTS coordinatorNodes(
 		address varchar(255) not null primary key, 
 		lastPing timestamp not null,
-		ping int not null           
+		ping int not null,
+		liveness tinyint not null default 0
 	);
 	CREATE TABLE IF NOT EXISTS messages(
 		id varchar(255) not null, 
 		storageNode varchar(255) not null, 
 		reportedOn timestamp not null, 
-		verified tinyint not null default 0
+		verified tinyint not null default 0,
+		replicationFactor int not null default 0
 	);
 	`
 	_, err = coordinatorDB.Exec(statement)
@@ -83,10 +97,93 @@ func Init() {
 		return
 	}
 
+	//Each StorageNode is recorded once per message, older databases may contain duplicate announcements
+	statement = `
+	DELETE FROM messages WHERE rowid NOT IN (SELECT MIN(rowid) FROM messages GROUP BY id, storageNode);
+	CREATE UNIQUE INDEX IF NOT EXISTS messageLocations ON messages(id, storageNode);
+	`
+	_, err = coordinatorDB.Exec(statement)
+	if err != nil {
+		log.Fatal(DBStructureError, "Failed to create message location index: "+err.Error())
+		return
+	}
+
+	//Tables created before liveness tracking lack the column
+	for _, table := range nodeTables {
+		err = addColumnIfNotExists(coordinatorDB, table, "liveness", "tinyint not null default 0")
+		if err != nil {
+			log.Fatal(DBStructureError, "Failed to add liveness to "+table+": "+err.Error())
+			return
+		}
+	}
+	err = addColumnIfNotExists(coordinatorDB, "storageNodes", "freeBytes", "integer not null default "+strconv.Itoa(unknownCapacity))
+	if err != nil {
+		log.Fatal(DBStructureError, "Failed to add freeBytes to storageNodes: "+err.Error())
+		return
+	}
+	err = addColumnIfNotExists(coordinatorDB, "storageNodes", "loadScore", "real not null default 0")
+	if err != nil {
+		log.Fatal(DBStructureError, "Failed to add loadScore to storageNodes: "+err.Error())
+		return
+	}
+	//Tables created before replication factors were recorded lack the column, their messages use the default factor
+	err = addColumnIfNotExists(coordinatorDB, "messages", "replicationFactor", "int not null default 0")
+	if err != nil {
+		log.Fatal(DBStructureError, "Failed to add replicationFactor to messages: "+err.Error())
+		return
+	}
+	if status := checkPlacementHash(); status != OK {
+		return
+	}
+
 	log.Info(OK, "Created Tables for CoordinatorDatabase.")
 	log.Info(OK, "Initialized database connections.")
 }
 
+//busyTimeout is the time in milliseconds a write waits for concurrent writes to the same database, instead of failing
+const busyTimeout = 5000
+
+//memoryDatabases counts the in-memory databases opened, so each Init opens new ones
+var memoryDatabases int64
+
+//databaseSource returns the data source name of the sqlite database name. The write-ahead log keeps reads from
+//waiting for writes, so concurrent requests only serialize their writes. The memory backend keeps the database in
+//memory shared by all connections of the pool, until the last one is closed
+func databaseSource(name string) string {
+	if settings.Current().DatabaseBackend == "memory" {
+		id := strconv.FormatInt(atomic.AddInt64(&memoryDatabases, 1), 10)
+		return "file:/" + name + "-" + id + ".db?vfs=memdb&_busy_timeout=" + strconv.Itoa(busyTimeout)
+	}
+	return "file:" + settings.Current().DataPath + "/databases/" + name + ".db?_journal_mode=WAL&_busy_timeout=" + strconv.Itoa(busyTimeout)
+}
+
+//addColumnIfNotExists adds column to table, unless the table already has it
+func addColumnIfNotExists(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, primaryKey int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
 //Close closes all Database connections
 func Close() {
 	log.Info(InProgress, "Closing Database connections...")
@@ -95,22 +192,29 @@ func Close() {
 	log.Info(OK, "Closed database connections.")
 }
 
-//LogMessageStorage logs to the StorageNode Database that a message has been received and stored locally
-func LogMessageStorage(id string) (status int) {
+//LogMessageStorage logs to the StorageNode Database that a message has been received and stored locally with version
+func LogMessageStorage(id string, version int64) (status int) {
 	log.Info(InProgress, "Logging new Message "+id+"...")
 	if _, c := CheckMessageStorage(id); c == true {
 		log.Error(SNDBIdConflict, "Message "+id+" already present in Database.")
 		return SNDBIdConflict
 	}
 
-	query := "INSERT INTO messages(id, expiresOn) VALUES (?, date('now', '+' || ? || ' days'))"
+	query := "INSERT INTO messages(id, expiresOn, version) VALUES (?, date('now', '+' || ? || ' days'), ?)"
 	stmt, err := storageDB.Prepare(query)
 	if err != nil {
 		log.Error(SNDBPrepareError, "Error logging Message "+id+" to Database: "+err.Error())
 		return SNDBPrepareError
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(id, settings.MessageMaxStoreTime)
+	_, err = stmt.Exec(id, settings.Current().MessageMaxStoreTime, version)
+	if err != nil {
+		log.Error(SNDBWriteError, "Error logging Message "+id+" to Database: "+err.Error())
+		return SNDBWriteError
+	}
+
+	//A message stored again is no longer considered deleted
+	_, err = storageDB.Exec("DELETE FROM deletedMessages WHERE id=?", id)
 	if err != nil {
 		log.Error(SNDBWriteError, "Error logging Message "+id+" to Database: "+err.Error())
 		return SNDBWriteError
@@ -141,6 +245,126 @@ func CheckMessageStorage(id string) (status int, hasMessage bool) {
 	return OK, true
 }
 
+//LogMessageDeletion removes a message from the StorageNode Database and remembers its deletion
+func LogMessageDeletion(id string) (status int) {
+	log.Info(InProgress, "Logging deletion of Message "+id+"...")
+	tx, err := storageDB.Begin()
+	if err != nil {
+		log.Error(SNDBPrepareError, "Error logging deletion of Message "+id+" to Database: "+err.Error())
+		return SNDBPrepareError
+	}
+
+	_, err = tx.Exec("DELETE FROM messages WHERE id=?", id)
+	if err == nil {
+		_, err = tx.Exec("INSERT OR REPLACE INTO deletedMessages(id, deletedOn) VALUES (?, datetime('now'))", id)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Error(SNDBWriteError, "Error logging deletion of Message "+id+" to Database: "+err.Error())
+		return SNDBWriteError
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Error(SNDBWriteError, "Error logging deletion of Message "+id+" to Database: "+err.Error())
+		return SNDBWriteError
+	}
+	log.Info(OK, "Successfully logged deletion of Message "+id+" to Database.")
+	return OK
+}
+
+//ForgetMessage removes a message from the StorageNode Database without remembering its deletion,
+//so the message can be stored on this Node again
+func ForgetMessage(id string) (status int) {
+	log.Info(InProgress, "Removing Message "+id+" from Database...")
+	_, err := storageDB.Exec("DELETE FROM messages WHERE id=?", id)
+	if err != nil {
+		log.Error(SNDBWriteError, "Error removing Message "+id+" from Database: "+err.Error())
+		return SNDBWriteError
+	}
+	log.Info(OK, "Removed Message "+id+" from Database.")
+	return OK
+}
+
+//UpdateMessageVersion records the version of a locally stored message which was replaced by a newer copy
+func UpdateMessageVersion(id string, version int64) (status int) {
+	log.Info(InProgress, "Logging version "+strconv.FormatInt(version, 10)+" of Message "+id+"...")
+	_, err := storageDB.Exec("UPDATE messages SET version=? WHERE id=?", version, id)
+	if err != nil {
+		log.Error(SNDBWriteError, "Error logging version of Message "+id+": "+err.Error())
+		return SNDBWriteError
+	}
+	return OK
+}
+
+//GetMessageVersions returns the versions of all locally stored messages by ID. Messages stored before they were
+//versioned have version 0 and are omitted
+func GetMessageVersions() (status int, versions map[string]int64) {
+	rows, err := storageDB.Query("SELECT id, version FROM messages WHERE version != 0")
+	if err != nil {
+		log.Error(SNDBReadError, "Error getting versions of Messages: "+err.Error())
+		return SNDBReadError, nil
+	}
+	defer rows.Close()
+
+	versions = make(map[string]int64)
+	for rows.Next() {
+		var id string
+		var version int64
+		if err := rows.Scan(&id, &version); err != nil {
+			log.Error(SNDBReadError, "Error getting versions of Messages: "+err.Error())
+			return SNDBReadError, nil
+		}
+		versions[id] = version
+	}
+	if err := rows.Err(); err != nil {
+		log.Error(SNDBReadError, "Error getting versions of Messages: "+err.Error())
+		return SNDBReadError, nil
+	}
+	return OK, versions
+}
+
+//CheckMessageDeletion checks whether a message has previously been deleted from this node
+func CheckMessageDeletion(id string) (status int, isDeleted bool) {
+	log.Info(InProgress, "Checking whether Message "+id+" has been deleted...")
+	query := "SELECT id FROM deletedMessages WHERE id=?"
+	stmt, err := storageDB.Prepare(query)
+	if err != nil {
+		log.Error(SNDBReadError, "Error: "+err.Error())
+		return SNDBReadError, false
+	}
+	defer stmt.Close()
+
+	var res string
+	err = stmt.QueryRow(id).Scan(&res)
+	if err != nil {
+		log.Info(OK, "Message "+id+" does not appear to have been deleted.")
+		return OK, false
+	}
+
+	log.Info(OK, "Message "+id+" has been deleted.")
+	return OK, true
+}
+
+//GetExpiredMessages returns the IDs of all messages stored longer than settings.MessageMaxStoreTime
+func GetExpiredMessages() (status int, ids []string) {
+	log.Info(InProgress, "Getting expired Messages...")
+	rows, err := storageDB.Query("SELECT id FROM messages WHERE expiresOn < date('now')")
+	if err != nil {
+		log.Error(SNDBReadError, "Error getting expired Messages: "+err.Error())
+		return SNDBReadError, nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	log.Info(OK, "Found "+strconv.Itoa(len(ids))+" expired Messages.")
+	return OK, ids
+}
+
 //CheckMessageStatusStorage checks the status of a locally stored message against the Coordinator Network and handles it respectively
 func CheckMessageStatusStorage(id string) {
 	//TODO: Check status of message against coordinator network, then delete or keep message and log time of last check
@@ -152,10 +376,10 @@ func CheckDueMessageStatusStorage() {
 	//remove all messages which have been received before now - settings.MessageMaxStoreTime
 }
 
-//AddStorageNode adds a StorageNode to the local database
+//AddStorageNode adds a StorageNode to the local database. Known StorageNodes are kept as they are
 func AddStorageNode(n node.Node) (status int) {
 	log.Info(InProgress, "Adding StorageNode "+n.Address+" to database...")
-	query := "INSERT INTO storageNodes(address, lastPing, ping) VALUES (?,?)"
+	query := "INSERT OR IGNORE INTO storageNodes(address, lastPing, ping) VALUES (?,?,?)"
 	stmt, err := coordinatorDB.Prepare(query)
 	if err != nil {
 		log.Error(CNDBPrepareError, "Error adding StorageNode "+n.Address+" to database: "+err.Error())
@@ -171,11 +395,15 @@ func AddStorageNode(n node.Node) (status int) {
 	return OK
 }
 
-//GetStorageNodes returns known StorageNodes
+//GetStorageNodes returns known StorageNodes, overloaded ones after the others of the same liveness.
+//With weighted settings.StorageNodeSelection, up to limit of them are selected by their free storage and load
 func GetStorageNodes(limit int) (status int, storageNodes []node.Node) {
 	log.Info(InProgress, "Exporting "+strconv.Itoa(limit)+" StorageNodes...")
 	var nodes []node.Node
-	query := "SELECT address, lastPing FROM storageNodes LIMIT " + strconv.Itoa(limit)
+	query := "SELECT address, lastPing, liveness FROM storageNodes ORDER BY " + livenessOrder + ", " + loadOrder
+	if !weighted() {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
 	rows, err := coordinatorDB.Query(query)
 	if err != nil {
 		log.Error(CNDBReadError, "Error exporting StorageNodes: "+err.Error())
@@ -184,30 +412,67 @@ func GetStorageNodes(limit int) (status int, storageNodes []node.Node) {
 	defer rows.Close()
 	for rows.Next() {
 		var address string
-		var lastPing int64
-		err = rows.Scan(&address, &lastPing)
+		var lastPing time.Time
+		var liveness int
+		err = rows.Scan(&address, &lastPing, &liveness)
 		if err != nil {
 			continue
 		}
 		nodes = append(nodes, node.Node{
-			Address: address, LastPing: time.Unix(lastPing, 0),
+			Address: address, LastPing: lastPing, Liveness: liveness,
 		})
 	}
+	if weighted() {
+		if status, capacities, loads := nodeCapacities(); status == OK {
+			nodes = selectWeighted(nodes, capacities, loads, limit)
+		} else if len(nodes) > limit {
+			nodes = nodes[:limit]
+		}
+	}
 	log.Info(OK, "Returning "+strconv.Itoa(len(nodes))+" StorageNodes.")
 	return OK, nodes
 }
 
-//AddCoordinatorNode adds a CoordinatorNode to the local database
+//GetRandomStorageNodes returns max <number> distinct random StorageNodes, never including the local node itself.
+//Overloaded StorageNodes are only returned if not enough others are known. With weighted settings.StorageNodeSelection,
+//StorageNodes with more free storage and less load are more likely to be returned
+func GetRandomStorageNodes(max int) (status int, nodes []node.Node) {
+	log.Info(InProgress, "Getting "+strconv.Itoa(max)+" random StorageNodes...")
+	//Addresses are the primary key of storageNodes, so every row is a distinct node
+	query := "SELECT address, lastPing, ping, liveness FROM storageNodes WHERE address NOT IN (?, ?) ORDER BY " + livenessOrder + ", " + loadOrder + ", RANDOM() LIMIT ?"
+	limit := max
+	if weighted() {
+		limit = -1
+	}
+	rows, err := coordinatorDB.Query(query, settings.Current().RemoteAddress, settings.Current().LocalAddress, limit)
+	if err != nil {
+		log.Error(CNDBReadError, "Error getting random StorageNodes: "+err.Error())
+		return CNDBReadError, nil
+	}
+	defer rows.Close()
+	nodes = scanNodes(rows)
+	if weighted() {
+		if status, capacities, loads := nodeCapacities(); status == OK {
+			nodes = selectWeighted(nodes, capacities, loads, max)
+		} else if len(nodes) > max {
+			nodes = nodes[:max]
+		}
+	}
+	log.Info(OK, "Returning "+strconv.Itoa(len(nodes))+" StorageNodes.")
+	return OK, nodes
+}
+
+//AddCoordinatorNode adds a CoordinatorNode to the local database. Known CoordinatorNodes are kept as they are
 func AddCoordinatorNode(n node.Node) (status int) {
 	log.Info(InProgress, "Adding CoordinatorNode "+n.Address+" to database...")
-	query := "INSERT INTO coordinatorNodes(address, lastPing, ping) VALUES (?,?)"
+	query := "INSERT OR IGNORE INTO coordinatorNodes(address, lastPing, ping) VALUES (?,?,?)"
 	stmt, err := coordinatorDB.Prepare(query)
 	if err != nil {
 		log.Error(CNDBPrepareError, "Error adding CoordinatorNode "+n.Address+" to database: "+err.Error())
 		return CNDBPrepareError
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(n.Address, n.LastPing, n.Ping)
+	_, err = stmt.Exec(n.Address, n.LastPing.Unix(), n.Ping)
 	if err != nil {
 		log.Error(CNDBWriteError, "Error adding CoordinatorNode "+n.Address+" to database: "+err.Error())
 		return CNDBWriteError
@@ -220,7 +485,7 @@ func AddCoordinatorNode(n node.Node) (status int) {
 func GetCoordinatorNodes() (status int, storageNodes []node.Node) {
 	log.Info(InProgress, "Exporting CoordinatorNodes...")
 	var nodes []node.Node
-	query := "SELECT address, lastPing FROM coordinatorNodes"
+	query := "SELECT address, lastPing, liveness FROM coordinatorNodes ORDER BY " + livenessOrder
 	rows, err := coordinatorDB.Query(query)
 	if err != nil {
 		log.Error(CNDBReadError, "Error exporting CoordinatorNodes: "+err.Error())
@@ -229,13 +494,14 @@ func GetCoordinatorNodes() (status int, storageNodes []node.Node) {
 	defer rows.Close()
 	for rows.Next() {
 		var address string
-		var lastPing int64
-		err = rows.Scan(&address, &lastPing)
+		var lastPing time.Time
+		var liveness int
+		err = rows.Scan(&address, &lastPing, &liveness)
 		if err != nil {
 			continue
 		}
 		nodes = append(nodes, node.Node{
-			Address: address, LastPing: time.Unix(lastPing, 0),
+			Address: address, LastPing: lastPing, Liveness: liveness,
 		})
 	}
 	log.Info(OK, "Returning "+strconv.Itoa(len(nodes))+" CoordinatorNodes.")
@@ -245,25 +511,278 @@ func GetCoordinatorNodes() (status int, storageNodes []node.Node) {
 //GetRandomCoordinatorNodes returns max <number> random CoordinatorNodes
 func GetRandomCoordinatorNodes(max int) (status int, nodes []node.Node) {
 	log.Info(InProgress, "Getting "+strconv.Itoa(max)+" random CoordinatorNodes...")
-	//TODO: Return random CoordinatorNodes
+	query := "SELECT address, lastPing, ping, liveness FROM coordinatorNodes ORDER BY " + livenessOrder + ", RANDOM() LIMIT ?"
+	rows, err := coordinatorDB.Query(query, max)
+	if err != nil {
+		log.Error(CNDBReadError, "Error getting random CoordinatorNodes: "+err.Error())
+		return CNDBReadError, nil
+	}
+	defer rows.Close()
+	nodes = scanNodes(rows)
+	log.Info(OK, "Returning "+strconv.Itoa(len(nodes))+" CoordinatorNodes.")
+	return OK, nodes
+}
+
+//livenessOrder sorts alive Nodes first and dead Nodes last, so they are only selected if no other Nodes are known
+const livenessOrder = "CASE liveness WHEN 1 THEN 0 WHEN 0 THEN 1 ELSE 2 END"
+
+//scanNodes reads Nodes from rows selecting address, lastPing, ping and liveness, skipping malformed rows
+//lastPing holds unix seconds, which the sqlite driver returns as time.Time, as the column is declared as timestamp
+func scanNodes(rows *sql.Rows) (nodes []node.Node) {
+	for rows.Next() {
+		var address string
+		var lastPing time.Time
+		var ping, liveness int
+		if err := rows.Scan(&address, &lastPing, &ping, &liveness); err != nil {
+			continue
+		}
+		nodes = append(nodes, node.Node{
+			Address: address, LastPing: lastPing, Ping: ping, Liveness: liveness,
+		})
+	}
+	return nodes
+}
+
+//nodeTables lists the tables a Node can be recorded in
+var nodeTables = []string{"storageNodes", "coordinatorNodes"}
+
+//MarkNodeAlive records a successful health check of the Node at address, in both Node tables
+func MarkNodeAlive(address string, ping int) (status int) {
+	for _, table := range nodeTables {
+		query := "UPDATE " + table + " SET liveness=?, lastPing=?, ping=? WHERE address=?"
+		_, err := coordinatorDB.Exec(query, node.LivenessAlive, time.Now().Unix(), ping, address)
+		if err != nil {
+			log.Error(CNDBWriteError, "Error marking Node "+address+" alive: "+err.Error())
+			return CNDBWriteError
+		}
+	}
+	return OK
+}
+
+//MarkNodeDead records a failed health check of the Node at address, in both Node tables
+func MarkNodeDead(address string) (status int) {
+	log.Info(InProgress, "Marking Node "+address+" dead...")
+	for _, table := range nodeTables {
+		query := "UPDATE " + table + " SET liveness=? WHERE address=?"
+		_, err := coordinatorDB.Exec(query, node.LivenessDead, address)
+		if err != nil {
+			log.Error(CNDBWriteError, "Error marking Node "+address+" dead: "+err.Error())
+			return CNDBWriteError
+		}
+	}
+	log.Info(OK, "Marked Node "+address+" dead.")
+	return OK
+}
+
+//RemoveStaleNodes removes the Nodes which last passed a health check before cutoff from both Node tables,
+//and returns their addresses
+func RemoveStaleNodes(cutoff time.Time) (status int, addresses []string) {
+	log.Info(InProgress, "Removing Nodes unseen since "+cutoff.Format(time.RFC3339)+"...")
+	tx, err := coordinatorDB.Begin()
+	if err != nil {
+		log.Error(CNDBPrepareError, "Error removing stale Nodes: "+err.Error())
+		return CNDBPrepareError, nil
+	}
+
+	removed := make(map[string]bool)
+	for _, table := range nodeTables {
+		rows, err := tx.Query("SELECT address FROM "+table+" WHERE lastPing < ?", cutoff.Unix())
+		if err != nil {
+			tx.Rollback()
+			log.Error(CNDBReadError, "Error removing stale Nodes: "+err.Error())
+			return CNDBReadError, nil
+		}
+		for rows.Next() {
+			var address string
+			if rows.Scan(&address) == nil && !removed[address] {
+				removed[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+		rows.Close()
+		if _, err = tx.Exec("DELETE FROM "+table+" WHERE lastPing < ?", cutoff.Unix()); err != nil {
+			tx.Rollback()
+			log.Error(CNDBWriteError, "Error removing stale Nodes: "+err.Error())
+			return CNDBWriteError, nil
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		log.Error(CNDBWriteError, "Error removing stale Nodes: "+err.Error())
+		return CNDBWriteError, nil
+	}
+	log.Info(OK, "Removed "+strconv.Itoa(len(addresses))+" stale Nodes.")
+	return OK, addresses
+}
+
+//AddMessageLocation records that the StorageNode at address stores a message, without a replication factor.
+//Repeated announcements only refresh reportedOn
+func AddMessageLocation(id string, address string) (status int) {
+	return AddMessageLocations([]string{id}, address, 0)
+}
+
+//AddMessageLocations records that the StorageNode at address stores the messages with ids, in one transaction.
+//replicationFactor is the number of copies besides the original the StorageNode keeps of them, or 0 if it did not
+//report one, see GetReplicationFactors. Repeated announcements only refresh reportedOn and the replication factor
+func AddMessageLocations(ids []string, address string, replicationFactor int) (status int) {
+	log.Info(InProgress, "Adding location "+address+" of "+strconv.Itoa(len(ids))+" Messages...")
+	tx, err := coordinatorDB.Begin()
+	if err != nil {
+		log.Error(CNDBPrepareError, "Error adding locations of "+address+": "+err.Error())
+		return CNDBPrepareError
+	}
+	query := `INSERT INTO messages(id, storageNode, reportedOn, replicationFactor) VALUES (?, ?, ?, ?)
+	ON CONFLICT(id, storageNode) DO UPDATE SET reportedOn=excluded.reportedOn,
+		replicationFactor=CASE WHEN excluded.replicationFactor > 0 THEN excluded.replicationFactor ELSE replicationFactor END`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		log.Error(CNDBPrepareError, "Error adding locations of "+address+": "+err.Error())
+		return CNDBPrepareError
+	}
+	defer stmt.Close()
+
+	reportedOn := time.Now().Unix()
+	for _, id := range ids {
+		if _, err = stmt.Exec(id, address, reportedOn, replicationFactor); err != nil {
+			tx.Rollback()
+			log.Error(CNDBWriteError, "Error adding location of Message "+id+": "+err.Error())
+			return CNDBWriteError
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		log.Error(CNDBWriteError, "Error adding locations of "+address+": "+err.Error())
+		return CNDBWriteError
+	}
+	log.Info(OK, "Added location "+address+" of "+strconv.Itoa(len(ids))+" Messages.")
+	return OK
+}
+
+//CountMessageLocations returns the number of StorageNodes known to store each of the messages with ids
+func CountMessageLocations(ids []string) (status int, counts map[string]int) {
+	stmt, err := coordinatorDB.Prepare("SELECT COUNT(*) FROM messages WHERE id=?")
+	if err != nil {
+		log.Error(CNDBPrepareError, "Error counting message locations: "+err.Error())
+		return CNDBPrepareError, nil
+	}
+	defer stmt.Close()
+
+	counts = make(map[string]int, len(ids))
+	for _, id := range ids {
+		var count int
+		if err = stmt.QueryRow(id).Scan(&count); err != nil {
+			log.Error(CNDBReadError, "Error counting locations of Message "+id+": "+err.Error())
+			return CNDBReadError, nil
+		}
+		counts[id] = count
+	}
+	return OK, counts
+}
+
+//GetReplicationFactors returns the replication factor recorded for each of the messages with ids, the largest one
+//reported by their StorageNodes, or defaultFactor if none reported one
+func GetReplicationFactors(ids []string, defaultFactor int) (status int, factors map[string]int) {
+	stmt, err := coordinatorDB.Prepare("SELECT COALESCE(MAX(replicationFactor), 0) FROM messages WHERE id=?")
+	if err != nil {
+		log.Error(CNDBPrepareError, "Error getting replication factors: "+err.Error())
+		return CNDBPrepareError, nil
+	}
+	defer stmt.Close()
+
+	factors = make(map[string]int, len(ids))
+	for _, id := range ids {
+		var factor int
+		if err = stmt.QueryRow(id).Scan(&factor); err != nil {
+			log.Error(CNDBReadError, "Error getting replication factor of Message "+id+": "+err.Error())
+			return CNDBReadError, nil
+		}
+		if factor < 1 {
+			factor = defaultFactor
+		}
+		factors[id] = factor
+	}
+	return OK, factors
+}
+
+//RemoveMessageLocation records that the StorageNode at address no longer stores a message
+func RemoveMessageLocation(id string, address string) (status int) {
+	log.Info(InProgress, "Removing location "+address+" of Message "+id+"...")
+	_, err := coordinatorDB.Exec("DELETE FROM messages WHERE id=? AND storageNode=?", id, address)
+	if err != nil {
+		log.Error(CNDBWriteError, "Error removing location of Message "+id+": "+err.Error())
+		return CNDBWriteError
+	}
+	log.Info(OK, "Removed location "+address+" of Message "+id+".")
+	return OK
+}
+
+//GetMessageLocations returns the addresses of all StorageNodes known to store a message
+func GetMessageLocations(id string) (status int, addresses []string) {
+	rows, err := coordinatorDB.Query("SELECT storageNode FROM messages WHERE id=? ORDER BY storageNode", id)
+	if err != nil {
+		log.Error(CNDBReadError, "Error getting locations of Message "+id+": "+err.Error())
+		return CNDBReadError, nil
+	}
+	defer rows.Close()
+
+	addresses = []string{}
+	for rows.Next() {
+		var address string
+		if err = rows.Scan(&address); err != nil {
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	return OK, addresses
+}
+
+//RemoveNodeLocations removes all locations of the StorageNode at address and returns the IDs of the affected messages
+func RemoveNodeLocations(address string) (status int, ids []string) {
+	log.Info(InProgress, "Removing all message locations of "+address+"...")
+	tx, err := coordinatorDB.Begin()
+	if err != nil {
+		log.Error(CNDBPrepareError, "Error removing message locations of "+address+": "+err.Error())
+		return CNDBPrepareError, nil
+	}
+
+	rows, err := tx.Query("SELECT id FROM messages WHERE storageNode=?", address)
+	if err == nil {
+		for rows.Next() {
+			var id string
+			if rows.Scan(&id) == nil {
+				ids = append(ids, id)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM messages WHERE storageNode=?", address)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.Error(CNDBWriteError, "Error removing message locations of "+address+": "+err.Error())
+		return CNDBWriteError, nil
+	}
 
-	result := []node.Node{
-		{
-			Address:  "test1",
-			LastPing: time.Time{},
-		},
-		{
-			Address:  "test2",
-			LastPing: time.Time{},
-		},
-		{
-			Address:  "test3",
-			LastPing: time.Time{},
-		},
+	err = tx.Commit()
+	if err != nil {
+		log.Error(CNDBWriteError, "Error removing message locations of "+address+": "+err.Error())
+		return CNDBWriteError, nil
 	}
+	log.Info(OK, "Removed "+strconv.Itoa(len(ids))+" message locations of "+address+".")
+	return OK, ids
+}
 
-	log.Info(OK, "Returning "+strconv.Itoa(len(result))+" CoordinatorNodes.")
-	return OK, result
+//CountUnderReplicatedMessages returns the number of messages known to be stored on fewer StorageNodes than the original
+//and their recorded replication factor of copies, or defaultFactor copies if none is recorded, see GetReplicationFactors
+func CountUnderReplicatedMessages(defaultFactor int) (status int, count int) {
+	query := "SELECT COUNT(*) FROM (SELECT id FROM messages GROUP BY id HAVING COUNT(*) < COALESCE(NULLIF(MAX(replicationFactor), 0), ?) + 1)"
+	err := coordinatorDB.QueryRow(query, defaultFactor).Scan(&count)
+	if err != nil {
+		log.Error(CNDBReadError, "Error counting under-replicated messages: "+err.Error())
+		return CNDBReadError, 0
+	}
+	return OK, count
 }
 
 //ClearNodeTables removes all elements from storageNodes and coordinatorNodes tables, for bootstrapping
@@ -298,3 +817,21 @@ func UpdateMessageStatusStorage(messageID string, status int) int {
 	log.Info(OK, "Updated status of Message "+messageID+". New status: "+strconv.Itoa(status))
 	return OK
 }
+
+//GetMessageStatusStorage returns the status of a message in the local database
+func GetMessageStatusStorage(messageID string) (status int, messageStatus int) {
+	query := "SELECT verified FROM messages WHERE id=?"
+	stmt, err := storageDB.Prepare(query)
+	if err != nil {
+		log.Error(SNDBPrepareError, "Error reading status of message "+messageID+": "+err.Error())
+		return SNDBPrepareError, -1
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRow(messageID).Scan(&messageStatus)
+	if err != nil {
+		log.Error(SNDBReadError, "Failed reading status of message "+messageID+": "+err.Error())
+		return SNDBReadError, -1
+	}
+	return OK, messageStatus
+}
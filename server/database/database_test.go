@@ -0,0 +1,203 @@
+package database
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	. "subframe/status"
+	"subframe/structs/node"
+	"sync"
+	"testing"
+)
+
+//TestMain silences the logs of the tests
+func TestMain(m *testing.M) {
+	logger.Level = logger.LogtypeFatal
+	os.Exit(m.Run())
+}
+
+//setupDatabase opens the databases in a temporary data directory, which are closed after the test
+func setupDatabase(t *testing.T) {
+	previousPath := settings.Current().DataPath
+	settings.Current().DataPath = t.TempDir()
+	if err := os.MkdirAll(settings.Current().DataPath+"/databases", 0700); err != nil {
+		t.Fatal(err)
+	}
+	Init()
+	t.Cleanup(func() {
+		Close()
+		settings.Current().DataPath = previousPath
+	})
+}
+
+func TestGetRandomStorageNodesAreDistinctAndRemote(t *testing.T) {
+	for _, selection := range []string{"random", "weighted"} {
+		t.Run(selection, func(t *testing.T) {
+			setupDatabase(t)
+			previousSelection := settings.Current().StorageNodeSelection
+			settings.Current().StorageNodeSelection = selection
+			t.Cleanup(func() { settings.Current().StorageNodeSelection = previousSelection })
+
+			//The local Node may learn its own addresses from peers
+			AddStorageNode(node.Node{Address: settings.Current().RemoteAddress})
+			AddStorageNode(node.Node{Address: settings.Current().LocalAddress})
+			for index := 0; index < 8; index++ {
+				AddStorageNode(node.Node{Address: "10.0.0." + strconv.Itoa(index) + ":9123"})
+				//Nodes announced twice are kept once
+				AddStorageNode(node.Node{Address: "10.0.0." + strconv.Itoa(index) + ":9123"})
+			}
+
+			for _, max := range []int{1, 5, 8, 20} {
+				status, nodes := GetRandomStorageNodes(max)
+				if status != OK {
+					t.Fatalf("GetRandomStorageNodes(%d) = %d", max, status)
+				}
+				want := max
+				if want > 8 {
+					want = 8
+				}
+				if len(nodes) != want {
+					t.Errorf("GetRandomStorageNodes(%d) returned %d StorageNodes, want %d", max, len(nodes), want)
+				}
+				seen := make(map[string]bool)
+				for _, n := range nodes {
+					if seen[n.Address] {
+						t.Errorf("GetRandomStorageNodes(%d) returned %s twice", max, n.Address)
+					}
+					if n.Address == settings.Current().RemoteAddress || n.Address == settings.Current().LocalAddress {
+						t.Errorf("GetRandomStorageNodes(%d) returned the local Node %s", max, n.Address)
+					}
+					seen[n.Address] = true
+				}
+			}
+		})
+	}
+}
+
+func TestDatabasesUseWriteAheadLog(t *testing.T) {
+	setupDatabase(t)
+
+	for name, db := range map[string]*sql.DB{"storage": storageDB, "coordinator": coordinatorDB} {
+		var mode string
+		var timeout int
+		if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+			t.Errorf("%s database journal_mode = %q, %v, want wal", name, mode, err)
+		}
+		if err := db.QueryRow("PRAGMA busy_timeout").Scan(&timeout); err != nil || timeout != busyTimeout {
+			t.Errorf("%s database busy_timeout = %d, %v, want %d", name, timeout, err, busyTimeout)
+		}
+	}
+}
+
+//withDatabaseBackend sets settings.DatabaseBackend for the duration of the test, before setupDatabase opens them
+func withDatabaseBackend(t *testing.T, backend string) {
+	previous := settings.Current().DatabaseBackend
+	settings.Current().DatabaseBackend = backend
+	t.Cleanup(func() { settings.Current().DatabaseBackend = previous })
+}
+
+func TestConcurrentWritesDoNotFail(t *testing.T) {
+	for _, backend := range []string{"sqlite", "memory"} {
+		t.Run(backend, func(t *testing.T) {
+			withDatabaseBackend(t, backend)
+			setupDatabase(t)
+
+			var wg sync.WaitGroup
+			statuses := make(chan int, 3*50)
+			for index := 0; index < 50; index++ {
+				wg.Add(1)
+				go func(id string) {
+					defer wg.Done()
+					statuses <- LogMessageStorage(id, 0)
+					statuses <- AddMessageLocation(id, "10.0.0.1:9123")
+					statuses <- AddStorageNode(node.Node{Address: "10.0.1." + id + ":9123"})
+				}(strconv.Itoa(index))
+			}
+			wg.Wait()
+			close(statuses)
+			for status := range statuses {
+				if status != OK {
+					t.Fatalf("concurrent write = %d, want OK", status)
+				}
+			}
+			if _, nodes := GetStorageNodes(100); len(nodes) != 50 {
+				t.Errorf("GetStorageNodes() returned %d StorageNodes, want 50", len(nodes))
+			}
+		})
+	}
+}
+
+func TestDatabasesSurviveRestart(t *testing.T) {
+	setupDatabase(t)
+	AddStorageNode(node.Node{Address: "10.0.0.1:9123"})
+	LogMessageStorage("abc", 3)
+	AddMessageLocation("abc", "10.0.0.1:9123")
+
+	Close()
+	Init()
+
+	if _, nodes := GetStorageNodes(10); len(nodes) != 1 || nodes[0].Address != "10.0.0.1:9123" {
+		t.Errorf("StorageNodes after restart = %v, want 10.0.0.1:9123", nodes)
+	}
+	if _, stored := CheckMessageStorage("abc"); !stored {
+		t.Error("message abc is not stored after restart")
+	}
+	if _, addresses := GetMessageLocations("abc"); len(addresses) != 1 || addresses[0] != "10.0.0.1:9123" {
+		t.Errorf("locations of abc after restart = %v, want 10.0.0.1:9123", addresses)
+	}
+}
+
+func TestMemoryDatabasesAreNotKept(t *testing.T) {
+	withDatabaseBackend(t, "memory")
+	setupDatabase(t)
+	AddStorageNode(node.Node{Address: "10.0.0.1:9123"})
+	LogMessageStorage("abc", 3)
+
+	files, err := ioutil.ReadDir(settings.Current().DataPath + "/databases")
+	if err != nil || len(files) != 0 {
+		t.Errorf("files in the databases directory = %d, %v, want none", len(files), err)
+	}
+
+	Close()
+	Init()
+
+	if _, nodes := GetStorageNodes(10); len(nodes) != 0 {
+		t.Errorf("StorageNodes after reopening = %v, want none", nodes)
+	}
+	if _, stored := CheckMessageStorage("abc"); stored {
+		t.Error("message abc is stored after reopening")
+	}
+}
+
+func TestCountUnderReplicatedMessagesCountsTheOriginal(t *testing.T) {
+	setupDatabase(t)
+	//With a replication factor of 2, three StorageNodes have to store a message
+	AddMessageLocations([]string{"single", "double", "triple"}, "10.0.0.1:9123", 0)
+	AddMessageLocations([]string{"double", "triple"}, "10.0.0.2:9123", 0)
+	AddMessageLocations([]string{"triple"}, "10.0.0.3:9123", 0)
+
+	if status, count := CountUnderReplicatedMessages(2); status != OK || count != 2 {
+		t.Errorf("CountUnderReplicatedMessages(2) = %d, %d, want %d, 2", status, count, OK)
+	}
+}
+
+func TestReplicationFactorsAreRecordedPerMessage(t *testing.T) {
+	setupDatabase(t)
+	//small is kept with 4 copies, and only reported with that factor by its first StorageNode
+	AddMessageLocations([]string{"small", "large"}, "10.0.0.1:9123", 0)
+	AddMessageLocations([]string{"small"}, "10.0.0.1:9123", 4)
+	AddMessageLocations([]string{"small", "large"}, "10.0.0.2:9123", 0)
+	AddMessageLocations([]string{"small", "large"}, "10.0.0.3:9123", 0)
+
+	status, factors := GetReplicationFactors([]string{"small", "large", "unknown"}, 2)
+	if status != OK || factors["small"] != 4 || factors["large"] != 2 || factors["unknown"] != 2 {
+		t.Errorf("GetReplicationFactors() = %d, %v, want 4 for small and the default of 2 otherwise", status, factors)
+	}
+	//Three StorageNodes store both, which is enough for the default factor only
+	if status, count := CountUnderReplicatedMessages(2); status != OK || count != 1 {
+		t.Errorf("CountUnderReplicatedMessages(2) = %d, %d, want %d, 1", status, count, OK)
+	}
+}
@@ -0,0 +1,55 @@
+package database
+
+import (
+	"database/sql"
+	"subframe/server/settings"
+	. "subframe/status"
+)
+
+//legacyPlacementHash is the hash function of the ring before settings.PlacementHash was introduced
+const legacyPlacementHash = "sha256"
+
+//checkPlacementHash compares settings.PlacementHash to the one recorded in the CoordinatorDatabase, so a changed
+//setting does not silently move messages to other StorageNodes. Databases without record which already know
+//StorageNodes or messages placed them with legacyPlacementHash. A change is only recorded with
+//settings.PlacementRebalance, otherwise the Node does not start and DBPlacementChanged is returned
+func checkPlacementHash() (status int) {
+	_, err := coordinatorDB.Exec("CREATE TABLE IF NOT EXISTS placement(hash varchar(32) not null)")
+	if err != nil {
+		log.Fatal(DBStructureError, "Failed to create placement table: "+err.Error())
+		return DBStructureError
+	}
+
+	var recorded string
+	err = coordinatorDB.QueryRow("SELECT hash FROM placement LIMIT 1").Scan(&recorded)
+	if err == sql.ErrNoRows {
+		var known int
+		err = coordinatorDB.QueryRow("SELECT (SELECT COUNT(*) FROM storageNodes) + (SELECT COUNT(*) FROM messages)").Scan(&known)
+		recorded = settings.Current().PlacementHash
+		if known > 0 {
+			recorded = legacyPlacementHash
+		}
+		if err == nil {
+			_, err = coordinatorDB.Exec("INSERT INTO placement(hash) VALUES(?)", recorded)
+		}
+	}
+	if err != nil {
+		log.Fatal(CNDBReadError, "Failed to read placement hash: "+err.Error())
+		return CNDBReadError
+	}
+	if recorded == settings.Current().PlacementHash {
+		return OK
+	}
+
+	if !settings.Current().PlacementRebalance {
+		log.Fatal(DBPlacementChanged, "Messages were placed with placement-hash "+recorded+", but "+settings.Current().PlacementHash+
+			" is configured. Set placement-hash to "+recorded+", or start with -placement-rebalance to move messages to their new StorageNodes.")
+		return DBPlacementChanged
+	}
+	log.Warn(DBPlacementChanged, "Changing placement-hash from "+recorded+" to "+settings.Current().PlacementHash+". Anti-entropy moves messages to their new StorageNodes.")
+	if _, err = coordinatorDB.Exec("UPDATE placement SET hash = ?", settings.Current().PlacementHash); err != nil {
+		log.Fatal(CNDBWriteError, "Failed to record placement hash: "+err.Error())
+		return CNDBWriteError
+	}
+	return OK
+}
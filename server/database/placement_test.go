@@ -0,0 +1,75 @@
+package database
+
+import (
+	. "subframe/status"
+	"subframe/structs/node"
+	"testing"
+)
+
+//recordedPlacementHash returns the placement hash recorded in the CoordinatorDatabase
+func recordedPlacementHash(t *testing.T) string {
+	t.Helper()
+	var recorded string
+	if err := coordinatorDB.QueryRow("SELECT hash FROM placement").Scan(&recorded); err != nil {
+		t.Fatal(err)
+	}
+	return recorded
+}
+
+//checkPlacement calls checkPlacementHash, reporting whether it refused to start the Node instead of returning
+func checkPlacement() (status int, refused bool) {
+	defer func() { refused = recover() != nil }()
+	return checkPlacementHash(), false
+}
+
+func TestCheckPlacementHash(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		rebalance  bool
+		refused    bool
+		recorded   string
+	}{
+		{"unchanged", "xxhash", false, false, "xxhash"},
+		{"changed", "sha256", false, true, "xxhash"},
+		{"rebalanced", "sha256", true, false, "sha256"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withPlacementHash(t, "xxhash", false)
+			setupDatabase(t)
+			if recorded := recordedPlacementHash(t); recorded != "xxhash" {
+				t.Fatalf("recorded hash of a new database = %q, want xxhash", recorded)
+			}
+
+			withPlacementHash(t, test.configured, test.rebalance)
+			if status, refused := checkPlacement(); refused != test.refused || !refused && status != OK {
+				t.Errorf("checkPlacementHash() = %d, refused %v, want refused %v", status, refused, test.refused)
+			}
+			if recorded := recordedPlacementHash(t); recorded != test.recorded {
+				t.Errorf("recorded hash = %q, want %q", recorded, test.recorded)
+			}
+		})
+	}
+}
+
+func TestCheckPlacementHashOfLegacyDatabase(t *testing.T) {
+	withPlacementHash(t, "xxhash", false)
+	setupDatabase(t)
+	//Databases from before the placement hash was recorded placed their StorageNodes with SHA-256
+	AddStorageNode(node.Node{Address: "10.0.0.1:9123"})
+	if _, err := coordinatorDB.Exec("DROP TABLE placement"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, refused := checkPlacement(); !refused {
+		t.Error("checkPlacementHash() started with xxhash, want refusing to move the legacy placement")
+	}
+	if recorded := recordedPlacementHash(t); recorded != legacyPlacementHash {
+		t.Errorf("recorded hash = %q, want %q", recorded, legacyPlacementHash)
+	}
+	withPlacementHash(t, legacyPlacementHash, false)
+	if status, refused := checkPlacement(); refused || status != OK {
+		t.Errorf("checkPlacementHash() with %s = %d, refused %v, want OK", legacyPlacementHash, status, refused)
+	}
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"subframe/server/settings"
+	. "subframe/status"
+	"subframe/structs/node"
+	"sync"
+)
+
+//virtualNodes is the number of points every StorageNode has on the ring. More points spread messages more evenly
+const virtualNodes = 100
+
+//ringPoint is one virtual node on the hash ring
+type ringPoint struct {
+	hash uint64
+	node int
+}
+
+//hashRing maps message IDs to StorageNodes by consistent hashing. A joining or leaving Node only moves
+//the messages between its points and their predecessors
+type hashRing struct {
+	nodes  []node.Node
+	points []ringPoint
+	//members identifies the Node set the ring was built from
+	members string
+}
+
+var ringMutex sync.Mutex
+var ring *hashRing
+
+//ringHash returns the position of key on the ring, computed with settings.PlacementHash
+func ringHash(key string) uint64 {
+	if settings.Current().PlacementHash == "xxhash" {
+		return xxhash64([]byte(key))
+	}
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+func newHashRing(nodes []node.Node, members string) *hashRing {
+	r := &hashRing{nodes: nodes, members: members, points: make([]ringPoint, 0, len(nodes)*virtualNodes)}
+	for index, n := range nodes {
+		for v := 0; v < virtualNodes; v++ {
+			r.points = append(r.points, ringPoint{hash: ringHash(n.Address + "#" + strconv.Itoa(v)), node: index})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].hash < r.points[j].hash })
+	return r
+}
+
+//replicas returns up to n distinct Nodes following the hash of id clockwise on the ring
+func (r *hashRing) replicas(id string, n int) []node.Node {
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+	replicas := make([]node.Node, 0, n)
+	if n <= 0 {
+		return replicas
+	}
+
+	hash := ringHash(id)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+	seen := make(map[int]bool, n)
+	for i := 0; len(replicas) < n; i++ {
+		point := r.points[(start+i)%len(r.points)]
+		if seen[point.node] {
+			continue
+		}
+		seen[point.node] = true
+		replicas = append(replicas, r.nodes[point.node])
+	}
+	return replicas
+}
+
+//ringMembers returns the StorageNodes placed on the ring sorted by address: all known StorageNodes
+//not marked dead, and the local Node itself
+func ringMembers() (status int, nodes []node.Node) {
+	query := "SELECT address, lastPing, ping, liveness FROM storageNodes WHERE liveness != ? AND address NOT IN (?, ?)"
+	rows, err := coordinatorDB.Query(query, node.LivenessDead, settings.Current().RemoteAddress, settings.Current().LocalAddress)
+	if err != nil {
+		log.Error(CNDBReadError, "Error getting StorageNodes for the hash ring: "+err.Error())
+		return CNDBReadError, nil
+	}
+	defer rows.Close()
+	nodes = append(scanNodes(rows), node.Node{Address: settings.Current().RemoteAddress, Liveness: node.LivenessAlive})
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Address < nodes[j].Address })
+	return OK, nodes
+}
+
+//GetReplicaNodes returns the up to n StorageNodes responsible for storing the message with ID, in order of preference.
+//Every Node knowing the same StorageNodes computes the same replicas. The result can include the local Node
+func GetReplicaNodes(messageID string, n int) (status int, nodes []node.Node) {
+	status, members := ringMembers()
+	if status != OK {
+		return status, nil
+	}
+
+	key := ""
+	for _, member := range members {
+		key += member.Address + "\n"
+	}
+
+	//The ring is only rebuilt when the set of StorageNodes changes
+	ringMutex.Lock()
+	if ring == nil || ring.members != key {
+		log.Info(InProgress, "Building hash ring of "+strconv.Itoa(len(members))+" StorageNodes...")
+		ring = newHashRing(members, key)
+	}
+	current := ring
+	ringMutex.Unlock()
+
+	return OK, current.replicas(messageID, n)
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"subframe/server/settings"
+	"testing"
+)
+
+//withPlacementHash sets settings.PlacementHash and settings.PlacementRebalance for the duration of the test
+func withPlacementHash(t *testing.T, hash string, rebalance bool) {
+	current := settings.Current()
+	previousHash, previousRebalance := current.PlacementHash, current.PlacementRebalance
+	current.PlacementHash, current.PlacementRebalance = hash, rebalance
+	t.Cleanup(func() { current.PlacementHash, current.PlacementRebalance = previousHash, previousRebalance })
+}
+
+func TestRingHash(t *testing.T) {
+	//Positions must never change for a hash, or messages move to other StorageNodes
+	tests := []struct {
+		hash string
+		key  string
+		want uint64
+	}{
+		{"xxhash", "abc", 0x44bc2cf5ad770999},
+		{"xxhash", "10.0.0.1:9123#0", 0x347f6257a40a13d0},
+		{"sha256", "abc", 0xba7816bf8f01cfea},
+		{"sha256", "10.0.0.1:9123#0", 0xf5b8357a17ef3565},
+	}
+	for _, test := range tests {
+		t.Run(test.hash+"/"+test.key, func(t *testing.T) {
+			withPlacementHash(t, test.hash, false)
+			if got := ringHash(test.key); got != test.want {
+				t.Errorf("ringHash(%q) = %#016x, want %#016x", test.key, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"math"
+	"math/rand"
+	"subframe/server/settings"
+	. "subframe/status"
+	"subframe/structs/node"
+)
+
+//unknownCapacity is the free storage recorded for StorageNodes which have not reported it yet
+const unknownCapacity = -1
+
+//overloadedScore is the load score from which StorageNodes reject new messages
+const overloadedScore = 1.0
+
+//loadOrder sorts overloaded StorageNodes after the others, as they reject new messages
+const loadOrder = "loadScore >= 1"
+
+//minLoadFactor is the share of its weight a StorageNode keeps in weighted selection however loaded it is
+const minLoadFactor = 0.05
+
+//SetNodeLoad records the load score the StorageNode at address reported in its Health Report
+func SetNodeLoad(address string, score float64) (status int) {
+	_, err := coordinatorDB.Exec("UPDATE storageNodes SET loadScore=? WHERE address=?", score, address)
+	if err != nil {
+		log.Error(CNDBWriteError, "Error recording load of StorageNode "+address+": "+err.Error())
+		return CNDBWriteError
+	}
+	return OK
+}
+
+//SetNodeCapacity records the free storage in bytes the StorageNode at address reported
+func SetNodeCapacity(address string, freeBytes int64) (status int) {
+	_, err := coordinatorDB.Exec("UPDATE storageNodes SET freeBytes=? WHERE address=?", freeBytes, address)
+	if err != nil {
+		log.Error(CNDBWriteError, "Error recording capacity of StorageNode "+address+": "+err.Error())
+		return CNDBWriteError
+	}
+	return OK
+}
+
+//nodeCapacities returns the free storage and the load score recorded per StorageNode address
+func nodeCapacities() (status int, capacities map[string]int64, loads map[string]float64) {
+	rows, err := coordinatorDB.Query("SELECT address, freeBytes, loadScore FROM storageNodes")
+	if err != nil {
+		log.Error(CNDBReadError, "Error reading capacities of StorageNodes: "+err.Error())
+		return CNDBReadError, nil, nil
+	}
+	defer rows.Close()
+	capacities = make(map[string]int64)
+	loads = make(map[string]float64)
+	for rows.Next() {
+		var address string
+		var freeBytes int64
+		var load float64
+		if rows.Scan(&address, &freeBytes, &load) == nil {
+			capacities[address] = freeBytes
+			loads[address] = load
+		}
+	}
+	return OK, capacities, loads
+}
+
+//weighted returns whether StorageNodes are selected by free storage, as set in settings.StorageNodeSelection
+func weighted() bool {
+	return settings.Current().StorageNodeSelection == "weighted"
+}
+
+//livenessRank returns the position of liveness in livenessOrder
+func livenessRank(liveness int) int {
+	switch liveness {
+	case node.LivenessAlive:
+		return 0
+	case node.LivenessUnknown:
+		return 1
+	}
+	return 2
+}
+
+//selectionRank returns the position of n in livenessOrder and loadOrder
+func selectionRank(n node.Node, loads map[string]float64) int {
+	rank := 2 * livenessRank(n.Liveness)
+	if loads[n.Address] >= overloadedScore {
+		rank++
+	}
+	return rank
+}
+
+//selectWeighted returns up to n of nodes, drawn at random with a probability proportional to their free storage,
+//reduced by their load. nodes have to be sorted by livenessOrder and loadOrder, and Nodes of a better liveness are
+//still selected first, followed by overloaded ones. Nodes which have not reported their free storage weigh as much
+//as the average Node which has
+func selectWeighted(nodes []node.Node, capacities map[string]int64, loads map[string]float64, n int) (selected []node.Node) {
+	for start := 0; start < len(nodes) && len(selected) < n; {
+		end := start
+		for end < len(nodes) && selectionRank(nodes[end], loads) == selectionRank(nodes[start], loads) {
+			end++
+		}
+		selected = append(selected, drawWeighted(nodes[start:end], capacities, loads, n-len(selected), sharedRandom{})...)
+		start = end
+	}
+	return selected
+}
+
+//loadFactor returns the share of its weight a Node with load score keeps
+func loadFactor(load float64) float64 {
+	return math.Max(1-load, minLoadFactor)
+}
+
+//random draws the numbers of weighted selection. It is implemented by *rand.Rand, so tests can draw from a seeded source
+type random interface {
+	Intn(n int) int
+	Float64() float64
+}
+
+//sharedRandom draws from the shared source of math/rand, which is safe for concurrent use
+type sharedRandom struct{}
+
+func (sharedRandom) Intn(n int) int {
+	return rand.Intn(n)
+}
+
+func (sharedRandom) Float64() float64 {
+	return rand.Float64()
+}
+
+//drawWeighted draws up to n distinct nodes with a probability proportional to their free storage and load factor
+func drawWeighted(nodes []node.Node, capacities map[string]int64, loads map[string]float64, n int, random random) (drawn []node.Node) {
+	weights := make([]float64, len(nodes))
+	var known, total float64
+	for index, candidate := range nodes {
+		if capacity, ok := capacities[candidate.Address]; ok && capacity != unknownCapacity {
+			weights[index] = float64(capacity)
+			total += weights[index]
+			known++
+		} else {
+			weights[index] = -1
+		}
+	}
+	average := 1.0
+	if known > 0 && total > 0 {
+		average = total / known
+	}
+	for index, candidate := range nodes {
+		if weights[index] < 0 {
+			weights[index] = average
+		}
+		weights[index] *= loadFactor(loads[candidate.Address])
+	}
+
+	remaining := append([]node.Node(nil), nodes...)
+	for len(drawn) < n && len(remaining) > 0 {
+		total = 0
+		for _, weight := range weights {
+			total += weight
+		}
+		//Only full Nodes are left, which are drawn uniformly
+		pick := random.Intn(len(remaining))
+		if total > 0 {
+			target := random.Float64() * total
+			for pick = 0; pick < len(remaining)-1 && target >= weights[pick]; pick++ {
+				target -= weights[pick]
+			}
+		}
+		drawn = append(drawn, remaining[pick])
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+		weights = append(weights[:pick], weights[pick+1:]...)
+	}
+	return drawn
+}
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"math"
+	"math/rand"
+	"subframe/structs/node"
+	"testing"
+)
+
+func TestDrawWeightedFollowsCapacity(t *testing.T) {
+	nodes := []node.Node{{Address: "large"}, {Address: "medium"}, {Address: "small"}, {Address: "loaded"}, {Address: "unreported"}}
+	capacities := map[string]int64{"large": 6000, "medium": 2000, "small": 500, "loaded": 2000, "unreported": unknownCapacity}
+	loads := map[string]float64{"loaded": 0.75}
+	//The unreported Node weighs the average of 2625 bytes, the loaded one a quarter of its 2000 bytes
+	total := 6000.0 + 2000 + 500 + 500 + 2625
+	want := map[string]float64{"large": 6000 / total, "medium": 2000 / total, "small": 500 / total, "loaded": 500 / total, "unreported": 2625 / total}
+
+	const draws = 20000
+	const tolerance = 0.01
+	random := rand.New(rand.NewSource(1))
+	counts := make(map[string]int)
+	for i := 0; i < draws; i++ {
+		drawn := drawWeighted(nodes, capacities, loads, 1, random)
+		if len(drawn) != 1 {
+			t.Fatalf("drawWeighted() drew %d Nodes, want 1", len(drawn))
+		}
+		counts[drawn[0].Address]++
+	}
+	for address, share := range want {
+		if got := float64(counts[address]) / draws; math.Abs(got-share) > tolerance {
+			t.Errorf("%s drawn in %.3f of draws, want %.3f ± %.2f", address, got, share, tolerance)
+		}
+	}
+}
+
+func TestDrawWeightedDrawsDistinctNodes(t *testing.T) {
+	nodes := []node.Node{{Address: "a"}, {Address: "b"}, {Address: "c"}}
+	//Full Nodes are still drawn, uniformly, once the others are
+	capacities := map[string]int64{"a": 1 << 30, "b": 0, "c": 0}
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		drawn := drawWeighted(nodes, capacities, nil, 5, random)
+		if len(drawn) != 3 {
+			t.Fatalf("drawWeighted() drew %d Nodes, want all 3", len(drawn))
+		}
+		seen := make(map[string]bool)
+		for _, n := range drawn {
+			if seen[n.Address] {
+				t.Fatalf("drawWeighted() drew %s twice: %v", n.Address, drawn)
+			}
+			seen[n.Address] = true
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	//Vectors of the reference implementation, covering inputs below and above the 32 byte stripes
+	tests := []struct {
+		data string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"The quick brown fox jumps over the lazy dog", 0x0b242d361fda71bc},
+		{strings.Repeat("abcdefghij", 10), 0xc4cc1eafce2327f1},
+	}
+	for _, test := range tests {
+		if got := xxhash64([]byte(test.data)); got != test.want {
+			t.Errorf("xxhash64(%q) = %#016x, want %#016x", test.data, got, test.want)
+		}
+	}
+}
@@ -1,90 +1,297 @@
 package jobqueue
 
 import (
+	"context"
 	"strconv"
 	"subframe/server/logger"
+	"subframe/server/metrics"
 	"subframe/server/settings"
+	"subframe/server/tracing"
 	. "subframe/status"
+	"sync/atomic"
 	"time"
 )
 
 var log = logger.Logger{Prefix: "jobqueue/Main"}
 
-//Task will be executed by Job
-type Task func(data interface{})
+//Task will be executed by Job. ctx carries the request ID of the Job, and is canceled if the Queue is stopped
+//before the Job finished. Jobs outlive the request they were created for, so they are not canceled with it.
+//A returned error marks the Job as failed
+type Task func(ctx context.Context, data interface{}) error
+
+//RetryPolicy defines how often a failed Job is retried. The delay before each retry is Backoff, doubled for every further attempt
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+}
 
 //Job will be executed
 type Job struct {
+	//Name is set for Jobs created with NewJob, only those are persisted
+	Name string
 	Task Task
 	Data interface{}
+	//Retry is optional, Jobs without RetryPolicy are executed once
+	Retry *RetryPolicy
+	//Attempt counts the failed executions of the Job
+	Attempt int
+	//RequestID is the ID of the request the Job was created for, it is passed to Task in its context
+	RequestID string
+	//TraceParent is the trace context of the request the Job was created for, its execution is traced as part of it
+	TraceParent string
+	//id identifies persisted Jobs in the journal
+	id int64
+}
+
+var jobsTotal = metrics.NewCounterVec("subframe_jobs_total", "Executed Jobs by result: success, retry or failed.", "result")
+
+func init() {
+	metrics.NewGaugeFunc("subframe_job_queue_length", "Jobs waiting in the Queue.", func() float64 {
+		return float64(len(Queue))
+	})
+	metrics.NewGaugeFunc("subframe_jobs_active", "Jobs currently executed by workers.", func() float64 {
+		return float64(atomic.LoadInt32(&activeJobs))
+	})
+}
+
+//DeadLetter is a Job which failed on its final attempt
+type DeadLetter struct {
+	Job Job
+	Err error
 }
 
+//deadLetterCapacity bounds DeadLetters, the oldest DeadLetter is discarded once it is full
+const deadLetterCapacity = 100
+
+//DeadLetters holds the most recent Jobs which exhausted their retries, for inspection
+var DeadLetters = make(chan DeadLetter, deadLetterCapacity)
+
 func (j Job) execute() {
-	j.Task(j.Data)
+	ctx := jobsContext
+	if j.RequestID != "" {
+		ctx = logger.WithRequestID(ctx, j.RequestID)
+	}
+	name := j.Name
+	if name == "" {
+		name = "unnamed"
+	}
+	ctx, span := tracing.Start(tracing.WithTraceParent(ctx, j.TraceParent), "job "+name, tracing.KindInternal)
+	span.SetAttribute("subframe.job.attempt", strconv.Itoa(j.Attempt+1))
+	err := j.Task(ctx, j.Data)
+	if err != nil {
+		span.SetError(err.Error())
+	}
+	span.End()
+	atomic.AddInt64(&processedJobs, 1)
+	if err == nil {
+		jobsTotal.Inc("success")
+		j.finish()
+		return
+	}
+
+	j.Attempt++
+	if j.Retry == nil || j.Attempt >= j.Retry.MaxAttempts {
+		log.WithContext(ctx).Error(JQJobFailed, "Job failed after "+strconv.Itoa(j.Attempt)+" attempts: "+err.Error())
+		jobsTotal.Inc("failed")
+		atomic.AddInt64(&failedJobs, 1)
+		deadLetter(DeadLetter{Job: j, Err: err})
+		j.finish()
+		return
+	}
+
+	jobsTotal.Inc("retry")
+	atomic.AddInt64(&retriedJobs, 1)
+	delay := j.Retry.Backoff << uint(j.Attempt-1)
+	log.WithContext(ctx).Warn(JQJobFailed, "Job failed (attempt "+strconv.Itoa(j.Attempt)+"): "+err.Error()+". Retrying in "+delay.String()+"...")
+	//Re-enqueue asynchronously, as this worker would otherwise block on the queue it is consuming
+	time.AfterFunc(delay, func() {
+		Enqueue(j)
+	})
+}
+
+//finish removes a persisted Job from the journal once it will not be executed again
+func (j Job) finish() {
+	if j.persisted() {
+		jobJournal.recordDone(j.id)
+	}
 }
 
+//deadLetter adds letter to DeadLetters, discarding the oldest DeadLetter if it is full
+func deadLetter(letter DeadLetter) {
+	for {
+		select {
+		case DeadLetters <- letter:
+			return
+		default:
+		}
+		select {
+		case <-DeadLetters:
+		default:
+		}
+	}
+}
+
+//enqueueTimeout is how long Enqueue waits for space in a full Queue
+const enqueueTimeout = 5 * time.Second
+
+//Queue holds all jobs waiting to be executed. It buffers up to settings.QueueMaxLength jobs
+var Queue chan Job
+
 type worker struct {
-	id  string
-	die chan bool
+	id int
 }
 
-func (sw worker) start() {
-	log.Info(InProgress, "Starting worker "+sw.id+".")
+//activeJobs counts the Jobs currently executed by workers
+var activeJobs int32
+
+//Counters of Jobs since the start, reported by GetStats
+var processedJobs, failedJobs, retriedJobs, droppedJobs int64
+
+//workers is the number of workers started by Init
+var workers int32
+
+//lastProgress is the time in unix nanoseconds a worker last took or finished a Job
+var lastProgress int64
+
+//stopped is set once Stop is called, Enqueue rejects Jobs from then on
+var stopped int32
+
+//quit stops the workers
+var quit = make(chan bool)
+
+//jobsContext is the parent of the contexts of all Jobs, cancelJobs aborts the Jobs still executing on Stop
+var jobsContext, cancelJobs = context.WithCancel(context.Background())
+
+func (w worker) start() {
 	go func() {
 		for {
-			workerCount := len(workerPool)
-			queueLength := len(Queue)
-
-			if workerCount < settings.MaxWorkers && queueLength >= settings.QueueMaxLength {
-				log.Info(JQQueueTooLong, "Queue length exceeds settings.MaxQueueLength. Trying to spawn new worker...")
-				SpawnWorker()
-			} else if workerCount > 1 && queueLength <= settings.QueueMaxLength {
-				log.Info(JQTooManyWorkers, "Too many workers for current queue length. Killing worker "+sw.id+"...")
-				sw.die <- true
-			}
 			select {
 			case job := <-Queue:
-				{
-					job.execute()
-				}
-			case <-sw.die:
-				{
-					log.Info(InProgress, "Killing worker "+sw.id+"...")
-					removeWorkerFromPool(sw.id)
-					return
-				}
+				atomic.AddInt32(&activeJobs, 1)
+				atomic.StoreInt64(&lastProgress, time.Now().UnixNano())
+				job.execute()
+				atomic.StoreInt64(&lastProgress, time.Now().UnixNano())
+				atomic.AddInt32(&activeJobs, -1)
+			case <-quit:
+				log.Info(OK, "Worker "+strconv.Itoa(w.id)+" stopped.")
+				return
 			}
 		}
 	}()
 }
 
-var workerPool []*worker
+//Init creates the Queue and starts settings.JobWorkers workers draining it
+func Init() {
+	log.Info(InProgress, "Starting "+strconv.Itoa(settings.Current().JobWorkers)+" Workers...")
+	Queue = make(chan Job, settings.Current().QueueMaxLength)
+	atomic.StoreInt64(&lastProgress, time.Now().UnixNano())
+	for id := 0; id < settings.Current().JobWorkers; id++ {
+		worker{id: id}.start()
+	}
+	atomic.StoreInt32(&workers, int32(settings.Current().JobWorkers))
+	log.Info(OK, "Started Workers.")
+}
 
-//Queue holds all jobs waiting to be executed
-var Queue = make(chan Job)
+//Enqueue adds job to the Queue. If the Queue stays full for enqueueTimeout, job is dropped and JQQueueTooLong returned.
+//After Stop, all Jobs are rejected with JQStopped
+func Enqueue(job Job) (status int) {
+	if atomic.LoadInt32(&stopped) == 1 {
+		//Persisted Jobs stay pending in the journal and are recovered on the next start
+		log.Warn(JQStopped, "Job Queue is stopped. Rejecting Job "+job.Name+".")
+		return JQStopped
+	}
 
-//SpawnWorker spawns a new Worker, if MaxWorkers setting allows it
-func SpawnWorker() {
-	if len(workerPool) >= settings.MaxWorkers {
-		log.Warn(JQTooManyWorkers, "settings.MaxWorkers does not allow for a new Worker to be spawned.")
-		return
+	if job.persisted() {
+		jobJournal.recordEnqueue(&job)
+	}
+
+	select {
+	case Queue <- job:
+		return OK
+	default:
 	}
-	log.Info(InProgress, "Spawning and starting new Worker...")
-	worker := worker{
-		id:  strconv.FormatInt(time.Now().Unix(), 16),
-		die: make(chan bool),
+
+	log.Warn(JQQueueTooLong, "Queue is full ("+strconv.Itoa(len(Queue))+" Jobs). Waiting...")
+	timeout := time.NewTimer(enqueueTimeout)
+	defer timeout.Stop()
+	select {
+	case Queue <- job:
+		return OK
+	case <-timeout.C:
+		log.Error(JQQueueTooLong, "Queue is still full after "+enqueueTimeout.String()+". Dropping Job.")
+		atomic.AddInt64(&droppedJobs, 1)
+		job.finish()
+		return JQQueueTooLong
+	}
+}
+
+//Length returns the number of Jobs waiting in the Queue and the number of Jobs currently executed
+func Length() (queued int, active int) {
+	return len(Queue), int(atomic.LoadInt32(&activeJobs))
+}
+
+//Stats describes the Queue and the Jobs executed since the start. Processed counts every execution of a Job,
+//Failed the Jobs which failed on their final attempt and Retried the failed executions which were retried.
+//Dropped counts the Jobs rejected because the Queue stayed full
+type Stats struct {
+	Length    int   `json:"length"`
+	Capacity  int   `json:"capacity"`
+	Workers   int   `json:"workers"`
+	Active    int   `json:"active"`
+	Processed int64 `json:"processed"`
+	Failed    int64 `json:"failed"`
+	Retried   int64 `json:"retried"`
+	Dropped   int64 `json:"dropped"`
+}
+
+//GetStats returns the current Stats of the Queue
+func GetStats() Stats {
+	return Stats{
+		Length:    len(Queue),
+		Capacity:  cap(Queue),
+		Workers:   int(atomic.LoadInt32(&workers)),
+		Active:    int(atomic.LoadInt32(&activeJobs)),
+		Processed: atomic.LoadInt64(&processedJobs),
+		Failed:    atomic.LoadInt64(&failedJobs),
+		Retried:   atomic.LoadInt64(&retriedJobs),
+		Dropped:   atomic.LoadInt64(&droppedJobs),
+	}
+}
+
+//Stalled returns whether Jobs are waiting, but no worker took or finished a Job for threshold,
+//or whether the Queue is stopped
+func Stalled(threshold time.Duration) bool {
+	if atomic.LoadInt32(&stopped) == 1 {
+		return true
 	}
-	workerPool = append(workerPool, &worker)
-	log.Info(OK, "New worker count: "+strconv.Itoa(len(workerPool)))
-	worker.start()
+	last := time.Unix(0, atomic.LoadInt64(&lastProgress))
+	return len(Queue) > 0 && time.Since(last) > threshold
 }
 
-func removeWorkerFromPool(id string) {
-	//Remove worker with id from pool
-	for index, value := range workerPool {
-		if value.id == id {
-			workerPool = append(workerPool[:index], workerPool[index+1:]...)
+//Stop rejects new Jobs and waits up to timeout for the workers to finish all queued Jobs, then stops the workers.
+//Returns whether the Queue was drained in time
+func Stop(timeout time.Duration) (drained bool) {
+	log.Info(InProgress, "Stopping Job Queue. Draining "+strconv.Itoa(len(Queue))+" queued Jobs...")
+	atomic.StoreInt32(&stopped, 1)
+
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for len(Queue) > 0 || atomic.LoadInt32(&activeJobs) > 0 {
+		if time.Now().After(deadline) {
+			break
 		}
+		<-ticker.C
+	}
+	drained = len(Queue) == 0 && atomic.LoadInt32(&activeJobs) == 0
+	cancelJobs()
+	close(quit)
+	closeJournal()
+
+	if !drained {
+		log.Warn(JQQueueTooLong, "Stopped Job Queue with "+strconv.Itoa(len(Queue))+" Jobs left after "+timeout.String()+".")
+		return false
 	}
-	log.Info(OK, "Worker "+id+" killed.")
+	log.Info(OK, "Stopped Job Queue.")
+	return true
 }
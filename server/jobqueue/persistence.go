@@ -0,0 +1,324 @@
+package jobqueue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/server/tracing"
+	. "subframe/status"
+	"sync"
+	"time"
+)
+
+//Decoder restores the Data of a persisted Job from its JSON encoding
+type Decoder func(raw []byte) (interface{}, error)
+
+//registeredTask describes a Task which can be persisted and restored by name
+type registeredTask struct {
+	task   Task
+	decode Decoder
+	retry  *RetryPolicy
+}
+
+var registry = make(map[string]registeredTask)
+
+//Register makes task available under name for Jobs created with NewJob, which are persisted if settings.PersistJobs is set.
+//decode restores the Data of such Jobs after a restart. Register has to be called before Recover, usually from init
+func Register(name string, task Task, decode Decoder, retry *RetryPolicy) {
+	registry[name] = registeredTask{task: task, decode: decode, retry: retry}
+}
+
+//NewJob creates a Job executing the Task registered as name
+func NewJob(name string, data interface{}) Job {
+	registered, ok := registry[name]
+	if !ok {
+		log.Fatal(JQUnknownTask, "No Task registered as "+name)
+	}
+	return Job{
+		Name:  name,
+		Task:  registered.task,
+		Data:  data,
+		Retry: registered.retry,
+	}
+}
+
+//NewJobContext creates a Job executing the Task registered as name, which keeps the request ID carried by ctx
+func NewJobContext(ctx context.Context, name string, data interface{}) Job {
+	job := NewJob(name, data)
+	job.RequestID = logger.RequestID(ctx)
+	job.TraceParent = tracing.TraceParent(ctx)
+	return job
+}
+
+//journalRecord is one line of the job journal. A Job is pending from its last "enqueue" record until its "done" record
+type journalRecord struct {
+	Op      string          `json:"op"`
+	ID      int64           `json:"id"`
+	Name    string          `json:"name,omitempty"`
+	Attempt int             `json:"attempt,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	//RequestID and TraceParent are kept, so recovered Jobs still log and trace as part of the request they were created for
+	RequestID   string `json:"requestID,omitempty"`
+	TraceParent string `json:"traceParent,omitempty"`
+}
+
+//compactionThreshold is the number of records after which the journal is rewritten to only contain pending Jobs
+const compactionThreshold = 1000
+
+//journal is the append-only log of persisted Jobs in settings.DataPath
+type journal struct {
+	mutex   sync.Mutex
+	file    *os.File
+	path    string
+	nextID  int64
+	records int
+	pending map[int64]journalRecord
+	//closed is set once the journal is closed on shutdown, Jobs still running are then left pending
+	closed bool
+}
+
+var jobJournal *journal
+
+func journalPath() string {
+	return settings.Current().DataPath + "/jobqueue.log"
+}
+
+//persisted returns whether j is written to the journal
+func (j Job) persisted() bool {
+	return jobJournal != nil && j.Name != ""
+}
+
+//recordEnqueue writes j to the journal, assigning it an ID on its first attempt
+func (jn *journal) recordEnqueue(j *Job) {
+	data, err := json.Marshal(j.Data)
+	if err != nil {
+		log.Error(JQJournalError, "Could not persist Job "+j.Name+": "+err.Error())
+		return
+	}
+
+	jn.mutex.Lock()
+	defer jn.mutex.Unlock()
+	if j.id == 0 {
+		jn.nextID++
+		j.id = jn.nextID
+	}
+	record := journalRecord{Op: "enqueue", ID: j.id, Name: j.Name, Attempt: j.Attempt, Data: data, RequestID: j.RequestID, TraceParent: j.TraceParent}
+	jn.pending[j.id] = record
+	jn.append(record)
+}
+
+//recordDone marks the Job with id as finished, successfully or not
+func (jn *journal) recordDone(id int64) {
+	jn.mutex.Lock()
+	defer jn.mutex.Unlock()
+	delete(jn.pending, id)
+	jn.append(journalRecord{Op: "done", ID: id})
+	if jn.records >= compactionThreshold && !jn.closed {
+		jn.compact()
+	}
+}
+
+//append writes record to the journal file and syncs it to disk. The mutex has to be held
+func (jn *journal) append(record journalRecord) {
+	if jn.closed {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err == nil {
+		_, err = jn.file.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = jn.file.Sync()
+	}
+	if err != nil {
+		log.Error(JQJournalError, "Error writing Job journal: "+err.Error())
+		return
+	}
+	jn.records++
+}
+
+//compact rewrites the journal to only contain pending Jobs. The mutex has to be held
+func (jn *journal) compact() {
+	tmpPath := jn.path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Error(JQJournalError, "Error compacting Job journal: "+err.Error())
+		return
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, record := range sortedRecords(jn.pending) {
+		line, _ := json.Marshal(record)
+		writer.Write(append(line, '\n'))
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err == nil {
+		err = os.Rename(tmpPath, jn.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		log.Error(JQJournalError, "Error compacting Job journal: "+err.Error())
+		return
+	}
+
+	jn.file.Close()
+	jn.file, err = os.OpenFile(jn.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatal(JQJournalError, "Error reopening Job journal: "+err.Error())
+	}
+	jn.records = len(jn.pending)
+}
+
+//sortedRecords returns records ordered by ID, which is the order the Jobs were first enqueued in
+func sortedRecords(records map[int64]journalRecord) []journalRecord {
+	sorted := make([]journalRecord, 0, len(records))
+	for _, record := range records {
+		sorted = append(sorted, record)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
+
+//readJournal returns the Jobs pending in the journal at path. Truncated last lines of interrupted writes are skipped
+func readJournal(path string) (pending map[int64]journalRecord, lastID int64, err error) {
+	pending = make(map[int64]journalRecord)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return pending, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var record journalRecord
+		if json.Unmarshal(scanner.Bytes(), &record) != nil {
+			log.Warn(JQJournalError, "Skipping corrupt Job journal record.")
+			continue
+		}
+		if record.ID > lastID {
+			lastID = record.ID
+		}
+		switch record.Op {
+		case "enqueue":
+			pending[record.ID] = record
+		case "done":
+			delete(pending, record.ID)
+		}
+	}
+	return pending, lastID, scanner.Err()
+}
+
+//Recover opens the job journal and re-enqueues all Jobs which were pending when the Node stopped, spread over
+//settings.AnnounceJitter seconds. Has to be called after Init, and does nothing unless settings.PersistJobs is set
+func Recover() {
+	if !settings.Current().PersistJobs {
+		return
+	}
+
+	log.Info(InProgress, "Recovering pending Jobs...")
+	path := journalPath()
+	pending, lastID, err := readJournal(path)
+	if err != nil {
+		log.Fatal(JQJournalError, "Error reading Job journal: "+err.Error())
+	}
+
+	jobJournal = &journal{path: path, nextID: lastID, pending: pending}
+	jobJournal.mutex.Lock()
+	jobJournal.file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err == nil {
+		jobJournal.compact()
+	}
+	jobJournal.mutex.Unlock()
+	if err != nil {
+		log.Fatal(JQJournalError, "Error opening Job journal: "+err.Error())
+	}
+
+	records := sortedRecords(pending)
+	window := time.Duration(settings.Current().AnnounceJitter) * time.Second
+	recovered := 0
+	for index, record := range records {
+		registered, ok := registry[record.Name]
+		var data interface{}
+		if ok && registered.decode != nil {
+			data, err = registered.decode(record.Data)
+		}
+		if !ok || err != nil {
+			log.Error(JQUnknownTask, "Cannot recover Job "+strconv.FormatInt(record.ID, 10)+" ("+record.Name+"). Discarding...")
+			jobJournal.recordDone(record.ID)
+			continue
+		}
+
+		job := Job{
+			Name:        record.Name,
+			Task:        registered.task,
+			Data:        data,
+			Retry:       registered.retry,
+			Attempt:     record.Attempt,
+			RequestID:   record.RequestID,
+			TraceParent: record.TraceParent,
+			id:          record.ID,
+		}
+		if delay := stagger(index, len(records), window); delay > 0 {
+			go enqueueRecovered(job, delay)
+		} else {
+			//Recovered Jobs are not dropped if the Queue is full, but wait for the workers
+			Queue <- job
+		}
+		recovered++
+	}
+	log.Info(OK, "Recovered "+strconv.Itoa(recovered)+" pending Jobs, spread over "+window.String()+".")
+}
+
+//stagger returns the delay of the index-th of count Jobs spread over window. Each Job gets an equal slot of the
+//window, and a random offset within it, so the Jobs neither burst nor run in lockstep with other Nodes
+func stagger(index int, count int, window time.Duration) time.Duration {
+	if window <= 0 || count <= 0 {
+		return 0
+	}
+	slot := window / time.Duration(count)
+	if slot <= 0 {
+		return 0
+	}
+	return time.Duration(index)*slot + time.Duration(rand.Int63n(int64(slot)))
+}
+
+//enqueueRecovered adds a recovered Job to the Queue after delay, waiting for the workers like Recover does. If the
+//Queue is stopped before, the Job stays pending in the journal
+func enqueueRecovered(job Job, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-quit:
+		return
+	}
+	select {
+	case Queue <- job:
+	case <-quit:
+	}
+}
+
+//closeJournal closes the journal file. Jobs still pending are recovered on the next start
+func closeJournal() {
+	if jobJournal == nil {
+		return
+	}
+	jobJournal.mutex.Lock()
+	defer jobJournal.mutex.Unlock()
+	jobJournal.file.Close()
+	jobJournal.closed = true
+}
@@ -0,0 +1,166 @@
+package jobqueue
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	. "subframe/status"
+	"testing"
+	"time"
+)
+
+//TestMain silences the logs of the tests
+func TestMain(m *testing.M) {
+	logger.Level = logger.LogtypeFatal
+	os.Exit(m.Run())
+}
+
+//setupJournal persists Jobs in a temporary data directory, recovering them without jitter into a Queue without
+//workers. Settings are restored after the test
+func setupJournal(t *testing.T) {
+	previousPath, previousPersist, previousJitter, previousQueue := settings.Current().DataPath, settings.Current().PersistJobs, settings.Current().AnnounceJitter, Queue
+	settings.Current().DataPath = t.TempDir()
+	settings.Current().PersistJobs = true
+	settings.Current().AnnounceJitter = 0
+	Queue = make(chan Job, 10)
+	t.Cleanup(func() {
+		if jobJournal != nil {
+			jobJournal.file.Close()
+			jobJournal = nil
+		}
+		settings.Current().DataPath, settings.Current().PersistJobs, settings.Current().AnnounceJitter, Queue = previousPath, previousPersist, previousJitter, previousQueue
+	})
+	Recover()
+}
+
+//crash loses the queued Jobs and the open journal without recording them done, like a killed Node
+func crash() {
+	Queue = make(chan Job, 10)
+	jobJournal.file.Close()
+	jobJournal = nil
+}
+
+//registerRecording registers a Task under name which sends the Data of its Jobs to the returned channel
+func registerRecording(t *testing.T, name string) <-chan string {
+	executed := make(chan string, 10)
+	Register(name, func(ctx context.Context, data interface{}) error {
+		executed <- data.(string)
+		return nil
+	}, func(raw []byte) (interface{}, error) {
+		var data string
+		err := json.Unmarshal(raw, &data)
+		return data, err
+	}, nil)
+	t.Cleanup(func() { delete(registry, name) })
+	return executed
+}
+
+func TestRecoverRunsJobsPendingAtCrash(t *testing.T) {
+	executed := registerRecording(t, "test/recover")
+	setupJournal(t)
+
+	if status := Enqueue(NewJob("test/recover", "payload")); status != OK {
+		t.Fatalf("Enqueue() = %d, want OK", status)
+	}
+	crash()
+
+	Recover()
+	if len(Queue) != 1 {
+		t.Fatalf("%d Jobs recovered, want 1", len(Queue))
+	}
+	job := <-Queue
+	job.execute()
+	if data := <-executed; data != "payload" {
+		t.Errorf("recovered Job ran with %q, want %q", data, "payload")
+	}
+
+	//Once executed, the Job is done and not recovered again
+	crash()
+	Recover()
+	if len(Queue) != 0 {
+		t.Errorf("%d Jobs recovered after the Job was done, want 0", len(Queue))
+	}
+}
+
+func TestRecoverDiscardsUnknownTasks(t *testing.T) {
+	registerRecording(t, "test/removed")
+	setupJournal(t)
+
+	Enqueue(NewJob("test/removed", "payload"))
+	crash()
+	delete(registry, "test/removed")
+
+	Recover()
+	if len(Queue) != 0 {
+		t.Errorf("%d Jobs of an unregistered Task recovered, want 0", len(Queue))
+	}
+	if pending := len(jobJournal.pending); pending != 0 {
+		t.Errorf("%d Jobs left pending in the journal, want 0", pending)
+	}
+}
+
+func TestStagger(t *testing.T) {
+	window := 10 * time.Second
+	slot := window / 10
+	for index := 0; index < 10; index++ {
+		delay := stagger(index, 10, window)
+		if delay < time.Duration(index)*slot || delay >= time.Duration(index+1)*slot {
+			t.Errorf("stagger(%d, 10, %v) = %v, want within [%v, %v)", index, window, delay, time.Duration(index)*slot, time.Duration(index+1)*slot)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		count  int
+		window time.Duration
+	}{
+		{"no window", 10, 0},
+		{"no Jobs", 0, window},
+		{"more Jobs than nanoseconds", 100, 10 * time.Nanosecond},
+	}
+	for _, test := range tests {
+		if delay := stagger(0, test.count, test.window); delay != 0 {
+			t.Errorf("stagger() with %s = %v, want 0", test.name, delay)
+		}
+	}
+}
+
+func TestRecoverSpreadsJobsOverWindow(t *testing.T) {
+	executed := registerRecording(t, "test/stagger")
+	setupJournal(t)
+	const count = 4
+	for index := 0; index < count; index++ {
+		Enqueue(NewJob("test/stagger", strconv.Itoa(index)))
+	}
+	crash()
+
+	settings.Current().AnnounceJitter = 1
+	window := time.Second
+	slot := window / count
+	start := time.Now()
+	Recover()
+	if len(Queue) == count {
+		t.Fatal("all Jobs recovered at once, want them spread over the window")
+	}
+	for received := 0; received < count; received++ {
+		select {
+		case job := <-Queue:
+			arrival := time.Since(start)
+			index, _ := strconv.Atoi(job.Data.(string))
+			//Jobs are recovered in the order they were journaled, each in its own slot of the window
+			if arrival < time.Duration(index)*slot {
+				t.Errorf("Job %d recovered after %v, want not before %v", index, arrival, time.Duration(index)*slot)
+			}
+			job.execute()
+			<-executed
+		case <-time.After(2 * window):
+			t.Fatalf("%d of %d Jobs recovered within %v", received, count, 2*window)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < time.Duration(count-1)*slot {
+		t.Errorf("Jobs recovered within %v, want spread over %v", elapsed, window)
+	}
+}
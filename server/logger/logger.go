@@ -1,15 +1,23 @@
 package logger
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/bclicn/color"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"subframe/status"
+	"sync"
 	"time"
 )
 
+//LogtypeDebug defines Debug-Level Logs
+const LogtypeDebug = 0
+
 //LogtypeInfo defines Info-Level Logs
 const LogtypeInfo = 1
 
@@ -25,9 +33,9 @@ const LogtypeFatal = 4
 //Initialized shows whether the Logger has been initialized
 var Initialized = false
 
-var logtypeDescriptions = [...]string{"INFO", "WARN", "ERROR", "FATAL"}
+var logtypeDescriptions = [...]string{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"}
 var logFile *os.File
-var logLogger = Logger{"logger/Logger"}
+var logLogger = Logger{Prefix: "logger/Logger"}
 
 //LogPath is the Path at which log files reside
 var LogPath string
@@ -35,27 +43,40 @@ var LogPath string
 //ColorizedLogs turns on or off colorized realtime log output
 var ColorizedLogs bool
 
+//Level is the lowest log type which is logged. Fatal logs are always logged
+var Level = LogtypeInfo
+
+//JSONLogs turns on logging JSON lines instead of formatted text, for collection by log processors
+var JSONLogs bool
+
 var logQueue []string
 
 //Init initializes the Logger
 func Init() {
-	logLogger.Info("Initializing Logger...")
-	logFile = makeLogFile()
+	logLogger.Info(status.InProgress, "Initializing Logger...")
+	file := makeLogFile()
+	logMutex.Lock()
+	logFile = file
 	Initialized = true
-	logLogger.Info("Initialized Logger")
+	logMutex.Unlock()
+	logLogger.Info(status.OK, "Initialized Logger")
 }
 
 //Close closes the Logger
 func Close() {
-	logLogger.Info("Closing Logger...")
+	logLogger.Info(status.InProgress, "Closing Logger...")
+	logMutex.Lock()
 	logFile.Close()
 	Initialized = false
-	logLogger.Info("Closed Logger.")
+	logMutex.Unlock()
+	logLogger.Info(status.OK, "Closed Logger.")
 }
 
 //Logger creates a new Logger for a specific context
 type Logger struct {
 	Prefix string
+	//Fields are attached to every log of the Logger, like the message ID of a request
+	Fields map[string]string
 }
 
 //Log holds relevant information about a log element
@@ -65,32 +86,107 @@ type Log struct {
 	Type    int
 	Status  int
 	Message string
+	Fields  map[string]string
+}
+
+type contextKey int
+
+const requestIDKey contextKey = 0
+
+//WithRequestID returns a copy of ctx carrying requestID, which correlates the logs of one operation across Nodes
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+//RequestID returns the request ID carried by ctx, or an empty string
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+//WithContext returns a copy of the Logger attaching the request ID carried by ctx to every log
+func (l Logger) WithContext(ctx context.Context) Logger {
+	if requestID := RequestID(ctx); requestID != "" {
+		return l.With("requestID", requestID)
+	}
+	return l
+}
+
+//ParseLevel returns the log type named level, like "debug" or "warn"
+func ParseLevel(level string) (logType int, ok bool) {
+	for index, description := range logtypeDescriptions {
+		if strings.EqualFold(level, description) {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+//With returns a copy of the Logger which attaches the field key to every log
+func (l Logger) With(key string, value string) Logger {
+	fields := make(map[string]string, len(l.Fields)+1)
+	for k, v := range l.Fields {
+		fields[k] = v
+	}
+	fields[key] = value
+	return Logger{Prefix: l.Prefix, Fields: fields}
+}
+
+func (l Logger) log(logType int, status int, message string) {
+	if logType < Level && logType != LogtypeFatal {
+		return
+	}
+	mainLogger(Log{
+		Time:    time.Now(),
+		Prefix:  l.Prefix,
+		Type:    logType,
+		Status:  status,
+		Message: message,
+		Fields:  l.Fields,
+	})
+}
+
+//Debug logs a debug-message
+func (l Logger) Debug(status int, message string) {
+	l.log(LogtypeDebug, status, message)
 }
 
 //Info logs an info-message
 func (l Logger) Info(status int, message string) {
-	mainLogger(Log{time.Now(), l.Prefix, LogtypeInfo, status, message})
+	l.log(LogtypeInfo, status, message)
 }
 
 //Warn logs a warn-message
 func (l Logger) Warn(status int, message string) {
-	mainLogger(Log{time.Now(), l.Prefix, LogtypeWarn, status, message})
+	l.log(LogtypeWarn, status, message)
 }
 
 //Error logs an error-message
 func (l Logger) Error(status int, message string) {
-	mainLogger(Log{time.Now(), l.Prefix, LogtypeError, status, message})
+	l.log(LogtypeError, status, message)
 }
 
 //Fatal logs a fatal message and panics
 func (l Logger) Fatal(status int, message string) {
-	mainLogger(Log{time.Now(), l.Prefix, LogtypeFatal, status, message})
+	l.log(LogtypeFatal, status, message)
 	panic(errors.New(message))
 }
 
+//logMutex serializes log output of concurrent requests and jobs
+var logMutex sync.Mutex
 var lastPrefix string
 
 func mainLogger(l Log) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	if JSONLogs {
+		line := formatLogLineJSON(l)
+		logToCLI(line)
+		logToFile(line)
+		return
+	}
+
 	if lastPrefix != "" && lastPrefix != l.Prefix {
 		//Log empty line when changing Prefixes / Contexts
 		logToCLI("")
@@ -106,6 +202,14 @@ func mainLogger(l Log) {
 	lastPrefix = l.Prefix
 }
 
+//Access writes a preformatted access log line, regardless of Level and JSONLogs
+func Access(line string) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logToCLI(line)
+	logToFile(line)
+}
+
 func logToCLI(logLine string) {
 	fmt.Println(logLine)
 }
@@ -116,17 +220,13 @@ func logToFile(logLine string) {
 		if len(logQueue) > 0 {
 
 			l := Log{
-				time.Now(),
-				"logger/Logger",
-				LogtypeInfo,
-				status.OK,
-				"Writing logQueue (" + strconv.Itoa(len(logQueue)) + " Elements) to logfile...",
-			}
-			if ColorizedLogs {
-				logToCLI(formatLogLineCLI(l))
-			} else {
-				logToCLI(formatLogLine(l))
+				Time:    time.Now(),
+				Prefix:  "logger/Logger",
+				Type:    LogtypeInfo,
+				Status:  status.OK,
+				Message: "Writing logQueue (" + strconv.Itoa(len(logQueue)) + " Elements) to logfile...",
 			}
+			logToCLI(formatLogLineFor(l))
 
 			for _, value := range logQueue {
 				logFile.WriteString(value + "\n")
@@ -135,18 +235,14 @@ func logToFile(logLine string) {
 			logQueue = logQueue[:0]
 
 			l = Log{
-				time.Now(),
-				"logger/Logger",
-				LogtypeInfo,
-				status.OK,
-				"Wrote logQueue to logfile...",
+				Time:    time.Now(),
+				Prefix:  "logger/Logger",
+				Type:    LogtypeInfo,
+				Status:  status.OK,
+				Message: "Wrote logQueue to logfile...",
 			}
 
-			if ColorizedLogs {
-				logToCLI(formatLogLineCLI(l))
-			} else {
-				logToCLI(formatLogLine(l))
-			}
+			logToCLI(formatLogLineFor(l))
 		}
 		logFile.WriteString(logLine + "\n")
 		logFile.Sync()
@@ -173,7 +269,7 @@ func formatPrefix(prefix string) (formatted string) {
 }
 
 func formatLogType(logType int) (formatted string) {
-	return "[" + logtypeDescriptions[logType-1] + "]"
+	return "[" + logtypeDescriptions[logType] + "]"
 }
 
 func formatStatusCode(status int) (formatted string) {
@@ -182,8 +278,51 @@ func formatStatusCode(status int) (formatted string) {
 
 func formatLogLine(l Log) (line string) {
 	//Format like:
-	//[Mon Jan 1 12:13:14 2019] [INFO] [logger/Init] [1000] Initialized Logger.
-	return formatTime(l.Time) + " " + formatLogType(l.Type) + " " + formatPrefix(l.Prefix) + " " + formatStatusCode(l.StatusCode) + " " + l.Message
+	//[Mon Jan 1 12:13:14 2019] [INFO] [logger/Init] [1000] Initialized Logger. action=get messageID=abc
+	return formatTime(l.Time) + " " + formatLogType(l.Type) + " " + formatPrefix(l.Prefix) + " " + formatStatusCode(l.Status) + " " + l.Message + formatFields(l.Fields)
+}
+
+//formatFields renders fields as key=value pairs, sorted by key
+func formatFields(fields map[string]string) (formatted string) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		formatted += " " + key + "=" + fields[key]
+	}
+	return formatted
+}
+
+func formatLogLineJSON(l Log) (line string) {
+	//Format like:
+	//{"level":"info","message":"Initialized Logger","prefix":"logger/Logger","status":1000,"time":"2019-01-01T12:13:14Z"}
+	entry := make(map[string]interface{}, len(l.Fields)+5)
+	for key, value := range l.Fields {
+		entry[key] = value
+	}
+	entry["time"] = l.Time.Format(time.RFC3339Nano)
+	entry["level"] = strings.ToLower(logtypeDescriptions[l.Type])
+	entry["prefix"] = l.Prefix
+	entry["status"] = l.Status
+	entry["message"] = l.Message
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return `{"level":"error","message":` + strconv.Quote("Failed to encode log line: "+err.Error()) + `}`
+	}
+	return string(encoded)
+}
+
+//formatLogLineFor formats l for the CLI like mainLogger does
+func formatLogLineFor(l Log) (line string) {
+	if JSONLogs {
+		return formatLogLineJSON(l)
+	}
+	if ColorizedLogs {
+		return formatLogLineCLI(l)
+	}
+	return formatLogLine(l)
 }
 
 func formatTimeCLI(t time.Time) (formatted string) {
@@ -197,19 +336,19 @@ func formatPrefixCLI(prefix string) (formatted string) {
 func formatLogTypeCLI(logType int) (formatted string) {
 	switch logType {
 	case LogtypeInfo:
-		return "[" + color.BGreen(logtypeDescriptions[logType-1]) + "]"
+		return "[" + color.BGreen(logtypeDescriptions[logType]) + "]"
 	case LogtypeWarn:
-		return "[" + color.BYellow(logtypeDescriptions[logType-1]) + "]"
+		return "[" + color.BYellow(logtypeDescriptions[logType]) + "]"
 	case LogtypeError:
-		return "[" + color.BLightRed(logtypeDescriptions[logType-1]) + "]"
+		return "[" + color.BLightRed(logtypeDescriptions[logType]) + "]"
 	case LogtypeFatal:
-		return "[" + color.BRed(logtypeDescriptions[logType-1]) + "]"
+		return "[" + color.BRed(logtypeDescriptions[logType]) + "]"
 	}
-	return "[" + logtypeDescriptions[logType-1] + "]"
+	return "[" + logtypeDescriptions[logType] + "]"
 }
 
 func formatLogLineCLI(l Log) (line string) {
 	//Format like:
 	//[Mon Jan 1 12:13:14 2019] [INFO] [logger/Init] Initialized Logger.
-	return formatTimeCLI(l.Time) + " " + formatLogTypeCLI(l.Type) + " " + formatPrefixCLI(l.Prefix) + " " + l.Message
+	return formatTimeCLI(l.Time) + " " + formatLogTypeCLI(l.Type) + " " + formatPrefixCLI(l.Prefix) + " " + l.Message + formatFields(l.Fields)
 }
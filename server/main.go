@@ -4,13 +4,17 @@ import (
 	"os"
 	"os/signal"
 	"subframe/server/bootstrapper"
+	"subframe/server/buildinfo"
 	"subframe/server/database"
 	"subframe/server/jobqueue"
 	"subframe/server/logger"
 	"subframe/server/networking"
 	"subframe/server/settings"
 	"subframe/server/storage"
+	"subframe/server/tracing"
 	. "subframe/status"
+	"syscall"
+	"time"
 )
 
 var log = logger.Logger{Prefix: "main/Main"}
@@ -19,6 +23,7 @@ var greeter = "                  .--.                  \n              `-/oooooo
 func main() {
 	println(greeter)
 	log.Info(OK, "Welcome to SuBFraMe Server!")
+	log.Info(OK, "Version "+buildinfo.Get().String())
 	log.Info(InProgress, "Initializing Server...")
 
 	settings.Read()
@@ -30,21 +35,33 @@ func main() {
 	logger.Init()
 	defer logger.Close()
 
-	jobqueue.SpawnWorker()
+	//Spans of the shutdown are exported before the logs are closed
+	tracing.Init()
+	defer tracing.Stop()
+
+	//Deferred calls run in reverse, so shutdown stops accepting requests first, then drains the job queue,
+	//and closes the database afterwards
+	database.Init()
+	defer database.Close()
+
+	jobqueue.Init()
+	defer jobqueue.Stop(time.Duration(settings.Current().ShutdownTimeout) * time.Second)
 
 	networking.Init()
 	defer networking.Stop()
 
-	database.Init()
-	defer database.Close()
+	//Pending Jobs need the database, and the networking Tasks registered
+	jobqueue.Recover()
 
 	bootstrapper.Bootstrap()
 
-	//Wait for interrupt, then return
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt)
+	//Wait for interrupt or termination by the orchestrator, then return. SIGHUP reloads the settings
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
-	<-c
+	for <-c == syscall.SIGHUP {
+		settings.Reload()
+	}
 	log.Info(InProgress, "Stopping SuBFraMe Server...")
 	return
 }
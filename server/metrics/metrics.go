@@ -0,0 +1,220 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//collector is a metric which can write itself in the Prometheus text format
+type collector interface {
+	write(buffer *bytes.Buffer)
+}
+
+var registryMutex sync.Mutex
+var registry []collector
+
+func register(c collector) {
+	registryMutex.Lock()
+	registry = append(registry, c)
+	registryMutex.Unlock()
+}
+
+//Handler serves all registered metrics in the Prometheus text format
+func Handler(w http.ResponseWriter, req *http.Request) {
+	var buffer bytes.Buffer
+	registryMutex.Lock()
+	for _, c := range registry {
+		c.write(&buffer)
+	}
+	registryMutex.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buffer.Bytes())
+}
+
+//CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+	mutex  sync.Mutex
+	values map[string]float64
+}
+
+//NewCounterVec creates and registers a counter with the given label names
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+//Inc increments the counter for labelValues, given in the order of the label names
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+//Add adds value to the counter for labelValues
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+	c.mutex.Lock()
+	c.values[key] += value
+	c.mutex.Unlock()
+}
+
+func (c *CounterVec) write(buffer *bytes.Buffer) {
+	writeHeader(buffer, c.name, c.help, "counter")
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, key := range sortedKeys(c.values) {
+		writeSample(buffer, c.name, key, c.values[key])
+	}
+}
+
+//GaugeFunc is a gauge whose value is read when metrics are collected
+type GaugeFunc struct {
+	name  string
+	help  string
+	value func() float64
+}
+
+//NewGaugeFunc creates and registers a gauge reporting the result of value
+func NewGaugeFunc(name string, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, value: value}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(buffer *bytes.Buffer) {
+	writeHeader(buffer, g.name, g.help, "gauge")
+	writeSample(buffer, g.name, "", g.value())
+}
+
+//DefaultBuckets are histogram buckets in seconds, suited for network request durations
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+type histogramValue struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+//HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mutex   sync.Mutex
+	values  map[string]*histogramValue
+}
+
+//NewHistogramVec creates and registers a histogram with the given upper bucket bounds and label names
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogramValue)}
+	register(h)
+	return h
+}
+
+//Observe records value in the histogram for labelValues
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := formatLabels(h.labels, labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	v, ok := h.values[key]
+	if !ok {
+		v = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	for index, bound := range h.buckets {
+		if value <= bound {
+			v.counts[index]++
+		}
+	}
+	v.sum += value
+	v.count++
+}
+
+func (h *HistogramVec) write(buffer *bytes.Buffer) {
+	writeHeader(buffer, h.name, h.help, "histogram")
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v := h.values[key]
+		for index, bound := range h.buckets {
+			writeSample(buffer, h.name+"_bucket", appendLabel(key, "le", formatFloat(bound)), float64(v.counts[index]))
+		}
+		writeSample(buffer, h.name+"_bucket", appendLabel(key, "le", "+Inf"), float64(v.count))
+		writeSample(buffer, h.name+"_sum", key, v.sum)
+		writeSample(buffer, h.name+"_count", key, float64(v.count))
+	}
+}
+
+func writeHeader(buffer *bytes.Buffer, name string, help string, metricType string) {
+	buffer.WriteString("# HELP " + name + " " + help + "\n")
+	buffer.WriteString("# TYPE " + name + " " + metricType + "\n")
+}
+
+func writeSample(buffer *bytes.Buffer, name string, labels string, value float64) {
+	buffer.WriteString(name)
+	if labels != "" {
+		buffer.WriteString("{" + labels + "}")
+	}
+	buffer.WriteString(" " + formatFloat(value) + "\n")
+}
+
+//formatLabels renders name="value" pairs, missing values are left empty
+func formatLabels(names []string, values []string) string {
+	pairs := make([]string, len(names))
+	for index, name := range names {
+		value := ""
+		if index < len(values) {
+			value = values[index]
+		}
+		pairs[index] = name + "=\"" + escapeLabelValue(value) + "\""
+	}
+	return strings.Join(pairs, ",")
+}
+
+func appendLabel(labels string, name string, value string) string {
+	if labels == "" {
+		return name + "=\"" + value + "\""
+	}
+	return labels + "," + name + "=\"" + value + "\""
+}
+
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func escapeLabelValue(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
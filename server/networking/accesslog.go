@@ -0,0 +1,67 @@
+package networking
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"time"
+)
+
+//accessLogEntry is an access log line in the json format
+type accessLogEntry struct {
+	Time      string `json:"time"`
+	Remote    string `json:"remote"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Action    string `json:"action"`
+	MessageID string `json:"messageID,omitempty"`
+	Status    int    `json:"status"`
+	Size      int64  `json:"size"`
+	Latency   int64  `json:"latencyMs"`
+	RequestID string `json:"requestID,omitempty"`
+}
+
+//writeAccessLog writes an access log line for a request handled since start, in settings.AccessLogFormat
+func writeAccessLog(req *http.Request, action string, messageID string, recorder *statusRecorder, start time.Time) {
+	if settings.Current().AccessLogFormat == "off" {
+		return
+	}
+	entry := accessLogEntry{
+		Time:      start.Format(time.RFC3339),
+		Remote:    remoteIP(req),
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		Action:    action,
+		MessageID: messageID,
+		Status:    recorder.status,
+		Size:      recorder.written,
+		Latency:   int64(time.Since(start) / time.Millisecond),
+		RequestID: logger.RequestID(req.Context()),
+	}
+
+	if settings.Current().AccessLogFormat == "json" {
+		line, err := json.Marshal(entry)
+		if err == nil {
+			logger.Access(string(line))
+		}
+		return
+	}
+	logger.Access(commonLogLine(entry, start, req.Proto))
+}
+
+//commonLogLine formats entry in the Common Log Format, followed by the latency, action, message ID and request ID
+func commonLogLine(entry accessLogEntry, start time.Time, proto string) string {
+	messageID := entry.MessageID
+	if messageID == "" {
+		messageID = "-"
+	}
+	requestID := entry.RequestID
+	if requestID == "" {
+		requestID = "-"
+	}
+	return entry.Remote + " - - [" + start.Format("02/Jan/2006:15:04:05 -0700") + "] \"" +
+		entry.Method + " " + entry.Path + " " + proto + "\" " + strconv.Itoa(entry.Status) + " " + strconv.FormatInt(entry.Size, 10) +
+		" " + strconv.FormatInt(entry.Latency, 10) + "ms " + entry.Action + " " + messageID + " " + requestID
+}
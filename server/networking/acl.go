@@ -0,0 +1,135 @@
+package networking
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	. "subframe/status"
+	"subframe/structs/message"
+	"time"
+)
+
+//maxReaders bounds the identities a message can be shared with
+const maxReaders = 64
+
+//readAllowed returns whether identity may read a message with owner and readers. Messages without owner are public,
+//and Nodes signing their requests with settings.ClusterSecret read every message to replicate it
+func readAllowed(identity string, privileged bool, owner string, readers []string) bool {
+	if owner == "" || privileged || (identity != "" && identity == owner) {
+		return true
+	}
+	for _, reader := range readers {
+		if identity != "" && identity == reader {
+			return true
+		}
+	}
+	return false
+}
+
+//denyAccess responds to a request for a message it may not access, with 401 if it presented no identity
+func denyAccess(res http.ResponseWriter, req *http.Request, identity string, id string) {
+	if identity == "" {
+		slog.Warn(SNAccessDenied, "Rejecting unauthenticated request for private Message "+id+" from "+req.RemoteAddr)
+		res.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(res, http.StatusUnauthorized, ErrorUnauthorized, "Authentication required for message "+id)
+		return
+	}
+	slog.Warn(SNAccessDenied, "Rejecting request of "+identity+" for private Message "+id+" from "+req.RemoteAddr)
+	writeError(res, http.StatusForbidden, ErrorForbidden, "Access to message "+id+" denied")
+}
+
+//authorizeRead returns whether the request may read the locally stored message id, and writes an error response otherwise
+func (r storageRequest) authorizeRead(id string) bool {
+	owner, readers, status := storage.ACL(id)
+	if status != http.StatusOK {
+		writeError(r.res, status, errorCodeForStatus(status), "Error getting message with ID "+id)
+		return false
+	}
+	if !readAllowed(r.identity, r.privileged, owner, readers) {
+		denyAccess(r.res, r.req, r.identity, id)
+		return false
+	}
+	return true
+}
+
+//authorizeDelete returns whether the request may delete the locally stored message id, which only its owner may.
+//It writes an error response otherwise
+func (r storageRequest) authorizeDelete(id string) bool {
+	owner, _, status := storage.ACL(id)
+	if status != http.StatusOK {
+		writeError(r.res, status, errorCodeForStatus(status), "Error deleting message "+id)
+		return false
+	}
+	if !readAllowed(r.identity, r.privileged, owner, nil) {
+		denyAccess(r.res, r.req, r.identity, id)
+		return false
+	}
+	return true
+}
+
+//messageACL returns the owner and readers of a message put with the request. Puts of identities are owned by them
+//and shared with the X-Readers header, copies pushed by other Nodes keep the ACL passed in the owner and readers
+//parameters. Other puts are public
+func (r storageRequest) messageACL() (owner string, readers []string, valid bool) {
+	readerList := r.req.Header.Get("X-Readers")
+	switch {
+	case r.privileged:
+		owner = r.req.URL.Query().Get("owner")
+		readerList = r.req.URL.Query().Get("readers")
+		if owner != "" && !settings.ValidIdentity(owner) {
+			return "", nil, false
+		}
+	case r.identity != "":
+		owner = r.identity
+	}
+	if readerList == "" {
+		return owner, nil, true
+	}
+	//Readers without owner would not restrict anything
+	if owner == "" {
+		return "", nil, false
+	}
+	for _, reader := range strings.Split(readerList, ",") {
+		reader = strings.TrimSpace(reader)
+		if !settings.ValidIdentity(reader) {
+			return "", nil, false
+		}
+		readers = append(readers, reader)
+	}
+	return owner, readers, len(readers) <= maxReaders
+}
+
+//replicaPutQuery returns the query pushing msg to another StorageNode, passing on its provenance, expiry, ACL, headers
+//and content type
+func replicaPutQuery(msg message.Message) string {
+	query := "/put/" + msg.ID
+	params := url.Values{}
+	if msg.Sender != "" {
+		params.Set("sender", msg.Sender)
+	}
+	if msg.Recipient != "" {
+		params.Set("recipient", msg.Recipient)
+	}
+	if msg.CreatedAt != nil {
+		params.Set("createdAt", msg.CreatedAt.Format(time.RFC3339Nano))
+	}
+	if msg.ExpiresAt != nil {
+		params.Set("expiresAt", msg.ExpiresAt.Format(time.RFC3339Nano))
+	}
+	if msg.Owner != "" {
+		params.Set("owner", msg.Owner)
+	}
+	if len(msg.Readers) > 0 {
+		params.Set("readers", strings.Join(msg.Readers, ","))
+	}
+	addMetaParams(params, msg.Headers)
+	if msg.Version != 0 {
+		params.Set("version", strconv.FormatInt(msg.Version, 10))
+	}
+	//Always set, so the copy is stored with the recorded content type, which is sniffed again if there is none
+	params.Set(contentTypeParam, msg.ContentType)
+	return query + "?" + params.Encode()
+}
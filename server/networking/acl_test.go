@@ -0,0 +1,81 @@
+package networking
+
+import (
+	"net/http"
+	"strings"
+	"subframe/server/settings"
+	"testing"
+)
+
+//withAccessTokens sets settings.AccessTokens for the duration of the test
+func withAccessTokens(t *testing.T, tokens string) {
+	previous := settings.Current().AccessTokens
+	settings.Current().AccessTokens = tokens
+	t.Cleanup(func() { settings.Current().AccessTokens = previous })
+}
+
+//bearer returns the headers presenting token
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+func TestReadAllowed(t *testing.T) {
+	readers := []string{"bob", "carol"}
+	tests := []struct {
+		name       string
+		identity   string
+		privileged bool
+		owner      string
+		readers    []string
+		want       bool
+	}{
+		{"owner", "alice", false, "alice", readers, true},
+		{"allowed reader", "bob", false, "alice", readers, true},
+		{"other identity", "mallory", false, "alice", readers, false},
+		{"no identity", "", false, "alice", readers, false},
+		{"no identity and empty reader", "", false, "alice", []string{""}, false},
+		{"public message", "", false, "", nil, true},
+		{"public message read by an identity", "mallory", false, "", nil, true},
+		{"Node replicating", "", true, "alice", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := readAllowed(test.identity, test.privileged, test.owner, test.readers); got != test.want {
+				t.Errorf("readAllowed() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPrivateMessageAccess(t *testing.T) {
+	setupNode(t)
+	withAccessTokens(t, "alice:alice-token,bob:bob-token,mallory:mallory-token")
+
+	headers := bearer("alice-token")
+	headers["X-Readers"] = "bob"
+	expectStatus(t, serve(http.MethodPost, "/storage/put/private", strings.NewReader("secret"), headers), http.StatusOK)
+	expectStatus(t, serve(http.MethodPost, "/storage/put/public", strings.NewReader("hello"), nil), http.StatusOK)
+
+	tests := []struct {
+		name       string
+		target     string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"owner", "/storage/get/private", bearer("alice-token"), http.StatusOK},
+		{"allowed reader", "/storage/get/private", bearer("bob-token"), http.StatusOK},
+		{"denied identity", "/storage/get/private", bearer("mallory-token"), http.StatusForbidden},
+		{"unauthenticated", "/storage/get/private", nil, http.StatusUnauthorized},
+		{"public message", "/storage/get/public", nil, http.StatusOK},
+		{"public message read by an identity", "/storage/get/public", bearer("mallory-token"), http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serve(http.MethodGet, test.target, nil, test.headers)
+			expectStatus(t, recorder, test.wantStatus)
+			if test.wantStatus != http.StatusOK && strings.Contains(recorder.Body.String(), "secret") {
+				t.Errorf("denied response leaks the content: %s", recorder.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"subframe/server/database"
+	"subframe/server/jobqueue"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	. "subframe/status"
+	"subframe/structs/message"
+	"time"
+)
+
+var alog = logger.Logger{Prefix: "networking/AntiEntropy"}
+
+var antiEntropyStop = make(chan bool)
+
+//digestResponse is the response of /control/digest
+type digestResponse struct {
+	Buckets []string `json:"buckets"`
+}
+
+//startAntiEntropy periodically compares the locally stored messages with random StorageNodes,
+//and pulls missing messages this Node should hold, until stopAntiEntropy is called
+func startAntiEntropy() {
+	if settings.Current().AntiEntropyInterval <= 0 {
+		alog.Info(OK, "Anti-Entropy is disabled.")
+		return
+	}
+
+	alog.Info(InProgress, "Starting Anti-Entropy with an interval of "+strconv.Itoa(settings.Current().AntiEntropyInterval)+" minutes...")
+	go func() {
+		ticker := time.NewTicker(time.Duration(settings.Current().AntiEntropyInterval) * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				syncWithPeers()
+			case <-antiEntropyStop:
+				alog.Info(OK, "Stopped Anti-Entropy.")
+				return
+			}
+		}
+	}()
+}
+
+//stopAntiEntropy stops Anti-Entropy, aborting a run in progress
+func stopAntiEntropy() {
+	close(antiEntropyStop)
+}
+
+//syncWithPeers compares the local digest with settings.AntiEntropyPeers random StorageNodes
+func syncWithPeers() {
+	//A draining Node gives its messages away instead of collecting more, a read-only one does not change its storage
+	if isDraining() || isReadOnly() {
+		return
+	}
+	status, peers := database.GetRandomStorageNodes(settings.Current().AntiEntropyPeers)
+	if status != OK || len(peers) == 0 {
+		alog.Warn(status, "No StorageNodes to sync with.")
+		return
+	}
+
+	pulled := 0
+	for _, peer := range peers {
+		pulled += syncWithPeer(peer.Address)
+	}
+	alog.Info(OK, "Synced with "+strconv.Itoa(len(peers))+" StorageNodes, pulled "+strconv.Itoa(pulled)+" Messages.")
+}
+
+//syncWithPeer pulls the messages the StorageNode at address stores in buckets whose digest differs from the local one.
+//Returns the number of pulled messages
+func syncWithPeer(address string) (pulled int) {
+	local, status := storage.Digest()
+	if status != http.StatusOK {
+		return 0
+	}
+
+	s, response := SendNodeRequest(NODE_STORAGE, address, "/control/digest", "")
+	var remote digestResponse
+	if s != OK || json.Unmarshal(response, &remote) != nil || len(remote.Buckets) != storage.DigestBuckets {
+		alog.Warn(s, "Could not get digest of StorageNode "+address)
+		return 0
+	}
+
+	for bucket := range local {
+		if local[bucket] == remote.Buckets[bucket] || remote.Buckets[bucket] == "" {
+			continue
+		}
+		select {
+		case <-antiEntropyStop:
+			return pulled
+		default:
+		}
+		pulled += syncBucket(address, bucket)
+	}
+	return pulled
+}
+
+//syncBucket pulls messages of bucket which are stored on the StorageNode at address, but missing locally or stored
+//with an older version
+func syncBucket(address string, bucket int) (pulled int) {
+	s, response := SendNodeRequest(NODE_STORAGE, address, "/control/bucket?bucket="+strconv.Itoa(bucket), "")
+	var remote messageList
+	if s != OK || json.Unmarshal(response, &remote) != nil {
+		alog.Warn(s, "Could not get bucket "+strconv.Itoa(bucket)+" of StorageNode "+address)
+		return 0
+	}
+
+	local, versions, status := storage.BucketIDs(bucket)
+	if status != http.StatusOK {
+		return 0
+	}
+	stored := make(map[string]bool, len(local))
+	for _, id := range local {
+		stored[id] = true
+	}
+
+	for _, id := range remote.IDs {
+		if !validMessageID(id) {
+			continue
+		}
+		if stored[id] {
+			//The versions of both copies are compared again when storing, including their checksums
+			if remote.Versions[id] > versions[id] && pullMessage(address, id) {
+				pulled++
+			}
+			continue
+		}
+		if _, deleted := database.CheckMessageDeletion(id); deleted {
+			continue
+		}
+		//Without the size of the message, a copy too many is preferred over a missing one
+		factor := settings.Current().MaxReplicationFactor()
+		if size, known := remote.Sizes[id]; known {
+			factor = settings.Current().ReplicationFactorFor(size)
+		}
+		if shouldHoldMessage(id, factor) && pullMessage(address, id) {
+			pulled++
+		}
+	}
+	return pulled
+}
+
+//shouldHoldMessage returns whether this Node is one of the replicas the message maps to on the hash ring, the original
+//and factor copies
+func shouldHoldMessage(id string, factor int) bool {
+	status, replicas := database.GetReplicaNodes(id, factor+1)
+	if status != OK {
+		return false
+	}
+	for _, replica := range replicas {
+		if replica.Address == settings.Current().RemoteAddress {
+			return true
+		}
+	}
+	return false
+}
+
+//pullMessage copies the message id from the StorageNode at address into local storage and announces it.
+//A locally stored copy is replaced if the pulled one has a newer version
+func pullMessage(address string, id string) bool {
+	s, response := SendNodeRequest(NODE_STORAGE, address, "/get/"+id, "")
+	var msg message.Message
+	if s != OK || json.Unmarshal(response, &msg) != nil || msg.ID != id {
+		alog.Warn(s, "Could not pull Message "+id+" from StorageNode "+address)
+		return false
+	}
+
+	//Copies of Nodes not versioning messages yet have no version, and are stored as they are
+	status := recordStored(msg, storage.PutReplica(context.Background(), msg))
+	if status != http.StatusOK {
+		alog.Error(status, "Error storing pulled Message "+id)
+		return false
+	}
+
+	alog.Info(OK, "Pulled Message "+id+" from StorageNode "+address)
+	jobqueue.Enqueue(jobqueue.NewJob(announceJob, id))
+	return true
+}
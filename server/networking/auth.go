@@ -0,0 +1,129 @@
+package networking
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"subframe/server/settings"
+	. "subframe/status"
+)
+
+//requiresAuthentication returns whether the request's action has to present settings.AuthToken
+func (r *storageRequest) requiresAuthentication() bool {
+	if settings.Current().AuthToken == "" {
+		return false
+	}
+	if r.action == "get" || r.action == "batch-get" {
+		return settings.Current().AuthenticateReads
+	}
+	return true
+}
+
+//authenticate checks the request's Bearer token and writes an error response if it is missing or wrong.
+//Tokens of settings.AccessTokens authenticate the requests of identityAllowed, settings.AdminToken those of control actions.
+//Requests of other Nodes are privileged by their signature instead, see checkSignature
+func (r *storageRequest) authenticate() bool {
+	r.identity = requestIdentity(r.req)
+	if r.action == "control" && presentsAdminToken(r.req) {
+		r.privileged = true
+		return true
+	}
+	if !r.requiresAuthentication() || (r.identity != "" && r.identityAllowed()) {
+		return true
+	}
+	return checkToken(r.res, r.req, r.action)
+}
+
+//identityAllowed returns whether the request's action may be sent by identities of settings.AccessTokens.
+//Other actions manage the Node and are reserved to settings.AuthToken
+func (r *storageRequest) identityAllowed() bool {
+	switch r.action {
+	case "get", "put", "delete", "batch-get":
+		return true
+	case "control":
+		return r.slug == "stat" || r.slug == "upload-status" || r.slug == "replicas"
+	}
+	return false
+}
+
+//requestIdentity returns the identity of settings.AccessTokens whose token req presents, or an empty string
+func requestIdentity(req *http.Request) (identity string) {
+	token, ok := bearerToken(req)
+	if !ok {
+		return ""
+	}
+	for accessToken, accessIdentity := range settings.Current().AccessTokenIdentities() {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(accessToken)) == 1 {
+			identity = accessIdentity
+		}
+	}
+	return identity
+}
+
+//checkToken verifies req presents settings.AuthToken as Bearer token, and writes an error response otherwise
+func checkToken(res http.ResponseWriter, req *http.Request, action string) bool {
+	token, ok := bearerToken(req)
+	if !ok {
+		slog.Warn(SNAuthMissingToken, "Rejecting unauthenticated "+action+" request from "+req.RemoteAddr)
+		res.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(res, http.StatusUnauthorized, ErrorUnauthorized, "Authentication required")
+		return false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(settings.Current().AuthToken)) != 1 {
+		slog.Warn(SNAuthInvalidToken, "Rejecting "+action+" request with invalid token from "+req.RemoteAddr)
+		writeError(res, http.StatusForbidden, ErrorForbidden, "Invalid token")
+		return false
+	}
+	return true
+}
+
+//adminControlActions lists the control actions of StorageNodes and CoordinatorNodes which manage the Node or expose its
+//internals, and are not sent by other Nodes. They require settings.AdminToken if it is set
+var adminControlActions = map[string]bool{
+	"compact":          true,
+	"drain":            true,
+	"drain-status":     true,
+	"list-messages":    true,
+	"locate":           true,
+	"queue-stats":      true,
+	"set-readonly":     true,
+	"storage-stats":    true,
+	"under-replicated": true,
+	"verify":           true,
+	"verify-status":    true,
+}
+
+//presentsAdminToken returns whether req presents settings.AdminToken as Bearer token
+func presentsAdminToken(req *http.Request) bool {
+	token, ok := bearerToken(req)
+	return ok && settings.Current().AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(settings.Current().AdminToken)) == 1
+}
+
+//checkAdmin verifies req presents settings.AdminToken for one of adminControlActions, and writes a 403 error response
+//otherwise. Other tokens do not grant access, even settings.AuthToken. Allows all requests if no AdminToken is set
+func checkAdmin(res http.ResponseWriter, req *http.Request, action string) bool {
+	if settings.Current().AdminToken == "" || !adminControlActions[action] || presentsAdminToken(req) {
+		return true
+	}
+	slog.Warn(SNAuthAdminRequired, "Rejecting "+action+" control request without admin token from "+req.RemoteAddr)
+	writeError(res, http.StatusForbidden, ErrorForbidden, "Admin token required")
+	return false
+}
+
+//bearerToken extracts the token from an "Authorization: Bearer <token>" header
+func bearerToken(req *http.Request) (token string, ok bool) {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	token = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	return token, token != ""
+}
+
+//setAuthHeader adds settings.AuthToken to outgoing inter-node requests
+func setAuthHeader(req *http.Request) {
+	if settings.Current().AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+settings.Current().AuthToken)
+	}
+}
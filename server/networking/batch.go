@@ -0,0 +1,121 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	. "subframe/status"
+	"subframe/structs/message"
+	"sync"
+	"sync/atomic"
+)
+
+//batchGetWorkers bounds the messages read concurrently for one batch-get request
+const batchGetWorkers = 8
+
+//handleBatchGet responds with a JSON object mapping every ID of the POSTed JSON array to its message,
+//or to an error envelope if it cannot be served
+func (r storageRequest) handleBatchGet() {
+	//IDs are quoted and separated by commas, whitespace is allowed for some slack
+	maxBody := int64(settings.Current().BatchGetMaxSize*(settings.Current().MessageIDMaxLength+4)*2 + 2)
+	body, err := ioutil.ReadAll(http.MaxBytesReader(r.res, r.req.Body, maxBody))
+	if err != nil {
+		r.log.Error(GenericInputError, "Error reading batch-get request: "+err.Error())
+		writeError(r.res, http.StatusBadRequest, ErrorTransmissionFailed, "Transmission of request body failed")
+		return
+	}
+
+	var ids []string
+	if err := json.Unmarshal(body, &ids); err != nil {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Request body has to be a JSON array of message IDs")
+		return
+	}
+	if len(ids) > settings.Current().BatchGetMaxSize {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Batch of "+strconv.Itoa(len(ids))+" IDs exceeds the maximum of "+strconv.Itoa(settings.Current().BatchGetMaxSize))
+		return
+	}
+
+	r.log.Info(InProgress, "Handling batch-get of "+strconv.Itoa(len(ids))+" Messages...")
+	results := make([]interface{}, len(ids))
+	indices := make(chan int)
+	//The encoded response is at least as large as the contents, so reading stops once they exceed the limit
+	var contentSize int64
+	limit := responseLimit()
+	var wg sync.WaitGroup
+	workers := batchGetWorkers
+	if len(ids) < workers {
+		workers = len(ids)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				if limit > 0 && atomic.LoadInt64(&contentSize) > limit {
+					continue
+				}
+				results[index] = r.getBatchMessage(ids[index])
+				if msg, ok := results[index].(message.Message); ok {
+					atomic.AddInt64(&contentSize, int64(len(msg.Content)))
+				}
+			}
+		}()
+	}
+	for index := range ids {
+		indices <- index
+	}
+	close(indices)
+	wg.Wait()
+	if err := r.req.Context().Err(); err != nil {
+		r.log.Warn(StorageRequestCanceled, "Batch-get canceled: "+err.Error())
+		writeError(r.res, StorageRequestCanceled, ErrorCanceled, "Request canceled")
+		return
+	}
+	if limit > 0 && contentSize > limit {
+		r.log.Warn(GenericInputError, "Batch-get of "+strconv.Itoa(len(ids))+" Messages exceeds settings.ResponseMaxSize.")
+		writeResponseTooLarge(r.res)
+		return
+	}
+
+	response := make(map[string]interface{}, len(ids))
+	for index, id := range ids {
+		response[id] = results[index]
+	}
+	responsedata, err := json.Marshal(response)
+	if err != nil {
+		r.log.Error(GenericInternalError, "Error serving batch-get: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error serving messages from disk")
+		return
+	}
+	if limit > 0 && int64(len(responsedata)) > limit {
+		r.log.Warn(GenericInputError, "Batch-get of "+strconv.Itoa(len(ids))+" Messages exceeds settings.ResponseMaxSize.")
+		writeResponseTooLarge(r.res)
+		return
+	}
+	r.log.Info(OK, "Serving batch-get of "+strconv.Itoa(len(ids))+" Messages...")
+	writeJSON(r.res, http.StatusOK, string(responsedata))
+}
+
+//getBatchMessage returns the entry of id in a batch-get response, either the message or an error envelope
+func (r storageRequest) getBatchMessage(id string) interface{} {
+	if !validMessageID(id) {
+		return errorResponse{Error: errorDetail{Code: ErrorInvalidRequest, Message: "Invalid message ID"}}
+	}
+	var msg message.Message
+	status := withStorageTimeout(r.req.Context(), "get", settings.Current().StorageGetTimeout, func(ctx context.Context) (status int) {
+		msg, status = storage.GetContext(ctx, id)
+		return status
+	})
+	if status != http.StatusOK {
+		return errorResponse{Error: errorDetail{Code: errorCodeForStatus(status), Message: "Error getting message with ID " + id}}
+	}
+	if !readAllowed(r.identity, r.privileged, msg.Owner, msg.Readers) {
+		slog.Warn(SNAccessDenied, "Omitting private Message "+id+" from batch-get of "+r.req.RemoteAddr)
+		return errorResponse{Error: errorDetail{Code: ErrorForbidden, Message: "Access to message " + id + " denied"}}
+	}
+	return msg
+}
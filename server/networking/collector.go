@@ -0,0 +1,108 @@
+package networking
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"subframe/server/database"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	. "subframe/status"
+	"time"
+)
+
+var glog = logger.Logger{Prefix: "networking/Collector"}
+
+var collectorStop = make(chan bool)
+
+//startCollector periodically removes expired and orphaned messages until stopCollector is called
+func startCollector() {
+	if settings.Current().CollectorInterval <= 0 {
+		glog.Info(OK, "Garbage Collector is disabled.")
+		return
+	}
+
+	glog.Info(InProgress, "Starting Garbage Collector with an interval of "+strconv.Itoa(settings.Current().CollectorInterval)+" minutes...")
+	go func() {
+		ticker := time.NewTicker(time.Duration(settings.Current().CollectorInterval) * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				collectGarbage()
+			case <-collectorStop:
+				glog.Info(OK, "Stopped Garbage Collector.")
+				return
+			}
+		}
+	}()
+}
+
+//stopCollector stops the Garbage Collector, aborting a run in progress
+func stopCollector() {
+	close(collectorStop)
+}
+
+//collectGarbage removes expired messages, deannouncing them from the CoordinatorNetwork, and orphaned message files.
+//Removals are limited to settings.CollectorRate per second
+func collectGarbage() {
+	if isReadOnly() {
+		glog.Info(OK, "Node is read-only, skipping Garbage Collection.")
+		return
+	}
+	glog.Info(InProgress, "Collecting expired and orphaned Messages...")
+	rate := settings.Current().CollectorRate
+	if rate < 1 {
+		rate = 1
+	}
+	throttle := time.NewTicker(time.Second / time.Duration(rate))
+	defer throttle.Stop()
+
+	expired, _ := storage.FindExpired()
+	_, expiredInDB := database.GetExpiredMessages()
+	expired = append(expired, expiredInDB...)
+
+	reclaimed := 0
+	seen := make(map[string]bool)
+	for _, id := range expired {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if !waitForCollector(throttle) {
+			return
+		}
+		size, _ := storage.Size(id)
+		if deleteMessage(id) == http.StatusOK {
+			enqueueDeannounce(context.Background(), id)
+			emitEvent(context.Background(), eventExpired, id, size)
+			reclaimed++
+		}
+	}
+
+	orphans, _ := storage.FindOrphans()
+	for _, id := range orphans {
+		if !waitForCollector(throttle) {
+			return
+		}
+		storage.Delete(id)
+		reclaimed++
+	}
+
+	if settings.Current().UploadExpiry > 0 {
+		storage.ExpireUploads(time.Duration(settings.Current().UploadExpiry) * time.Hour)
+	}
+
+	glog.Info(OK, "Reclaimed "+strconv.Itoa(reclaimed)+" Messages ("+strconv.Itoa(len(seen))+" expired, "+strconv.Itoa(len(orphans))+" orphaned).")
+}
+
+//waitForCollector waits for the next removal slot, returning false if the Collector is being stopped
+func waitForCollector(throttle *time.Ticker) bool {
+	select {
+	case <-throttle.C:
+		return true
+	case <-collectorStop:
+		return false
+	}
+}
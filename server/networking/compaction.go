@@ -0,0 +1,63 @@
+package networking
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	. "subframe/status"
+	"time"
+)
+
+var colog = logger.Logger{Prefix: "networking/Compaction"}
+
+var compactionStop = make(chan bool)
+
+//startCompaction periodically packs small messages into segment files until stopCompaction is called
+func startCompaction() {
+	if settings.Current().CompactionInterval <= 0 {
+		colog.Info(OK, "Compaction is disabled.")
+		return
+	}
+
+	colog.Info(InProgress, "Starting Compaction with an interval of "+strconv.Itoa(settings.Current().CompactionInterval)+" minutes...")
+	go func() {
+		ticker := time.NewTicker(time.Duration(settings.Current().CompactionInterval) * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				//Read-only Nodes are compacted on request only
+				if !isReadOnly() {
+					storage.Compact()
+				}
+			case <-compactionStop:
+				colog.Info(OK, "Stopped Compaction.")
+				return
+			}
+		}
+	}()
+}
+
+//stopCompaction stops the periodic Compaction. A compaction in progress is finished
+func stopCompaction() {
+	close(compactionStop)
+}
+
+//handleCompact compacts the storage and responds with the result once done. Compactions in progress are waited for
+func (r storageRequest) handleCompact() {
+	result, status := storage.Compact()
+	if status != http.StatusOK {
+		writeError(r.res, status, errorCodeForStatus(status), "Error compacting storage")
+		return
+	}
+	response, err := json.Marshal(result)
+	if err != nil {
+		r.log.Error(GenericInternalError, "Failed to export compaction result: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error compacting storage")
+		return
+	}
+	writeJSON(r.res, http.StatusOK, string(response))
+}
@@ -0,0 +1,34 @@
+package networking
+
+import (
+	"net/http"
+	"strconv"
+	"subframe/server/settings"
+	. "subframe/status"
+	"sync/atomic"
+)
+
+//inFlight is the number of requests currently handled by the StorageNode and CoordinatorNode APIs
+var inFlight int64
+
+//overloadRetryAfter is the time in seconds clients are asked to wait once settings.MaxConcurrentRequests is reached
+const overloadRetryAfter = "1"
+
+//acquireRequest counts a request as in flight, or writes an error response to res and returns false if
+//settings.MaxConcurrentRequests are handled already. The returned release has to be called once the request is
+//handled. The limit is read on every request, so reloading settings applies it to subsequent requests
+func acquireRequest(res http.ResponseWriter) (release func(), ok bool) {
+	limit := int64(settings.Current().MaxConcurrentRequests)
+	if limit <= 0 {
+		return func() {}, true
+	}
+	if atomic.AddInt64(&inFlight, 1) <= limit {
+		return func() { atomic.AddInt64(&inFlight, -1) }, true
+	}
+	atomic.AddInt64(&inFlight, -1)
+
+	slog.Warn(GenericInternalError, "Handling "+strconv.FormatInt(limit, 10)+" requests already, rejecting request.")
+	res.Header().Set("Retry-After", overloadRetryAfter)
+	writeError(res, http.StatusServiceUnavailable, ErrorOverloaded, "Node is handling too many requests")
+	return nil, false
+}
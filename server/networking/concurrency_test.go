@@ -0,0 +1,62 @@
+package networking
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"subframe/server/settings"
+	"testing"
+)
+
+//withMaxConcurrentRequests sets settings.MaxConcurrentRequests for the duration of the test
+func withMaxConcurrentRequests(t *testing.T, limit int) {
+	previous := settings.Current().MaxConcurrentRequests
+	settings.Current().MaxConcurrentRequests = limit
+	t.Cleanup(func() { settings.Current().MaxConcurrentRequests = previous })
+}
+
+func TestAcquireRequestSheds(t *testing.T) {
+	withMaxConcurrentRequests(t, 3)
+
+	var releases []func()
+	for index := 0; index < 3; index++ {
+		release, ok := acquireRequest(httptest.NewRecorder())
+		if !ok {
+			t.Fatalf("request %d of 3 was shed", index+1)
+		}
+		releases = append(releases, release)
+	}
+
+	recorder := httptest.NewRecorder()
+	if _, ok := acquireRequest(recorder); ok {
+		t.Fatal("request 4 of 3 was acquired")
+	}
+	expectStatus(t, recorder, http.StatusServiceUnavailable)
+	if retryAfter := recorder.Header().Get("Retry-After"); retryAfter != overloadRetryAfter {
+		t.Errorf("Retry-After = %q, want %q", retryAfter, overloadRetryAfter)
+	}
+	//Requests beyond the limit are rejected before they are handled
+	setupNode(t)
+	expectStatus(t, serve(http.MethodGet, "/storage/get/abc", nil, nil), http.StatusServiceUnavailable)
+
+	releases[0]()
+	release, ok := acquireRequest(httptest.NewRecorder())
+	if !ok {
+		t.Fatal("request was shed after another one was released")
+	}
+	release()
+	for _, release := range releases[1:] {
+		release()
+	}
+	if inFlight != 0 {
+		t.Errorf("%d requests in flight after all were released, want 0", inFlight)
+	}
+}
+
+func TestAcquireRequestWithoutLimit(t *testing.T) {
+	withMaxConcurrentRequests(t, 0)
+	for index := 0; index < 100; index++ {
+		if _, ok := acquireRequest(httptest.NewRecorder()); !ok {
+			t.Fatal("request was shed without limit")
+		}
+	}
+}
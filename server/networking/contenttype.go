@@ -0,0 +1,81 @@
+package networking
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"subframe/server/settings"
+	. "subframe/status"
+)
+
+//genericContentType is served for messages without recorded content type. Declaring it tells nothing about the
+//content, so the content type is sniffed instead
+const genericContentType = "application/octet-stream"
+
+//contentTypeParam passes the recorded content type of a message to other StorageNodes, whose pushed copies are sent
+//with the raw content type
+const contentTypeParam = "contentType"
+
+//parseContentType returns contentType normalized, or an empty string if it is no media type or genericContentType
+func parseContentType(contentType string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.Contains(mediaType, "/") || mediaType == genericContentType {
+		return ""
+	}
+	return mime.FormatMediaType(mediaType, params)
+}
+
+//writeUnsupportedContentType writes a 415 response to a put of contentType, which settings.AllowedContentTypes does not
+//list
+func (r storageRequest) writeUnsupportedContentType(contentType string) {
+	r.log.Warn(GenericInputError, "Content type "+contentType+" of Message "+r.slug+" is not allowed, denying storage request.")
+	writeError(r.res, http.StatusUnsupportedMediaType, ErrorUnsupportedType, "Content type "+contentType+" is not allowed, expected one of "+settings.Current().AllowedContentTypes)
+}
+
+//isCopy returns whether the put request pushes a copy of another Node, which is stored with its recorded content
+//type regardless of settings.AllowedContentTypes. Only requests signed by Nodes push copies
+func (r storageRequest) isCopy() bool {
+	_, copied := r.req.URL.Query()[contentTypeParam]
+	return copied && r.privileged
+}
+
+//declaredContentType returns the content type declared for the message put with the request, or an empty string.
+//partType is the Content-Type of the multipart part holding the content, if any. Copies pushed by other Nodes pass
+//the recorded content type in the contentType parameter instead
+func (r storageRequest) declaredContentType(partType string) string {
+	if r.isCopy() {
+		return parseContentType(r.req.URL.Query().Get(contentTypeParam))
+	}
+	if isMultipart(r.req) {
+		return parseContentType(partType)
+	}
+	return parseContentType(r.req.Header.Get("Content-Type"))
+}
+
+//messageContentType returns the declared content type of a message, or the one sniffed from the first 512 bytes of
+//content by http.DetectContentType if none was declared
+func messageContentType(declared string, content []byte) string {
+	if declared != "" {
+		return declared
+	}
+	return http.DetectContentType(content)
+}
+
+//contentTypeAllowed returns whether messages of contentType may be put, see settings.AllowedContentTypes.
+//Listed types match regardless of parameters, a listed type/* matches all subtypes of type
+func contentTypeAllowed(contentType string) bool {
+	allowed := settings.Current().ContentTypes()
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, entry := range allowed {
+		if entry == mediaType || strings.HasSuffix(entry, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(entry, "*")) {
+			return true
+		}
+	}
+	return false
+}
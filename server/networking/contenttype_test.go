@@ -0,0 +1,182 @@
+package networking
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	"testing"
+)
+
+//pngContent starts with the signature http.DetectContentType sniffs as image/png
+const pngContent = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+//withAllowedContentTypes sets settings.AllowedContentTypes for the duration of the test
+func withAllowedContentTypes(t *testing.T, contentTypes string) {
+	previous := settings.Current().AllowedContentTypes
+	settings.Current().AllowedContentTypes = contentTypes
+	t.Cleanup(func() { settings.Current().AllowedContentTypes = previous })
+}
+
+//storedContentType returns the content type the raw content of message id is served with
+func storedContentType(t *testing.T, id string) string {
+	t.Helper()
+	recorder := serve(http.MethodGet, "/storage/get/"+id, nil, map[string]string{"Accept": "application/octet-stream"})
+	expectStatus(t, recorder, http.StatusOK)
+	return recorder.Header().Get("Content-Type")
+}
+
+func TestParseContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        string
+	}{
+		{"text/plain", "text/plain"},
+		{"Text/HTML; Charset=UTF-8", "text/html; charset=UTF-8"},
+		{"image/png ", "image/png"},
+		{"application/octet-stream", ""},
+		{"text", ""},
+		{"text/plain; charset", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := parseContentType(test.contentType); got != test.want {
+			t.Errorf("parseContentType(%q) = %q, want %q", test.contentType, got, test.want)
+		}
+	}
+}
+
+func TestMessageContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		declared string
+		content  string
+		want     string
+	}{
+		{"declared", "application/json", "plain text", "application/json"},
+		{"sniffed text", "", "plain text", "text/plain; charset=utf-8"},
+		{"sniffed image", "", pngContent, "image/png"},
+		{"sniffed binary", "", "\x00\x01\x02", "application/octet-stream"},
+	}
+	for _, test := range tests {
+		if got := messageContentType(test.declared, []byte(test.content)); got != test.want {
+			t.Errorf("messageContentType() of %s = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestContentTypeAllowed(t *testing.T) {
+	withAllowedContentTypes(t, " Text/Plain, ,image/* ")
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"text/plain", true},
+		{"text/plain; charset=utf-8", true},
+		{"TEXT/PLAIN", true},
+		{"image/png", true},
+		{"image/svg+xml", true},
+		{"text/html", false},
+		{"imagex/png", false},
+		{"image", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := contentTypeAllowed(test.contentType); got != test.want {
+			t.Errorf("contentTypeAllowed(%q) = %v, want %v", test.contentType, got, test.want)
+		}
+	}
+
+	withAllowedContentTypes(t, "")
+	if !contentTypeAllowed("application/x-anything") {
+		t.Error("contentTypeAllowed() without allowed content types = false, want all allowed")
+	}
+}
+
+func TestPutRecordsContentType(t *testing.T) {
+	setupNode(t)
+	body, multipartType := multipartBody(t, formPart{name: "file", fileName: "data.json", contentType: "application/json", content: "{}"})
+	tests := []struct {
+		name        string
+		body        io.Reader
+		contentType string
+		want        string
+	}{
+		{"declared", strings.NewReader("plain text"), "application/json", "application/json"},
+		{"declared with parameters", strings.NewReader("plain text"), "Text/Plain; Charset=ISO-8859-1", "text/plain; charset=ISO-8859-1"},
+		{"sniffed without declaration", strings.NewReader(pngContent), "", "image/png"},
+		{"sniffed instead of octet-stream", strings.NewReader(pngContent), "application/octet-stream", "image/png"},
+		{"sniffed instead of malformed", strings.NewReader("plain text"), "text", "text/plain; charset=utf-8"},
+		{"declared by the file part", body, multipartType, "application/json"},
+	}
+	for index, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id := "typed" + string(rune('a'+index))
+			headers := map[string]string{}
+			if test.contentType != "" {
+				headers["Content-Type"] = test.contentType
+			}
+			expectStatus(t, serve(http.MethodPost, "/storage/put/"+id, test.body, headers), http.StatusOK)
+			if got := storedContentType(t, id); got != test.want {
+				t.Errorf("stored content type = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnsupportedContentType(t *testing.T) {
+	setupNode(t)
+	withAllowedContentTypes(t, "text/*")
+	tests := []struct {
+		name        string
+		body        io.Reader
+		contentType string
+	}{
+		//Declared content types are rejected before the body is read
+		{"declared", unreadBody{t}, "image/png"},
+		{"sniffed", strings.NewReader(pngContent), ""},
+		{"sniffed instead of octet-stream", strings.NewReader(pngContent), "application/octet-stream"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if test.contentType != "" {
+				headers["Content-Type"] = test.contentType
+			}
+			recorder := serve(http.MethodPost, "/storage/put/image", test.body, headers)
+			expectStatus(t, recorder, http.StatusUnsupportedMediaType)
+			var response errorResponse
+			json.Unmarshal(recorder.Body.Bytes(), &response)
+			if response.Error.Code != ErrorUnsupportedType || !strings.Contains(response.Error.Message, "text/*") {
+				t.Errorf("error = %+v, want %s listing the allowed content types", response.Error, ErrorUnsupportedType)
+			}
+			if _, status := storage.Get("image"); status != http.StatusNotFound {
+				t.Errorf("get after rejected put = %d, want %d", status, http.StatusNotFound)
+			}
+		})
+	}
+
+	expectStatus(t, serve(http.MethodPost, "/storage/put/text", strings.NewReader("plain text"), nil), http.StatusOK)
+}
+
+func TestCopiesKeepContentType(t *testing.T) {
+	setupNode(t)
+	withClusterSecret(t, "secret")
+	withAllowedContentTypes(t, "text/*")
+
+	//Signed copies are stored with the content type of the original, even if it is no longer allowed
+	req := newSignedRequest(t, http.MethodPost, "/storage/put/copy?contentType=image%2Fpng", []byte("plain text"))
+	recorder := httptest.NewRecorder()
+	handleRequest(recorder, req)
+	expectStatus(t, recorder, http.StatusOK)
+	if got := storedContentType(t, "copy"); got != "image/png" {
+		t.Errorf("stored content type of the copy = %q, want image/png", got)
+	}
+
+	//Unsigned puts cannot pass a content type as copy
+	recorder = serve(http.MethodPost, "/storage/put/forged?contentType=image%2Fpng", strings.NewReader(pngContent), nil)
+	expectStatus(t, recorder, http.StatusUnsupportedMediaType)
+}
@@ -1,3 +1,480 @@
 package networking
 
-import ()
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"subframe/server/database"
+	"subframe/server/jobqueue"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	. "subframe/status"
+	"subframe/structs/node"
+	"time"
+)
+
+var clog = logger.Logger{Prefix: "networking/CoordinatorNode"}
+
+//coordinatorNodeActions maps every valid CoordinatorNode action to the HTTP methods it accepts
+var coordinatorNodeActions = map[string][]string{
+	"announce":      {http.MethodGet},
+	"bulk-announce": {http.MethodPost},
+	"deannounce":    {http.MethodGet},
+	"control":       {http.MethodGet},
+	"read":          {http.MethodGet},
+	"status":        {http.MethodGet},
+}
+
+//registerCoordinatorNodeAPI serves the CoordinatorNode API next to the StorageNode API
+func registerCoordinatorNodeAPI() {
+	http.HandleFunc("/coordinator/", handleCoordinatorRequest)
+}
+
+//coordinatorRequest is a request to /coordinator/<action>/<args...>
+type coordinatorRequest struct {
+	res    http.ResponseWriter
+	req    *http.Request
+	action string
+	args   []string
+	log    logger.Logger
+	//identity is set for requests presenting a token of settings.AccessTokens, privileged for requests signed by other
+	//Nodes
+	identity   string
+	privileged bool
+}
+
+func handleCoordinatorRequest(res http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	req = withRequestID(res, req)
+	req, span := startRequestSpan(req)
+	log := clog.WithContext(req.Context())
+	log.Info(InProgress, "Handling incoming "+req.Method+" request to "+req.URL.Path+"...")
+	compressed, finish := compressResponse(res, req)
+	defer finish()
+	responseWriter := &statusRecorder{ResponseWriter: compressed, status: http.StatusOK}
+	request := coordinatorRequest{
+		res: responseWriter,
+		req: req,
+		log: log,
+	}
+	defer func() {
+		countRequest(request.action, coordinatorNodeActions, responseWriter)
+		//Control requests name a subaction instead of a message, bulk announcements a StorageNode
+		messageID := ""
+		if len(request.args) > 0 && request.action != "control" && request.action != "bulk-announce" {
+			messageID = request.args[0]
+		}
+		writeAccessLog(req, request.action, messageID, responseWriter, start)
+		endRequestSpan(span, req, "coordinator/"+request.action, messageID, responseWriter)
+	}()
+
+	release, acquired := acquireRequest(responseWriter)
+	if !acquired {
+		return
+	}
+	defer release()
+
+	if !checkRateLimit(responseWriter, req) {
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/coordinator/"), "/")
+	allowed, validAction := coordinatorNodeActions[parts[0]]
+	if !validAction || len(parts) < 2 || parts[1] == "" {
+		log.Info(GenericInputError, "Action or arguments for "+req.URL.Path+" are invalid")
+		writeError(responseWriter, http.StatusBadRequest, ErrorInvalidRequest, "Invalid Action or Arguments")
+		return
+	}
+	request.action = parts[0]
+	request.args = parts[1:]
+
+	if !methodAllowed(req.Method, allowed) {
+		responseWriter.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(responseWriter, http.StatusMethodNotAllowed, ErrorInvalidMethod, req.Method+" is not allowed here.")
+		return
+	}
+
+	//Identities of settings.AccessTokens may only read, settings.AdminToken only use control actions
+	request.identity = requestIdentity(req)
+	identityAllowed := request.identity != "" && request.isRead()
+	identityAllowed = identityAllowed || (request.action == "control" && presentsAdminToken(req))
+	if request.requiresAuthentication() && !identityAllowed && !checkToken(responseWriter, req, request.action) {
+		return
+	}
+	signed, ok := checkSignature(responseWriter, req, request.action)
+	if !ok {
+		return
+	}
+	request.privileged = signed
+
+	switch request.action {
+	case "announce":
+		request.handleAnnounce()
+	case "bulk-announce":
+		request.handleBulkAnnounce()
+	case "deannounce":
+		request.handleDeannounce()
+	case "control":
+		request.handleControl()
+	case "read":
+		request.handleRead()
+	case "status":
+		request.handleStatus()
+	}
+}
+
+//requiresAuthentication returns whether the request has to present settings.AuthToken.
+//Looking up locations, statuses and messages are reads, announcements change the location index
+func (r coordinatorRequest) requiresAuthentication() bool {
+	if settings.Current().AuthToken == "" {
+		return false
+	}
+	if r.isRead() {
+		return settings.Current().AuthenticateReads
+	}
+	return true
+}
+
+//isRead returns whether the request only reads the location index or messages
+func (r coordinatorRequest) isRead() bool {
+	return r.action == "control" || r.action == "read" || r.action == "status"
+}
+
+//methodAllowed returns whether method is one of allowed
+func methodAllowed(method string, allowed []string) bool {
+	for _, value := range allowed {
+		if method == value {
+			return true
+		}
+	}
+	return false
+}
+
+//location splits the arguments of (de)announcements into message ID and normalized StorageNode address.
+//Addresses may contain slashes if they include a scheme
+func (r coordinatorRequest) location() (messageID string, address string, ok bool) {
+	if len(r.args) < 2 {
+		return "", "", false
+	}
+	messageID = r.args[0]
+	address, err := node.NormalizeAddress(strings.Join(r.args[1:], "/"))
+	return messageID, address, validMessageID(messageID) && err == nil
+}
+
+//replicationFactor returns the ?replicationFactor=<n> StorageNodes send with announcements, the replication factor for
+//the size of the announced messages, or 0 if it is not sent. Responds with 400 if it is not a positive integer
+func (r coordinatorRequest) replicationFactor() (factor int, ok bool) {
+	raw := r.req.URL.Query().Get("replicationFactor")
+	if raw == "" {
+		return 0, true
+	}
+	factor, err := strconv.Atoi(raw)
+	if err != nil || factor < 1 {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "replicationFactor has to be a positive integer")
+		return 0, false
+	}
+	return factor, true
+}
+
+//handleAnnounce records a StorageNode storing a message, and responds with whether it should redistribute the message.
+//StorageNodes send the replication factor for the size of the message with ?replicationFactor=<n>
+func (r coordinatorRequest) handleAnnounce() {
+	messageID, address, ok := r.location()
+	if !ok {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Expected /announce/<id>/<address>")
+		return
+	}
+	factor, ok := r.replicationFactor()
+	if !ok {
+		return
+	}
+
+	redistribute, ok := recordLocations([]string{messageID}, address, factor)
+	if !ok {
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error recording location of message "+messageID)
+		return
+	}
+	r.log.Info(OK, "Message "+messageID+" announced by "+address+". Redistributing: "+strconv.FormatBool(redistribute[messageID]))
+	writeResponse(r.res, http.StatusOK, strconv.FormatBool(redistribute[messageID]))
+}
+
+//handleBulkAnnounce records the StorageNode /bulk-announce/<address> storing every message of the POSTed JSON array
+//of IDs, e.g. when it comes back online. It responds with a JSON object mapping every ID to whether it should be
+//redistributed, like handleAnnounce. Messages of different replication factors are announced separately
+func (r coordinatorRequest) handleBulkAnnounce() {
+	address, err := node.NormalizeAddress(strings.Join(r.args, "/"))
+	if err != nil {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Expected /bulk-announce/<address>")
+		return
+	}
+	factor, ok := r.replicationFactor()
+	if !ok {
+		return
+	}
+
+	//IDs are quoted and separated by commas, whitespace is allowed for some slack
+	maxBody := int64(settings.Current().BulkAnnounceMaxSize*(settings.Current().MessageIDMaxLength+4)*2 + 2)
+	body, err := ioutil.ReadAll(http.MaxBytesReader(r.res, r.req.Body, maxBody))
+	if err != nil {
+		r.log.Error(GenericInputError, "Error reading bulk-announce request: "+err.Error())
+		writeError(r.res, http.StatusBadRequest, ErrorTransmissionFailed, "Transmission of request body failed")
+		return
+	}
+	var ids []string
+	if err := json.Unmarshal(body, &ids); err != nil {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Request body has to be a JSON array of message IDs")
+		return
+	}
+	if len(ids) > settings.Current().BulkAnnounceMaxSize {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Bulk announcement of "+strconv.Itoa(len(ids))+" IDs exceeds the maximum of "+strconv.Itoa(settings.Current().BulkAnnounceMaxSize))
+		return
+	}
+	for _, id := range ids {
+		if !validMessageID(id) {
+			writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid message ID "+strconv.Quote(id))
+			return
+		}
+	}
+
+	redistribute, ok := recordLocations(ids, address, factor)
+	if !ok {
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error recording locations of "+address)
+		return
+	}
+	response, err := json.Marshal(redistribute)
+	if err != nil {
+		r.log.Error(GenericInternalError, "Error marshalling bulk-announce response: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error recording locations of "+address)
+		return
+	}
+	r.log.Info(OK, strconv.Itoa(len(ids))+" Messages announced by "+address+".")
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+//recordLocations records the StorageNode at address storing the messages with ids and their replication factor, 0 if
+//it is unknown, and returns whether each of them should be redistributed. Redistribution is only needed while a
+//message is stored on fewer Nodes than its recorded replication factor, see database.GetReplicationFactors
+func recordLocations(ids []string, address string, factor int) (redistribute map[string]bool, ok bool) {
+	if database.AddMessageLocations(ids, address, factor) != OK {
+		return nil, false
+	}
+	status, counts := database.CountMessageLocations(ids)
+	if status != OK {
+		return nil, false
+	}
+	status, factors := database.GetReplicationFactors(ids, settings.Current().ReplicationFactor)
+	if status != OK {
+		return nil, false
+	}
+	redistribute = make(map[string]bool, len(ids))
+	for _, id := range ids {
+		redistribute[id] = counts[id] < factors[id]
+	}
+	return redistribute, true
+}
+
+//handleDeannounce removes a StorageNode from the locations of a message. Removing a location which is not recorded
+//succeeds as well, so StorageNodes can retry deannouncements
+func (r coordinatorRequest) handleDeannounce() {
+	messageID, address, ok := r.location()
+	if !ok {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Expected /deannounce/<id>/<address>")
+		return
+	}
+
+	if database.RemoveMessageLocation(messageID, address) != OK {
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error removing location of message "+messageID)
+		return
+	}
+	writeResponse(r.res, http.StatusOK, "Removed location "+address+" of message "+messageID)
+}
+
+//messageStatusStored is the status of messages stored on StorageNodes, see handleStatus
+const messageStatusStored = 1
+
+//handleStatus responds with the status of the message /status/<id>, messageStatusStored while StorageNodes are
+//recorded storing it, which StorageNodes refresh their local status from. Unknown messages are answered with 404
+func (r coordinatorRequest) handleStatus() {
+	messageID := r.args[0]
+	if !validMessageID(messageID) {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Expected /status/<id>")
+		return
+	}
+	status, counts := database.CountMessageLocations([]string{messageID})
+	if status != OK {
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error getting status of message "+messageID)
+		return
+	}
+	if counts[messageID] == 0 {
+		writeError(r.res, http.StatusNotFound, ErrorNotFound, "Message "+messageID+" not found")
+		return
+	}
+	writeResponse(r.res, http.StatusOK, strconv.Itoa(messageStatusStored))
+}
+
+func (r coordinatorRequest) handleControl() {
+	if !checkAdmin(r.res, r.req, r.args[0]) {
+		return
+	}
+	switch r.args[0] {
+	case "locate":
+		r.printMessageLocations()
+	case "under-replicated":
+		r.printUnderReplicated()
+	case "status":
+		r.printReplicationStatus()
+	default:
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Unknown control action "+r.args[0])
+	}
+}
+
+//replicationStatus is the response of /control/status/<id>
+type replicationStatus struct {
+	ID       string `json:"id"`
+	Replicas int    `json:"replicas"`
+	//ReplicationFactor is the one recorded for the message, see database.GetReplicationFactors
+	ReplicationFactor int `json:"replicationFactor"`
+	//Target is the number of StorageNodes which should store the message, the original and ReplicationFactor copies
+	Target int `json:"target"`
+	//State is "replicated", "under-replicated" or "over-replicated"
+	State string `json:"state"`
+}
+
+//printReplicationStatus responds with the number of StorageNodes storing the message /control/status/<id>
+//compared to the target of its replication factor of copies besides the original, so clients can confirm a message is durable
+func (r coordinatorRequest) printReplicationStatus() {
+	if len(r.args) < 2 || r.args[1] == "" {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Expected /control/status/<id>")
+		return
+	}
+	messageID := r.args[1]
+
+	status, locations := database.GetMessageLocations(messageID)
+	if status != OK {
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error locating message "+messageID)
+		return
+	}
+	if len(locations) == 0 {
+		writeError(r.res, http.StatusNotFound, ErrorNotFound, "Message "+messageID+" not found")
+		return
+	}
+
+	status, factors := database.GetReplicationFactors([]string{messageID}, settings.Current().ReplicationFactor)
+	if status != OK {
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error getting status of message "+messageID)
+		return
+	}
+
+	report := replicationStatus{ID: messageID, Replicas: len(locations), ReplicationFactor: factors[messageID], State: "replicated"}
+	report.Target = report.ReplicationFactor + 1
+	if report.Replicas < report.Target {
+		report.State = "under-replicated"
+	} else if report.Replicas > report.Target {
+		report.State = "over-replicated"
+	}
+
+	response, err := json.Marshal(report)
+	if err != nil {
+		r.log.Error(GenericInternalError, "Error marshalling replication status of Message "+messageID+": "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error getting status of message "+messageID)
+		return
+	}
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+//printMessageLocations responds with the JSON list of StorageNode addresses storing the message /control/locate/<id>
+func (r coordinatorRequest) printMessageLocations() {
+	if len(r.args) < 2 || r.args[1] == "" {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Expected /control/locate/<id>")
+		return
+	}
+	messageID := r.args[1]
+
+	status, locations := database.GetMessageLocations(messageID)
+	if status != OK {
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error locating message "+messageID)
+		return
+	}
+
+	response, err := json.Marshal(locations)
+	if err != nil {
+		r.log.Error(GenericInternalError, "Error marshalling locations of Message "+messageID+": "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error locating message "+messageID)
+		return
+	}
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+//printUnderReplicated responds with the number of messages stored on fewer StorageNodes than the original and their
+//replication factor of copies, and settings.ReplicationFactor, the factor of messages announced without one
+func (r coordinatorRequest) printUnderReplicated() {
+	status, count := database.CountUnderReplicatedMessages(settings.Current().ReplicationFactor)
+	if status != OK {
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error counting under-replicated messages")
+		return
+	}
+
+	response, err := json.Marshal(map[string]int{
+		"count":             count,
+		"replicationFactor": settings.Current().ReplicationFactor,
+	})
+	if err != nil {
+		r.log.Error(GenericInternalError, "Error marshalling under-replicated messages: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error counting under-replicated messages")
+		return
+	}
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+//pruneNodeLocations removes a dead StorageNode from the location index,
+//and queues asking the remaining holders of its messages to re-replicate them
+func pruneNodeLocations(address string) {
+	status, ids := database.RemoveNodeLocations(address)
+	if status != OK || len(ids) == 0 {
+		return
+	}
+
+	clog.Info(InProgress, "Queueing re-replication of "+strconv.Itoa(len(ids))+" Messages stored on dead Node "+address+"...")
+	jobqueue.Enqueue(jobqueue.NewJob(repairReplicationJob, ids))
+}
+
+//repairReplication asks a remaining holder of every under-replicated message in data to redistribute it, until the
+//original and its replication factor of copies are stored
+func repairReplication(ctx context.Context, data interface{}) error {
+	ids, ok := data.([]string)
+	if !ok {
+		clog.Error(GenericInternalError, "Error starting Re-Replication Thread")
+		return errors.New("re-replication job without message IDs")
+	}
+	log := clog.WithContext(ctx)
+
+	status, factors := database.GetReplicationFactors(ids, settings.Current().ReplicationFactor)
+	if status != OK {
+		return errors.New("failed to get replication factors of messages to re-replicate")
+	}
+	for _, id := range ids {
+		_, locations := database.GetMessageLocations(id)
+		if len(locations) > factors[id] {
+			continue
+		}
+		if len(locations) == 0 {
+			log.Error(GenericInternalError, "Message "+id+" is not stored on any known StorageNode anymore.")
+			continue
+		}
+
+		//Replicas counts the copies besides the one on the redistributing Node
+		query := "/redistribute/" + id + "?replicas=" + strconv.Itoa(len(locations)-1)
+		for _, address := range locations {
+			status, _ := SendNodeRequestContext(ctx, NODE_STORAGE, address, query, "")
+			if status == OK {
+				break
+			}
+			log.Warn(status, "StorageNode "+address+" failed to redistribute Message "+id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"subframe/server/database"
+	"subframe/server/settings"
+	"sync"
+	"testing"
+)
+
+//serveCoordinator handles a request to the CoordinatorNode API
+func serveCoordinator(method string, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handleCoordinatorRequest(recorder, httptest.NewRequest(method, target, nil))
+	return recorder
+}
+
+func TestCoordinatorMessageStatus(t *testing.T) {
+	setupNode(t)
+	database.AddMessageLocation("abc", "10.0.0.1:9123")
+
+	recorder := serveCoordinator(http.MethodGet, "/coordinator/status/abc")
+	expectStatus(t, recorder, http.StatusOK)
+	if body := strings.TrimSpace(recorder.Body.String()); body != "1" {
+		t.Errorf("status of a stored message = %q, want 1", body)
+	}
+	expectStatus(t, serveCoordinator(http.MethodGet, "/coordinator/status/unknown"), http.StatusNotFound)
+
+	//Once its last location is removed, the message is unknown
+	database.RemoveMessageLocation("abc", "10.0.0.1:9123")
+	expectStatus(t, serveCoordinator(http.MethodGet, "/coordinator/status/abc"), http.StatusNotFound)
+}
+
+//withReplicationFactor sets settings.ReplicationFactor without size tiers for the duration of the test
+func withReplicationFactor(t *testing.T, factor int) {
+	current := settings.Current()
+	previousFactor, previousTiers := current.ReplicationFactor, current.ReplicationTiers
+	current.ReplicationFactor, current.ReplicationTiers = factor, ""
+	t.Cleanup(func() { current.ReplicationFactor, current.ReplicationTiers = previousFactor, previousTiers })
+}
+
+func TestCoordinatorReplicationStatus(t *testing.T) {
+	setupNode(t)
+	withReplicationFactor(t, 2)
+
+	//The original and two copies are the target
+	states := []string{"under-replicated", "under-replicated", "replicated", "over-replicated"}
+	for index, state := range states {
+		replicas := index + 1
+		database.AddMessageLocation("abc", "10.0.0."+strconv.Itoa(replicas)+":9123")
+
+		recorder := serveCoordinator(http.MethodGet, "/coordinator/control/status/abc")
+		expectStatus(t, recorder, http.StatusOK)
+		var report replicationStatus
+		if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+			t.Fatalf("invalid replication status %q: %v", recorder.Body.String(), err)
+		}
+		want := replicationStatus{ID: "abc", Replicas: replicas, ReplicationFactor: 2, Target: 3, State: state}
+		if report != want {
+			t.Errorf("replication status = %+v, want %+v", report, want)
+		}
+	}
+}
+
+func TestRepairReplicationKeepsTheOriginalAndFactorCopies(t *testing.T) {
+	setupNode(t)
+	withReplicationFactor(t, 2)
+	withRetries(t, 1)
+	var mutex sync.Mutex
+	var queries []string
+	addresses := fakeNodes(t, NODE_STORAGE, 3, func(res http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		queries = append(queries, req.URL.RequestURI())
+		mutex.Unlock()
+		writeResponse(res, http.StatusAccepted, "Redistributing")
+	})
+	//abc lacks a copy, def has the original and both copies
+	database.AddMessageLocations([]string{"abc", "def"}, addresses[0], 0)
+	database.AddMessageLocations([]string{"abc", "def"}, addresses[1], 0)
+	database.AddMessageLocation("def", addresses[2])
+
+	if err := repairReplication(context.Background(), []string{"abc", "def"}); err != nil {
+		t.Fatalf("repairReplication() = %v", err)
+	}
+	if len(queries) != 1 || queries[0] != "/storage/redistribute/abc?replicas=1" {
+		t.Errorf("redistribution requests = %q, want one for abc with 1 replica elsewhere", queries)
+	}
+}
+
+func TestAnnouncedReplicationFactorIsRecorded(t *testing.T) {
+	setupNode(t)
+	withReplicationFactor(t, 2)
+	announce := func(target string, want string) {
+		t.Helper()
+		recorder := serveCoordinator(http.MethodGet, target)
+		expectStatus(t, recorder, http.StatusOK)
+		if body := strings.TrimSpace(recorder.Body.String()); body != want {
+			t.Errorf("%s = %s, want %s", target, body, want)
+		}
+	}
+	//small is kept with 4 copies, large with the default factor
+	for index := 1; index <= 3; index++ {
+		address := "10.0.0." + strconv.Itoa(index) + ":9123"
+		announce("/coordinator/announce/small/"+address+"?replicationFactor=4", "true")
+		announce("/coordinator/announce/large/"+address, strconv.FormatBool(index < 2))
+	}
+
+	recorder := serveCoordinator(http.MethodGet, "/coordinator/control/status/small")
+	expectStatus(t, recorder, http.StatusOK)
+	var report replicationStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid replication status %q: %v", recorder.Body.String(), err)
+	}
+	if report.ReplicationFactor != 4 || report.Target != 5 || report.State != "under-replicated" {
+		t.Errorf("replication status = %+v, want the announced factor of 4", report)
+	}
+
+	recorder = serveCoordinator(http.MethodGet, "/coordinator/control/under-replicated")
+	expectStatus(t, recorder, http.StatusOK)
+	if body := strings.TrimSpace(recorder.Body.String()); body != `{"count":1,"replicationFactor":2}` {
+		t.Errorf("under-replicated = %s, want only small", body)
+	}
+}
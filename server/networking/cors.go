@@ -0,0 +1,69 @@
+package networking
+
+import (
+	"net/http"
+	"strings"
+	"subframe/server/settings"
+)
+
+//corsAllowedHeaders are the request headers browsers may send cross-origin
+var corsAllowedHeaders = []string{
+	"Authorization", "Content-Type", "If-None-Match", "If-Range", "Range", requestIDHeader,
+	"X-Expires-In", "X-Expires-At", "X-Sender", "X-Recipient", "X-Readers", "X-Dry-Run", "X-Content-Length",
+}
+
+//corsExposedHeaders are the response headers scripts of other origins may read
+var corsExposedHeaders = []string{
+	"Accept-Ranges", "Content-Range", "ETag", "Retry-After", "WWW-Authenticate", "X-Checksum-SHA256", "X-Checksum-SHA512",
+	requestIDHeader,
+}
+
+//corsMaxAge is the time in seconds browsers may cache a preflight response
+const corsMaxAge = "600"
+
+//originAllowed returns whether origin is listed in settings.CORSAllowedOrigins
+func originAllowed(origin string) bool {
+	for _, allowed := range settings.Current().AllowedOrigins() {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+//setCORSHeaders allows the origin of req to read the response if it is listed in settings.CORSAllowedOrigins.
+//The origin is echoed instead of answering *, as browsers reject * for requests with credentials
+func setCORSHeaders(res http.ResponseWriter, req *http.Request) bool {
+	if settings.Current().CORSAllowedOrigins == "" {
+		return false
+	}
+	res.Header().Add("Vary", "Origin")
+	origin := req.Header.Get("Origin")
+	if origin == "" || !originAllowed(origin) {
+		return false
+	}
+
+	res.Header().Set("Access-Control-Allow-Origin", origin)
+	//Requests authenticated with a Bearer token are sent with credentials by browser clients
+	if settings.Current().AuthToken != "" {
+		res.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	res.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+	return true
+}
+
+//isPreflight returns whether req is a CORS preflight, asking whether a cross-origin request may be sent
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Origin") != "" && req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+//writePreflight responds to a CORS preflight for an action allowing methods. Preflights of origins which are not
+//allowed get no CORS headers, so the browser does not send the actual request
+func writePreflight(res http.ResponseWriter, allowed bool, methods []string) {
+	if allowed {
+		res.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		res.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+		res.Header().Set("Access-Control-Max-Age", corsMaxAge)
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
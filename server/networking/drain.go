@@ -0,0 +1,179 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"subframe/server/database"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	. "subframe/status"
+	"sync"
+)
+
+var dlog = logger.Logger{Prefix: "networking/Drain"}
+
+//drainPageSize is the number of local message IDs listed at once while draining
+const drainPageSize = 100
+
+//drainProgress is the response of /control/drain and /control/drain-status.
+//The Node can be shut down once Done is set and no message Failed
+type drainProgress struct {
+	Draining bool `json:"draining"`
+	Done     bool `json:"done"`
+	Total    int  `json:"total"`
+	Migrated int  `json:"migrated"`
+	Failed   int  `json:"failed"`
+}
+
+var drainMutex sync.Mutex
+var drain drainProgress
+var drainStop = make(chan bool)
+
+//isDraining returns whether the Node is draining and rejects new messages
+func isDraining() bool {
+	drainMutex.Lock()
+	defer drainMutex.Unlock()
+	return drain.Draining
+}
+
+func currentDrainProgress() drainProgress {
+	drainMutex.Lock()
+	defer drainMutex.Unlock()
+	return drain
+}
+
+//startDrain switches the Node to read-only, and starts migrating all local messages to other StorageNodes.
+//Returns false if the Node is already draining. Draining lasts until the Node is restarted
+func startDrain() bool {
+	drainMutex.Lock()
+	if drain.Draining {
+		drainMutex.Unlock()
+		return false
+	}
+	drain = drainProgress{Draining: true}
+	drainMutex.Unlock()
+
+	go migrateMessages()
+	return true
+}
+
+//stopDrain aborts a migration in progress
+func stopDrain() {
+	close(drainStop)
+}
+
+//migrateMessages copies every local message to another StorageNode and deannounces it from the CoordinatorNetwork.
+//Messages stay readable locally until the Node is shut down
+func migrateMessages() {
+	dlog.Info(InProgress, "Draining Node, migrating all Messages to other StorageNodes...")
+	var ids []string
+	for offset := 0; ; {
+		page, next, status := storage.List(offset, drainPageSize)
+		if status != http.StatusOK {
+			dlog.Error(status, "Error listing Messages to migrate. Aborting drain.")
+			return
+		}
+		ids = append(ids, page...)
+		if next == 0 {
+			break
+		}
+		offset = next
+	}
+
+	drainMutex.Lock()
+	drain.Total = len(ids)
+	drainMutex.Unlock()
+
+	for _, id := range ids {
+		select {
+		case <-drainStop:
+			dlog.Warn(GenericInternalError, "Drain aborted.")
+			return
+		default:
+		}
+
+		migrated := migrateMessage(id)
+		drainMutex.Lock()
+		if migrated {
+			drain.Migrated++
+		} else {
+			drain.Failed++
+		}
+		drainMutex.Unlock()
+	}
+
+	drainMutex.Lock()
+	drain.Done = true
+	progress := drain
+	drainMutex.Unlock()
+	dlog.Info(OK, "Drained Node: migrated "+strconv.Itoa(progress.Migrated)+" of "+strconv.Itoa(progress.Total)+" Messages, "+strconv.Itoa(progress.Failed)+" failed.")
+}
+
+//migrateMessage pushes the message id to the first StorageNode it maps to on the hash ring which does not store it yet.
+//Nodes already storing it keep its replication unchanged, so the next one is tried. The message is migrated once one
+//of them accepts the copy, or if all of them already store it
+func migrateMessage(id string) bool {
+	msg, status := storage.Get(id)
+	if status == http.StatusNotFound || status == StorageMessageExpired {
+		//Nothing is lost if the message is gone
+		return true
+	}
+	if status != http.StatusOK {
+		dlog.Error(status, "Cannot migrate Message "+id)
+		return false
+	}
+
+	//The replicas of the message besides this Node, and one more to take its place
+	_, candidates := database.GetReplicaNodes(id, settings.Current().ReplicationFactorFor(int64(len(msg.Content)))+2)
+	migrated, holding, failed := false, 0, 0
+	for _, candidate := range candidates {
+		if candidate.Address == settings.Current().RemoteAddress {
+			continue
+		}
+		s, response := SendNodeRequest(NODE_STORAGE, candidate.Address, replicaPutQuery(msg), msg.Content)
+		if s == OK {
+			migrated = true
+			break
+		}
+		if isConflict(response) {
+			holding++
+			continue
+		}
+		failed++
+		dlog.Warn(s, "Failed to migrate Message "+id+" to StorageNode "+candidate.Address)
+	}
+	if !migrated && holding > 0 && failed == 0 {
+		dlog.Info(OK, "All StorageNodes for Message "+id+" already store it")
+		migrated = true
+	}
+	if !migrated {
+		dlog.Error(GenericInternalError, "No StorageNode accepted Message "+id)
+		return false
+	}
+	//The receiving Node announces its copy, this Node no longer serves the message
+	enqueueDeannounce(context.Background(), id)
+	return true
+}
+
+//handleDrain starts draining the Node, and responds with the drain progress
+func (r storageRequest) handleDrain() {
+	status := http.StatusOK
+	if startDrain() {
+		r.log.Warn(InProgress, "Draining Node, rejecting new Messages.")
+		status = http.StatusAccepted
+	}
+	r.writeDrainProgress(status)
+}
+
+func (r storageRequest) writeDrainProgress(status int) {
+	response, err := json.Marshal(currentDrainProgress())
+	if err != nil {
+		r.log.Error(GenericInternalError, "Failed to export drain progress: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error getting drain progress")
+		return
+	}
+	writeJSON(r.res, status, string(response))
+}
@@ -0,0 +1,45 @@
+package networking
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+//conflictingNode answers puts as a StorageNode already storing the message
+func conflictingNode(res http.ResponseWriter, req *http.Request) {
+	writeError(res, http.StatusConflict, ErrorConflict, "Message is already stored")
+}
+
+//failingNode answers puts as a StorageNode failing to store the message
+func failingNode(res http.ResponseWriter, req *http.Request) {
+	writeError(res, http.StatusInternalServerError, ErrorInternal, "Failing on purpose")
+}
+
+func TestMigrateMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		handlers []http.HandlerFunc
+		want     bool
+	}{
+		{"all StorageNodes store the message already", []http.HandlerFunc{conflictingNode, conflictingNode}, true},
+		{"no StorageNode accepts a new copy", []http.HandlerFunc{conflictingNode, failingNode}, false},
+		{"a StorageNode accepts a new copy", []http.HandlerFunc{conflictingNode, func(res http.ResponseWriter, req *http.Request) {
+			writeResponse(res, http.StatusOK, "stored")
+		}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupNode(t)
+			withRetries(t, 1)
+			expectStatus(t, serve(http.MethodPost, "/storage/put/draining", strings.NewReader("content"), nil), http.StatusOK)
+			for _, handler := range test.handlers {
+				fakeNodes(t, NODE_STORAGE, 1, handler)
+			}
+
+			if got := migrateMessage("draining"); got != test.want {
+				t.Errorf("migrateMessage() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package networking
+
+import (
+	"net/http"
+	"strconv"
+	"subframe/server/database"
+	. "subframe/status"
+)
+
+//isDryRun returns whether the put req only asks if the message would be accepted, with ?validate=true or X-Dry-Run: true
+func isDryRun(req *http.Request) bool {
+	validate, _ := strconv.ParseBool(req.URL.Query().Get("validate"))
+	dryRun, _ := strconv.ParseBool(req.Header.Get("X-Dry-Run"))
+	return validate || dryRun
+}
+
+//declaredSize returns the size the client declared for the content of its put. Dry runs need not send the content,
+//so they may declare its size with the X-Content-Length header instead
+func (r storageRequest) declaredSize(dryRun bool) (size int64, valid bool) {
+	header := r.req.Header.Get("X-Content-Length")
+	if r.req.ContentLength > 0 || !dryRun || header == "" {
+		return r.req.ContentLength, true
+	}
+	size, err := strconv.ParseInt(header, 10, 64)
+	return size, err == nil && size >= 0
+}
+
+//validatePut responds whether the put would be accepted, without reading its body or storing anything.
+//Draining, size and capacity are checked by handlePut before
+func (r storageRequest) validatePut() {
+	messageID := r.slug
+	if _, valid := r.putMessage(); !valid {
+		return
+	}
+	if _, stored := database.CheckMessageStorage(messageID); stored {
+		writeError(r.res, http.StatusConflict, ErrorConflict, "Message "+messageID+" is already stored")
+		return
+	}
+	r.log.Info(OK, "Dry run of put of Message "+messageID+" succeeded.")
+	writeResponse(r.res, http.StatusOK, "Message "+messageID+" would be stored")
+}
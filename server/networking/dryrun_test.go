@@ -0,0 +1,90 @@
+package networking
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"subframe/server/database"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	"testing"
+)
+
+//storedFiles returns the files the filesystem backend and uploads hold messages in
+func storedFiles(t *testing.T) (files []string) {
+	t.Helper()
+	for _, dir := range []string{"messages", "metadata", "contents", "tmp", "uploads"} {
+		filepath.Walk(settings.Current().DataPath+"/"+dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			//Segment indexes are created on start, and names starting with a dot do not hold messages
+			if strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+func TestDryRunPutStoresNothing(t *testing.T) {
+	setupNode(t)
+	settings.Current().StorageBackend = "filesystem"
+	storage.Init()
+	withMessageMaxSize(t, 1)
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		headers    map[string]string
+		wantStatus int
+	}{
+		{"validate parameter", "/storage/put/abc?validate=true", "content", nil, http.StatusOK},
+		{"X-Dry-Run header", "/storage/put/abc", "content", map[string]string{"X-Dry-Run": "true"}, http.StatusOK},
+		{"declared size without content", "/storage/put/abc", "", map[string]string{"X-Dry-Run": "true", "X-Content-Length": "1024"}, http.StatusOK},
+		{"declared size too large", "/storage/put/abc", "", map[string]string{"X-Dry-Run": "true", "X-Content-Length": strconv.Itoa(2 * 1024 * 1024)}, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expectStatus(t, serve(http.MethodPost, test.target, strings.NewReader(test.body), test.headers), test.wantStatus)
+			if files := storedFiles(t); len(files) > 0 {
+				t.Errorf("dry run wrote %v", files)
+			}
+			if jobs := queuedJobs(); len(jobs) > 0 {
+				t.Errorf("dry run queued %d Jobs, want none", len(jobs))
+			}
+			if _, stored := database.CheckMessageStorage("abc"); stored {
+				t.Error("dry run logged the message to the database")
+			}
+			if used, _ := storage.Usage(); used != 0 {
+				t.Errorf("dry run used %d bytes of storage, want 0", used)
+			}
+		})
+	}
+
+	expectStatus(t, serve(http.MethodPost, "/storage/put/abc", strings.NewReader("content"), nil), http.StatusOK)
+	if len(storedFiles(t)) == 0 {
+		t.Error("put wrote no files, the dry runs are not checked")
+	}
+}
+
+func TestDryRunPutOfStoredMessage(t *testing.T) {
+	setupNode(t)
+	expectStatus(t, serve(http.MethodPost, "/storage/put/abc", strings.NewReader("content"), nil), http.StatusOK)
+	queuedJobs()
+
+	expectStatus(t, serve(http.MethodPost, "/storage/put/abc?validate=true", strings.NewReader("replaced"), nil), http.StatusConflict)
+	if jobs := queuedJobs(); len(jobs) > 0 {
+		t.Errorf("dry run queued %d Jobs, want none", len(jobs))
+	}
+}
@@ -0,0 +1,62 @@
+package networking
+
+import (
+	"net/http"
+	"strings"
+	. "subframe/status"
+)
+
+//messageETag returns the strong ETag of a message with checksum, or an empty string if no checksum is recorded.
+//The JSON envelope and the raw content are different representations, so they get different tags
+func messageETag(checksum string, raw bool) string {
+	if checksum == "" {
+		return ""
+	}
+	if raw {
+		return `"` + checksum + `-raw"`
+	}
+	return `"` + checksum + `"`
+}
+
+//notModified returns whether the If-None-Match header of req matches etag. If-None-Match uses the weak comparison,
+//so tags weakened by compressResponse still match
+func notModified(req *http.Request, etag string) bool {
+	header := req.Header.Get("If-None-Match")
+	if etag == "" || header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+//checkNotModified sets the ETag header and writes a 304 response if the client already has the current version
+func (r storageRequest) checkNotModified(etag string) bool {
+	if etag == "" {
+		return false
+	}
+	r.res.Header().Set("ETag", etag)
+	if !notModified(r.req, etag) {
+		return false
+	}
+	r.log.Info(OK, "Message "+r.slug+" not modified.")
+	r.res.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+//createOnly returns whether req is a conditional put with If-None-Match: *, which must not replace a stored message
+func createOnly(req *http.Request) bool {
+	return strings.TrimSpace(req.Header.Get("If-None-Match")) == "*"
+}
+
+//writePreconditionFailed rejects a conditional put of a message which is already stored
+func (r storageRequest) writePreconditionFailed() {
+	r.log.Warn(GenericInputError, "Message "+r.slug+" is already stored, rejecting conditional put.")
+	writeError(r.res, http.StatusPreconditionFailed, ErrorPreconditionFailed, "Message "+r.slug+" is already stored")
+}
@@ -0,0 +1,38 @@
+package networking
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCreateOnlyPut(t *testing.T) {
+	setupNode(t)
+	ifAbsent := map[string]string{"If-None-Match": "*"}
+
+	expectStatus(t, serve(http.MethodPost, "/storage/put/abc", strings.NewReader("first"), ifAbsent), http.StatusOK)
+
+	recorder := serve(http.MethodPost, "/storage/put/abc", strings.NewReader("second"), ifAbsent)
+	expectStatus(t, recorder, http.StatusPreconditionFailed)
+	if !strings.Contains(recorder.Body.String(), `"code":"`+ErrorPreconditionFailed+`"`) {
+		t.Errorf("body = %s, want code %s", recorder.Body.String(), ErrorPreconditionFailed)
+	}
+
+	raw := serve(http.MethodGet, "/storage/get/abc", nil, map[string]string{"Accept": "application/octet-stream"})
+	expectStatus(t, raw, http.StatusOK)
+	if raw.Body.String() != "first" {
+		t.Errorf("content = %q after the conditional put, want %q", raw.Body.String(), "first")
+	}
+}
+
+func TestCreateOnly(t *testing.T) {
+	for header, want := range map[string]bool{"*": true, " * ": true, `"abc"`: false, "": false} {
+		req, _ := http.NewRequest(http.MethodPost, "/storage/put/abc", nil)
+		if header != "" {
+			req.Header.Set("If-None-Match", header)
+		}
+		if got := createOnly(req); got != want {
+			t.Errorf("createOnly() with If-None-Match %q = %v, want %v", header, got, want)
+		}
+	}
+}
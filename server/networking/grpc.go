@@ -0,0 +1,525 @@
+package networking
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	. "subframe/status"
+	"time"
+)
+
+var grlog = logger.Logger{Prefix: "networking/GRPC"}
+
+//grpcServicePath prefixes the paths of the methods of the Storage service in storage.proto
+const grpcServicePath = "/subframe.v1.Storage/"
+
+//grpcFrameOverhead is the room left for the other fields of a request message besides its content
+const grpcFrameOverhead = 64 * 1024
+
+//gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcNotFound           = 5
+	grpcAlreadyExists      = 6
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+//forwardedGRPCHeaders are the request metadata passed on to the StorageNode API
+var forwardedGRPCHeaders = []string{"Authorization", requestIDHeader, "Traceparent"}
+
+var errCompressedMessage = errors.New("compressed gRPC messages are not supported")
+var errGRPCMessageTooLarge = errors.New("gRPC message exceeds the maximum message size")
+
+//grpcServer serves the gRPC interface
+var grpcServer *http.Server
+
+//startGRPCService serves the gRPC interface on settings.GRPCAddress, if set. gRPC requires HTTP/2, which clients
+//without TLS use without upgrading from HTTP/1.1
+func startGRPCService() {
+	if settings.Current().GRPCAddress == "" {
+		return
+	}
+	tlsConfig := loadTLSConfig()
+	server := &http.Server{
+		Addr:      settings.Current().GRPCAddress,
+		Handler:   http.HandlerFunc(handleGRPC),
+		TLSConfig: tlsConfig,
+		Protocols: new(http.Protocols),
+	}
+	server.Protocols.SetHTTP2(true)
+	grpcServer = server
+
+	if tlsConfig == nil {
+		server.Protocols.SetUnencryptedHTTP2(true)
+		grlog.Warn(NetworkingTLSConfigError, "Starting gRPC Server without TLS at "+settings.Current().GRPCAddress+"...")
+		go func() {
+			err := server.ListenAndServe()
+			if err != http.ErrServerClosed {
+				grlog.Fatal(GenericInternalError, "Fatal failure in gRPC Server: "+err.Error())
+			}
+		}()
+		return
+	}
+
+	grlog.Info(InProgress, "Starting gRPC Server with TLS at "+settings.Current().GRPCAddress+"...")
+	go func() {
+		err := server.ListenAndServeTLS("", "")
+		if err != http.ErrServerClosed {
+			grlog.Fatal(GenericInternalError, "Fatal failure in gRPC Server: "+err.Error())
+		}
+	}()
+}
+
+//stopGRPCService stops accepting calls and waits up to timeout for running calls to finish
+func stopGRPCService(timeout time.Duration) {
+	if grpcServer == nil {
+		return
+	}
+	grlog.Info(InProgress, "Stopping gRPC Server. Waiting for running calls...")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := grpcServer.Shutdown(ctx); err != nil {
+		grlog.Warn(GenericInternalError, "Running calls did not finish in time: "+err.Error())
+		grpcServer.Close()
+		return
+	}
+	grlog.Info(OK, "Stopped gRPC Server.")
+}
+
+//grpcStatus is the outcome of a gRPC call, sent in the trailers
+type grpcStatus struct {
+	code    int
+	message string
+	//errorCode is the error code of the StorageNode API, e.g. EXPIRED
+	errorCode string
+}
+
+//grpcCall is a call of a method of the Storage service
+type grpcCall struct {
+	res http.ResponseWriter
+	req *http.Request
+	log logger.Logger
+}
+
+//handleGRPC answers a gRPC call. The methods are served by the StorageNode API, see forward
+func handleGRPC(res http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+		writeError(res, http.StatusUnsupportedMediaType, ErrorInvalidRequest, "Only gRPC requests are served")
+		return
+	}
+	if value := req.Header.Get("Grpc-Timeout"); value != "" {
+		if timeout, valid := parseGRPCTimeout(value); valid {
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			defer cancel()
+			req = req.WithContext(ctx)
+		}
+	}
+	call := grpcCall{res: res, req: req, log: grlog.WithContext(req.Context())}
+	res.Header().Set("Content-Type", "application/grpc")
+	res.WriteHeader(http.StatusOK)
+
+	var status grpcStatus
+	switch req.URL.Path {
+	case grpcServicePath + "Get":
+		status = call.get()
+	case grpcServicePath + "Put":
+		status = call.put()
+	case grpcServicePath + "Delete":
+		status = call.delete()
+	case grpcServicePath + "Locate":
+		status = call.locate()
+	default:
+		status = grpcStatus{code: grpcUnimplemented, message: "Unknown method " + req.URL.Path}
+	}
+	if status.code != grpcOK {
+		call.log.Info(GenericInputError, "gRPC call "+req.URL.Path+" failed with status "+strconv.Itoa(status.code)+": "+status.message)
+	}
+	call.finish(status)
+}
+
+//parseGRPCTimeout parses the value of a grpc-timeout header, e.g. 100m for 100 milliseconds
+func parseGRPCTimeout(value string) (timeout time.Duration, valid bool) {
+	units := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second, 'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+	unit, known := units[value[len(value)-1]]
+	amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if !known || err != nil || amount < 0 {
+		return 0, false
+	}
+	return time.Duration(amount) * unit, true
+}
+
+//get streams the content of a message in chunks of GetResponse, the first one carrying the metadata
+func (c grpcCall) get() grpcStatus {
+	id, status := c.readID()
+	if status.code != grpcOK {
+		return status
+	}
+	first := true
+	response := newGRPCResponse(func(header http.Header, chunk []byte) error {
+		msg := protoMessage(nil).bytesField(1, chunk)
+		if first {
+			size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+			version, _ := strconv.ParseInt(header.Get(versionHeader), 10, 64)
+			msg = msg.stringField(2, checksumFromHeaders(header)).int64Field(3, size).int64Field(4, version).mapField(5, metaHeadersFrom(header)).
+				stringField(6, header.Get("Content-Type"))
+			first = false
+		}
+		return c.send(msg)
+	})
+	c.forward(http.MethodGet, "/storage/get/"+url.PathEscape(id), nil, http.Header{"Accept": {"application/octet-stream"}}, response)
+	return response.result()
+}
+
+//put stores a message streamed in PutRequests. The first one carries the ID and metadata, the content of all of
+//them is passed on as it arrives
+func (c grpcCall) put() grpcStatus {
+	data, err := c.receive()
+	if err != nil {
+		return receiveStatus(err)
+	}
+	var id string
+	var content []byte
+	var expiresIn int64
+	var contentType string
+	headers := make(map[string]string)
+	err = decodeProto(data, func(number int, varint uint64, value []byte) {
+		switch number {
+		case 1:
+			id = string(value)
+		case 2:
+			content = value
+		case 3:
+			expiresIn = int64(varint)
+		case 4:
+			if key, entry, entryErr := decodeMapEntry(value); entryErr == nil {
+				headers[key] = entry
+			}
+		case 5:
+			contentType = string(value)
+		}
+	})
+	if err != nil {
+		return grpcStatus{code: grpcInvalidArgument, message: err.Error()}
+	}
+
+	header := make(http.Header)
+	if expiresIn != 0 {
+		header.Set("X-Expires-In", strconv.FormatInt(expiresIn, 10))
+	}
+	for name, value := range headers {
+		header.Set(metaHeaderPrefix+name, value)
+	}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	body, writer := io.Pipe()
+	go func() {
+		for {
+			if _, err := writer.Write(content); err != nil {
+				return
+			}
+			data, err := c.receive()
+			if err == io.EOF {
+				writer.Close()
+				return
+			}
+			if err == nil {
+				content = nil
+				err = decodeProto(data, func(number int, _ uint64, value []byte) {
+					if number == 2 {
+						content = value
+					}
+				})
+			}
+			if err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	response := newGRPCResponse(nil)
+	c.forward(http.MethodPut, "/storage/put/"+url.PathEscape(id), body, header, response)
+	//Content the API did not read is discarded
+	body.Close()
+	return c.reply(response, protoMessage{})
+}
+
+func (c grpcCall) delete() grpcStatus {
+	id, status := c.readID()
+	if status.code != grpcOK {
+		return status
+	}
+	response := newGRPCResponse(nil)
+	c.forward(http.MethodDelete, "/storage/delete/"+url.PathEscape(id), nil, nil, response)
+	return c.reply(response, protoMessage{})
+}
+
+//locate responds with the StorageNodes the message is placed on, like /control/replicas
+func (c grpcCall) locate() grpcStatus {
+	id, status := c.readID()
+	if status.code != grpcOK {
+		return status
+	}
+	response := newGRPCResponse(nil)
+	c.forward(http.MethodGet, "/storage/control/replicas?id="+url.QueryEscape(id), nil, nil, response)
+	if response.status >= http.StatusMultipleChoices {
+		return response.result()
+	}
+	var addresses []string
+	if err := json.Unmarshal(response.body.Bytes(), &addresses); err != nil {
+		return grpcStatus{code: grpcInternal, message: "Error reading replicas: " + err.Error()}
+	}
+	msg := protoMessage{}
+	for _, address := range addresses {
+		msg = msg.stringField(1, address)
+	}
+	return c.reply(response, msg)
+}
+
+//readID reads a request message with the ID of a message as first field
+func (c grpcCall) readID() (id string, status grpcStatus) {
+	data, err := c.receive()
+	if err != nil {
+		return "", receiveStatus(err)
+	}
+	err = decodeProto(data, func(number int, _ uint64, value []byte) {
+		if number == 1 {
+			id = string(value)
+		}
+	})
+	if err != nil {
+		return "", grpcStatus{code: grpcInvalidArgument, message: err.Error()}
+	}
+	return id, grpcStatus{}
+}
+
+//receive reads the next length-prefixed message of the request. Returns io.EOF once the client sent all messages
+func (c grpcCall) receive() ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(c.req.Body, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errMalformedProto
+		}
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, errCompressedMessage
+	}
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if int64(length) > int64(settings.Current().MessageMaxSize)*1024*1024+grpcFrameOverhead {
+		return nil, errGRPCMessageTooLarge
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.req.Body, data); err != nil {
+		return nil, errMalformedProto
+	}
+	return data, nil
+}
+
+//receiveStatus returns the status of a call whose request message could not be read
+func receiveStatus(err error) grpcStatus {
+	switch err {
+	case io.EOF:
+		return grpcStatus{code: grpcInvalidArgument, message: "Missing request message"}
+	case errCompressedMessage:
+		return grpcStatus{code: grpcUnimplemented, message: err.Error()}
+	case errGRPCMessageTooLarge:
+		return grpcStatus{code: grpcResourceExhausted, message: err.Error()}
+	}
+	return grpcStatus{code: grpcInvalidArgument, message: err.Error()}
+}
+
+//send writes msg as length-prefixed message of the response
+func (c grpcCall) send(msg protoMessage) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	if _, err := c.res.Write(append(frame, msg...)); err != nil {
+		return err
+	}
+	if flusher, ok := c.res.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+//reply sends msg as response of a unary call, if the API answered successfully
+func (c grpcCall) reply(response *grpcResponse, msg protoMessage) grpcStatus {
+	status := response.result()
+	if status.code != grpcOK {
+		return status
+	}
+	if err := c.send(msg); err != nil {
+		return grpcStatus{code: grpcUnavailable, message: err.Error()}
+	}
+	return status
+}
+
+//finish sends status in the trailers, which end every response
+func (c grpcCall) finish(status grpcStatus) {
+	c.res.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(status.code))
+	if status.message != "" {
+		c.res.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGRPCMessage(status.message))
+	}
+	if status.errorCode != "" {
+		c.res.Header().Set(http.TrailerPrefix+"Subframe-Error-Code", status.errorCode)
+	}
+}
+
+//encodeGRPCMessage percent-encodes message for the grpc-message trailer
+func encodeGRPCMessage(message string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var encoded strings.Builder
+	for i := 0; i < len(message); i++ {
+		char := message[i]
+		if char < 0x20 || char > 0x7e || char == '%' {
+			encoded.WriteByte('%')
+			encoded.WriteByte(hexDigits[char>>4])
+			encoded.WriteByte(hexDigits[char&15])
+			continue
+		}
+		encoded.WriteByte(char)
+	}
+	return encoded.String()
+}
+
+//forward handles a request to path of the StorageNode API as if the gRPC client had sent it, so calls are
+//authenticated, limited, logged and counted like HTTP requests
+func (c grpcCall) forward(method string, path string, body io.Reader, header http.Header, response *grpcResponse) {
+	req, err := http.NewRequestWithContext(c.req.Context(), method, path, body)
+	if err != nil {
+		writeError(response, http.StatusBadRequest, ErrorInvalidRequest, "Invalid message ID")
+		return
+	}
+	for _, name := range forwardedGRPCHeaders {
+		if value := c.req.Header.Get(name); value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.RemoteAddr = c.req.RemoteAddr
+	req.Host = c.req.Host
+	handleRequest(response, req)
+}
+
+//grpcResponse receives the response of a request forwarded to the StorageNode API. The body of successful
+//responses is passed to stream as it is written, if set, other bodies are kept
+type grpcResponse struct {
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+	stream    func(header http.Header, chunk []byte) error
+	streamErr error
+}
+
+func newGRPCResponse(stream func(header http.Header, chunk []byte) error) *grpcResponse {
+	return &grpcResponse{header: make(http.Header), stream: stream}
+}
+
+func (r *grpcResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *grpcResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *grpcResponse) Write(data []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	if r.stream == nil || r.status >= http.StatusMultipleChoices {
+		return r.body.Write(data)
+	}
+	if err := r.stream(r.header, data); err != nil {
+		r.streamErr = err
+		return 0, err
+	}
+	return len(data), nil
+}
+
+//result returns the status of the call from the response of the API. Error messages and codes are taken from
+//the error response
+func (r *grpcResponse) result() grpcStatus {
+	if r.streamErr != nil {
+		return grpcStatus{code: grpcUnavailable, message: "Error sending response: " + r.streamErr.Error()}
+	}
+	if r.status < http.StatusMultipleChoices {
+		return grpcStatus{}
+	}
+	var errorBody errorResponse
+	json.Unmarshal(r.body.Bytes(), &errorBody)
+	status := grpcStatus{code: grpcCode(r.status), message: errorBody.Error.Message, errorCode: errorBody.Error.Code}
+	if status.message == "" {
+		status.message = http.StatusText(r.status)
+	}
+	return status
+}
+
+//grpcCode maps the HTTP status of an API response to a gRPC status code
+func grpcCode(status int) int {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestedRangeNotSatisfiable, http.StatusUnsupportedMediaType:
+		return grpcInvalidArgument
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound, http.StatusGone:
+		return grpcNotFound
+	case http.StatusConflict:
+		return grpcAlreadyExists
+	case http.StatusPreconditionFailed:
+		return grpcFailedPrecondition
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests, http.StatusInsufficientStorage:
+		return grpcResourceExhausted
+	case http.StatusServiceUnavailable:
+		return grpcUnavailable
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	}
+	return grpcInternal
+}
+
+//checksumFromHeaders returns the checksum of a streamed message from its X-Checksum-<function> header
+func checksumFromHeaders(header http.Header) string {
+	for name, values := range header {
+		if strings.HasPrefix(name, "X-Checksum-") && len(values) > 0 {
+			return storage.JoinChecksum(strings.ToLower(strings.TrimPrefix(name, "X-Checksum-")), values[0])
+		}
+	}
+	return ""
+}
+
+//metaHeadersFrom returns the headers of a streamed message from its X-Meta-* headers, see setMetaHeaders
+func metaHeadersFrom(header http.Header) map[string]string {
+	headers := make(map[string]string)
+	for name, values := range header {
+		if strings.HasPrefix(name, metaHeaderPrefix) && len(values) > 0 {
+			headers[strings.TrimPrefix(name, metaHeaderPrefix)] = values[0]
+		}
+	}
+	return headers
+}
@@ -0,0 +1,218 @@
+package networking
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"subframe/server/storage"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+//grpcFrame prefixes msg with the 5 byte header of an uncompressed gRPC message
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+//callGRPC calls method of the Storage service with the raw request body, and returns the gRPC status code of the call
+func callGRPC(t *testing.T, method string, body []byte) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, grpcServicePath+method, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/grpc")
+	recorder := httptest.NewRecorder()
+	handleGRPC(recorder, req)
+	code, err := strconv.Atoi(recorder.Result().Trailer.Get("Grpc-Status"))
+	if err != nil {
+		t.Fatalf("Grpc-Status trailer = %q: %v", recorder.Result().Trailer.Get("Grpc-Status"), err)
+	}
+	return code
+}
+
+func TestGRPCMalformedFrames(t *testing.T) {
+	setupNode(t)
+	withMessageMaxSize(t, 1)
+	valid := protoMessage(nil).stringField(1, "abc")
+	tooLarge := grpcFrame(nil)
+	binary.BigEndian.PutUint32(tooLarge[1:], 1024*1024+grpcFrameOverhead+1)
+	compressed := grpcFrame(valid)
+	compressed[0] = 1
+
+	tests := []struct {
+		name string
+		body []byte
+		code int
+	}{
+		{"no message", nil, grpcInvalidArgument},
+		{"truncated prefix", []byte{0, 0, 0}, grpcInvalidArgument},
+		{"truncated message", grpcFrame(valid)[:7], grpcInvalidArgument},
+		{"compressed message", compressed, grpcUnimplemented},
+		{"oversized message", tooLarge, grpcResourceExhausted},
+		{"malformed message", grpcFrame([]byte{0x0a, 0x05, 'a'}), grpcInvalidArgument},
+		{"unknown wire type", grpcFrame([]byte{0x0e}), grpcInvalidArgument},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, method := range []string{"Get", "Put", "Delete", "Locate"} {
+				if code := callGRPC(t, method, test.body); code != test.code {
+					t.Errorf("%s = status %d, want %d", method, code, test.code)
+				}
+			}
+		})
+	}
+}
+
+func TestGRPCPutWithMalformedChunk(t *testing.T) {
+	setupNode(t)
+	first := grpcFrame(protoMessage(nil).stringField(1, "abc").stringField(2, "first chunk"))
+	tests := []struct {
+		name  string
+		chunk []byte
+	}{
+		{"truncated chunk", grpcFrame(protoMessage(nil).stringField(2, "second chunk"))[:9]},
+		{"malformed chunk", grpcFrame([]byte{0x12, 0x7f})},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := callGRPC(t, "Put", append(append([]byte(nil), first...), test.chunk...)); code == grpcOK {
+				t.Error("Put of a malformed chunk succeeded")
+			}
+			if _, status := storage.Get("abc"); status == http.StatusOK {
+				t.Error("message abc was stored from a malformed chunk")
+			}
+		})
+	}
+}
+
+//rawCodec passes protoMessages to gRPC clients as encoded, so the tests need no generated code
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *v.(*protoMessage), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*v.(*protoMessage) = append(protoMessage(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+//grpcClient serves the gRPC interface on a test server, and returns a client of the grpc-go module connected to it
+func grpcClient(t *testing.T) *grpc.ClientConn {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(handleGRPC))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+
+	conn, err := grpc.NewClient(strings.TrimPrefix(server.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCClientRoundTrip(t *testing.T) {
+	setupNode(t)
+	conn := grpcClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	put, err := conn.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true}, grpcServicePath+"Put")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := []protoMessage{
+		protoMessage(nil).stringField(1, "abc").stringField(2, "hello ").mapField(4, map[string]string{"Author": "test"}).
+			stringField(5, "text/plain"),
+		protoMessage(nil).stringField(2, "world"),
+	}
+	for _, chunk := range chunks {
+		if err := put.SendMsg(&chunk); err != nil {
+			t.Fatalf("sending PutRequest: %v", err)
+		}
+	}
+	put.CloseSend()
+	var putResponse protoMessage
+	if err := put.RecvMsg(&putResponse); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+	runQueuedJobs(t)
+
+	get, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, grpcServicePath+"Get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := protoMessage(nil).stringField(1, "abc")
+	if err := get.SendMsg(&request); err != nil {
+		t.Fatal(err)
+	}
+	get.CloseSend()
+	var content bytes.Buffer
+	var size int64
+	var contentType string
+	headers := make(map[string]string)
+	for {
+		var response protoMessage
+		err := get.RecvMsg(&response)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Get() = %v", err)
+		}
+		decodeProto(response, func(number int, varint uint64, value []byte) {
+			switch number {
+			case 1:
+				content.Write(value)
+			case 3:
+				size = int64(varint)
+			case 5:
+				key, entry, _ := decodeMapEntry(value)
+				headers[key] = entry
+			case 6:
+				contentType = string(value)
+			}
+		})
+	}
+	if content.String() != "hello world" || size != int64(len("hello world")) {
+		t.Errorf("Get() = %q of size %d, want %q", content.String(), size, "hello world")
+	}
+	if headers["Author"] != "test" || !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("Get() headers = %v, content type %q, want Author: test and text/plain", headers, contentType)
+	}
+
+	var locateResponse protoMessage
+	if err := conn.Invoke(ctx, grpcServicePath+"Locate", &request, &locateResponse); err != nil {
+		t.Errorf("Locate() = %v", err)
+	}
+
+	var deleteResponse protoMessage
+	if err := conn.Invoke(ctx, grpcServicePath+"Delete", &request, &deleteResponse); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	//Errors of the StorageNode API reach the client as gRPC status
+	get, _ = conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, grpcServicePath+"Get")
+	get.SendMsg(&request)
+	get.CloseSend()
+	var response protoMessage
+	if err := get.RecvMsg(&response); grpcstatus.Code(err) != codes.NotFound {
+		t.Errorf("Get() of a deleted message = %v, want %v", err, codes.NotFound)
+	}
+}
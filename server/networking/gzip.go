@@ -0,0 +1,119 @@
+package networking
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"subframe/server/settings"
+)
+
+//gzipWriter gzips the response if the body reaches settings.ResponseCompressionThreshold. The status is held back
+//until the body is large enough, or the response is finished with Close, so the encoding headers can still be set
+type gzipWriter struct {
+	http.ResponseWriter
+	status     int
+	buffer     []byte
+	gzip       *gzip.Writer
+	sentHeader bool
+}
+
+//compressResponse wraps res in a gzipWriter if the client accepts gzip. The returned function has to be called
+//once the handler returned, to write out the rest of the response
+func compressResponse(res http.ResponseWriter, req *http.Request) (http.ResponseWriter, func()) {
+	if settings.Current().ResponseCompressionThreshold <= 0 || req.Method == http.MethodHead {
+		return res, func() {}
+	}
+	//Caches must not serve a compressed response to clients not accepting it
+	res.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(req.Header.Get("Accept-Encoding")) {
+		return res, func() {}
+	}
+	writer := &gzipWriter{ResponseWriter: res, status: http.StatusOK}
+	return writer, writer.Close
+}
+
+//acceptsGzip returns whether an Accept-Encoding header allows gzip, which it does unless it is missing or has q=0
+func acceptsGzip(header string) bool {
+	for _, coding := range strings.Split(header, ",") {
+		parts := strings.Split(coding, ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.sentHeader {
+		return
+	}
+	w.status = status
+}
+
+func (w *gzipWriter) Write(content []byte) (int, error) {
+	if w.gzip != nil {
+		return w.gzip.Write(content)
+	}
+	if w.sentHeader {
+		return w.ResponseWriter.Write(content)
+	}
+
+	w.buffer = append(w.buffer, content...)
+	if len(w.buffer) < settings.Current().ResponseCompressionThreshold {
+		return len(content), nil
+	}
+	//Content the handler already encoded is passed through, it would not shrink anyway. Ranges refer to the
+	//uncompressed content, so they are passed through as well
+	if w.Header().Get("Content-Encoding") != "" || w.Header().Get("Content-Range") != "" {
+		w.flush()
+		return len(content), nil
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	//The compressed bytes differ, so a strong ETag of the uncompressed representation becomes weak
+	if etag := w.Header().Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		w.Header().Set("ETag", "W/"+etag)
+	}
+	w.sentHeader = true
+	w.ResponseWriter.WriteHeader(w.status)
+	w.gzip = gzip.NewWriter(w.ResponseWriter)
+	buffered := w.buffer
+	w.buffer = nil
+	if _, err := w.gzip.Write(buffered); err != nil {
+		return 0, err
+	}
+	return len(content), nil
+}
+
+//flush writes the held back status and body uncompressed
+func (w *gzipWriter) flush() {
+	w.sentHeader = true
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buffer) > 0 {
+		w.ResponseWriter.Write(w.buffer)
+	}
+	w.buffer = nil
+}
+
+//Close finishes the response. Bodies below the threshold are sent uncompressed
+func (w *gzipWriter) Close() {
+	if w.gzip != nil {
+		w.gzip.Close()
+		return
+	}
+	if !w.sentHeader {
+		w.flush()
+	}
+}
@@ -0,0 +1,237 @@
+package networking
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"subframe/server/buildinfo"
+	"subframe/server/database"
+	"subframe/server/jobqueue"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	. "subframe/status"
+	"subframe/structs/node"
+	"time"
+)
+
+var hlog = logger.Logger{Prefix: "networking/HealthCheck"}
+
+//startTime is used to report the uptime of the Node
+var startTime = time.Now()
+
+//queueStallTimeout is how long queued Jobs may wait without any worker progress before the Node reports unavailable
+const queueStallTimeout = 5 * time.Minute
+
+//healthReport is the response of /storage/health
+type healthReport struct {
+	Status      string         `json:"status"`
+	Problems    []string       `json:"problems,omitempty"`
+	Uptime      int64          `json:"uptime"`
+	QueueLength int            `json:"queueLength"`
+	ActiveJobs  int            `json:"activeJobs"`
+	Used        int64          `json:"used"`
+	Total       int64          `json:"total"`
+	Load        nodeLoad       `json:"load"`
+	ReadOnly    bool           `json:"readOnly"`
+	Build       buildinfo.Info `json:"build"`
+}
+
+//registerHealthEndpoints serves /storage/health for readiness and /storage/health/live for liveness checks.
+//They are served outside the action dispatch, without rate limiting or authentication
+func registerHealthEndpoints() {
+	http.HandleFunc("/storage/health", handleReadiness)
+	http.HandleFunc("/storage/health/live", handleLiveness)
+}
+
+//healthMethodAllowed writes a 405 response for methods other than GET and HEAD
+func healthMethodAllowed(res http.ResponseWriter, req *http.Request) bool {
+	allowed := []string{http.MethodGet, http.MethodHead}
+	if methodAllowed(req.Method, allowed) {
+		return true
+	}
+	res.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(res, http.StatusMethodNotAllowed, ErrorInvalidMethod, req.Method+" is not allowed here.")
+	return false
+}
+
+//handleLiveness reports that the Node is up. Other Nodes ping it for their Health Checks
+func handleLiveness(res http.ResponseWriter, req *http.Request) {
+	recorder := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+	defer func() {
+		requestsTotal.Inc("health", strconv.Itoa(recorder.status))
+	}()
+	if !healthMethodAllowed(recorder, req) {
+		return
+	}
+	recorder.Header().Set("Content-Type", "application/json")
+	if req.Method == http.MethodHead {
+		recorder.WriteHeader(http.StatusOK)
+		return
+	}
+	writeResponse(recorder, http.StatusOK, `{"status":"ok"}`)
+}
+
+//handleReadiness responds 200 if the Node can store messages and its Job Queue makes progress, and 503 otherwise.
+//Overloaded and read-only Nodes report the status overloaded or read-only with 200, as they still serve reads
+func handleReadiness(res http.ResponseWriter, req *http.Request) {
+	recorder := &statusRecorder{ResponseWriter: res, status: http.StatusOK}
+	defer func() {
+		requestsTotal.Inc("health", strconv.Itoa(recorder.status))
+	}()
+	if !healthMethodAllowed(recorder, req) {
+		return
+	}
+
+	report := healthReport{Status: "ok", Uptime: int64(time.Since(startTime) / time.Second)}
+	report.QueueLength, report.ActiveJobs = jobqueue.Length()
+	report.Used, report.Total = storage.Usage()
+	report.Load = currentLoad()
+	report.ReadOnly = isReadOnly()
+	report.Build = buildinfo.Get()
+	if err := storage.Check(); err != nil {
+		report.Problems = append(report.Problems, "storage: "+err.Error())
+	}
+	if jobqueue.Stalled(queueStallTimeout) {
+		report.Problems = append(report.Problems, "job queue is not making progress")
+	}
+	if isDraining() {
+		report.Problems = append(report.Problems, "node is draining")
+	}
+
+	status := http.StatusOK
+	if len(report.Problems) > 0 {
+		report.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+		hlog.Warn(GenericInternalError, "Node is unavailable: "+strings.Join(report.Problems, ", "))
+	} else if report.ReadOnly {
+		report.Status = "read-only"
+	} else if !report.Load.AcceptingWrites {
+		report.Status = "overloaded"
+		hlog.Warn(GenericInternalError, "Node is overloaded with a load score of "+strconv.FormatFloat(report.Load.Score, 'f', 2, 64)+", rejecting new messages.")
+	}
+
+	response, err := json.Marshal(report)
+	if err != nil {
+		hlog.Error(GenericInternalError, "Failed to export Health Report: "+err.Error())
+		writeError(recorder, http.StatusInternalServerError, ErrorInternal, "Failed to export Health Report.")
+		return
+	}
+	recorder.Header().Set("Content-Type", "application/json")
+	if req.Method == http.MethodHead {
+		recorder.WriteHeader(status)
+		return
+	}
+	writeResponse(recorder, status, string(response))
+}
+
+var healthCheckStop = make(chan bool)
+
+//healthCheckMaxNodes bounds the number of StorageNodes checked per run
+const healthCheckMaxNodes = 1000
+
+//startHealthChecker periodically pings all known Nodes and records their liveness until stopHealthChecker is called
+func startHealthChecker() {
+	if settings.Current().HealthCheckInterval <= 0 {
+		hlog.Info(OK, "Health Checks are disabled.")
+		return
+	}
+
+	hlog.Info(InProgress, "Starting Health Checks with an interval of "+strconv.Itoa(settings.Current().HealthCheckInterval)+" seconds...")
+	go func() {
+		ticker := time.NewTicker(time.Duration(settings.Current().HealthCheckInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				checkNodeHealth()
+			case <-healthCheckStop:
+				hlog.Info(OK, "Stopped Health Checks.")
+				return
+			}
+		}
+	}()
+}
+
+//stopHealthChecker stops the Health Checks
+func stopHealthChecker() {
+	close(healthCheckStop)
+}
+
+//checkNodeHealth pings every known Node once, marking unreachable Nodes dead and pruning their message locations
+func checkNodeHealth() {
+	_, storageNodes := database.GetStorageNodes(healthCheckMaxNodes)
+	_, coordinatorNodes := database.GetCoordinatorNodes()
+
+	isStorageNode := make(map[string]bool, len(storageNodes))
+	for _, n := range storageNodes {
+		isStorageNode[n.Address] = true
+	}
+
+	//Nodes can be both StorageNode and CoordinatorNode, but only have to be pinged once
+	checked := make(map[string]bool)
+	alive := 0
+	for _, n := range append(storageNodes, coordinatorNodes...) {
+		if checked[n.Address] || n.Address == settings.Current().RemoteAddress {
+			continue
+		}
+		checked[n.Address] = true
+
+		select {
+		case <-healthCheckStop:
+			return
+		default:
+		}
+
+		ping := Ping(n.Address)
+		if ping < 0 {
+			if n.Liveness != node.LivenessDead {
+				hlog.Warn(GenericInternalError, "Node "+n.Address+" failed its Health Check.")
+			}
+			database.MarkNodeDead(n.Address)
+			pruneNodeLocations(n.Address)
+			continue
+		}
+		alive++
+		database.MarkNodeAlive(n.Address, ping)
+		if isStorageNode[n.Address] {
+			recordLoad(n.Address)
+		}
+		if isStorageNode[n.Address] && settings.Current().StorageNodeSelection == "weighted" {
+			recordCapacity(n.Address)
+		}
+	}
+	hlog.Info(OK, "Checked Health of "+strconv.Itoa(len(checked))+" Nodes, "+strconv.Itoa(alive)+" are alive.")
+}
+
+//recordCapacity records the free storage the StorageNode at address reports, which weights its selection
+func recordCapacity(address string) {
+	status, response := SendNodeRequest(NODE_STORAGE, address, "/control/get-storage-usage", "")
+	var usage storageUsage
+	if status != OK || json.Unmarshal(response, &usage) != nil || usage.Total <= 0 {
+		hlog.Debug(status, "Could not get storage usage of StorageNode "+address)
+		return
+	}
+	free := usage.Total - usage.Used
+	if free < 0 {
+		free = 0
+	}
+	database.SetNodeCapacity(address, free)
+}
+
+//recordLoad records the load score the StorageNode at address reports in its Health Report, which orders it behind
+//other StorageNodes once it is overloaded. Unavailable and read-only StorageNodes do not accept new messages either
+func recordLoad(address string) {
+	status, response := SendNodeRequest(NODE_STORAGE, address, "/health", "")
+	var report healthReport
+	if status != OK || json.Unmarshal(response, &report) != nil {
+		hlog.Debug(status, "Could not get Health Report of StorageNode "+address)
+		database.SetNodeLoad(address, 1)
+		return
+	}
+	if report.ReadOnly && report.Load.Score < 1 {
+		report.Load.Score = 1
+	}
+	database.SetNodeLoad(address, report.Load.Score)
+}
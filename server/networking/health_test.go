@@ -0,0 +1,66 @@
+package networking
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	"testing"
+)
+
+//breakStorage replaces the directories of the filesystem backend with files, so every operation on them fails with
+//an I/O error other than a missing blob
+func breakStorage(t *testing.T) {
+	for _, dir := range []string{"messages", "metadata", "contents"} {
+		path := settings.Current().DataPath + "/" + dir
+		if err := os.RemoveAll(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//readiness returns the status and health report of /storage/health
+func readiness(t *testing.T) (status int, report healthReport) {
+	recorder := httptest.NewRecorder()
+	handleReadiness(recorder, httptest.NewRequest(http.MethodGet, "/storage/health", nil))
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatalf("invalid health report %q: %v", recorder.Body.String(), err)
+	}
+	return recorder.Code, report
+}
+
+func TestUnavailableStorage(t *testing.T) {
+	setupNode(t)
+	settings.Current().StorageBackend = "filesystem"
+	storage.Init()
+	expectStatus(t, serve(http.MethodPost, "/storage/put/stored", strings.NewReader("content"), nil), http.StatusOK)
+	//Jobs are queued without workers, which would report the queue stalled
+	queuedJobs()
+	if status, report := readiness(t); status != http.StatusOK {
+		t.Fatalf("readiness before the failure = %d: %v", status, report.Problems)
+	}
+
+	breakStorage(t)
+	for name, recorder := range map[string]*httptest.ResponseRecorder{
+		"get":     serve(http.MethodGet, "/storage/get/stored", nil, nil),
+		"raw get": serve(http.MethodGet, "/storage/get/stored", nil, map[string]string{"Accept": "application/octet-stream"}),
+		"put":     serve(http.MethodPost, "/storage/put/new", strings.NewReader("content"), nil),
+	} {
+		if recorder.Code != http.StatusServiceUnavailable || !strings.Contains(recorder.Body.String(), ErrorStorageUnavailable) {
+			t.Errorf("%s = %d %s, want %d with %s", name, recorder.Code, recorder.Body.String(), http.StatusServiceUnavailable, ErrorStorageUnavailable)
+		}
+	}
+
+	queuedJobs()
+	status, report := readiness(t)
+	if status != http.StatusServiceUnavailable || report.Status != "unavailable" || len(report.Problems) != 1 || !strings.HasPrefix(report.Problems[0], "storage: ") {
+		t.Errorf("readiness = %d %q %v, want unavailable storage", status, report.Status, report.Problems)
+	}
+}
@@ -0,0 +1,182 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"subframe/server/database"
+	"subframe/server/jobqueue"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	. "subframe/status"
+	"subframe/structs/node"
+	"sync"
+	"time"
+)
+
+//Names of the Tasks registered with the jobqueue. They are persisted, so they must not change
+const (
+	announceJob          = "announce"
+	deannounceJob        = "deannounce"
+	updateStatusJob      = "update-status"
+	redistributeJob      = "redistribute"
+	repairReplicationJob = "repair-replication"
+)
+
+//announceRetryPolicy retries (de)announcements which reached no CoordinatorNode
+var announceRetryPolicy = &jobqueue.RetryPolicy{MaxAttempts: 5, Backoff: 10 * time.Second}
+
+func init() {
+	jobqueue.Register(announceJob, announceMessage, decodeMessageID, announceRetryPolicy)
+	jobqueue.Register(deannounceJob, deannounceMessage, decodeMessageID, announceRetryPolicy)
+	jobqueue.Register(updateStatusJob, refreshMessageStatus, decodeMessageID, nil)
+	jobqueue.Register(redistributeJob, redistribute, decodeRedistributionJob, redistributionRetryPolicy)
+	jobqueue.Register(repairReplicationJob, repairReplication, decodeMessageIDs, nil)
+}
+
+func decodeMessageID(raw []byte) (interface{}, error) {
+	var messageID string
+	err := json.Unmarshal(raw, &messageID)
+	return messageID, err
+}
+
+func decodeMessageIDs(raw []byte) (interface{}, error) {
+	var messageIDs []string
+	err := json.Unmarshal(raw, &messageIDs)
+	return messageIDs, err
+}
+
+func decodeRedistributionJob(raw []byte) (interface{}, error) {
+	job := &redistributionJob{}
+	err := json.Unmarshal(raw, job)
+	return job, err
+}
+
+//announceMessage tells the CoordinatorNetwork that this Node serves a message, and redistributes it if requested
+func announceMessage(ctx context.Context, data interface{}) error {
+	messageID, ok := data.(string)
+	if !ok {
+		slog.Error(GenericInternalError, "Error starting Announcing Thread")
+		return errors.New("announce job without message ID")
+	}
+	log := logger.Logger{Prefix: "networking/Announce-" + messageID}.WithContext(ctx)
+
+	log.Info(InProgress, "Getting CoordinatorNodes to announce Message to...")
+	_, coordinatorNodes := database.GetRandomCoordinatorNodes(settings.Current().CoordinatorAnnounceCount)
+	if len(coordinatorNodes) == 0 {
+		log.Error(CNDBReadError, "Received empty List of CoordinatorNodes.")
+		return errors.New("no CoordinatorNodes to announce message " + messageID + " to")
+	}
+	log.Info(InProgress, "Announcing Message to "+strconv.Itoa(len(coordinatorNodes))+" CoordinatorNodes...")
+	//Announce MessageID to CoordinatorNetwork, with the replication factor for its size
+	query := "/announce/" + messageID + "/" + url.PathEscape(settings.Current().RemoteAddress)
+	if size, status := storage.Size(messageID); status == http.StatusOK {
+		query += "?replicationFactor=" + strconv.Itoa(settings.Current().ReplicationFactorFor(size))
+	}
+	announced, requested := 0, 0
+	for _, result := range sendToCoordinators(ctx, coordinatorNodes, query) {
+		if result.status != OK {
+			log.Warn(result.status, "Failed to announce Message to CoordinatorNode "+result.address)
+			continue
+		}
+		announced++
+		if string(result.response) == "true" {
+			requested++
+		}
+	}
+	//Announcements are idempotent, so retrying all CoordinatorNodes is safe
+	if announced == 0 {
+		return errors.New("no CoordinatorNode accepted the announcement of message " + messageID)
+	}
+	//CoordinatorNodes may not know all locations of the message yet, so it is redistributed unless most of them
+	//know enough. A copy too many is preferred over a missing one
+	redistribute := requested*2 >= announced
+	log.Info(OK, "Announced Message to CoordinatorNetwork. Redistributing: "+strconv.FormatBool(redistribute))
+	if redistribute {
+		enqueueRedistribution(ctx, &redistributionJob{MessageID: messageID})
+	}
+	return nil
+}
+
+//coordinatorResult is the response of a CoordinatorNode to a request sent by sendToCoordinators
+type coordinatorResult struct {
+	address  string
+	status   int
+	response []byte
+}
+
+//sendToCoordinators sends the request queryString to all coordinatorNodes at once, and returns their responses
+//in the order of coordinatorNodes
+func sendToCoordinators(ctx context.Context, coordinatorNodes []node.Node, queryString string) []coordinatorResult {
+	results := make([]coordinatorResult, len(coordinatorNodes))
+	var wg sync.WaitGroup
+	for index, coordinatorNode := range coordinatorNodes {
+		wg.Add(1)
+		go func(index int, address string) {
+			defer wg.Done()
+			status, response := SendNodeRequestContext(ctx, NODE_COORDINATOR, address, queryString, "")
+			results[index] = coordinatorResult{address: address, status: status, response: response}
+		}(index, coordinatorNode.Address)
+	}
+	wg.Wait()
+	return results
+}
+
+//deannounceMessage tells the CoordinatorNetwork that this Node no longer serves a message
+func deannounceMessage(ctx context.Context, data interface{}) error {
+	messageID, ok := data.(string)
+	if !ok {
+		slog.Error(GenericInternalError, "Error starting Deannouncing Thread")
+		return errors.New("deannounce job without message ID")
+	}
+	log := logger.Logger{Prefix: "networking/Deannounce-" + messageID}.WithContext(ctx)
+
+	log.Info(InProgress, "Getting CoordinatorNodes to deannounce Message from...")
+	_, coordinatorNodes := database.GetRandomCoordinatorNodes(settings.Current().CoordinatorAnnounceCount)
+	if len(coordinatorNodes) == 0 {
+		log.Error(CNDBReadError, "Received empty List of CoordinatorNodes.")
+		return errors.New("no CoordinatorNodes to deannounce message " + messageID + " from")
+	}
+	log.Info(InProgress, "Deannouncing Message from "+strconv.Itoa(len(coordinatorNodes))+" CoordinatorNodes...")
+	deannounced := 0
+	for _, result := range sendToCoordinators(ctx, coordinatorNodes, "/deannounce/"+messageID+"/"+url.PathEscape(settings.Current().RemoteAddress)) {
+		if result.status != OK {
+			log.Warn(result.status, "Failed to deannounce Message from CoordinatorNode "+result.address)
+			continue
+		}
+		deannounced++
+	}
+	if deannounced == 0 {
+		return errors.New("no CoordinatorNode accepted the deannouncement of message " + messageID)
+	}
+	log.Info(OK, "Deannounced Message from CoordinatorNetwork.")
+	return nil
+}
+
+//refreshMessageStatus updates the local status of a message from the CoordinatorNetwork
+func refreshMessageStatus(ctx context.Context, data interface{}) error {
+	messageID, ok := data.(string)
+	if !ok {
+		slog.Error(GenericInternalError, "Error Starting Update-Thread")
+		return errors.New("update job without message ID")
+	}
+	log := logger.Logger{Prefix: "networking/Update-" + messageID}.WithContext(ctx)
+
+	//The local status is only overwritten with a confident answer, never during an outage of the CoordinatorNetwork
+	status, messageStatus := GetMessageStatusContext(ctx, messageID)
+	switch status {
+	case OK:
+		log.Info(InProgress, "Updating Message Status to "+strconv.Itoa(messageStatus))
+		database.UpdateMessageStatusStorage(messageID, messageStatus)
+		return nil
+	case CNNetworkingMessageNotFound:
+		log.Warn(status, "Message is unknown to the CoordinatorNetwork. Not updating local database.")
+		return nil
+	}
+	log.Error(status, "Received inconclusive Message Status. Not updating local database.")
+	return errors.New("inconclusive status of message " + messageID)
+}
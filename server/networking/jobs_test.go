@@ -0,0 +1,95 @@
+package networking
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"subframe/server/database"
+	. "subframe/status"
+	"subframe/structs/node"
+	"testing"
+	"time"
+)
+
+//setupStatusRefresh stores a message with status in the local database, whose status is refreshed from CoordinatorNodes
+//answering status requests with handler. Requests to other paths are rejected like CoordinatorNodes do
+func setupStatusRefresh(t *testing.T, status int, handler http.HandlerFunc) {
+	setupNode(t)
+	database.LogMessageStorage("abc", 0)
+	database.UpdateMessageStatusStorage("abc", status)
+	fakeNodes(t, NODE_COORDINATOR, 2, statusHandler(t, handler))
+}
+
+//statusHandler passes requests for the status of message abc to handler, and rejects others
+func statusHandler(t *testing.T, handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/coordinator/status/abc" {
+			t.Errorf("status requested from %s, want /coordinator/status/abc", req.URL.Path)
+			writeError(res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid Action or Arguments")
+			return
+		}
+		handler(res, req)
+	}
+}
+
+//expectMessageStatus fails the test if the local status of message abc is not messageStatus
+func expectMessageStatus(t *testing.T, messageStatus int) {
+	t.Helper()
+	if status, stored := database.GetMessageStatusStorage("abc"); status != OK || stored != messageStatus {
+		t.Errorf("local status = %d (%d), want %d", stored, status, messageStatus)
+	}
+}
+
+func TestRefreshMessageStatus(t *testing.T) {
+	setupStatusRefresh(t, 1, func(res http.ResponseWriter, req *http.Request) {
+		writeResponse(res, http.StatusOK, "2")
+	})
+
+	if err := refreshMessageStatus(context.Background(), "abc"); err != nil {
+		t.Fatalf("refreshMessageStatus() = %v", err)
+	}
+	expectMessageStatus(t, 2)
+}
+
+func TestRefreshMessageStatusTimeout(t *testing.T) {
+	setupStatusRefresh(t, 1, func(res http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		writeResponse(res, http.StatusOK, "2")
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := refreshMessageStatus(ctx, "abc"); err == nil {
+		t.Error("refreshMessageStatus() succeeded without an answer of the CoordinatorNodes")
+	}
+	expectMessageStatus(t, 1)
+}
+
+func TestRefreshMessageStatusNotFound(t *testing.T) {
+	setupStatusRefresh(t, 1, func(res http.ResponseWriter, req *http.Request) {
+		writeError(res, http.StatusNotFound, ErrorNotFound, "Message not found")
+	})
+
+	if err := refreshMessageStatus(context.Background(), "abc"); err != nil {
+		t.Errorf("refreshMessageStatus() of an unknown message = %v, want no retry", err)
+	}
+	expectMessageStatus(t, 1)
+}
+
+func TestRefreshMessageStatusFromCoordinatorNode(t *testing.T) {
+	setupNode(t)
+	database.LogMessageStorage("abc", 0)
+	//The CoordinatorNode shares the database, and records the message stored on another StorageNode
+	database.AddMessageLocation("abc", "10.0.0.1:9123")
+	server := httptest.NewServer(http.HandlerFunc(handleCoordinatorRequest))
+	t.Cleanup(server.Close)
+	database.AddCoordinatorNode(node.Node{Address: server.URL})
+
+	if err := refreshMessageStatus(context.Background(), "abc"); err != nil {
+		t.Fatalf("refreshMessageStatus() = %v", err)
+	}
+	expectMessageStatus(t, messageStatusStored)
+}
@@ -0,0 +1,111 @@
+package networking
+
+import (
+	"math"
+	"net/http"
+	"subframe/server/jobqueue"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	"sync"
+	"time"
+)
+
+//errorWindow is the period over which the error rate of a Node is measured
+const errorWindow = time.Minute
+
+//minErrorRateRequests is the number of requests within errorWindow below which the error rate is not taken into account,
+//so single failures of an idle Node do not make it overloaded
+const minErrorRateRequests = 20
+
+//overloadedRetryAfter is the time in seconds clients are asked to wait before putting to an overloaded Node again
+const overloadedRetryAfter = "30"
+
+//nodeLoad is the load of a Node as reported in its Health Report. Usages and the error rate are percentages, Score is
+//the largest of them relative to its threshold in settings. Nodes with a Score of 1 or more do not accept new messages
+type nodeLoad struct {
+	Score           float64 `json:"score"`
+	QueueUsage      float64 `json:"queueUsage"`
+	DiskUsage       float64 `json:"diskUsage"`
+	ErrorRate       float64 `json:"errorRate"`
+	AcceptingWrites bool    `json:"acceptingWrites"`
+}
+
+//requestWindow counts handled requests and server errors of the current and previous errorWindow
+type requestWindow struct {
+	mutex            sync.Mutex
+	start            time.Time
+	requests         int
+	errors           int
+	previousRequests int
+	previousErrors   int
+}
+
+var recentRequests requestWindow
+
+//rotate starts a new window if the current one has passed. Must be called with the mutex held
+func (w *requestWindow) rotate(now time.Time) {
+	elapsed := now.Sub(w.start)
+	if elapsed < errorWindow {
+		return
+	}
+	w.previousRequests, w.previousErrors = 0, 0
+	if elapsed < 2*errorWindow {
+		w.previousRequests, w.previousErrors = w.requests, w.errors
+	}
+	w.requests, w.errors = 0, 0
+	w.start = now.Truncate(errorWindow)
+}
+
+//record counts a request responded to with status. Only server errors count as failed, except the 503 responses of a
+//Node rejecting requests, which would otherwise keep it overloaded
+func (w *requestWindow) record(status int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.rotate(time.Now())
+	w.requests++
+	if status >= 500 && status != http.StatusServiceUnavailable {
+		w.errors++
+	}
+}
+
+//rate returns the percentage of failed requests within the last errorWindow, weighting the previous window by the
+//share of it still in range. Returns 0 for fewer than minErrorRateRequests requests
+func (w *requestWindow) rate() float64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	now := time.Now()
+	w.rotate(now)
+	share := 1 - float64(now.Sub(w.start))/float64(errorWindow)
+	requests := float64(w.requests) + share*float64(w.previousRequests)
+	errors := float64(w.errors) + share*float64(w.previousErrors)
+	if requests < minErrorRateRequests {
+		return 0
+	}
+	return 100 * errors / requests
+}
+
+//currentLoad returns the load of the Node, measured against the thresholds in settings
+func currentLoad() nodeLoad {
+	var load nodeLoad
+	queued, _ := jobqueue.Length()
+	if settings.Current().QueueMaxLength > 0 {
+		load.QueueUsage = 100 * float64(queued) / float64(settings.Current().QueueMaxLength)
+	}
+	if used, total := storage.Usage(); total > 0 {
+		load.DiskUsage = 100 * float64(used) / float64(total)
+	}
+	load.ErrorRate = recentRequests.rate()
+
+	load.Score = math.Max(loadRatio(load.QueueUsage, settings.Current().OverloadQueueUsage), loadRatio(load.DiskUsage, settings.Current().OverloadDiskUsage))
+	load.Score = math.Max(load.Score, loadRatio(load.ErrorRate, settings.Current().OverloadErrorRate))
+	load.AcceptingWrites = load.Score < 1
+	return load
+}
+
+//loadRatio returns usage relative to the threshold percentage, or 0 if the threshold is disabled
+func loadRatio(usage float64, threshold int) float64 {
+	if threshold <= 0 {
+		return 0
+	}
+	return usage / float64(threshold)
+}
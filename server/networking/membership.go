@@ -0,0 +1,134 @@
+package networking
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strconv"
+	"subframe/server/database"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	. "subframe/status"
+	"subframe/structs/node"
+	"time"
+)
+
+var melog = logger.Logger{Prefix: "networking/Membership"}
+
+var membershipStop = make(chan bool)
+
+//startMembership periodically merges the Nodes known to random StorageNodes into the database, and removes Nodes
+//which have not been seen for settings.NodeExpiry hours, until stopMembership is called
+func startMembership() {
+	if settings.Current().MembershipInterval <= 0 {
+		melog.Info(OK, "Membership refresh is disabled.")
+		return
+	}
+
+	melog.Info(InProgress, "Starting Membership refresh with an interval of "+strconv.Itoa(settings.Current().MembershipInterval)+" minutes...")
+	go func() {
+		ticker := time.NewTicker(time.Duration(settings.Current().MembershipInterval) * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !waitMembershipJitter() {
+					melog.Info(OK, "Stopped Membership refresh.")
+					return
+				}
+				refreshMembership()
+				expireNodes()
+			case <-membershipStop:
+				melog.Info(OK, "Stopped Membership refresh.")
+				return
+			}
+		}
+	}()
+}
+
+//stopMembership stops the Membership refresh
+func stopMembership() {
+	close(membershipStop)
+}
+
+//waitMembershipJitter waits a random time of up to settings.AnnounceJitter seconds, so the refreshes of Nodes started
+//together do not reach their peers at once. Returns false if the Membership refresh was stopped meanwhile
+func waitMembershipJitter() bool {
+	if settings.Current().AnnounceJitter <= 0 {
+		return true
+	}
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(settings.Current().AnnounceJitter) * int64(time.Second))))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-membershipStop:
+		return false
+	}
+}
+
+//refreshMembership adds the Nodes exported by settings.MembershipPeers random StorageNodes to the database
+func refreshMembership() {
+	status, peers := database.GetRandomStorageNodes(settings.Current().MembershipPeers)
+	if status != OK || len(peers) == 0 {
+		melog.Warn(status, "No StorageNodes to refresh the known Nodes from.")
+		return
+	}
+
+	learned := 0
+	for _, peer := range peers {
+		select {
+		case <-membershipStop:
+			return
+		default:
+		}
+		learned += mergeNodes(peer.Address, "get-storage-nodes", database.AddStorageNode)
+		learned += mergeNodes(peer.Address, "get-coordinator-nodes", database.AddCoordinatorNode)
+	}
+	melog.Info(OK, "Refreshed known Nodes from "+strconv.Itoa(len(peers))+" StorageNodes, learned "+strconv.Itoa(learned)+" Nodes.")
+}
+
+//mergeNodes adds the Nodes the StorageNode at address exports with the control action using add, and returns the
+//number of Nodes which were not known before. Nodes the peer considers dead are not added
+func mergeNodes(address string, action string, add func(node.Node) int) (learned int) {
+	status, response := SendNodeRequest(NODE_STORAGE, address, "/control/"+action, "")
+	var exported []node.Node
+	if status != OK || json.Unmarshal(response, &exported) != nil {
+		melog.Debug(status, "Could not get Nodes from StorageNode "+address)
+		return 0
+	}
+
+	known := make(map[string]bool)
+	_, storageNodes := database.GetStorageNodes(healthCheckMaxNodes)
+	_, coordinatorNodes := database.GetCoordinatorNodes()
+	for _, n := range append(storageNodes, coordinatorNodes...) {
+		known[n.Address] = true
+	}
+	for _, n := range exported {
+		normalized, err := node.NormalizeAddress(n.Address)
+		if err != nil || n.Liveness == node.LivenessDead || normalized == settings.Current().RemoteAddress || known[normalized] {
+			continue
+		}
+		//Learned Nodes count as seen now, the next health check records whether they are alive
+		if add(node.Node{Address: normalized, LastPing: time.Now()}) == OK {
+			learned++
+		}
+	}
+	return learned
+}
+
+//expireNodes removes Nodes which have not passed a health check for settings.NodeExpiry hours.
+//Without health checks, no Node is ever seen, so none are removed
+func expireNodes() {
+	if settings.Current().NodeExpiry <= 0 || settings.Current().HealthCheckInterval <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-time.Duration(settings.Current().NodeExpiry) * time.Hour)
+	status, addresses := database.RemoveStaleNodes(cutoff)
+	if status != OK {
+		return
+	}
+	for _, address := range addresses {
+		melog.Info(OK, "Removed Node "+address+", which was not seen for "+strconv.Itoa(settings.Current().NodeExpiry)+" hours.")
+		pruneNodeLocations(address)
+	}
+}
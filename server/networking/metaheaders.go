@@ -0,0 +1,82 @@
+package networking
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+//metaHeaderPrefix starts the request headers a message's headers are put with, and the response headers they are
+//returned in when streaming it, e.g. X-Meta-Content-Type
+const metaHeaderPrefix = "X-Meta-"
+
+//metaParamPrefix starts the query parameters passing a message's headers to other StorageNodes
+const metaParamPrefix = "meta-"
+
+//maxMetaHeaders bounds the number of headers of a message
+const maxMetaHeaders = 32
+
+//maxMetaHeadersSize bounds the summed length of the names and values of a message's headers
+const maxMetaHeadersSize = 8192
+
+//messageHeaders returns the headers of a message put with the request from its X-Meta-* headers, by name without the
+//prefix. Copies pushed by other Nodes pass them in meta-* parameters of signed requests instead. Repeated headers are
+//joined with commas
+func (r storageRequest) messageHeaders() (headers map[string]string, valid bool) {
+	values := make(map[string][]string)
+	for name, value := range r.req.Header {
+		if strings.HasPrefix(name, metaHeaderPrefix) {
+			values[strings.TrimPrefix(name, metaHeaderPrefix)] = value
+		}
+	}
+	if r.privileged {
+		for name, value := range r.req.URL.Query() {
+			if strings.HasPrefix(name, metaParamPrefix) {
+				values[http.CanonicalHeaderKey(strings.TrimPrefix(name, metaParamPrefix))] = value
+			}
+		}
+	}
+	if len(values) == 0 {
+		return nil, true
+	}
+	if len(values) > maxMetaHeaders {
+		return nil, false
+	}
+
+	headers = make(map[string]string, len(values))
+	size := 0
+	for name, value := range values {
+		joined := strings.TrimSpace(strings.Join(value, ","))
+		if name == "" || !validHeaderText(name) || !validHeaderText(joined) {
+			return nil, false
+		}
+		size += len(name) + len(joined)
+		headers[name] = joined
+	}
+	return headers, size <= maxMetaHeadersSize
+}
+
+//validHeaderText returns whether text only consists of printable characters, which can be sent in a header again
+func validHeaderText(text string) bool {
+	for _, char := range text {
+		if !unicode.IsPrint(char) || char > unicode.MaxASCII {
+			return false
+		}
+	}
+	return true
+}
+
+//setMetaHeaders returns the headers of a message as X-Meta-* headers of the response
+func setMetaHeaders(res http.ResponseWriter, headers map[string]string) {
+	for name, value := range headers {
+		res.Header().Set(metaHeaderPrefix+name, value)
+	}
+}
+
+//addMetaParams adds the headers of a message to params as meta-* parameters
+func addMetaParams(params url.Values, headers map[string]string) {
+	for name, value := range headers {
+		params.Set(metaParamPrefix+name, value)
+	}
+}
@@ -0,0 +1,102 @@
+package networking
+
+import (
+	"net/http"
+	"strconv"
+	"subframe/server/metrics"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	. "subframe/status"
+	"time"
+)
+
+var requestsTotal = metrics.NewCounterVec("subframe_requests_total", "Handled API requests by action and HTTP status.", "action", "status")
+
+var nodeRequestDuration = metrics.NewHistogramVec("subframe_node_request_duration_seconds", "Duration of outgoing requests to other Nodes, per attempt.", metrics.DefaultBuckets, "node_type", "result")
+
+var replicationFailures = metrics.NewCounterVec("subframe_replication_failures_total", "Redistribution attempts which stored fewer Replicas than the replication factor.")
+
+var notFoundCacheHits = metrics.NewCounterVec("subframe_not_found_cache_hits_total", "Gets answered with 404 from the IDs of messages recently not found.")
+
+var storageTimeouts = metrics.NewCounterVec("subframe_storage_timeouts_total", "Storage operations abandoned after exceeding their timeout, by operation.", "operation")
+
+func init() {
+	metrics.NewGaugeFunc("subframe_stored_bytes", "Bytes used by locally stored messages.", func() float64 {
+		used, _ := storage.Usage()
+		return float64(used)
+	})
+	metrics.NewGaugeFunc("subframe_storage_capacity_bytes", "Bytes available for message storage.", func() float64 {
+		_, total := storage.Usage()
+		return float64(total)
+	})
+	metrics.NewGaugeFunc("subframe_load_score", "Load score of the Node, new messages are rejected from 1.", func() float64 {
+		return currentLoad().Score
+	})
+}
+
+//registerMetricsEndpoint serves metrics at /metrics if settings.MetricsEnabled is set
+func registerMetricsEndpoint() {
+	if !settings.Current().MetricsEnabled {
+		return
+	}
+	mlog.Info(OK, "Serving metrics at /metrics.")
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, req *http.Request) {
+		if settings.Current().AuthToken != "" && !checkToken(w, req, "metrics") {
+			return
+		}
+		metrics.Handler(w, req)
+	})
+}
+
+//statusRecorder remembers the status code written to the wrapped ResponseWriter.
+//Only the first status is written, a second response after it is discarded instead of corrupting the body
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	superfluous bool
+	//written counts the body bytes written, before compression
+	written int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		r.superfluous = true
+		return
+	}
+	r.wroteHeader = true
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(content []byte) (int, error) {
+	if r.superfluous {
+		return len(content), nil
+	}
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(content)
+	r.written += int64(n)
+	return n, err
+}
+
+//countRequest counts a handled request. Unknown actions are counted together, keeping the number of series bounded
+func countRequest(action string, known map[string][]string, recorder *statusRecorder) {
+	if _, ok := known[action]; !ok {
+		action = "unknown"
+	}
+	requestsTotal.Inc(action, strconv.Itoa(recorder.status))
+	recentRequests.record(recorder.status)
+}
+
+//observeNodeRequest records the duration of an outgoing request started at start
+func observeNodeRequest(nodeType int, start time.Time, status int) {
+	nodeTypeLabel := "storage"
+	if nodeType == NODE_COORDINATOR {
+		nodeTypeLabel = "coordinator"
+	}
+	result := "ok"
+	if status != OK {
+		result = "error"
+	}
+	nodeRequestDuration.Observe(time.Since(start).Seconds(), nodeTypeLabel, result)
+}
@@ -0,0 +1,92 @@
+package networking
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+)
+
+//multipartOverhead is the size of boundaries and part headers a multipart/form-data put may send beyond its content
+const multipartOverhead = 64 * 1024
+
+//contentFieldName is the form field taken as content of multipart puts without file part
+const contentFieldName = "content"
+
+//errContentTooLarge is returned by readContent for content exceeding the maximum message size
+var errContentTooLarge = errors.New("content exceeds the maximum message size")
+
+//errNoContentPart is returned by readContent for multipart bodies without file part or content field
+var errNoContentPart = errors.New("multipart body has no file part")
+
+//isMultipart returns whether req is a multipart/form-data upload, as sent by HTML forms
+func isMultipart(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+//countingReader counts the bytes read from reader
+type countingReader struct {
+	reader io.Reader
+	read   int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+//readContent reads the content of a put of at most maxSize bytes: the raw body, or the first file part of
+//multipart/form-data uploads. Other form fields are skipped, a field named contentFieldName is taken if there is no file part.
+//The body has to be limited by a MaxBytesReader of bodyLimit bytes. partType is the Content-Type of the file part
+func (r storageRequest) readContent(maxSize int64, bodyLimit int64) (content []byte, partType string, err error) {
+	body := &countingReader{reader: r.req.Body}
+	if !isMultipart(r.req) {
+		content, err = ioutil.ReadAll(body)
+	} else {
+		content, partType, err = readMultipartContent(r.req, body, maxSize)
+	}
+	if err != nil && body.read >= bodyLimit {
+		return nil, "", errContentTooLarge
+	}
+	return content, partType, err
+}
+
+func readMultipartContent(req *http.Request, body io.Reader, maxSize int64) (content []byte, partType string, err error) {
+	_, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if params["boundary"] == "" {
+		return nil, "", errors.New("multipart body without boundary")
+	}
+	reader := multipart.NewReader(body, params["boundary"])
+	var field []byte
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			if field == nil {
+				return nil, "", errNoContentPart
+			}
+			return field, "", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if part.FileName() == "" && part.FormName() != contentFieldName {
+			continue
+		}
+
+		content, err = ioutil.ReadAll(io.LimitReader(part, maxSize+1))
+		if err != nil {
+			return nil, "", err
+		}
+		if int64(len(content)) > maxSize {
+			return nil, "", errContentTooLarge
+		}
+		if part.FileName() != "" {
+			return content, part.Header.Get("Content-Type"), nil
+		}
+		field = content
+	}
+}
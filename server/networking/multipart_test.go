@@ -0,0 +1,113 @@
+package networking
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+//formPart is a part of a multipart/form-data body, a file part if fileName is set
+type formPart struct {
+	name        string
+	fileName    string
+	contentType string
+	content     string
+}
+
+//multipartBody encodes parts as multipart/form-data, and returns the body with its Content-Type
+func multipartBody(t *testing.T, parts ...formPart) (body *bytes.Buffer, contentType string) {
+	body = &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		disposition := `form-data; name="` + part.name + `"`
+		if part.fileName != "" {
+			disposition += `; filename="` + part.fileName + `"`
+		}
+		header.Set("Content-Disposition", disposition)
+		if part.contentType != "" {
+			header.Set("Content-Type", part.contentType)
+		}
+		field, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		field.Write([]byte(part.content))
+	}
+	writer.Close()
+	return body, writer.FormDataContentType()
+}
+
+func TestReadMultipartContent(t *testing.T) {
+	file := formPart{name: "upload", fileName: "note.txt", contentType: "text/markdown", content: "file content"}
+	field := formPart{name: contentFieldName, content: "field content"}
+	other := formPart{name: "comment", content: "ignored"}
+
+	tests := []struct {
+		name         string
+		parts        []formPart
+		maxSize      int64
+		wantContent  string
+		wantPartType string
+		wantErr      error
+	}{
+		{"file part", []formPart{other, file}, 100, "file content", "text/markdown", nil},
+		{"file part before content field", []formPart{field, file}, 100, "file content", "text/markdown", nil},
+		{"content field without file part", []formPart{other, field}, 100, "field content", "", nil},
+		{"no content", []formPart{other}, 100, "", "", errNoContentPart},
+		{"file part at the limit", []formPart{file}, int64(len(file.content)), "file content", "text/markdown", nil},
+		{"file part exceeding the limit", []formPart{file}, int64(len(file.content)) - 1, "", "", errContentTooLarge},
+		{"content field exceeding the limit", []formPart{field}, 4, "", "", errContentTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, contentType := multipartBody(t, test.parts...)
+			req := httptest.NewRequest(http.MethodPost, "/storage/put/abc", body)
+			req.Header.Set("Content-Type", contentType)
+
+			content, partType, err := readMultipartContent(req, req.Body, test.maxSize)
+			if err != test.wantErr {
+				t.Fatalf("readMultipartContent() error = %v, want %v", err, test.wantErr)
+			}
+			if string(content) != test.wantContent || partType != test.wantPartType {
+				t.Errorf("readMultipartContent() = %q, %q, want %q, %q", content, partType, test.wantContent, test.wantPartType)
+			}
+		})
+	}
+}
+
+func TestReadMultipartContentWithoutBoundary(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/storage/put/abc", strings.NewReader("content"))
+	req.Header.Set("Content-Type", "multipart/form-data")
+	if _, _, err := readMultipartContent(req, req.Body, 100); err == nil {
+		t.Error("readMultipartContent() accepted a body without boundary")
+	}
+}
+
+func TestMultipartAndRawPuts(t *testing.T) {
+	setupNode(t)
+	withMessageMaxSize(t, 1)
+	raw := map[string]string{"Accept": "application/octet-stream"}
+
+	body, contentType := multipartBody(t, formPart{name: "upload", fileName: "note.txt", content: "from a form"})
+	expectStatus(t, serve(http.MethodPost, "/storage/put/form", body, map[string]string{"Content-Type": contentType}), http.StatusOK)
+	expectStatus(t, serve(http.MethodPost, "/storage/put/raw", strings.NewReader("raw body"), nil), http.StatusOK)
+	for id, want := range map[string]string{"form": "from a form", "raw": "raw body"} {
+		recorder := serve(http.MethodGet, "/storage/get/"+id, nil, raw)
+		expectStatus(t, recorder, http.StatusOK)
+		if recorder.Body.String() != want {
+			t.Errorf("content of %s = %q, want %q", id, recorder.Body.String(), want)
+		}
+	}
+
+	//The file part is limited to settings.MessageMaxSize, even though the body may exceed it by multipartOverhead
+	large, contentType := multipartBody(t, formPart{name: "upload", fileName: "large.bin", content: strings.Repeat("a", 1024*1024+1)})
+	expectStatus(t, serve(http.MethodPost, "/storage/put/large", large, map[string]string{"Content-Type": contentType}), http.StatusRequestEntityTooLarge)
+
+	empty, contentType := multipartBody(t, formPart{name: "comment", content: "no file"})
+	expectStatus(t, serve(http.MethodPost, "/storage/put/empty", empty, map[string]string{"Content-Type": contentType}), http.StatusBadRequest)
+}
@@ -2,7 +2,9 @@ package networking
 
 import (
 	"subframe/server/logger"
+	"subframe/server/settings"
 	. "subframe/status"
+	"time"
 )
 
 var mlog = logger.Logger{Prefix: "networking/Main"}
@@ -10,16 +12,37 @@ var mlog = logger.Logger{Prefix: "networking/Main"}
 //Init Initializes StorageNode HTTP Api and starts coordinator network service
 func Init() {
 	mlog.Info(InProgress, "Initializing Networking...")
+	initNodeClient()
+	loadReadOnly()
+
 	//Start StorageNode Api
 	startStorageNodeAPIService()
+	startGRPCService()
+
+	startCollector()
+	startHealthChecker()
+	startAntiEntropy()
+	startMembership()
+	startCompaction()
 
 	//Start CoordinatorNode service
+	registerCoordinatorNodeAPI()
+	registerMetricsEndpoint()
 	mlog.Info(OK, "Initialized Networking.")
 }
 
 //Stop terminates and stops all active network connections and interfaces
 func Stop() {
 	mlog.Info(InProgress, "Stopping Networking...")
+	stopStorageNodeAPIService(time.Duration(settings.Current().ShutdownTimeout) * time.Second)
+	stopGRPCService(time.Duration(settings.Current().ShutdownTimeout) * time.Second)
+	stopCollector()
+	stopHealthChecker()
+	stopAntiEntropy()
+	stopMembership()
+	stopCompaction()
+	stopDrain()
+	stopVerification()
 
 	mlog.Info(OK, "Stopped Networking.")
 }
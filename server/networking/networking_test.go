@@ -0,0 +1,99 @@
+package networking
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"subframe/server/database"
+	"subframe/server/jobqueue"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	"subframe/structs/node"
+	"testing"
+)
+
+//TestMain silences the logs of the tests, which reject malformed requests on purpose
+func TestMain(m *testing.M) {
+	logger.Level = logger.LogtypeFatal
+	settings.Current().AccessLogFormat = "off"
+	os.Exit(m.Run())
+}
+
+//setupNode initializes the in-memory storage and database of a StorageNode with a temporary data directory. Jobs are queued
+//without workers, so tests can inspect them. Settings are restored after the test
+func setupNode(t testing.TB) {
+	current := settings.Current()
+	previousPath, previousBackend, previousDatabase, previousQueue := current.DataPath, current.StorageBackend, current.DatabaseBackend, jobqueue.Queue
+	current.DataPath = t.TempDir()
+	current.StorageBackend, current.DatabaseBackend = "memory", "memory"
+	jobqueue.Queue = make(chan jobqueue.Job, 100)
+
+	storage.Init()
+	database.Init()
+	t.Cleanup(func() {
+		database.Close()
+		current.DataPath, current.StorageBackend, current.DatabaseBackend, jobqueue.Queue = previousPath, previousBackend, previousDatabase, previousQueue
+	})
+}
+
+//serve handles a request to the StorageNode API, setting headers by name
+func serve(method string, target string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	handleRequest(recorder, req)
+	return recorder
+}
+
+//queuedJobs drains and returns the Jobs queued since setupNode
+func queuedJobs() (jobs []jobqueue.Job) {
+	for {
+		select {
+		case job := <-jobqueue.Queue:
+			jobs = append(jobs, job)
+		default:
+			return jobs
+		}
+	}
+}
+
+//expectStatus fails the test if recorder did not respond with status
+func expectStatus(t *testing.T, recorder *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if recorder.Code != status {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, status, recorder.Body.String())
+	}
+}
+
+//runQueuedJobs executes the queued Jobs, and the Jobs they queue, until the Queue is empty. Failed Jobs are not retried
+func runQueuedJobs(t *testing.T) {
+	t.Helper()
+	for jobs := queuedJobs(); len(jobs) > 0; jobs = queuedJobs() {
+		for _, job := range jobs {
+			if err := job.Task(context.Background(), job.Data); err != nil {
+				t.Logf("Job %s failed: %v", job.Name, err)
+			}
+		}
+	}
+}
+
+//fakeNodes starts count test servers answering all requests with handler, and adds them to the database as
+//StorageNodes or CoordinatorNodes
+func fakeNodes(t testing.TB, nodeType int, count int, handler http.HandlerFunc) (addresses []string) {
+	for index := 0; index < count; index++ {
+		server := httptest.NewServer(handler)
+		t.Cleanup(server.Close)
+		if nodeType == NODE_STORAGE {
+			database.AddStorageNode(node.Node{Address: server.URL})
+		} else {
+			database.AddCoordinatorNode(node.Node{Address: server.URL})
+		}
+		addresses = append(addresses, server.URL)
+	}
+	return addresses
+}
@@ -2,139 +2,366 @@ package networking
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"subframe/server/database"
 	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/server/tracing"
 	. "subframe/status"
+	"subframe/structs/node"
+	"sync"
+	"syscall"
+	"time"
 )
 
 var nlog = logger.Logger{Prefix: "networking/NodeConnector"}
 
+//nodeClient is shared by all outgoing requests, so connections to the same Node are reused
+var nodeClient = newNodeClient()
+
+//initNodeClient recreates nodeClient with the timeouts and connection limits of the settings read on startup
+func initNodeClient() {
+	nodeClient = newNodeClient()
+}
+
+//newNodeClient creates a client with a Transport tuned for repeated requests to few Nodes
+func newNodeClient() *http.Client {
+	connectTimeout := time.Duration(settings.Current().NodeConnectTimeout) * time.Second
+	requestTimeout := time.Duration(settings.Current().NodeRequestTimeout) * time.Second
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   settings.Current().NodeMaxIdleConnections,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: requestTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   requestTimeout,
+	}
+}
+
 //NODE_STORAGE specifies that the request is to be sent to a StorageNode
 var NODE_STORAGE = 1
 
 //NODE_COORDINATOR specifies that the request is to be sent to a CoordinatorNode
 var NODE_COORDINATOR = 2
 
-//SendNodeRequest sends a synchronous request to the specified node
+//SendNodeRequest sends a synchronous request to the specified node, bounded by settings.NodeRequestTimeout
 func SendNodeRequest(nodeType int, address string, queryString string, data string) (status int, response []byte) {
+	return SendNodeRequestContext(context.Background(), nodeType, address, queryString, data)
+}
+
+//SendNodeRequestContext sends a synchronous request to the specified node, which is aborted once ctx is done.
+//Timeouts, refused connections and non-2xx responses are reported with distinct status codes
+//Failed requests are retried up to settings.NodeRequestAttempts times with exponential backoff,
+//unless the Node responded with a 4xx status
+func SendNodeRequestContext(ctx context.Context, nodeType int, address string, queryString string, data string) (status int, response []byte) {
+	var errs requestErrors
+	var send func() (int, []byte)
 	switch nodeType {
 	case NODE_STORAGE:
-		return sendStorageNodeRequest(address, queryString, data)
+		errs = storageNodeErrors
+		send = func() (int, []byte) { return sendStorageNodeRequest(ctx, address, queryString, data) }
 	case NODE_COORDINATOR:
-		return sendCoordinatorNodeRequest(address, queryString)
+		errs = coordinatorNodeErrors
+		send = func() (int, []byte) { return sendCoordinatorNodeRequest(ctx, address, queryString) }
+	default:
+		return NetworkingBadNodeType, nil
 	}
-	return NetworkingBadNodeType, nil
+
+	delay := time.Duration(settings.Current().NodeRequestRetryDelay) * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		status, response = send()
+		observeNodeRequest(nodeType, start, status)
+		if status == OK || status == errs.badResponse || attempt >= settings.Current().NodeRequestAttempts {
+			return status, response
+		}
+
+		//Jitter keeps Nodes retrying against the same peer from synchronizing
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		nlog.WithContext(ctx).Warn(status, "Request to "+address+" failed (attempt "+strconv.Itoa(attempt)+"). Retrying in "+wait.String()+"...")
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return errs.timeout, nil
+		}
+		delay *= 2
+	}
+}
+
+//requestErrors holds the status codes reported for failed requests to one type of Node.
+//badResponse is used for 4xx and other unexpected responses, serverError for 5xx
+type requestErrors struct {
+	outgoing, reading, timeout, refused, badResponse, serverError int
+}
+
+var storageNodeErrors = requestErrors{
+	SNNetworkingOutgoingRequestError, SNNetworkingReadingResponseError, SNNetworkingTimeout, SNNetworkingConnectionRefused, SNNetworkingBadResponse, SNNetworkingServerError,
+}
+
+var coordinatorNodeErrors = requestErrors{
+	CNNetworkingOutgoingRequestError, CNNetworkingReadingResponseError, CNNetworkingTimeout, CNNetworkingConnectionRefused, CNNetworkingBadResponse, CNNetworkingServerError,
 }
 
-func sendStorageNodeRequest(address string, queryString string, data string) (status int, response []byte) {
-	var resp *http.Response
+func sendStorageNodeRequest(ctx context.Context, address string, queryString string, data string) (status int, response []byte) {
+	log := nlog.WithContext(ctx)
+	var req *http.Request
 	var err error
 	if data == "" {
 		//There is no data to be POSTed, send GET Request
-		nlog.Info("Sending StorageNode GET Request to " + address + "/storage" + queryString + "...")
-		resp, err = http.Get(address + "/storage" + queryString)
-
+		log.Info(InProgress, "Sending StorageNode GET Request to "+nodeURL(address)+"/storage"+queryString+"...")
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, nodeURL(address)+"/storage"+queryString, nil)
 	} else {
 		//There is data to be POSTed, send POST Request
-		nlog.Info("Sending StorageNode POST Request to " + address + "/storage" + queryString + "...")
-		resp, err = http.Post(address+"/storage"+queryString, "raw", bytes.NewBufferString(data))
+		log.Info(InProgress, "Sending StorageNode POST Request to "+nodeURL(address)+"/storage"+queryString+"...")
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, nodeURL(address)+"/storage"+queryString, bytes.NewBufferString(data))
+		if err == nil {
+			req.Header.Set("Content-Type", "raw")
+		}
 	}
 	if err != nil {
-		nlog.Error("Error sending request: " + err.Error())
+		log.Error(SNNetworkingOutgoingRequestError, "Error creating request: "+err.Error())
 		return SNNetworkingOutgoingRequestError, nil
 	}
-	defer resp.Body.Close()
+	return doNodeRequest(req, storageNodeErrors)
+}
 
-	nlog.Info("Reading response...")
-	body, err := ioutil.ReadAll(resp.Body)
+func sendCoordinatorNodeRequest(ctx context.Context, address string, queryString string) (status int, response []byte) {
+	log := nlog.WithContext(ctx)
+	//TODO: Send Request, get response; if in coordinator network send request via socket
+	log.Info(InProgress, "Sending CoordinatorNode HTTP Request to "+nodeURL(address)+"/coordinator"+queryString+"...")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, nodeURL(address)+"/coordinator"+queryString, nil)
 	if err != nil {
-		nlog.Error("Error reading response: " + err.Error())
-		return SNNetworkingReadingResponseError, nil
+		log.Error(CNNetworkingOutgoingRequestError, "Error creating request: "+err.Error())
+		return CNNetworkingOutgoingRequestError, nil
 	}
-
-	nlog.Info("Read response.")
-	return OK, body
+	return doNodeRequest(req, coordinatorNodeErrors)
 }
 
-func sendCoordinatorNodeRequest(address string, queryString string) (status int, response []byte) {
-	//TODO: Send Request, get response; if in coordinator network send request via socket
-	nlog.Info("Sending CoordinatorNode HTTP Request to " + address + "/coordinator" + queryString + "...")
-	resp, err := http.Get(address + "/coordinator" + queryString)
+//doNodeRequest sends req and reads the response body. Non-2xx responses still return the body
+func doNodeRequest(req *http.Request, errs requestErrors) (status int, response []byte) {
+	log := nlog.WithContext(req.Context())
+	ctx, span := tracing.Start(req.Context(), "HTTP "+req.Method, tracing.KindClient)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", req.URL.String())
+	defer func() {
+		if status != OK {
+			span.SetError("Request failed with status " + strconv.Itoa(status))
+		}
+		span.End()
+	}()
+	tracing.Inject(ctx, req.Header)
+	setAuthHeader(req)
+	if requestID := logger.RequestID(req.Context()); requestID != "" {
+		req.Header.Set(requestIDHeader, requestID)
+	}
+	if !signRequest(req) {
+		return errs.outgoing, nil
+	}
+
+	resp, err := nodeClient.Do(req)
 	if err != nil {
-		nlog.Error("Error sending request: " + err.Error())
-		return CNNetworkingOutgoingRequestError, nil
+		status = classifyRequestError(err, errs)
+		log.Error(status, "Error sending request: "+err.Error())
+		return status, nil
 	}
 	defer resp.Body.Close()
 
-	nlog.Info("Reading response...")
+	log.Debug(InProgress, "Reading response...")
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		nlog.Error("Error reading response: " + err.Error())
-		return CNNetworkingReadingResponseError, nil
+		status = classifyRequestError(err, errs)
+		if status == errs.outgoing {
+			status = errs.reading
+		}
+		log.Error(status, "Error reading response: "+err.Error())
+		return status, nil
 	}
 
-	nlog.Info("Read response")
+	if resp.StatusCode >= 500 {
+		log.Warn(errs.serverError, "Node responded with "+resp.Status)
+		return errs.serverError, body
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Warn(errs.badResponse, "Node responded with "+resp.Status)
+		return errs.badResponse, body
+	}
+
+	log.Debug(OK, "Read response.")
 	return OK, body
 }
 
-//Ping returns the current Ping to the specified address
+//classifyRequestError distinguishes timeouts and refused connections from other request errors
+func classifyRequestError(err error, errs requestErrors) int {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return errs.timeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return errs.refused
+	}
+	return errs.outgoing
+}
+
+//Ping returns the round trip time in milliseconds of a health check of the Node at address, or -1 if it is unreachable
 func Ping(address string) (ping int) {
-	//TODO: Get Ping of Node
-	nlog.Info("Pinging Node " + address)
+	nlog.Debug(InProgress, "Pinging Node "+address+"...")
 
-	ping = 123
+	start := time.Now()
+	status, _ := SendNodeRequest(NODE_STORAGE, address, "/health/live", "")
+	if status != OK {
+		nlog.Warn(status, "Ping test for "+address+" failed.")
+		return -1
+	}
+	ping = int(time.Since(start) / time.Millisecond)
 
-	nlog.Info("Ping test for " + address + " returned: " + strconv.Itoa(ping))
+	nlog.Debug(OK, "Ping test for "+address+" returned: "+strconv.Itoa(ping))
 	return ping
 }
 
 //GetMessageStatus queries the CoordinatorNetwork for the status of the specified message
-func GetMessageStatus(messageID string) (status int) {
-	nlog.Info("Getting Status for Message " + messageID + " from CoordinatorNetwork...")
+func GetMessageStatus(messageID string) (status int, messageStatus int) {
+	return GetMessageStatusContext(context.Background(), messageID)
+}
+
+//GetMessageStatusContext queries up to settings.CoordinatorAnnounceCount CoordinatorNodes for the status of the specified
+//message, forwarding the request ID of ctx. If none of them answers, the remaining known CoordinatorNodes are queried in
+//rounds of the same size, until one answers or all were asked. Each round is bounded by settings.NodeRequestTimeout.
+//Returns OK and the status if all answering CoordinatorNodes agree, CNNetworkingMessageNotFound if all answering
+//CoordinatorNodes do not know the message, CNNetworkingBadResponse if they rejected the request, and CNNetworkingUnreachable
+//if none answered. Rejections are definite answers, which are not failed over. messageStatus is -1 unless OK is returned
+func GetMessageStatusContext(ctx context.Context, messageID string) (status int, messageStatus int) {
+	log := nlog.WithContext(ctx)
+	log.Info(InProgress, "Getting Status for Message "+messageID+" from CoordinatorNetwork...")
 	//If Message is not present in local database, no need to check status
 	s, isStored := database.CheckMessageStorage(messageID)
-
 	if s != OK {
-		nlog.Error("Failed to check whether message is stored on this Node. Aborting...")
-		return
+		log.Error(s, "Failed to check whether message is stored on this Node. Aborting...")
+		return s, -1
+	}
+	if !isStored {
+		log.Error(GenericInputError, "Message "+messageID+" does not appear to be stored on this Node.")
+		return GenericInputError, -1
+	}
+
+	log.Debug(InProgress, "Getting CoordinatorNodes...")
+	s, coordinatorNodes := database.GetRandomCoordinatorNodes(settings.Current().CoordinatorAnnounceCount)
+	if s != OK || len(coordinatorNodes) == 0 {
+		log.Error(s, "Failed to get CoordinatorNodes.")
+		return CNNetworkingUnreachable, -1
 	}
 
-	if isStored {
-		nlog.Error("Message " + messageID + " does not appear to be stored on this Node.")
-		return
+	status, messageStatus = askMessageStatus(ctx, messageID, coordinatorNodes)
+	if status != CNNetworkingUnreachable {
+		return status, messageStatus
 	}
 
-	//Get Status from up to three different coordinator nodes
-	nlog.Info("Getting CoordinatorNodes...")
-	s, coordinatorNodes := database.GetRandomCoordinatorNodes(3)
+	//Fail over to the CoordinatorNodes not asked yet, alive ones first
+	asked := make(map[string]bool, len(coordinatorNodes))
+	for _, value := range coordinatorNodes {
+		asked[value.Address] = true
+	}
+	s, allNodes := database.GetCoordinatorNodes()
 	if s != OK {
-		nlog.Error("Failed to get CoordinatorNodes.")
-		return
+		log.Error(s, "Failed to get remaining CoordinatorNodes.")
+		return CNNetworkingUnreachable, -1
+	}
+	var remaining []node.Node
+	for _, value := range allNodes {
+		if !asked[value.Address] {
+			remaining = append(remaining, value)
+		}
 	}
-	nlog.Info("Got " + strconv.Itoa(len(coordinatorNodes)) + " CoordinatorNodes.")
-	newStatus := make([]string, len(coordinatorNodes))
+	for len(remaining) > 0 && ctx.Err() == nil {
+		round := remaining
+		if len(round) > settings.Current().CoordinatorAnnounceCount {
+			round = round[:settings.Current().CoordinatorAnnounceCount]
+		}
+		remaining = remaining[len(round):]
+		log.Warn(CNNetworkingUnreachable, "No CoordinatorNode answered yet. Asking "+strconv.Itoa(len(round))+" more CoordinatorNodes...")
+		status, messageStatus = askMessageStatus(ctx, messageID, round)
+		if status != CNNetworkingUnreachable {
+			return status, messageStatus
+		}
+	}
+	log.Error(CNNetworkingUnreachable, "No CoordinatorNode answered the Status request for Message "+messageID)
+	return CNNetworkingUnreachable, -1
+}
+
+//askMessageStatus queries coordinatorNodes at once for the status of the specified message, see GetMessageStatusContext.
+//Returns CNNetworkingUnreachable if none of them answered, not even with a 4xx rejection
+func askMessageStatus(ctx context.Context, messageID string, coordinatorNodes []node.Node) (status int, messageStatus int) {
+	log := nlog.WithContext(ctx)
+	//All CoordinatorNodes are asked at once, so a hanging one cannot hold the query longer than the timeout
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(settings.Current().NodeRequestTimeout)*time.Second)
+	defer cancel()
+	statuses := make([]int, len(coordinatorNodes))
+	responses := make([][]byte, len(coordinatorNodes))
+	var wg sync.WaitGroup
 	for index, value := range coordinatorNodes {
-		newStatus[index] = sendCoordinatorNodeRequest(value, "/status/"+messageID)
+		wg.Add(1)
+		go func(index int, address string) {
+			defer wg.Done()
+			statuses[index], responses[index] = SendNodeRequestContext(ctx, NODE_COORDINATOR, address, "/status/"+messageID, "")
+		}(index, value.Address)
 	}
+	wg.Wait()
 
-	nlog.Info("Got status from " + strconv.Itoa(len(coordinatorNodes)) + " Nodes. Checking...")
-	for _, value := range newStatus {
-		if value != newStatus[0] {
+	answered, notFound, rejected := 0, 0, 0
+	messageStatus = -1
+	for index := range coordinatorNodes {
+		if statuses[index] != OK {
+			if errorCode(responses[index]) == ErrorNotFound {
+				notFound++
+			} else if statuses[index] == CNNetworkingBadResponse {
+				rejected++
+			}
+			continue
+		}
+		value, err := strconv.Atoi(strings.TrimSpace(string(responses[index])))
+		if err != nil {
+			log.Warn(CNNetworkingBadResponse, "Invalid Status from CoordinatorNode "+coordinatorNodes[index].Address+": "+err.Error())
+			rejected++
+			continue
+		}
+		if answered > 0 && value != messageStatus {
 			//TODO: Network is out of sync; handle appropriately
-			nlog.Error("Status do not match. CoordinatorNetwork appears out of sync.")
-			return -1
+			log.Error(CNNetworkingBadResponse, "Status do not match. CoordinatorNetwork appears out of sync.")
+			return CNNetworkingBadResponse, -1
 		}
+		messageStatus = value
+		answered++
 	}
 
-	//Network is in sync, return status
-	nlog.Info("New Status appear valid. Returning.")
-	status, err := strconv.Atoi(newStatus[0])
-	if err == nil {
-		return status
+	switch {
+	case answered > 0 && notFound > 0:
+		log.Error(CNNetworkingBadResponse, "Message is unknown to some CoordinatorNodes. CoordinatorNetwork appears out of sync.")
+		return CNNetworkingBadResponse, -1
+	case answered > 0:
+		log.Debug(OK, "Got Status "+strconv.Itoa(messageStatus)+" from "+strconv.Itoa(answered)+" CoordinatorNodes.")
+		return OK, messageStatus
+	case notFound > 0:
+		log.Warn(CNNetworkingMessageNotFound, "Message "+messageID+" is unknown to the CoordinatorNetwork.")
+		return CNNetworkingMessageNotFound, -1
+	case rejected > 0:
+		log.Error(CNNetworkingBadResponse, strconv.Itoa(rejected)+" CoordinatorNodes rejected the Status request for Message "+messageID)
+		return CNNetworkingBadResponse, -1
 	}
-	nlog.Error("Error returning new Status: " + err.Error())
-	return -1
+	log.Warn(CNNetworkingUnreachable, strconv.Itoa(len(coordinatorNodes))+" CoordinatorNodes did not answer the Status request for Message "+messageID)
+	return CNNetworkingUnreachable, -1
 }
@@ -0,0 +1,218 @@
+package networking
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"subframe/server/database"
+	"subframe/server/settings"
+	. "subframe/status"
+	"subframe/structs/node"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNodeClientIsPooledBeforeInit(t *testing.T) {
+	if nodeClient == http.DefaultClient {
+		t.Fatal("nodeClient is http.DefaultClient")
+	}
+	transport, ok := nodeClient.Transport.(*http.Transport)
+	if !ok || transport.MaxIdleConnsPerHost < 1 {
+		t.Errorf("nodeClient does not keep idle connections per Node")
+	}
+}
+
+//BenchmarkAnnounce announces a message to the same three CoordinatorNodes repeatedly, and reports the connections
+//opened per announcement
+func BenchmarkAnnounce(b *testing.B) {
+	clients := map[string]*http.Client{
+		"shared":   newNodeClient(),
+		"unpooled": {Transport: &http.Transport{DisableKeepAlives: true}},
+	}
+	for name, client := range clients {
+		b.Run(name, func(b *testing.B) {
+			setupNode(b)
+			previous := nodeClient
+			nodeClient = client
+			b.Cleanup(func() { nodeClient = previous })
+
+			var connections int64
+			for index := 0; index < 3; index++ {
+				server := httptest.NewUnstartedServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+					writeResponse(res, http.StatusOK, "false")
+				}))
+				server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+					if state == http.StateNew {
+						atomic.AddInt64(&connections, 1)
+					}
+				}
+				server.Start()
+				b.Cleanup(server.Close)
+				database.AddCoordinatorNode(node.Node{Address: server.URL})
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := announceMessage(context.Background(), "benchmark"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&connections))/float64(b.N), "conns/op")
+		})
+	}
+}
+
+//withRetries sets settings.NodeRequestAttempts and a short retry delay for the duration of the test
+func withRetries(t *testing.T, attempts int) {
+	current := settings.Current()
+	previousAttempts, previousDelay := current.NodeRequestAttempts, current.NodeRequestRetryDelay
+	current.NodeRequestAttempts, current.NodeRequestRetryDelay = attempts, 1
+	t.Cleanup(func() { current.NodeRequestAttempts, current.NodeRequestRetryDelay = previousAttempts, previousDelay })
+}
+
+//flakyNode starts a test server failing the first failures requests with status, and counts all requests
+func flakyNode(t *testing.T, failures int64, status int) (address string, requests *int64) {
+	requests = new(int64)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt64(requests, 1) <= failures {
+			writeError(res, status, errorCodeForStatus(status), "Failing on purpose")
+			return
+		}
+		writeResponse(res, http.StatusOK, "stored")
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, requests
+}
+
+func TestSendNodeRequestRetriesServerErrors(t *testing.T) {
+	withRetries(t, 3)
+	address, requests := flakyNode(t, 2, http.StatusInternalServerError)
+
+	status, response := SendNodeRequest(NODE_STORAGE, address, "/put/abc", "content")
+	if status != OK || string(response) != "stored" {
+		t.Errorf("SendNodeRequest() = %d, %q, want the response of the third attempt", status, response)
+	}
+	if got := atomic.LoadInt64(requests); got != 3 {
+		t.Errorf("requests = %d, want 3", got)
+	}
+}
+
+func TestSendNodeRequestGivesUpAfterAttempts(t *testing.T) {
+	withRetries(t, 2)
+	address, requests := flakyNode(t, 2, http.StatusServiceUnavailable)
+
+	if status, _ := SendNodeRequest(NODE_STORAGE, address, "/put/abc", "content"); status != SNNetworkingServerError {
+		t.Errorf("SendNodeRequest() = %d, want %d", status, SNNetworkingServerError)
+	}
+	if got := atomic.LoadInt64(requests); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+}
+
+func TestSendNodeRequestDoesNotRetryClientErrors(t *testing.T) {
+	withRetries(t, 3)
+	address, requests := flakyNode(t, 1, http.StatusConflict)
+
+	if status, _ := SendNodeRequest(NODE_STORAGE, address, "/put/abc", "content"); status != SNNetworkingBadResponse {
+		t.Errorf("SendNodeRequest() = %d, want %d", status, SNNetworkingBadResponse)
+	}
+	if got := atomic.LoadInt64(requests); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+}
+
+//deadNode returns the address of a test server which has been shut down, refusing all connections
+func deadNode(t *testing.T) string {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	return server.URL
+}
+
+func TestAskMessageStatusUsesLiveCoordinators(t *testing.T) {
+	withRetries(t, 1)
+	live := httptest.NewServer(statusHandler(t, func(res http.ResponseWriter, req *http.Request) {
+		writeResponse(res, http.StatusOK, "2")
+	}))
+	t.Cleanup(live.Close)
+	failing, _ := flakyNode(t, 1<<30, http.StatusInternalServerError)
+
+	tests := []struct {
+		name          string
+		addresses     []string
+		status        int
+		messageStatus int
+	}{
+		{"dead and live", []string{deadNode(t), live.URL}, OK, 2},
+		{"live and dead", []string{live.URL, deadNode(t)}, OK, 2},
+		{"failing and live", []string{failing, live.URL}, OK, 2},
+		{"all dead", []string{deadNode(t), failing}, CNNetworkingUnreachable, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var coordinatorNodes []node.Node
+			for _, address := range test.addresses {
+				coordinatorNodes = append(coordinatorNodes, node.Node{Address: address})
+			}
+			status, messageStatus := askMessageStatus(context.Background(), "abc", coordinatorNodes)
+			if status != test.status || messageStatus != test.messageStatus {
+				t.Errorf("askMessageStatus() = %d, %d, want %d, %d", status, messageStatus, test.status, test.messageStatus)
+			}
+		})
+	}
+}
+
+//setupStatusFailover stores message abc locally, and adds the CoordinatorNodes first and last, answering status
+//requests with their handlers. first is marked alive and last dead, so they are asked in that order, one per round.
+//Returns the number of requests to last
+func setupStatusFailover(t *testing.T, first http.HandlerFunc, last http.HandlerFunc) (requests *int64) {
+	setupNode(t)
+	withRetries(t, 1)
+	previousCount := settings.Current().CoordinatorAnnounceCount
+	settings.Current().CoordinatorAnnounceCount = 1
+	t.Cleanup(func() { settings.Current().CoordinatorAnnounceCount = previousCount })
+	database.LogMessageStorage("abc", 0)
+
+	database.MarkNodeAlive(fakeNodes(t, NODE_COORDINATOR, 1, statusHandler(t, first))[0], 1)
+	requests = new(int64)
+	database.MarkNodeDead(fakeNodes(t, NODE_COORDINATOR, 1, statusHandler(t, func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(requests, 1)
+		last(res, req)
+	}))[0])
+	return requests
+}
+
+func TestGetMessageStatusFailsOverInRounds(t *testing.T) {
+	requests := setupStatusFailover(t, func(res http.ResponseWriter, req *http.Request) {
+		writeError(res, http.StatusServiceUnavailable, ErrorOverloaded, "Failing on purpose")
+	}, func(res http.ResponseWriter, req *http.Request) {
+		writeResponse(res, http.StatusOK, "2")
+	})
+	//Dead CoordinatorNodes between both are asked in the rounds in between
+	database.AddCoordinatorNode(node.Node{Address: deadNode(t)})
+	database.AddCoordinatorNode(node.Node{Address: deadNode(t)})
+
+	status, messageStatus := GetMessageStatusContext(context.Background(), "abc")
+	if status != OK || messageStatus != 2 {
+		t.Errorf("GetMessageStatusContext() = %d, %d, want %d, 2", status, messageStatus, OK)
+	}
+	if got := atomic.LoadInt64(requests); got != 1 {
+		t.Errorf("requests to the last CoordinatorNode = %d, want 1", got)
+	}
+}
+
+func TestGetMessageStatusDoesNotFailOverRejections(t *testing.T) {
+	requests := setupStatusFailover(t, func(res http.ResponseWriter, req *http.Request) {
+		writeError(res, http.StatusForbidden, ErrorForbidden, "Failing on purpose")
+	}, func(res http.ResponseWriter, req *http.Request) {
+		writeResponse(res, http.StatusOK, "2")
+	})
+
+	status, messageStatus := GetMessageStatusContext(context.Background(), "abc")
+	if status != CNNetworkingBadResponse || messageStatus != -1 {
+		t.Errorf("GetMessageStatusContext() = %d, %d, want %d, -1", status, messageStatus, CNNetworkingBadResponse)
+	}
+	if got := atomic.LoadInt64(requests); got != 0 {
+		t.Errorf("requests to the last CoordinatorNode = %d, want 0", got)
+	}
+}
@@ -0,0 +1,85 @@
+package networking
+
+import (
+	"container/list"
+	"subframe/server/settings"
+	"sync"
+	"time"
+)
+
+//notFoundCache remembers the IDs of messages which were recently not found locally, so repeated gets of them do not
+//read storage. The least recently looked up IDs are evicted beyond settings.NotFoundCacheSize
+type notFoundCache struct {
+	mutex   sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	//puts counts invalidations, so misses seen before a put of the message are not remembered after it
+	puts uint64
+}
+
+type notFoundEntry struct {
+	id      string
+	expires time.Time
+}
+
+var notFound = notFoundCache{order: list.New(), entries: make(map[string]*list.Element)}
+
+//generation returns the state to pass to remember for a lookup of storage starting now
+func (c *notFoundCache) generation() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.puts
+}
+
+//contains returns whether id was not found within the last settings.NotFoundCacheTTL seconds
+func (c *notFoundCache) contains(id string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[id]
+	if !ok {
+		return false
+	}
+	if time.Now().After(element.Value.(*notFoundEntry).expires) {
+		c.remove(element)
+		return false
+	}
+	c.order.MoveToFront(element)
+	return true
+}
+
+//remember records id as not found, unless a message was stored after generation returned since
+func (c *notFoundCache) remember(id string, since uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if settings.Current().NotFoundCacheSize <= 0 || c.puts != since {
+		return
+	}
+	expires := time.Now().Add(time.Duration(settings.Current().NotFoundCacheTTL) * time.Second)
+	if element, ok := c.entries[id]; ok {
+		element.Value.(*notFoundEntry).expires = expires
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[id] = c.order.PushFront(&notFoundEntry{id: id, expires: expires})
+	//The size may have been lowered by a reload
+	for c.order.Len() > settings.Current().NotFoundCacheSize {
+		c.remove(c.order.Back())
+	}
+}
+
+//invalidate forgets id once the message is stored. Must be called after it is logged to the database,
+//so gets can find it
+func (c *notFoundCache) invalidate(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.puts++
+	if element, ok := c.entries[id]; ok {
+		c.remove(element)
+	}
+}
+
+//remove drops element from the cache. Must be called with the mutex held
+func (c *notFoundCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*notFoundEntry).id)
+}
@@ -0,0 +1,97 @@
+package networking
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"subframe/server/settings"
+	"testing"
+)
+
+//withNotFoundCache sets the size and TTL in seconds of the cache of missing messages for the duration of the test
+func withNotFoundCache(t *testing.T, size int, ttl int) {
+	current := settings.Current()
+	previousSize, previousTTL := current.NotFoundCacheSize, current.NotFoundCacheTTL
+	current.NotFoundCacheSize, current.NotFoundCacheTTL = size, ttl
+	t.Cleanup(func() { current.NotFoundCacheSize, current.NotFoundCacheTTL = previousSize, previousTTL })
+}
+
+func newNotFoundCache() *notFoundCache {
+	return &notFoundCache{order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func TestPutInvalidatesNotFound(t *testing.T) {
+	setupNode(t)
+	withNotFoundCache(t, 100, 60)
+
+	expectStatus(t, serve(http.MethodGet, "/storage/get/later", nil, nil), http.StatusNotFound)
+	if !notFound.contains("later") {
+		t.Fatal("missing message was not remembered")
+	}
+	expectStatus(t, serve(http.MethodPost, "/storage/put/later", strings.NewReader("content"), nil), http.StatusOK)
+	if notFound.contains("later") {
+		t.Error("stored message is still remembered as missing")
+	}
+
+	recorder := serve(http.MethodGet, "/storage/get/later", nil, map[string]string{"Accept": "application/octet-stream"})
+	expectStatus(t, recorder, http.StatusOK)
+	if recorder.Body.String() != "content" {
+		t.Errorf("content = %q, want %q", recorder.Body.String(), "content")
+	}
+}
+
+func TestNotFoundCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	withNotFoundCache(t, 2, 60)
+	cache := newNotFoundCache()
+
+	cache.remember("a", cache.generation())
+	cache.remember("b", cache.generation())
+	//Looking up a makes b the least recently used
+	cache.contains("a")
+	cache.remember("c", cache.generation())
+
+	for id, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if got := cache.contains(id); got != want {
+			t.Errorf("contains(%s) = %v, want %v", id, got, want)
+		}
+	}
+	if cache.order.Len() != 2 || len(cache.entries) != 2 {
+		t.Errorf("cache holds %d entries (%d indexed), want 2", cache.order.Len(), len(cache.entries))
+	}
+}
+
+func TestNotFoundCacheSkipsMissesBeforePuts(t *testing.T) {
+	withNotFoundCache(t, 10, 60)
+	cache := newNotFoundCache()
+
+	//The message is stored while it is looked up
+	since := cache.generation()
+	cache.invalidate("racing")
+	cache.remember("racing", since)
+	if cache.contains("racing") {
+		t.Error("miss seen before a put is remembered after it")
+	}
+}
+
+func TestNotFoundCacheExpiry(t *testing.T) {
+	withNotFoundCache(t, 10, 0)
+	cache := newNotFoundCache()
+
+	cache.remember("expired", cache.generation())
+	if cache.contains("expired") {
+		t.Error("expired miss is still remembered")
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("%d entries left after expiry, want 0", len(cache.entries))
+	}
+}
+
+func TestNotFoundCacheDisabled(t *testing.T) {
+	withNotFoundCache(t, 0, 60)
+	cache := newNotFoundCache()
+
+	cache.remember("missing", cache.generation())
+	if cache.contains("missing") {
+		t.Error("miss remembered by a disabled cache")
+	}
+}
@@ -0,0 +1,114 @@
+package networking
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+//Protobuf wire types, see https://protobuf.dev/programming-guides/encoding
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errMalformedProto = errors.New("malformed protobuf message")
+
+//protoMessage is an encoded protobuf message, built by appending fields. Fields with their default value are
+//omitted, like proto3 does
+type protoMessage []byte
+
+func (m protoMessage) tag(number int, wireType int) protoMessage {
+	return binary.AppendUvarint(m, uint64(number)<<3|uint64(wireType))
+}
+
+func (m protoMessage) bytesField(number int, value []byte) protoMessage {
+	if len(value) == 0 {
+		return m
+	}
+	m = binary.AppendUvarint(m.tag(number, wireBytes), uint64(len(value)))
+	return append(m, value...)
+}
+
+func (m protoMessage) stringField(number int, value string) protoMessage {
+	return m.bytesField(number, []byte(value))
+}
+
+func (m protoMessage) int64Field(number int, value int64) protoMessage {
+	if value == 0 {
+		return m
+	}
+	return binary.AppendUvarint(m.tag(number, wireVarint), uint64(value))
+}
+
+//mapField appends a map<string, string>, sorted by key so equal maps are encoded equally
+func (m protoMessage) mapField(number int, values map[string]string) protoMessage {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		entry := protoMessage(nil).stringField(1, key).stringField(2, values[key])
+		//Entries are always written, even if empty
+		m = append(binary.AppendUvarint(m.tag(number, wireBytes), uint64(len(entry))), entry...)
+	}
+	return m
+}
+
+//decodeProto calls visit for every field of data with its number, and its value for varints or its content for
+//length-delimited fields. Fixed-size fields are skipped, as no message uses them
+func decodeProto(data []byte, visit func(number int, varint uint64, content []byte)) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 || key>>3 == 0 {
+			return errMalformedProto
+		}
+		data = data[n:]
+		number := int(key >> 3)
+		switch key & 7 {
+		case wireVarint:
+			value, n := binary.Uvarint(data)
+			if n <= 0 {
+				return errMalformedProto
+			}
+			data = data[n:]
+			visit(number, value, nil)
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || length > uint64(len(data)-n) {
+				return errMalformedProto
+			}
+			visit(number, 0, data[n:n+int(length)])
+			data = data[n+int(length):]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errMalformedProto
+			}
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errMalformedProto
+			}
+			data = data[4:]
+		default:
+			return errMalformedProto
+		}
+	}
+	return nil
+}
+
+//decodeMapEntry decodes an entry of a map<string, string>
+func decodeMapEntry(entry []byte) (key string, value string, err error) {
+	err = decodeProto(entry, func(number int, _ uint64, content []byte) {
+		switch number {
+		case 1:
+			key = string(content)
+		case 2:
+			value = string(content)
+		}
+	})
+	return key, value, err
+}
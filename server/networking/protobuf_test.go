@@ -0,0 +1,68 @@
+package networking
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeProto(t *testing.T) {
+	msg := protoMessage(nil).stringField(1, "abc").bytesField(2, []byte{0, 1, 2}).int64Field(3, 300).
+		mapField(4, map[string]string{"b": "2", "a": ""})
+	//Fixed-size fields of newer message versions are skipped
+	msg = append(msg.tag(6, wireFixed64), 1, 2, 3, 4, 5, 6, 7, 8)
+	msg = append(msg.tag(7, wireFixed32), 1, 2, 3, 4)
+
+	var id, content string
+	var expiresIn uint64
+	headers := make(map[string]string)
+	err := decodeProto(msg, func(number int, varint uint64, value []byte) {
+		switch number {
+		case 1:
+			id = string(value)
+		case 2:
+			content = string(value)
+		case 3:
+			expiresIn = varint
+		case 4:
+			key, entry, _ := decodeMapEntry(value)
+			headers[key] = entry
+		default:
+			t.Errorf("visited skipped field %d", number)
+		}
+	})
+	if err != nil {
+		t.Fatalf("decodeProto() = %v", err)
+	}
+	if id != "abc" || content != "\x00\x01\x02" || expiresIn != 300 {
+		t.Errorf("decoded %q, %q, %d, want abc, 000102, 300", id, content, expiresIn)
+	}
+	if want := map[string]string{"a": "", "b": "2"}; !reflect.DeepEqual(headers, want) {
+		t.Errorf("decoded headers %v, want %v", headers, want)
+	}
+}
+
+func TestDecodeMalformedProto(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated key", []byte{0x80}},
+		{"field number 0", []byte{0x02, 0x00}},
+		{"truncated varint", []byte{0x18, 0xff}},
+		{"missing length", []byte{0x0a}},
+		{"length beyond message", []byte{0x0a, 0x05, 'a', 'b', 'c'}},
+		{"overflowing length", []byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"truncated fixed64", []byte{0x09, 1, 2, 3}},
+		{"truncated fixed32", []byte{0x0d, 1, 2}},
+		{"group wire type", []byte{0x0b}},
+		{"unknown wire type", []byte{0x0e}},
+		{"garbage after field", append(protoMessage(nil).stringField(1, "abc"), 0xff)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := decodeProto(test.data, func(int, uint64, []byte) {}); err != errMalformedProto {
+				t.Errorf("decodeProto(%x) = %v, want %v", test.data, err, errMalformedProto)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"subframe/server/database"
+	"subframe/server/jobqueue"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/server/tracing"
+	. "subframe/status"
+	"subframe/structs/message"
+	"sync"
+)
+
+//replicaRead is the result of reading a message from one of its locations
+type replicaRead struct {
+	address string
+	msg     message.Message
+	found   bool
+	missing bool
+}
+
+//readReplica gets the message id from the StorageNode at address. missing is set if the Node responded it does not store it
+func readReplica(ctx context.Context, address string, id string) (read replicaRead) {
+	read.address = address
+	status, response := SendNodeRequestContext(ctx, NODE_STORAGE, address, "/get/"+id, "")
+	if status != OK {
+		read.missing = errorCode(response) == ErrorNotFound
+		return read
+	}
+	read.found = json.Unmarshal(response, &read.msg) == nil && read.msg.ID == id
+	return read
+}
+
+//handleRead responds with the content of /read/<id> agreed on by settings.ReadQuorumSize() of its locations,
+//and queues storing it on locations which responded they do not have it
+func (r coordinatorRequest) handleRead() {
+	messageID := r.args[0]
+	status, locations := database.GetMessageLocations(messageID)
+	if status != OK {
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error locating message "+messageID)
+		return
+	}
+	if len(locations) == 0 {
+		writeError(r.res, http.StatusNotFound, ErrorNotFound, "Message "+messageID+" not found")
+		return
+	}
+
+	reads := make([]replicaRead, len(locations))
+	var wg sync.WaitGroup
+	for index, address := range locations {
+		wg.Add(1)
+		go func(index int, address string) {
+			defer wg.Done()
+			reads[index] = readReplica(r.req.Context(), address, messageID)
+		}(index, address)
+	}
+	wg.Wait()
+
+	//Replicas agree if they return the same content
+	votes := make(map[string]int)
+	var agreed *message.Message
+	quorum := settings.Current().ReadQuorumSize()
+	for index := range reads {
+		if !reads[index].found {
+			continue
+		}
+		votes[reads[index].msg.Content]++
+		if votes[reads[index].msg.Content] >= quorum && agreed == nil {
+			agreed = &reads[index].msg
+		}
+	}
+	if agreed == nil {
+		r.log.Warn(GenericInternalError, "No "+strconv.Itoa(quorum)+" of "+strconv.Itoa(len(locations))+" locations agree on Message "+messageID)
+		writeError(r.res, http.StatusServiceUnavailable, ErrorQuorumNotReached, "Read quorum of "+strconv.Itoa(quorum)+" not reached for message "+messageID)
+		return
+	}
+	//Replicas are read with signed requests, so the ACL is checked against the identity of the client
+	if !readAllowed(r.identity, r.privileged, agreed.Owner, agreed.Readers) {
+		denyAccess(r.res, r.req, r.identity, messageID)
+		return
+	}
+
+	var stale []string
+	for _, read := range reads {
+		if read.missing {
+			stale = append(stale, read.address)
+		}
+	}
+	if len(stale) > 0 {
+		r.log.Info(InProgress, "Queueing read repair of Message "+messageID+" on "+strconv.Itoa(len(stale))+" StorageNodes...")
+		jobqueue.Enqueue(jobqueue.Job{Task: readRepair, Data: &readRepairJob{Message: *agreed, Addresses: stale}, RequestID: logger.RequestID(r.req.Context()), TraceParent: tracing.TraceParent(r.req.Context())})
+	}
+
+	response, err := json.Marshal(agreed)
+	if err != nil {
+		r.log.Error(GenericInternalError, "Error marshalling Message "+messageID+": "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error reading message "+messageID)
+		return
+	}
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+//readRepairJob stores Message on the StorageNodes at Addresses
+type readRepairJob struct {
+	Message   message.Message
+	Addresses []string
+}
+
+//readRepair puts the agreed content of a message to locations which missed it, with its provenance, expiry and ACL
+func readRepair(ctx context.Context, data interface{}) error {
+	job, ok := data.(*readRepairJob)
+	if !ok {
+		clog.Error(GenericInternalError, "Error starting Read Repair Thread")
+		return errors.New("read repair job without message")
+	}
+	log := clog.WithContext(ctx)
+
+	for _, address := range job.Addresses {
+		status, response := SendNodeRequestContext(ctx, NODE_STORAGE, address, replicaPutQuery(job.Message), job.Message.Content)
+		if status != OK && !isConflict(response) {
+			log.Warn(status, "Read repair of Message "+job.Message.ID+" on StorageNode "+address+" failed.")
+			continue
+		}
+		log.Info(OK, "Repaired Message "+job.Message.ID+" on StorageNode "+address)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package networking
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//byteRange is a satisfiable range of length bytes starting at start
+type byteRange struct {
+	start, length int64
+}
+
+//contentRange formats r as Content-Range header value for content of size bytes
+func (r byteRange) contentRange(size int64) string {
+	return "bytes " + strconv.FormatInt(r.start, 10) + "-" + strconv.FormatInt(r.start+r.length-1, 10) + "/" + strconv.FormatInt(size, 10)
+}
+
+//requestedRange returns the range of content of size bytes with etag the Range header of req asks for.
+//Requests without Range header, with several ranges, malformed ones or an If-Range header not matching etag get the
+//whole content, so rng is nil. satisfiable is false if the range starts beyond the content
+func requestedRange(req *http.Request, size int64, etag string) (rng *byteRange, satisfiable bool) {
+	header := req.Header.Get("Range")
+	if header == "" || !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return nil, true
+	}
+	//If-Range dates are not supported, as messages do not change
+	if ifRange := req.Header.Get("If-Range"); ifRange != "" && (etag == "" || ifRange != etag) {
+		return nil, true
+	}
+
+	spec := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(header, "bytes=")), "-", 2)
+	if len(spec) != 2 {
+		return nil, true
+	}
+	if spec[0] == "" {
+		//Suffix ranges ask for the last bytes
+		suffix, err := strconv.ParseInt(spec[1], 10, 64)
+		if err != nil || suffix < 0 {
+			return nil, true
+		}
+		if suffix == 0 || size == 0 {
+			return nil, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return &byteRange{start: size - suffix, length: suffix}, true
+	}
+
+	start, err := strconv.ParseInt(spec[0], 10, 64)
+	if err != nil || start < 0 {
+		return nil, true
+	}
+	end := size - 1
+	if spec[1] != "" {
+		end, err = strconv.ParseInt(spec[1], 10, 64)
+		if err != nil || end < start {
+			return nil, true
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return nil, false
+	}
+	return &byteRange{start: start, length: end - start + 1}, true
+}
@@ -0,0 +1,103 @@
+package networking
+
+import (
+	"crypto/subtle"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"subframe/server/settings"
+	. "subframe/status"
+	"sync"
+	"time"
+)
+
+//bucketExpiry is the time of inactivity after which an IP's bucket is discarded
+const bucketExpiry = 10 * time.Minute
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+var limiter = rateLimiter{buckets: make(map[string]*tokenBucket)}
+
+//allow takes a token from ip's bucket. If none is left, it returns the time until the next token is available
+func (l *rateLimiter) allow(ip string, rate float64, burst float64) (allowed bool, retryAfter time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > bucketExpiry {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[ip]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst}
+		l.buckets[ip] = bucket
+	} else {
+		bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*rate)
+	}
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+//sweep removes buckets of IPs that have been inactive for longer than bucketExpiry
+func (l *rateLimiter) sweep(now time.Time) {
+	for ip, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > bucketExpiry {
+			delete(l.buckets, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+//checkRateLimit applies the per-IP rate limit and writes an error response if it is exceeded.
+//Inter-node requests presenting settings.AuthToken are exempt
+func (r *storageRequest) checkRateLimit() bool {
+	return checkRateLimit(r.res, r.req)
+}
+
+//checkRateLimit applies the per-IP rate limit to req and writes an error response to res if it is exceeded
+func checkRateLimit(res http.ResponseWriter, req *http.Request) bool {
+	if settings.Current().RateLimitRequests <= 0 {
+		return true
+	}
+
+	if token, ok := bearerToken(req); ok && settings.Current().AuthToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(settings.Current().AuthToken)) == 1 {
+		return true
+	}
+
+	ip := remoteIP(req)
+	allowed, retryAfter := limiter.allow(ip, float64(settings.Current().RateLimitRequests), math.Max(1, float64(settings.Current().RateLimitBurst)))
+	if allowed {
+		return true
+	}
+
+	slog.Warn(SNRateLimited, "Rate limit exceeded by "+ip)
+	res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeError(res, http.StatusTooManyRequests, ErrorRateLimited, "Rate limit exceeded")
+	return false
+}
+
+//remoteIP returns the IP part of the request's remote address
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,88 @@
+package networking
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"subframe/server/settings"
+	. "subframe/status"
+	"sync"
+	"time"
+)
+
+//readOnlyFileName marks the Node as read-only in its data directory, so the mode survives a restart
+const readOnlyFileName = "readonly"
+
+//readOnlyRetryAfter is the time in seconds clients are asked to wait before writing to a read-only Node again
+const readOnlyRetryAfter = "60"
+
+//writeActions are the actions rejected while the Node is read-only
+var writeActions = map[string]bool{"put": true, "delete": true, "update": true}
+
+var readOnlyMutex sync.Mutex
+var readOnly bool
+
+//loadReadOnly restores the read-only mode recorded in the data directory
+func loadReadOnly() {
+	_, err := os.Stat(settings.Current().DataPath + "/" + readOnlyFileName)
+	readOnlyMutex.Lock()
+	readOnly = err == nil
+	readOnlyMutex.Unlock()
+	if readOnly {
+		mlog.Warn(OK, "Node is read-only, rejecting writes until /control/set-readonly?on=false.")
+	}
+}
+
+//isReadOnly returns whether the Node rejects writes, see writeActions
+func isReadOnly() bool {
+	readOnlyMutex.Lock()
+	defer readOnlyMutex.Unlock()
+	return readOnly
+}
+
+//setReadOnly switches the read-only mode, recording it in the data directory first
+func setReadOnly(on bool) error {
+	readOnlyMutex.Lock()
+	defer readOnlyMutex.Unlock()
+	path := settings.Current().DataPath + "/" + readOnlyFileName
+	var err error
+	if on {
+		err = ioutil.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644)
+	} else if err = os.Remove(path); os.IsNotExist(err) {
+		err = nil
+	}
+	if err == nil {
+		readOnly = on
+	}
+	return err
+}
+
+//rejectReadOnly writes a 503 response to requests of writeActions while the Node is read-only
+func (r storageRequest) rejectReadOnly() bool {
+	if !writeActions[r.action] || !isReadOnly() {
+		return false
+	}
+	r.log.Warn(GenericInputError, "Node is read-only, denying "+r.action+" request.")
+	r.res.Header().Set("Retry-After", readOnlyRetryAfter)
+	writeError(r.res, http.StatusServiceUnavailable, ErrorReadOnly, "Node is read-only and does not accept writes")
+	return true
+}
+
+//handleSetReadOnly switches the read-only mode with /control/set-readonly?on=<true|false>, and responds with it
+func (r storageRequest) handleSetReadOnly() {
+	on, err := strconv.ParseBool(r.req.URL.Query().Get("on"))
+	if err != nil {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Parameter on has to be true or false")
+		return
+	}
+	if err := setReadOnly(on); err != nil {
+		r.log.Error(GenericInternalError, "Failed to record read-only mode: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error switching read-only mode")
+		return
+	}
+	r.log.Warn(OK, "Node is read-only: "+strconv.FormatBool(on))
+	response, _ := json.Marshal(map[string]bool{"readOnly": on})
+	writeJSON(r.res, http.StatusOK, string(response))
+}
@@ -0,0 +1,81 @@
+package networking
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//setReadOnlyControl switches the read-only mode of the Node with /control/set-readonly. The mode is left after the
+//test
+func setReadOnlyControl(t *testing.T, on string) *httptest.ResponseRecorder {
+	t.Cleanup(func() { readOnly = false })
+	return serve(http.MethodGet, "/storage/control/set-readonly?on="+on, nil, nil)
+}
+
+//expectReadOnlyRejection fails the test if recorder is no rejection of a write to a read-only Node
+func expectReadOnlyRejection(t *testing.T, recorder *httptest.ResponseRecorder) {
+	t.Helper()
+	expectStatus(t, recorder, http.StatusServiceUnavailable)
+	var response errorResponse
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	if response.Error.Code != ErrorReadOnly || recorder.Header().Get("Retry-After") != readOnlyRetryAfter {
+		t.Errorf("rejection = %s with Retry-After %q, want %s with %s", response.Error.Code, recorder.Header().Get("Retry-After"),
+			ErrorReadOnly, readOnlyRetryAfter)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	setupNode(t)
+	expectStatus(t, serve(http.MethodPost, "/storage/put/abc", strings.NewReader("content"), nil), http.StatusOK)
+
+	recorder := setReadOnlyControl(t, "true")
+	expectStatus(t, recorder, http.StatusOK)
+	if body := strings.TrimSpace(recorder.Body.String()); body != `{"readOnly":true}` {
+		t.Errorf("set-readonly responded %s, want the new mode", body)
+	}
+
+	expectReadOnlyRejection(t, serve(http.MethodPost, "/storage/put/def", strings.NewReader("content"), nil))
+	expectReadOnlyRejection(t, serve(http.MethodPost, "/storage/put/abc", strings.NewReader("overwritten"), nil))
+	expectReadOnlyRejection(t, serve(http.MethodDelete, "/storage/delete/abc", nil, nil))
+	recorder = serve(http.MethodGet, "/storage/get/abc", nil, map[string]string{"Accept": "application/octet-stream"})
+	expectStatus(t, recorder, http.StatusOK)
+	if recorder.Body.String() != "content" {
+		t.Errorf("get of a read-only Node = %q, want %q", recorder.Body.String(), "content")
+	}
+	expectStatus(t, serve(http.MethodGet, "/storage/get/def", nil, nil), http.StatusNotFound)
+
+	expectStatus(t, setReadOnlyControl(t, "false"), http.StatusOK)
+	expectStatus(t, serve(http.MethodPost, "/storage/put/def", strings.NewReader("content"), nil), http.StatusOK)
+}
+
+func TestReadOnlySurvivesRestart(t *testing.T) {
+	setupNode(t)
+	expectStatus(t, setReadOnlyControl(t, "true"), http.StatusOK)
+
+	//A restarted Node starts writable until it loads the recorded mode
+	readOnly = false
+	loadReadOnly()
+	if !isReadOnly() {
+		t.Fatal("Node is writable after a restart, want read-only")
+	}
+	expectReadOnlyRejection(t, serve(http.MethodPost, "/storage/put/abc", strings.NewReader("content"), nil))
+
+	expectStatus(t, setReadOnlyControl(t, "false"), http.StatusOK)
+	loadReadOnly()
+	if isReadOnly() {
+		t.Error("Node is read-only after a restart, want writable")
+	}
+}
+
+func TestSetReadOnlyRequiresMode(t *testing.T) {
+	setupNode(t)
+	for _, on := range []string{"", "maybe"} {
+		expectStatus(t, setReadOnlyControl(t, on), http.StatusBadRequest)
+	}
+	if isReadOnly() {
+		t.Error("Node is read-only after invalid set-readonly requests")
+	}
+}
@@ -0,0 +1,121 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"subframe/server/database"
+	"subframe/server/jobqueue"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	. "subframe/status"
+	"subframe/structs/node"
+	"time"
+)
+
+var rlog = logger.Logger{Prefix: "networking/Redistributor"}
+
+//redistributionRetryPolicy retries redistributions which stored fewer Replicas than the replication factor of the message
+var redistributionRetryPolicy = &jobqueue.RetryPolicy{MaxAttempts: 5, Backoff: 30 * time.Second}
+
+//redistributionJob is shared by all attempts, so Replicas stored by earlier attempts are not pushed again
+type redistributionJob struct {
+	MessageID string
+	Replicas  int
+	//Pushed lists the StorageNodes this job stored Replicas on
+	Pushed []string `json:",omitempty"`
+}
+
+func (j *redistributionJob) pushed(address string) bool {
+	for _, value := range j.Pushed {
+		if value == address {
+			return true
+		}
+	}
+	return false
+}
+
+//enqueueRedistribution queues pushing a locally stored message to other StorageNodes, logged with the request ID of ctx
+func enqueueRedistribution(ctx context.Context, data *redistributionJob) {
+	jobqueue.Enqueue(jobqueue.NewJobContext(ctx, redistributeJob, data))
+}
+
+//redistribute pushes a message to as many StorageNodes as are missing to reach the replication factor for its size,
+//see settings.ReplicationFactorFor
+func redistribute(ctx context.Context, data interface{}) error {
+	job, ok := data.(*redistributionJob)
+	if !ok {
+		rlog.Error(GenericInternalError, "Error starting Redistribution Thread")
+		return errors.New("redistribution job without message")
+	}
+	log := logger.Logger{Prefix: "networking/Redistribute-" + job.MessageID}.WithContext(ctx)
+
+	msg, status := storage.GetContext(ctx, job.MessageID)
+	if status != http.StatusOK {
+		//Retrying does not help if the message is gone, so the job is not failed
+		log.Error(GenericInternalError, "Cannot redistribute Message "+job.MessageID+": "+strconv.Itoa(status))
+		return nil
+	}
+
+	factor := settings.Current().ReplicationFactorFor(int64(len(msg.Content)))
+	missing := factor - job.Replicas
+	log.Info(InProgress, "Getting "+strconv.Itoa(missing)+" StorageNodes to redistribute Message to...")
+	storageNodes := replicaTargets(job, factor, missing)
+	if len(storageNodes) == 0 {
+		log.Warn(CNDBReadError, "Received empty List of StorageNodes.")
+	}
+
+	for _, value := range storageNodes {
+		status, response := SendNodeRequestContext(ctx, NODE_STORAGE, value.Address, replicaPutQuery(msg), msg.Content)
+		if status != OK && !isConflict(response) {
+			log.Warn(status, "Failed to push Message to StorageNode "+value.Address)
+			continue
+		}
+		job.Replicas++
+		job.Pushed = append(job.Pushed, value.Address)
+	}
+
+	if job.Replicas >= factor {
+		log.Info(OK, "Redistributed Message to "+strconv.Itoa(job.Replicas)+" StorageNodes.")
+		return nil
+	}
+	replicationFailures.Inc()
+	return errors.New("only " + strconv.Itoa(job.Replicas) + " of " + strconv.Itoa(factor) + " replicas of message " + job.MessageID + " stored")
+}
+
+//replicaTargets returns up to missing of the factor StorageNodes the message of job maps to on the hash ring,
+//skipping the local Node and Nodes it was already pushed to
+func replicaTargets(job *redistributionJob, factor int, missing int) (targets []node.Node) {
+	if missing <= 0 {
+		return nil
+	}
+	//The local Node may be one of the replicas, so one more is requested
+	_, replicas := database.GetReplicaNodes(job.MessageID, factor+1)
+	for _, replica := range replicas {
+		if len(targets) >= missing {
+			break
+		}
+		if replica.Address == settings.Current().RemoteAddress || job.pushed(replica.Address) {
+			continue
+		}
+		targets = append(targets, replica)
+	}
+	return targets
+}
+
+//isConflict returns whether response is an error response reporting the message is already stored
+func isConflict(response []byte) bool {
+	return errorCode(response) == ErrorConflict
+}
+
+//errorCode returns the code of an error response of another Node, or an empty string for other responses
+func errorCode(response []byte) string {
+	var errorBody errorResponse
+	if json.Unmarshal(response, &errorBody) != nil {
+		return ""
+	}
+	return errorBody.Error.Code
+}
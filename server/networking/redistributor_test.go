@@ -0,0 +1,36 @@
+package networking
+
+import (
+	"net/http"
+	"strings"
+	"subframe/server/settings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPutPushesReplicationFactorCopies(t *testing.T) {
+	setupNode(t)
+	current := settings.Current()
+	previousFactor, previousTiers := current.ReplicationFactor, current.ReplicationTiers
+	current.ReplicationFactor, current.ReplicationTiers = 2, ""
+	t.Cleanup(func() { current.ReplicationFactor, current.ReplicationTiers = previousFactor, previousTiers })
+
+	//CoordinatorNodes know no copies yet, so they request the redistribution
+	fakeNodes(t, NODE_COORDINATOR, 3, func(res http.ResponseWriter, req *http.Request) {
+		writeResponse(res, http.StatusOK, "true")
+	})
+	var puts int64
+	fakeNodes(t, NODE_STORAGE, 5, func(res http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/storage/put/") {
+			atomic.AddInt64(&puts, 1)
+		}
+		writeResponse(res, http.StatusOK, "stored")
+	})
+
+	expectStatus(t, serve(http.MethodPost, "/storage/put/replicated", strings.NewReader("content"), nil), http.StatusOK)
+	runQueuedJobs(t)
+
+	if got := atomic.LoadInt64(&puts); got != 2 {
+		t.Errorf("downstream puts = %d, want 2", got)
+	}
+}
@@ -0,0 +1,37 @@
+package networking
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"subframe/server/logger"
+)
+
+//requestIDHeader carries the ID correlating the logs of one request across all Nodes handling it
+const requestIDHeader = "X-Request-ID"
+
+//maxRequestIDLength is the maximum length of an incoming request ID. Longer IDs are replaced
+const maxRequestIDLength = 128
+
+//newRequestID returns a random hex-encoded request ID
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(id)
+}
+
+//withRequestID returns req with the request ID sent by the client, or a new one if it sent none or a malformed one,
+//attached to its context. The ID is echoed in the response, so clients can refer to it
+func withRequestID(res http.ResponseWriter, req *http.Request) *http.Request {
+	requestID := req.Header.Get(requestIDHeader)
+	if requestID == "" || len(requestID) > maxRequestIDLength || !slugPattern.MatchString(requestID) {
+		requestID = newRequestID()
+	}
+	if requestID == "" {
+		return req
+	}
+	res.Header().Set(requestIDHeader, requestID)
+	return req.WithContext(logger.WithRequestID(req.Context(), requestID))
+}
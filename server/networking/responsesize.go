@@ -0,0 +1,38 @@
+package networking
+
+import (
+	"net/http"
+	"strconv"
+	"subframe/server/settings"
+)
+
+//listOverhead is the size of a messageList response besides its IDs, with the largest next offset
+const listOverhead = len(`{"ids":[],"next":9223372036854775807}`)
+
+//responseLimit returns the maximum size in bytes of batch-get and list-messages responses, or 0 if unlimited
+func responseLimit() int64 {
+	return int64(settings.Current().ResponseMaxSize) * 1024
+}
+
+//fitList shortens the page of ids listed from offset so its response fits responseLimit, and returns the offset of
+//the following page, next if the page is complete. Returns false if not even one ID fits
+func fitList(ids []string, offset int, next int) (fitted []string, fittedNext int, ok bool) {
+	limit := responseLimit()
+	if limit <= 0 {
+		return ids, next, true
+	}
+	size := int64(listOverhead)
+	for index, id := range ids {
+		//IDs never need escaping, so every one takes its length, quotes and a comma
+		size += int64(len(id)) + 3
+		if size > limit {
+			return ids[:index], offset + index, index > 0
+		}
+	}
+	return ids, next, true
+}
+
+//writeResponseTooLarge responds with 413 to a request whose response would exceed responseLimit
+func writeResponseTooLarge(res http.ResponseWriter) {
+	writeError(res, http.StatusRequestEntityTooLarge, ErrorResponseTooLarge, "Response exceeds the maximum of "+strconv.Itoa(settings.Current().ResponseMaxSize)+" KB")
+}
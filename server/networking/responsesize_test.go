@@ -0,0 +1,66 @@
+package networking
+
+import (
+	"encoding/json"
+	"strings"
+	"subframe/server/settings"
+	"testing"
+)
+
+//withResponseMaxSize sets settings.ResponseMaxSize in KB for the duration of the test
+func withResponseMaxSize(t *testing.T, size int) {
+	previous := settings.Current().ResponseMaxSize
+	settings.Current().ResponseMaxSize = size
+	t.Cleanup(func() { settings.Current().ResponseMaxSize = previous })
+}
+
+//listIDs returns count IDs of length characters each
+func listIDs(count int, length int) []string {
+	ids := make([]string, count)
+	for index := range ids {
+		ids[index] = strings.Repeat(string(rune('a'+index%26)), length)
+	}
+	return ids
+}
+
+func TestFitList(t *testing.T) {
+	withResponseMaxSize(t, 1)
+	//21 IDs of 44 characters take exactly the 1024 bytes of the limit: 37 bytes of overhead and 47 bytes each
+	exact := listIDs(21, 44)
+	over := append(listIDs(20, 44), strings.Repeat("z", 45))
+
+	tests := []struct {
+		name      string
+		ids       []string
+		next      int
+		wantCount int
+		wantNext  int
+		wantOK    bool
+	}{
+		{"exactly at the limit", exact, 500, 21, 500, true},
+		{"one byte over the limit", over, 500, 20, 120, true},
+		{"first ID over the limit", []string{strings.Repeat("a", 1024)}, 500, 0, 100, false},
+		{"last page", nil, 0, 0, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			//The page is listed from offset 100. A cut page continues after its last fitted ID
+			fitted, next, ok := fitList(test.ids, 100, test.next)
+			if ok != test.wantOK || len(fitted) != test.wantCount || next != test.wantNext {
+				t.Fatalf("fitList() = %d IDs, next %d, %v, want %d IDs, next %d, %v", len(fitted), next, ok, test.wantCount, test.wantNext, test.wantOK)
+			}
+			response, _ := json.Marshal(messageList{IDs: fitted, Next: next})
+			if int64(len(response)) > responseLimit() {
+				t.Errorf("response of %d bytes exceeds the limit of %d", len(response), responseLimit())
+			}
+		})
+	}
+}
+
+func TestFitListWithoutLimit(t *testing.T) {
+	withResponseMaxSize(t, 0)
+	ids := listIDs(1000, 100)
+	if fitted, next, ok := fitList(ids, 0, 1000); !ok || len(fitted) != len(ids) || next != 1000 {
+		t.Errorf("fitList() = %d IDs, next %d, %v, want the whole page", len(fitted), next, ok)
+	}
+}
@@ -0,0 +1,141 @@
+package networking
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"subframe/server/settings"
+	. "subframe/status"
+	"sync"
+	"time"
+)
+
+//Headers of signed inter-node requests
+const (
+	signatureHeader = "X-Subframe-Signature"
+	timestampHeader = "X-Subframe-Timestamp"
+	nonceHeader     = "X-Subframe-Nonce"
+)
+
+//interNodeActions lists the actions only other Nodes send, which have to be signed if settings.ClusterSecret is set
+var interNodeActions = map[string]bool{
+	"update":        true,
+	"redistribute":  true,
+	"announce":      true,
+	"bulk-announce": true,
+	"deannounce":    true,
+}
+
+//signature returns the hex-encoded HMAC-SHA256 over method, request URI, timestamp, nonce and body
+func signature(method string, uri string, timestamp string, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(settings.Current().ClusterSecret))
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//signRequest signs an outgoing inter-node request with settings.ClusterSecret. Every attempt is signed anew,
+//so retries are not rejected as replays. Returns false if the body could not be read for signing
+func signRequest(req *http.Request) bool {
+	if settings.Current().ClusterSecret == "" {
+		return true
+	}
+
+	var body []byte
+	if req.GetBody != nil {
+		reader, err := req.GetBody()
+		if err == nil {
+			body, err = ioutil.ReadAll(reader)
+			reader.Close()
+		}
+		if err != nil {
+			nlog.Error(SNNetworkingOutgoingRequestError, "Error signing request: "+err.Error())
+			return false
+		}
+	}
+
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		nlog.Error(SNNetworkingOutgoingRequestError, "Error signing request: "+err.Error())
+		return false
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(nonceHeader, nonce)
+	req.Header.Set(signatureHeader, signature(req.Method, req.URL.RequestURI(), timestamp, nonce, body))
+	return true
+}
+
+//seenNonces remembers the nonces of accepted requests until their signature expires, to reject replays
+var seenNoncesMutex sync.Mutex
+var seenNonces = make(map[string]time.Time)
+var lastNoncePrune time.Time
+
+//rememberNonce returns false if nonce was already used, and records it until expiry otherwise
+func rememberNonce(nonce string, expiry time.Time) bool {
+	seenNoncesMutex.Lock()
+	defer seenNoncesMutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(lastNoncePrune) > time.Second {
+		for seen, seenExpiry := range seenNonces {
+			if now.After(seenExpiry) {
+				delete(seenNonces, seen)
+			}
+		}
+		lastNoncePrune = now
+	}
+	if _, seen := seenNonces[nonce]; seen {
+		return false
+	}
+	seenNonces[nonce] = expiry
+	return true
+}
+
+//checkSignature verifies the signature of an inter-node request, and writes a 401 response if it is missing, wrong,
+//too old or replayed. Requests of other actions, e.g. puts pushing copies and gets reading private messages, are sent
+//by clients as well and only verified if they carry a signature. Returns whether the request was signed by a Node, and
+//false as ok if it was rejected. The body is restored for the handler
+func checkSignature(res http.ResponseWriter, req *http.Request, action string) (signed bool, ok bool) {
+	if settings.Current().ClusterSecret == "" || (!interNodeActions[action] && req.Header.Get(signatureHeader) == "") {
+		return false, true
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(res, req.Body, int64(settings.Current().MessageMaxSize)*1024*1024))
+	if err != nil {
+		writeError(res, http.StatusBadRequest, ErrorTransmissionFailed, "Transmission of request body failed")
+		return false, false
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	timestamp := req.Header.Get(timestampHeader)
+	nonce := req.Header.Get(nonceHeader)
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	maxAge := time.Duration(settings.Current().SignatureMaxAge) * time.Second
+	expected := signature(req.Method, req.URL.RequestURI(), timestamp, nonce, body)
+
+	reason := ""
+	switch {
+	case err != nil || nonce == "":
+		reason = "unsigned"
+	case !hmac.Equal([]byte(req.Header.Get(signatureHeader)), []byte(expected)):
+		reason = "invalid signature"
+	case time.Since(time.Unix(sent, 0)) > maxAge || time.Until(time.Unix(sent, 0)) > maxAge:
+		reason = "expired signature"
+	case !rememberNonce(nonce, time.Unix(sent, 0).Add(maxAge)):
+		reason = "replayed"
+	}
+	if reason != "" {
+		slog.Warn(SNAuthInvalidSignature, "Rejecting "+reason+" "+action+" request from "+req.RemoteAddr)
+		writeError(res, http.StatusUnauthorized, ErrorUnauthorized, "Invalid request signature")
+		return false, false
+	}
+	return true, true
+}
@@ -0,0 +1,123 @@
+package networking
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"subframe/server/settings"
+	"testing"
+)
+
+//withClusterSecret sets settings.ClusterSecret for the duration of the test
+func withClusterSecret(t *testing.T, secret string) {
+	previous := settings.Current().ClusterSecret
+	settings.Current().ClusterSecret = secret
+	t.Cleanup(func() { settings.Current().ClusterSecret = previous })
+}
+
+func newSignedRequest(t *testing.T, method string, target string, body []byte) *http.Request {
+	req, err := http.NewRequest(method, "http://node"+target, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signRequest(req) {
+		t.Fatal("signing request failed")
+	}
+	return req
+}
+
+func TestCheckSignatureOfPuts(t *testing.T) {
+	withClusterSecret(t, "secret")
+
+	signed := newSignedRequest(t, http.MethodPost, "/storage/put/abc?version=3", []byte("content"))
+	tampered := newSignedRequest(t, http.MethodPost, "/storage/put/abc?version=3", []byte("content"))
+	tampered.URL.RawQuery = "version=4"
+	unsigned := httptest.NewRequest(http.MethodPost, "/storage/put/abc", bytes.NewReader([]byte("content")))
+
+	tests := []struct {
+		name       string
+		req        *http.Request
+		wantSigned bool
+		wantOK     bool
+	}{
+		{"signed by a Node", signed, true, true},
+		{"signature not matching the query", tampered, false, false},
+		{"unsigned client put", unsigned, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			gotSigned, gotOK := checkSignature(recorder, test.req, "put")
+			if gotSigned != test.wantSigned || gotOK != test.wantOK {
+				t.Fatalf("checkSignature() = %v, %v, want %v, %v", gotSigned, gotOK, test.wantSigned, test.wantOK)
+			}
+			if !test.wantOK && recorder.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", recorder.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestCheckSignatureRestoresBody(t *testing.T) {
+	withClusterSecret(t, "secret")
+
+	req := newSignedRequest(t, http.MethodPost, "/storage/put/abc", []byte("content"))
+	if _, ok := checkSignature(httptest.NewRecorder(), req, "put"); !ok {
+		t.Fatal("signed put was rejected")
+	}
+	var body bytes.Buffer
+	body.ReadFrom(req.Body)
+	if body.String() != "content" {
+		t.Errorf("body = %q, want %q", body.String(), "content")
+	}
+}
+
+func TestCheckSignatureRejectsReplays(t *testing.T) {
+	withClusterSecret(t, "secret")
+
+	req := newSignedRequest(t, http.MethodPost, "/storage/put/abc", nil)
+	replay := req.Clone(req.Context())
+	if _, ok := checkSignature(httptest.NewRecorder(), req, "put"); !ok {
+		t.Fatal("signed put was rejected")
+	}
+	if _, ok := checkSignature(httptest.NewRecorder(), replay, "put"); ok {
+		t.Error("replayed put was accepted")
+	}
+}
+
+func TestCheckSignatureRequiresInterNodeActions(t *testing.T) {
+	withClusterSecret(t, "secret")
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/storage/update", nil)
+	if _, ok := checkSignature(recorder, req, "update"); ok || recorder.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned update: ok = %v, status = %d, want rejection with %d", ok, recorder.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestCheckSignatureWithoutClusterSecret(t *testing.T) {
+	withClusterSecret(t, "")
+
+	req := httptest.NewRequest(http.MethodPost, "/storage/put/abc", nil)
+	req.Header.Set(signatureHeader, "forged")
+	signed, ok := checkSignature(httptest.NewRecorder(), req, "put")
+	if signed || !ok {
+		t.Errorf("checkSignature() = %v, %v, want false, true", signed, ok)
+	}
+}
+
+func TestAuthTokenDoesNotPrivilege(t *testing.T) {
+	previous := settings.Current().AuthToken
+	settings.Current().AuthToken = "token"
+	t.Cleanup(func() { settings.Current().AuthToken = previous })
+
+	req := httptest.NewRequest(http.MethodGet, "/storage/get/abc", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	request := storageRequest{res: httptest.NewRecorder(), req: req, action: "get", slug: "abc"}
+	if !request.authenticate() {
+		t.Fatal("request presenting the auth token was rejected")
+	}
+	if request.privileged {
+		t.Error("request presenting the auth token is privileged")
+	}
+}
@@ -1,54 +1,169 @@
 package networking
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"subframe/server/buildinfo"
 	"subframe/server/database"
 	"subframe/server/jobqueue"
 	"subframe/server/logger"
 	"subframe/server/settings"
 	"subframe/server/storage"
+	. "subframe/status"
 	"subframe/structs/message"
+	"time"
+	"unicode"
 )
 
 var slog = logger.Logger{Prefix: "networking/StorageNode"}
 
-var storageNodeActions = []string{
-	"get",
-	"put",
-	"update",
-	"control",
+//defaultListLimit and maxListLimit bound the page size of list-messages
+const defaultListLimit = 100
+const maxListLimit = 1000
+
+//slugPattern matches valid slugs. It is compiled once, as it is checked on every request
+var slugPattern = regexp.MustCompile("^[A-Za-z0-9_-]*$")
+
+//reservedNames are file names Windows refuses to create, whatever the extension
+var reservedNames = regexp.MustCompile("(?i)^(CON|PRN|AUX|NUL|COM[0-9]|LPT[0-9])$")
+
+//validMessageID returns whether id is a valid message ID. IDs are used as file names, so they are bounded by
+//settings.MessageIDMinLength and settings.MessageIDMaxLength, and names reserved by the file system are rejected
+func validMessageID(id string) bool {
+	return len(id) >= settings.Current().MessageIDMinLength && len(id) <= settings.Current().MessageIDMaxLength &&
+		slugPattern.MatchString(id) && !reservedNames.MatchString(id)
 }
 
+//storageNodeActions maps every valid action to the HTTP methods it accepts
+var storageNodeActions = map[string][]string{
+	"get":     {http.MethodGet, http.MethodHead},
+	"put":     {http.MethodPut, http.MethodPost},
+	"delete":  {http.MethodDelete},
+	"update":  {http.MethodPost},
+	"control": {http.MethodGet},
+	//batch-get takes the IDs in the body instead of a slug
+	"batch-get": {http.MethodPost},
+	//redistribute is sent by CoordinatorNodes for messages which lost replicas
+	"redistribute": {http.MethodGet, http.MethodPost},
+}
+
+//apiServer serves the StorageNode and CoordinatorNode APIs
+var apiServer *http.Server
+
 func startStorageNodeAPIService() {
-	slog.Info("Starting HTTP Server at " + settings.LocalAddress + "...")
+	tlsConfig := loadTLSConfig()
+	server := &http.Server{
+		Addr:      settings.Current().LocalAddress,
+		TLSConfig: tlsConfig,
+	}
+	apiServer = server
 	http.HandleFunc("/storage/", handleRequest)
+	registerHealthEndpoints()
+
+	if tlsConfig == nil {
+		slog.Warn(NetworkingTLSConfigError, "Starting HTTP Server without TLS at "+settings.Current().LocalAddress+"...")
+		go func() {
+			err := server.ListenAndServe()
+			if err != http.ErrServerClosed {
+				slog.Fatal(GenericInternalError, "Fatal failure in HTTP Storage Interface Server: "+err.Error())
+			}
+		}()
+		return
+	}
+
+	slog.Info(InProgress, "Starting HTTPS Server at "+settings.Current().LocalAddress+"...")
 	go func() {
-		err := (http.ListenAndServe(settings.LocalAddress, nil))
-		slog.Fatal("Fatal failure in HTTP Storage Interface Server: " + err.Error())
+		//Certificates are already loaded into server.TLSConfig
+		err := server.ListenAndServeTLS("", "")
+		if err != http.ErrServerClosed {
+			slog.Fatal(GenericInternalError, "Fatal failure in HTTP Storage Interface Server: "+err.Error())
+		}
 	}()
 }
 
+//stopStorageNodeAPIService stops accepting requests and waits up to timeout for in-flight requests to finish
+func stopStorageNodeAPIService(timeout time.Duration) {
+	if apiServer == nil {
+		return
+	}
+	slog.Info(InProgress, "Stopping HTTP Server. Waiting for in-flight requests...")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := apiServer.Shutdown(ctx); err != nil {
+		slog.Warn(GenericInternalError, "In-flight requests did not finish in time: "+err.Error())
+		apiServer.Close()
+		return
+	}
+	slog.Info(OK, "Stopped HTTP Server.")
+}
+
 func handleRequest(responseWriter http.ResponseWriter, req *http.Request) {
-	slog.Info("Handling incoming " + req.Method + " request to " + req.URL.Path + "...")
+	start := time.Now()
+	req = withRequestID(responseWriter, req)
+	req, span := startRequestSpan(req)
+	log := slog.WithContext(req.Context())
+	log.Debug(InProgress, "Handling incoming "+req.Method+" request to "+req.URL.Path+"...")
+	compressed, finish := compressResponse(responseWriter, req)
+	defer finish()
+	recorder := &statusRecorder{ResponseWriter: compressed, status: http.StatusOK}
 	request := storageRequest{
-		res: responseWriter,
+		res: recorder,
 		req: req,
+		log: log,
+	}
+	defer func() {
+		countRequest(request.action, storageNodeActions, recorder)
+		writeAccessLog(req, request.action, request.slug, recorder, start)
+		endRequestSpan(span, req, "storage/"+request.action, request.slug, recorder)
+	}()
+
+	//Errors need the CORS headers as well, for browser clients to read them
+	corsAllowed := setCORSHeaders(recorder, req)
+
+	//Requests are shed before their body is read, which could exhaust memory if too many are handled at once
+	release, acquired := acquireRequest(recorder)
+	if !acquired {
+		return
+	}
+	defer release()
+
+	if !request.checkRateLimit() {
+		return
 	}
 
 	if request.parsePath() != http.StatusOK || !request.isValid() {
-		slog.Info("Action or Slug for " + req.URL.Path + " is invalid")
-		writeResponse(responseWriter, http.StatusBadRequest, "Invalid Action or Slug")
+		log.Info(GenericInputError, "Action or Slug for "+req.URL.Path+" is invalid")
+		writeError(request.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid Action or Slug")
+		return
+	}
+
+	//Browsers send preflights without credentials, so they are answered before authentication
+	if isPreflight(req) {
+		writePreflight(recorder, corsAllowed, storageNodeActions[request.action])
+		return
+	}
+
+	if !request.checkMethod() {
 		return
 	}
 
-	//Handle Request
-	slog.Info("Request appears valid (Action: " + request.action + ", Slug: " + request.slug + "). Processing...")
+	if !request.authenticate() {
+		return
+	}
+	signed, ok := checkSignature(recorder, req, request.action)
+	if !ok {
+		return
+	}
+	request.privileged = request.privileged || signed
+
+	//Every further log of the request carries its action and message ID
+	request.log = log.With("action", request.action).With("messageID", request.slug)
+	request.log.Debug(InProgress, "Request appears valid. Processing...")
 	request.handle()
 }
 
@@ -58,236 +173,1091 @@ type storageRequest struct {
 	action string
 	slug   string
 	valid  bool
+	log    logger.Logger
+	//identity is set for requests presenting a token of settings.AccessTokens, privileged for requests signed by other
+	//Nodes and control requests presenting settings.AdminToken
+	identity   string
+	privileged bool
 }
 
+//parsePath extracts action and optional slug from /storage/<action>[/<slug>].
+//Paths without an action, with further segments or with a slug not matching [A-Za-z0-9_-] are malformed
 func (r *storageRequest) parsePath() (status int) {
-	parts := strings.Split(r.req.URL.Path, "/")[1:]
-	if len(parts) < 2 {
+	path := strings.TrimPrefix(r.req.URL.Path, "/storage/")
+	if path == r.req.URL.Path {
 		return http.StatusBadRequest
-	} else if len(parts) < 3 {
-		r.action = parts[1]
+	}
+
+	parts := strings.Split(path, "/")
+	if parts[0] == "" || len(parts) > 2 {
+		return http.StatusBadRequest
+	}
+	r.action = parts[0]
+	if len(parts) < 2 {
 		return http.StatusOK
 	}
-	r.action = parts[1]
-	rexp, err := regexp.Compile("[^A-Za-z0-9]")
-	if err != nil {
+
+	//Slugs are used as storage keys, so they are rejected instead of rewritten, which could map distinct IDs to the same key
+	if len(parts[1]) > settings.MessageIDLengthLimit || !slugPattern.MatchString(parts[1]) {
 		return http.StatusBadRequest
 	}
-	r.slug = rexp.ReplaceAllString(parts[2], "-")
+	r.slug = parts[1]
 	return http.StatusOK
 }
 
 func (r *storageRequest) isValid() bool {
-	validAction := false
-	validMsgID := false
-	for _, a := range storageNodeActions {
-		if r.action == a {
-			validAction = true
-		}
-	}
-	if len(r.slug) > 0 {
-		validMsgID = true
+	_, validAction := storageNodeActions[r.action]
+	//Control actions take the name of a subaction instead of a message ID
+	validMsgID := validMessageID(r.slug)
+	switch r.action {
+	case "batch-get":
+		validMsgID = len(r.slug) == 0
+	case "control":
+		validMsgID = len(r.slug) > 0
 	}
 	r.valid = validAction && validMsgID
 	return validAction && validMsgID
 }
 
+//checkMethod verifies the request method is allowed for the action, and writes a 405 response otherwise
+func (r *storageRequest) checkMethod() bool {
+	allowed := storageNodeActions[r.action]
+	if methodAllowed(r.req.Method, allowed) {
+		return true
+	}
+
+	r.log.Error(GenericInputError, "Client is trying to "+r.action+" with a "+r.req.Method+" Request.")
+	r.res.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(r.res, http.StatusMethodNotAllowed, ErrorInvalidMethod, r.req.Method+" is not allowed here.")
+	return false
+}
+
 func (r storageRequest) handle() {
+	//Read-only Nodes keep serving reads and control actions
+	if r.rejectReadOnly() {
+		return
+	}
 	//Handle request
 	switch r.action {
 	case "get":
+		if r.req.Method == http.MethodHead {
+			r.handleHead()
+			return
+		}
 		r.handleGet()
 	case "put":
 		r.handlePut()
+	case "delete":
+		r.handleDelete()
 	case "control":
 		r.handleControl()
 	case "update":
 		r.updateMessageStatus()
+	case "redistribute":
+		r.handleRedistribute()
+	case "batch-get":
+		r.handleBatchGet()
+	default:
+		//isValid only admits actions of storageNodeActions, so every one of them has to be handled above
+		r.log.Error(GenericInternalError, "No handler for action "+r.action)
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Unknown action "+r.action)
 	}
 }
 
+//handleRedistribute queues pushing a locally stored message to further StorageNodes.
+//The optional replicas parameter is the number of copies already stored on other Nodes
+func (r storageRequest) handleRedistribute() {
+	messageID := r.slug
+	if _, stored := database.CheckMessageStorage(messageID); !stored {
+		writeError(r.res, http.StatusNotFound, ErrorNotFound, "Message "+messageID+" not found")
+		return
+	}
+
+	replicas, err := strconv.Atoi(r.req.URL.Query().Get("replicas"))
+	if err != nil || replicas < 0 {
+		replicas = 0
+	}
+
+	r.log.Info(InProgress, "Redistributing Message "+messageID+" with "+strconv.Itoa(replicas)+" known Replicas...")
+	enqueueRedistribution(r.req.Context(), &redistributionJob{MessageID: messageID, Replicas: replicas})
+	writeResponse(r.res, http.StatusAccepted, "Redistributing message "+messageID)
+}
+
 func (r storageRequest) handleGet() {
-	slog.Info("Handling MessageGET Request for " + r.slug + "...")
+	r.log.Info(InProgress, "Handling MessageGET Request for "+r.slug+"...")
+	if notFound.contains(r.slug) {
+		notFoundCacheHits.Inc()
+		r.log.Info(OK, "Message "+r.slug+" was recently not found.")
+		writeError(r.res, http.StatusNotFound, ErrorNotFound, "Error getting message with ID "+r.slug)
+		return
+	}
+	since := notFound.generation()
+	if !r.authorizeRead(r.slug) {
+		return
+	}
 
-	if r.req.Method != "GET" {
-		slog.Error("Client is trying to MessageGET with a " + r.req.Method + " Request.")
-		writeResponse(r.res, http.StatusBadRequest, r.req.Method+" is not allowed here.")
+	//Parts of the JSON wrapper are of no use, so ranges are served from the raw content
+	if acceptsRaw(r.req) || r.req.Header.Get("Range") != "" {
+		r.streamMessage(since)
 		return
 	}
 
-	message, readingError := storage.Get(r.slug)
+	var message message.Message
+	readingError := withStorageTimeout(r.req.Context(), "get", settings.Current().StorageGetTimeout, func(ctx context.Context) (status int) {
+		message, status = storage.GetContext(ctx, r.slug)
+		return status
+	})
+	if readingError == http.StatusNotFound {
+		notFound.remember(r.slug, since)
+	}
 	if readingError != http.StatusOK {
-		slog.Error("Cannot server Message " + r.slug + ": " + strconv.Itoa(readingError))
-		writeResponse(r.res, readingError, "Error getting message with ID "+r.slug)
+		r.log.Error(readingError, "Cannot serve Message "+r.slug+": "+strconv.Itoa(readingError))
+		writeError(r.res, readingError, errorCodeForStatus(readingError), "Error getting message with ID "+r.slug)
+		return
+	}
+	if r.checkNotModified(messageETag(message.Checksum, false)) {
 		return
 	}
 	responsedata, encodingError := json.Marshal(message)
 	if encodingError != nil {
-		slog.Error("Error serving Message " + r.slug + ": " + encodingError.Error())
-		writeResponse(r.res, http.StatusInternalServerError, "Error serving message from disk")
+		r.log.Error(GenericInternalError, "Error serving Message "+r.slug+": "+encodingError.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error serving message from disk")
 		return
 	}
-	slog.Info("Serving Message " + r.slug + "...")
-	writeResponse(r.res, http.StatusOK, string(responsedata))
+	r.log.Info(OK, "Serving Message "+r.slug+"...")
+	writeJSON(r.res, http.StatusOK, string(responsedata))
 }
 
-func (r storageRequest) handlePut() {
-	slog.Info("Handling MessagePUT Request for " + r.slug + "...")
+//acceptsRaw returns whether the client prefers raw message content over the JSON envelope
+func acceptsRaw(req *http.Request) bool {
+	accept := req.Header.Get("Accept")
+	return strings.Contains(accept, "application/octet-stream") && !strings.Contains(accept, "application/json")
+}
 
-	if r.req.Method != "POST" {
-		slog.Error("Client is trying to MessagePUT with a " + r.req.Method + " Request.")
-		writeResponse(r.res, http.StatusBadRequest, r.req.Method+" is not allowed here.")
+//streamMessage copies the raw message content to the response without loading it into memory.
+//A single range requested with the Range header is served with 206, reading only as much content as needed.
+//Missing messages are remembered in notFound, see notFoundCache.remember for since
+func (r storageRequest) streamMessage(since uint64) {
+	content, size, status := storage.Open(r.slug)
+	if status == http.StatusNotFound {
+		notFound.remember(r.slug, since)
+	}
+	if status != http.StatusOK {
+		r.log.Error(GenericInputError, "Cannot serve Message "+r.slug+": "+strconv.Itoa(status))
+		writeError(r.res, status, errorCodeForStatus(status), "Error getting message with ID "+r.slug)
 		return
 	}
+	defer content.Close()
 
-	messageID := r.slug
-	r.req.Body = http.MaxBytesReader(r.res, r.req.Body, int64(settings.MessageMaxSize)*1024*1024)
-	messageBody, error := ioutil.ReadAll(r.req.Body)
-	if error != nil {
-		if len(messageBody) >= settings.MessageMaxSize*1024*1024 {
-			exceeds := (len(messageBody) / 1024 / 1024) - settings.MessageMaxSize
-			slog.Error("Message size exceeds settings.MessageMaxSize (by " + strconv.Itoa(exceeds) + "M), denying storage request.")
-			writeResponse(r.res, http.StatusRequestEntityTooLarge, "Message too large to be accepted by this node")
+	//Streamed content cannot be verified before sending, so clients verify it end-to-end
+	sum := storage.Checksum(r.slug)
+	etag := messageETag(sum, true)
+	if r.checkNotModified(etag) {
+		return
+	}
+	r.res.Header().Set("Accept-Ranges", "bytes")
+	rng, satisfiable := requestedRange(r.req, size, etag)
+	if !satisfiable {
+		r.res.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+		writeError(r.res, http.StatusRequestedRangeNotSatisfiable, ErrorRangeNotSatisfiable, "Range exceeds message of "+strconv.FormatInt(size, 10)+" bytes")
+		return
+	}
+
+	status = http.StatusOK
+	length := size
+	if rng != nil {
+		if err := storage.Skip(content, rng.start); err != nil {
+			r.log.Error(GenericInternalError, "Error reading Message "+r.slug+": "+err.Error())
+			writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error reading message "+r.slug)
 			return
 		}
-		slog.Error("Transmission of message failed: " + error.Error())
-		writeResponse(r.res, http.StatusBadRequest, "Transmission of Message Body failed. Please try again.")
+		status = http.StatusPartialContent
+		length = rng.length
+		r.res.Header().Set("Content-Range", rng.contentRange(size))
+	}
+	r.log.Info(InProgress, "Streaming Message "+r.slug+"...")
+	contentType := storage.ContentType(r.slug)
+	if contentType == "" {
+		contentType = genericContentType
+	}
+	r.res.Header().Set("Content-Type", contentType)
+	//Browsers must not guess a more dangerous type than the recorded one
+	r.res.Header().Set("X-Content-Type-Options", "nosniff")
+	r.res.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	setMetaHeaders(r.res, storage.Headers(r.slug))
+	if version := storage.Version(r.slug); version != 0 {
+		r.res.Header().Set(versionHeader, strconv.FormatInt(version, 10))
+	}
+	//The checksum covers the whole content, clients verify ranges against the ETag instead
+	if sum != "" && rng == nil {
+		function, hash := storage.SplitChecksum(sum)
+		r.res.Header().Set("X-Checksum-"+strings.ToUpper(function), hash)
+	}
+	r.res.WriteHeader(status)
+	written, err := io.CopyN(r.res, content, length)
+	if err != nil {
+		r.log.Error(GenericInternalError, "Error streaming Message "+r.slug+" after "+strconv.FormatInt(written, 10)+" bytes: "+err.Error())
 		return
 	}
+	r.log.Info(OK, "Streamed Message "+r.slug+".")
+}
 
-	//TODO: Verify that message is somewhat valid
-	if len(messageBody) == 0 {
-		slog.Error("Message Body is empty")
-		writeResponse(r.res, http.StatusBadRequest, "Empty Message Body")
-		return
+//maxPartyLength bounds the sender and recipient of a message
+const maxPartyLength = 256
+
+//parseParty reads an optional sender or recipient. Values have to be printable and at most maxPartyLength bytes
+func parseParty(value string) (party string, valid bool) {
+	party = strings.TrimSpace(value)
+	if len(party) > maxPartyLength {
+		return "", false
+	}
+	for _, char := range party {
+		if !unicode.IsPrint(char) {
+			return "", false
+		}
 	}
+	return party, true
+}
 
-	slog.Info("Message " + messageID + " successfully transmitted. Storing...")
-	message := message.Message{
-		ID:      messageID,
-		Content: string(messageBody),
+//messageParties returns the sender, recipient and creation time of a message put with the request. The sender is the
+//identity the request is authenticated as, which an X-Sender header has to match, the recipient is declared with
+//X-Recipient, and CreatedAt is recorded by the Node storing it. Copies pushed by other Nodes pass the ones of the
+//original in the sender, recipient and createdAt parameters instead. Returns http.StatusOK, or the error status
+func (r storageRequest) messageParties() (sender string, recipient string, createdAt *time.Time, status int) {
+	if r.privileged {
+		query := r.req.URL.Query()
+		var senderValid, recipientValid bool
+		sender, senderValid = parseParty(query.Get("sender"))
+		recipient, recipientValid = parseParty(query.Get("recipient"))
+		if !senderValid || !recipientValid {
+			return "", "", nil, http.StatusBadRequest
+		}
+		if raw := query.Get("createdAt"); raw != "" {
+			created, err := time.Parse(time.RFC3339Nano, raw)
+			if err != nil {
+				return "", "", nil, http.StatusBadRequest
+			}
+			createdAt = &created
+		}
+		return sender, recipient, createdAt, http.StatusOK
 	}
 
-	status := storage.Put(message)
-	if status == http.StatusOK {
-		status = database.LogMessageStorage(messageID)
+	declared, senderValid := parseParty(r.req.Header.Get("X-Sender"))
+	recipient, recipientValid := parseParty(r.req.Header.Get("X-Recipient"))
+	if !senderValid || !recipientValid {
+		return "", "", nil, http.StatusBadRequest
+	}
+	if declared != "" && declared != r.identity {
+		return "", "", nil, http.StatusForbidden
 	}
+	return r.identity, recipient, nil, http.StatusOK
+}
 
+//parseExpiry reads an optional expiry from the X-Expires-In (seconds) or X-Expires-At (RFC 3339) header.
+//Expiries are capped at settings.MessageMaxStoreTime
+func parseExpiry(req *http.Request) (expiresAt *time.Time, valid bool) {
+	var expiry time.Time
+	if in := req.Header.Get("X-Expires-In"); in != "" {
+		seconds, err := strconv.ParseInt(in, 10, 64)
+		if err != nil || seconds <= 0 {
+			return nil, false
+		}
+		expiry = time.Now().Add(time.Duration(seconds) * time.Second)
+	} else if at := req.Header.Get("X-Expires-At"); at != "" {
+		var err error
+		expiry, err = time.Parse(time.RFC3339, at)
+		if err != nil || !expiry.After(time.Now()) {
+			return nil, false
+		}
+	} else {
+		return nil, true
+	}
+
+	maxExpiry := time.Now().AddDate(0, 0, settings.Current().MessageMaxStoreTime)
+	if expiry.After(maxExpiry) {
+		expiry = maxExpiry
+	}
+	expiry = expiry.UTC()
+	return &expiry, true
+}
+
+//messageExpiry returns the expiry of a message put with the request. Copies pushed by other Nodes keep the expiry
+//passed in the expiresAt parameter, as it is, other puts read it with parseExpiry
+func (r storageRequest) messageExpiry() (expiresAt *time.Time, valid bool) {
+	raw := r.req.URL.Query().Get("expiresAt")
+	if raw == "" || !r.privileged {
+		return parseExpiry(r.req)
+	}
+	expiry, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return nil, false
+	}
+	expiry = expiry.UTC()
+	return &expiry, true
+}
+
+func (r storageRequest) handleHead() {
+	r.log.Info(InProgress, "Handling MessageHEAD Request for "+r.slug+"...")
+	if !r.authorizeRead(r.slug) {
+		return
+	}
+
+	size, status := storage.Size(r.slug)
 	if status != http.StatusOK {
-		slog.Error("Error storing message: " + strconv.Itoa(status))
-		writeResponse(r.res, status, "Error storing message "+messageID)
+		r.log.Info(OK, "Message "+r.slug+" is not present on this Node.")
+		r.res.WriteHeader(httpStatus(status))
 		return
 	}
 
-	slog.Info("Successfully stored Message " + messageID)
-	writeResponse(r.res, http.StatusOK, "Successfully stored message "+messageID)
+	if r.checkNotModified(messageETag(storage.Checksum(r.slug), acceptsRaw(r.req))) {
+		return
+	}
+	r.res.Header().Set("Accept-Ranges", "bytes")
+	r.res.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	r.res.WriteHeader(http.StatusOK)
+	r.log.Info(OK, "Message "+r.slug+" is present on this Node.")
+}
 
-	task := func(data interface{}) {
-		log := logger.Logger{Prefix: "networking/Announce-" + messageID}
-		messageID, ok := data.(string)
-		if !ok {
-			log.Error("Error starting Announcing Thread")
+func (r storageRequest) handlePut() {
+	r.log.Info(InProgress, "Handling MessagePUT Request for "+r.slug+"...")
+
+	messageID := r.slug
+	if isDraining() {
+		r.log.Warn(GenericInputError, "Node is draining, denying storage request.")
+		writeError(r.res, http.StatusServiceUnavailable, ErrorDraining, "Node is draining and does not accept new messages")
+		return
+	}
+	if load := currentLoad(); !load.AcceptingWrites {
+		r.log.Warn(GenericInternalError, "Node is overloaded with a load score of "+strconv.FormatFloat(load.Score, 'f', 2, 64)+", denying storage request.")
+		r.res.Header().Set("Retry-After", overloadedRetryAfter)
+		writeError(r.res, http.StatusServiceUnavailable, ErrorOverloaded, "Node is overloaded and does not accept new messages")
+		return
+	}
+	//Conditional puts of stored messages are rejected before the body is transmitted
+	if createOnly(r.req) {
+		if _, stored := database.CheckMessageStorage(messageID); stored {
+			r.writePreconditionFailed()
 			return
 		}
+	}
+	dryRun := isDryRun(r.req)
+	declaredSize, valid := r.declaredSize(dryRun)
+	if !valid {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid X-Content-Length header")
+		return
+	}
+	//The declared size is checked before reading, MaxBytesReader still limits clients sending more than they declared
+	maxSize := int64(settings.Current().MessageMaxSize) * 1024 * 1024
+	bodyLimit := maxSize
+	if isMultipart(r.req) {
+		bodyLimit += multipartOverhead
+	}
+	if declaredSize > bodyLimit {
+		r.log.Error(GenericInputError, "Declared message size of "+strconv.FormatInt(declaredSize, 10)+" bytes exceeds settings.MessageMaxSize, denying storage request.")
+		writeError(r.res, http.StatusRequestEntityTooLarge, ErrorMessageTooLarge, "Message too large to be accepted by this node")
+		return
+	}
+
+	//Reject before reading the body if the node is full, or the declared size would not fit
+	if !storage.HasSpace(0) || (declaredSize > 0 && !storage.HasSpace(declaredSize)) {
+		r.log.Warn(GenericInputError, "Insufficient storage for Message "+messageID+", denying storage request.")
+		writeError(r.res, http.StatusInsufficientStorage, ErrorInsufficientStorage, "Insufficient storage on this node")
+		return
+	}
 
-		log.Info("Getting CoordinatorNodes to announce Message to...")
-		//Get three random coordinatorNodes
-		coordinatorNodes := database.GetRandomCoordinatorNodes(3)
-		if len(coordinatorNodes) == 0 {
-			log.Error("Received empty List of CoordinatorNodes.")
+	//Declared content types are checked before reading, sniffed ones once the content is read
+	if declared := r.declaredContentType(""); declared != "" && !r.isCopy() && !contentTypeAllowed(declared) {
+		r.writeUnsupportedContentType(declared)
+		return
+	}
+
+	if dryRun {
+		r.validatePut()
+		return
+	}
+
+	//Chunked uploads are appended to until they are completed
+	query := r.req.URL.Query()
+	if query.Get("complete") != "" {
+		r.completeUpload(query.Get("complete"))
+		return
+	}
+	if query.Get("offset") != "" {
+		r.appendChunk(query.Get("offset"), maxSize)
+		return
+	}
+
+	r.req.Body = http.MaxBytesReader(r.res, r.req.Body, bodyLimit)
+	messageBody, partType, err := r.readContent(maxSize, bodyLimit)
+	if err != nil {
+		if err == errContentTooLarge {
+			r.log.Error(GenericInputError, "Message size exceeds settings.MessageMaxSize, denying storage request.")
+			writeError(r.res, http.StatusRequestEntityTooLarge, ErrorMessageTooLarge, "Message too large to be accepted by this node")
 			return
 		}
-		log.Info("Announcing Message to " + strconv.Itoa(len(coordinatorNodes)) + " CoordinatorNodes...")
-		//Announce MessageID to CoordinatorNetwork
-		var redistribute = "true"
-		for _, value := range coordinatorNodes {
-			r := SendNodeRequest(NODE_COORDINATOR, value, "/announce/"+messageID+"/"+settings.RemoteAddress, "")
-			//If at least one node orders to not further distribute the message, do not
-			if r == "false" {
-				redistribute = r
-			}
-		}
-		log.Info("Announced Message to CoordinatorNetwork. Redistributing: " + redistribute)
-		if redistribute == "true" {
-			//TODO: Push Message to other StorageNodes
+		if err == errNoContentPart {
+			r.log.Error(GenericInputError, "Multipart upload without file part")
+			writeError(r.res, http.StatusBadRequest, ErrorEmptyMessage, "Multipart upload has no file part")
+			return
 		}
+		r.log.Error(GenericInputError, "Transmission of message failed: "+err.Error())
+		writeError(r.res, http.StatusBadRequest, ErrorTransmissionFailed, "Transmission of Message Body failed. Please try again.")
+		return
 	}
-	job := jobqueue.Job{
-		Task: task,
-		Data: messageID,
+
+	//TODO: Verify that message is somewhat valid
+	if len(messageBody) == 0 {
+		r.log.Error(GenericInputError, "Message Body is empty")
+		writeError(r.res, http.StatusBadRequest, ErrorEmptyMessage, "Empty Message Body")
+		return
 	}
-	select {
-	case jobqueue.Queue <- job:
+
+	r.log.Info(InProgress, "Message "+messageID+" successfully transmitted. Storing...")
+	r.storeMessage(messageBody, r.declaredContentType(partType))
+}
+
+//putMessage returns the message put with the request, without content, from its headers.
+//It writes an error response and returns false if a header is invalid
+func (r storageRequest) putMessage() (msg message.Message, valid bool) {
+	messageID := r.slug
+	expiresAt, valid := r.messageExpiry()
+	if !valid {
+		r.log.Error(GenericInputError, "Invalid expiry for Message "+messageID)
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid X-Expires-In or X-Expires-At header, or expiresAt parameter")
+		return msg, false
+	}
+	sender, recipient, createdAt, status := r.messageParties()
+	if status == http.StatusForbidden {
+		r.log.Warn(GenericInputError, "Sender of Message "+messageID+" is not the authenticated identity")
+		writeError(r.res, http.StatusForbidden, ErrorForbidden, "X-Sender has to be the identity of the access token")
+		return msg, false
+	}
+	if status != http.StatusOK {
+		r.log.Error(GenericInputError, "Invalid sender or recipient for Message "+messageID)
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid X-Sender or X-Recipient header")
+		return msg, false
+	}
+	owner, readers, aclValid := r.messageACL()
+	if !aclValid {
+		r.log.Error(GenericInputError, "Invalid readers for Message "+messageID)
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid X-Readers header, which requires an access token")
+		return msg, false
+	}
+	version, versionValid := r.copyVersion()
+	if !versionValid {
+		r.log.Error(GenericInputError, "Invalid version for Message "+messageID)
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid version parameter")
+		return msg, false
+	}
+	headers, headersValid := r.messageHeaders()
+	if !headersValid {
+		r.log.Error(GenericInputError, "Invalid headers for Message "+messageID)
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid X-Meta-* headers, at most "+strconv.Itoa(maxMetaHeaders)+
+			" of printable ASCII with "+strconv.Itoa(maxMetaHeadersSize)+" bytes in total are allowed")
+		return msg, false
+	}
+
+	return message.Message{
+		ID:        messageID,
+		ExpiresAt: expiresAt,
+		CreatedAt: createdAt,
+		Sender:    sender,
+		Recipient: recipient,
+		Owner:     owner,
+		Readers:   readers,
+		Headers:   headers,
+		Version:   version,
+	}, true
+}
+
+//storeMessage stores the transmitted content of the message with the declared content type, or the one sniffed from
+//content, responds and queues announcing it. It returns whether the message was stored
+func (r storageRequest) storeMessage(content []byte, declaredType string) bool {
+	messageID := r.slug
+	message, valid := r.putMessage()
+	if !valid {
+		return false
+	}
+	message.Content = string(content)
+	message.ContentType = messageContentType(declaredType, content)
+	if !r.isCopy() && !contentTypeAllowed(message.ContentType) {
+		r.writeUnsupportedContentType(message.ContentType)
+		return false
+	}
+
+	status := withStorageTimeout(r.req.Context(), "put", settings.Current().StoragePutTimeout, func(ctx context.Context) int {
+		return putVersioned(ctx, message)
+	})
+
+	if status == http.StatusConflict && createOnly(r.req) {
+		r.writePreconditionFailed()
+		return false
 	}
+	if status != http.StatusOK {
+		r.log.Error(status, "Error storing message: "+strconv.Itoa(status))
+		writeError(r.res, status, errorCodeForStatus(status), "Error storing message "+messageID)
+		return false
+	}
+
+	r.log.Info(OK, "Successfully stored Message "+messageID)
+	writeResponse(r.res, http.StatusOK, "Successfully stored message "+messageID)
+
+	jobqueue.Enqueue(jobqueue.NewJobContext(r.req.Context(), announceJob, messageID))
+	emitEvent(r.req.Context(), eventStored, messageID, int64(len(content)))
+	return true
+}
+
+//uploadProgress is the response to chunks and upload-status requests of chunked uploads
+type uploadProgress struct {
+	ID     string `json:"id"`
+	Length int64  `json:"length"`
+}
+
+func (r storageRequest) writeUploadProgress(status int, length int64) {
+	response, _ := json.Marshal(uploadProgress{ID: r.slug, Length: length})
+	writeJSON(r.res, status, string(response))
+}
+
+//appendChunk appends the body to the chunked upload of the message, starting at offset.
+//Mismatching offsets are answered with 409 and the current length, so clients can resume from there
+func (r storageRequest) appendChunk(offsetParam string, maxSize int64) {
+	offset, err := strconv.ParseInt(offsetParam, 10, 64)
+	if err != nil || offset < 0 {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid offset "+offsetParam)
+		return
+	}
+	if _, stored := database.CheckMessageStorage(r.slug); stored {
+		writeError(r.res, http.StatusConflict, ErrorConflict, "Message "+r.slug+" is already stored")
+		return
+	}
+
+	length, status := storage.AppendUpload(r.slug, offset, r.req.Body, maxSize)
+	switch status {
+	case http.StatusOK, http.StatusConflict:
+		r.log.Info(OK, "Upload of Message "+r.slug+" has "+strconv.FormatInt(length, 10)+" bytes.")
+		r.writeUploadProgress(status, length)
+	case http.StatusRequestEntityTooLarge:
+		writeError(r.res, status, ErrorMessageTooLarge, "Message too large to be accepted by this node")
+	case http.StatusBadRequest:
+		writeError(r.res, status, ErrorTransmissionFailed, "Transmission of chunk failed. Resume at offset "+strconv.FormatInt(length, 10)+".")
+	case http.StatusInsufficientStorage:
+		writeError(r.res, status, ErrorInsufficientStorage, "Insufficient storage on this node")
+	default:
+		writeError(r.res, status, errorCodeForStatus(status), "Error uploading message "+r.slug)
+	}
+}
+
+//completeUpload stores the chunked upload of the message, if it has the expected total size
+func (r storageRequest) completeUpload(sizeParam string) {
+	size, err := strconv.ParseInt(sizeParam, 10, 64)
+	if err != nil || size <= 0 {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid size "+sizeParam)
+		return
+	}
+
+	content, status := storage.ReadUpload(r.slug, size)
+	if status == http.StatusConflict {
+		length, _ := storage.UploadLength(r.slug)
+		r.writeUploadProgress(status, length)
+		return
+	}
+	if status != http.StatusOK {
+		writeError(r.res, status, errorCodeForStatus(status), "Error completing upload of message "+r.slug)
+		return
+	}
+
+	r.log.Info(InProgress, "Upload of Message "+r.slug+" is complete. Storing...")
+	//The chunks carry the content, so its content type is declared when completing the upload
+	if r.storeMessage(content, r.declaredContentType("")) {
+		storage.DiscardUpload(r.slug)
+	}
+}
+
+func (r storageRequest) handleDelete() {
+	r.log.Info(InProgress, "Handling MessageDELETE Request for "+r.slug+"...")
+	messageID := r.slug
+
+	if _, deleted := database.CheckMessageDeletion(messageID); deleted {
+		r.log.Info(OK, "Message "+messageID+" has already been deleted.")
+		writeResponse(r.res, http.StatusOK, "Successfully deleted message "+messageID)
+		return
+	}
+
+	if _, stored := database.CheckMessageStorage(messageID); !stored {
+		r.log.Error(GenericInputError, "Cannot delete Message "+messageID+": Not in database")
+		writeError(r.res, http.StatusNotFound, ErrorNotFound, "Message "+messageID+" not found")
+		return
+	}
+	if !r.authorizeDelete(messageID) {
+		return
+	}
+
+	size, _ := storage.Size(messageID)
+	status := deleteMessage(messageID)
+	if status != http.StatusOK {
+		writeError(r.res, status, errorCodeForStatus(status), "Error deleting message "+messageID)
+		return
+	}
+
+	r.log.Info(OK, "Successfully deleted Message "+messageID)
+	writeResponse(r.res, http.StatusOK, "Successfully deleted message "+messageID)
+	enqueueDeannounce(r.req.Context(), messageID)
+	emitEvent(r.req.Context(), eventDeleted, messageID, size)
+}
+
+//deleteMessage removes a message from storage and logs its deletion to the database
+func deleteMessage(messageID string) (status int) {
+	status = storage.Delete(messageID)
+	if status != http.StatusOK && status != http.StatusNotFound {
+		slog.Error(GenericInternalError, "Error deleting message: "+strconv.Itoa(status))
+		return status
+	}
+
+	if database.LogMessageDeletion(messageID) != OK {
+		slog.Error(SNDBWriteError, "Error logging deletion of message "+messageID)
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
+
+//enqueueDeannounce queues telling the CoordinatorNetwork that this Node no longer serves a message, logged with the request ID of ctx
+func enqueueDeannounce(ctx context.Context, messageID string) {
+	jobqueue.Enqueue(jobqueue.NewJobContext(ctx, deannounceJob, messageID))
+}
+
+//storageControlActions lists the actions served at /storage/control/<action>, as handled by handleControl
+var storageControlActions = []string{
+	"bucket", "compact", "digest", "drain", "drain-status", "get-coordinator-nodes", "get-storage-nodes",
+	"get-storage-usage", "list-messages", "queue-stats", "replicas", "set-readonly", "stat", "storage-stats",
+	"upload-status", "verify", "verify-status", "version",
 }
 
 func (r storageRequest) handleControl() {
 	action := r.slug
+	if !checkAdmin(r.res, r.req, action) {
+		return
+	}
 	switch action {
 	case "get-storage-nodes":
 		r.printStorageNodes()
 	case "get-coordinator-nodes":
 		r.printCoordinatorNodes()
+	case "list-messages":
+		r.printMessageList()
+	case "drain":
+		r.handleDrain()
+	case "drain-status":
+		r.writeDrainProgress(http.StatusOK)
+	case "storage-stats":
+		r.printStorageStats()
+	case "get-storage-usage":
+		r.printStorageUsage()
+	case "upload-status":
+		r.printUploadStatus()
+	case "replicas":
+		r.printReplicas()
+	case "stat":
+		r.printStat()
+	case "digest":
+		r.printDigest()
+	case "bucket":
+		r.printBucket()
+	case "compact":
+		r.handleCompact()
+	case "queue-stats":
+		r.printQueueStats()
+	case "verify":
+		r.handleVerify()
+	case "verify-status":
+		r.writeVerifyProgress(http.StatusOK)
+	case "set-readonly":
+		r.handleSetReadOnly()
+	case "version":
+		r.printVersion()
+	default:
+		r.log.Info(GenericInputError, "Unknown control action "+action)
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Unknown control action "+action+", expected one of "+strings.Join(storageControlActions, ", "))
 	}
 }
 
-func (r storageRequest) printStorageNodes() {
-	slog.Info("Exporting 10 StorageNodes...")
-	storageNodes := database.GetStorageNodes(10)
-	response, err := json.Marshal(storageNodes)
+//printVersion responds with the version, commit and Go version the Node was built with
+func (r storageRequest) printVersion() {
+	response, _ := json.Marshal(buildinfo.Get())
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+//printUploadStatus responds with the number of bytes received for the chunked upload /control/upload-status?id=<id>
+func (r storageRequest) printUploadStatus() {
+	id := r.req.URL.Query().Get("id")
+	if !validMessageID(id) {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid message ID")
+		return
+	}
+
+	length, status := storage.UploadLength(id)
+	if status != http.StatusOK {
+		writeError(r.res, status, errorCodeForStatus(status), "No upload of message "+id+" in progress")
+		return
+	}
+	r.slug = id
+	r.writeUploadProgress(http.StatusOK, length)
+}
+
+//printStat responds with the metadata of the message /control/stat?id=<id> without its content, or 404
+func (r storageRequest) printStat() {
+	id := r.req.URL.Query().Get("id")
+	if !validMessageID(id) {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid message ID")
+		return
+	}
+
+	if !r.authorizeRead(id) {
+		return
+	}
+	stat, status := storage.Stat(id)
+	if status != http.StatusOK {
+		writeError(r.res, status, errorCodeForStatus(status), "Error getting stat of message "+id)
+		return
+	}
+	response, err := json.Marshal(stat)
+	if err != nil {
+		r.log.Error(GenericInternalError, "Failed to export stat: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error getting stat of message "+id)
+		return
+	}
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+//printReplicas responds with the JSON list of StorageNode addresses the message /control/replicas?id=<id> is placed on,
+//so clients can route requests for it without asking a CoordinatorNode. Messages not stored locally are placed with
+//settings.ReplicationFactor, as their size is unknown
+func (r storageRequest) printReplicas() {
+	id := r.req.URL.Query().Get("id")
+	if !validMessageID(id) {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid message ID")
+		return
+	}
+
+	factor := settings.Current().ReplicationFactor
+	if size, status := storage.Size(id); status == http.StatusOK {
+		factor = settings.Current().ReplicationFactorFor(size)
+	}
+	status, replicas := database.GetReplicaNodes(id, factor+1)
+	if status != OK {
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error getting replicas of message "+id)
+		return
+	}
+	addresses := make([]string, len(replicas))
+	for index, replica := range replicas {
+		addresses[index] = replica.Address
+	}
+
+	response, err := json.Marshal(addresses)
+	if err != nil {
+		r.log.Error(GenericInternalError, "Failed to export replicas: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error getting replicas of message "+id)
+		return
+	}
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+//printDigest responds with the hashes of the message IDs in every bucket, for Anti-Entropy of other StorageNodes
+func (r storageRequest) printDigest() {
+	digest, status := storage.Digest()
+	if status != http.StatusOK {
+		writeError(r.res, status, errorCodeForStatus(status), "Error calculating digest")
+		return
+	}
+
+	response, err := json.Marshal(digestResponse{Buckets: digest})
+	if err != nil {
+		r.log.Error(GenericInternalError, "Failed to export digest: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error calculating digest")
+		return
+	}
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+//printBucket responds with the IDs of the locally stored messages in bucket /control/bucket?bucket=<n>, their versions
+//and sizes, so peers can tell whether they hold a replica of them
+func (r storageRequest) printBucket() {
+	bucket, err := strconv.Atoi(r.req.URL.Query().Get("bucket"))
+	if err != nil {
+		writeError(r.res, http.StatusBadRequest, ErrorInvalidRequest, "Invalid bucket")
+		return
+	}
+
+	ids, versions, status := storage.BucketIDs(bucket)
+	if status != http.StatusOK {
+		writeError(r.res, status, errorCodeForStatus(status), "Error listing bucket "+strconv.Itoa(bucket))
+		return
+	}
+
+	sizes := make(map[string]int64, len(ids))
+	for _, id := range ids {
+		if size, status := storage.Size(id); status == http.StatusOK {
+			sizes[id] = size
+		}
+	}
+
+	response, err := json.Marshal(messageList{IDs: ids, Versions: versions, Sizes: sizes})
+	if err != nil {
+		r.log.Error(GenericInternalError, "Failed to export bucket: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error listing bucket "+strconv.Itoa(bucket))
+		return
+	}
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+type messageList struct {
+	IDs  []string `json:"ids"`
+	Next int      `json:"next,omitempty"`
+	//Versions are listed for buckets, by ID of the messages which have one
+	Versions map[string]int64 `json:"versions,omitempty"`
+	//Sizes are listed for buckets, by ID of the messages which are not expired
+	Sizes map[string]int64 `json:"sizes,omitempty"`
+}
+
+func (r storageRequest) printMessageList() {
+	offset, err := strconv.Atoi(r.req.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	limit, err := strconv.Atoi(r.req.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	r.log.Info(InProgress, "Exporting Message List (offset "+strconv.Itoa(offset)+", limit "+strconv.Itoa(limit)+")...")
+	ids, next, status := storage.List(offset, limit)
+	if status != http.StatusOK {
+		r.log.Error(GenericInternalError, "Failed to export Message List.")
+		writeError(r.res, status, errorCodeForStatus(status), "Failed to export Message List.")
+		return
+	}
+	//Pages exceeding settings.ResponseMaxSize are shortened, the next page starts after the last ID listed
+	ids, next, fits := fitList(ids, offset, next)
+	if !fits {
+		writeResponseTooLarge(r.res)
+		return
+	}
+
+	response, err := json.Marshal(messageList{IDs: ids, Next: next})
+	if err != nil {
+		r.log.Error(GenericInternalError, "Failed to export Message List: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Failed to export Message List.")
+		return
+	}
+	r.log.Info(OK, "Exported Message List.")
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+func (r storageRequest) printStorageStats() {
+	r.log.Info(InProgress, "Exporting Storage Stats...")
+	stats, status := storage.GetStats()
+	if status != http.StatusOK {
+		writeError(r.res, status, errorCodeForStatus(status), "Failed to export Storage Stats.")
+		return
+	}
+
+	response, err := json.Marshal(stats)
+	if err != nil {
+		r.log.Error(GenericInternalError, "Failed to export Storage Stats: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Failed to export Storage Stats.")
+		return
+	}
+	r.log.Info(OK, "Exported Storage Stats.")
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+type storageUsage struct {
+	Used  int64 `json:"used"`
+	Total int64 `json:"total"`
+}
+
+func (r storageRequest) printStorageUsage() {
+	used, total := storage.Usage()
+	response, err := json.Marshal(storageUsage{Used: used, Total: total})
 	if err != nil {
-		slog.Error("Failed to export StorageNodes: " + err.Error())
-		writeResponse(r.res, http.StatusInternalServerError, "Failed to export StorageNodes.")
+		r.log.Error(GenericInternalError, "Failed to export Storage Usage: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Failed to export Storage Usage.")
 		return
 	}
-	slog.Info("Exported StorageNodes.")
-	writeResponse(r.res, http.StatusOK, string(response))
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+func (r storageRequest) printQueueStats() {
+	response, err := json.Marshal(jobqueue.GetStats())
+	if err != nil {
+		r.log.Error(GenericInternalError, "Failed to export Queue Stats: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Failed to export Queue Stats.")
+		return
+	}
+	writeJSON(r.res, http.StatusOK, string(response))
+}
+
+func (r storageRequest) printStorageNodes() {
+	r.log.Info(InProgress, "Exporting 10 StorageNodes...")
+	status, storageNodes := database.GetStorageNodes(10)
+	if status != OK {
+		r.log.Error(status, "Failed to read StorageNodes from database.")
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Failed to export StorageNodes.")
+		return
+	}
+	r.writeExport("StorageNodes", storageNodes)
 }
 
 func (r storageRequest) printCoordinatorNodes() {
-	slog.Info("Exporting CoordinatorNodes...")
-	coordinatorNodes := database.GetCoordinatorNodes()
-	response, err := json.Marshal(coordinatorNodes)
+	r.log.Info(InProgress, "Exporting CoordinatorNodes...")
+	status, coordinatorNodes := database.GetCoordinatorNodes()
+	if status != OK {
+		r.log.Error(status, "Failed to read CoordinatorNodes from database.")
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Failed to export CoordinatorNodes.")
+		return
+	}
+	r.writeExport("CoordinatorNodes", coordinatorNodes)
+}
+
+//writeExport writes value as JSON response, or only a 500 response if value cannot be encoded
+func (r storageRequest) writeExport(what string, value interface{}) {
+	response, err := json.Marshal(value)
 	if err != nil {
-		slog.Error("Failed to export CoordinatorNodes: " + err.Error())
-		writeResponse(r.res, http.StatusInternalServerError, "Failed to export CoordinatorNodes.")
+		r.log.Error(GenericInternalError, "Failed to export "+what+": "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Failed to export "+what+".")
 		return
 	}
-	slog.Info("Exported CoordinatorNodes.")
-	writeResponse(r.res, http.StatusOK, string(response))
+	r.log.Info(OK, "Exported "+what+".")
+	writeJSON(r.res, http.StatusOK, string(response))
 }
 
 func (r storageRequest) updateMessageStatus() {
-	slog.Info("Received UPDATE for Message " + r.slug)
+	r.log.Info(InProgress, "Received UPDATE for Message "+r.slug)
 	messageID := r.slug
 
-	job := jobqueue.Job{
-		Task: func(data interface{}) {
-			messageID, ok := data.(string)
-			if ok {
-				log := logger.Logger{Prefix: "networking/Update-" + messageID}
-				status := GetMessageStatus(messageID)
-				if status > -1 {
-					log.Info("Updating Message Status to " + strconv.Itoa(status))
-					database.UpdateMessageStatusStorage(messageID, status)
-					return
-				}
-				log.Error("Received inconclusive Message Status. Not updating local database.")
-			} else {
-				slog.Error("Error Starting Update-Thread")
-			}
-		},
-		Data: messageID,
+	if jobqueue.Enqueue(jobqueue.NewJobContext(r.req.Context(), updateStatusJob, messageID)) != OK {
+		writeError(r.res, http.StatusServiceUnavailable, ErrorInternal, "Too many pending jobs")
+		return
 	}
 
-	select {
-	case jobqueue.Queue <- job:
+	writeResponse(r.res, http.StatusOK, "OK")
+}
+
+//Machine-readable error codes returned in the error response envelope
+const (
+	ErrorInvalidRequest      = "INVALID_REQUEST"
+	ErrorInvalidMethod       = "INVALID_METHOD"
+	ErrorNotFound            = "NOT_FOUND"
+	ErrorConflict            = "CONFLICT"
+	ErrorPreconditionFailed  = "PRECONDITION_FAILED"
+	ErrorMessageTooLarge     = "MESSAGE_TOO_LARGE"
+	ErrorResponseTooLarge    = "RESPONSE_TOO_LARGE"
+	ErrorEmptyMessage        = "EMPTY_MESSAGE"
+	ErrorTransmissionFailed  = "TRANSMISSION_FAILED"
+	ErrorInsufficientStorage = "INSUFFICIENT_STORAGE"
+	ErrorRateLimited         = "RATE_LIMITED"
+	ErrorUnauthorized        = "UNAUTHORIZED"
+	ErrorForbidden           = "FORBIDDEN"
+	ErrorChecksumMismatch    = "CHECKSUM_MISMATCH"
+	ErrorExpired             = "EXPIRED"
+	ErrorRangeNotSatisfiable = "RANGE_NOT_SATISFIABLE"
+	ErrorQuorumNotReached    = "QUORUM_NOT_REACHED"
+	ErrorDraining            = "DRAINING"
+	ErrorReadOnly            = "READ_ONLY"
+	ErrorOverloaded          = "OVERLOADED"
+	ErrorCanceled            = "CANCELED"
+	ErrorStorageUnavailable  = "STORAGE_UNAVAILABLE"
+	ErrorTimeout             = "TIMEOUT"
+	ErrorUnsupportedType     = "UNSUPPORTED_CONTENT_TYPE"
+	ErrorInternal            = "INTERNAL_ERROR"
+)
+
+type errorResponse struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+//errorCodeForStatus maps HTTP status codes returned by the storage layer to error codes
+func errorCodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrorInvalidRequest
+	case http.StatusNotFound:
+		return ErrorNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrorInvalidMethod
+	case http.StatusConflict:
+		return ErrorConflict
+	case http.StatusPreconditionFailed:
+		return ErrorPreconditionFailed
+	case http.StatusRequestEntityTooLarge:
+		return ErrorMessageTooLarge
+	case http.StatusInsufficientStorage:
+		return ErrorInsufficientStorage
+	case StorageChecksumMismatch:
+		return ErrorChecksumMismatch
+	case StorageMessageExpired:
+		return ErrorExpired
+	case http.StatusRequestedRangeNotSatisfiable:
+		return ErrorRangeNotSatisfiable
+	case StorageRequestCanceled:
+		return ErrorCanceled
+	case StorageUnavailable:
+		return ErrorStorageUnavailable
+	case StorageTimeout:
+		return ErrorTimeout
+	case http.StatusUnsupportedMediaType:
+		return ErrorUnsupportedType
 	}
+	return ErrorInternal
+}
 
-	writeResponse(r.res, http.StatusOK, "OK")
+//httpStatus maps internal status codes to HTTP status codes, passing through HTTP status codes
+func httpStatus(status int) int {
+	if status == StorageMessageExpired {
+		return http.StatusNotFound
+	}
+	//Usually nobody receives the response of a canceled request, but requests exceeding a deadline are answered
+	if status == StorageRequestCanceled || status == StorageUnavailable {
+		return http.StatusServiceUnavailable
+	}
+	if status == StorageTimeout {
+		return http.StatusGatewayTimeout
+	}
+	if status < 100 || status > 599 {
+		return http.StatusInternalServerError
+	}
+	return status
 }
 
+//writeError writes an error response envelope like {"error":{"code":"NOT_FOUND","message":"..."}}
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	response, err := json.Marshal(errorResponse{Error: errorDetail{Code: code, Message: message}})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, `{"error":{"code":"`+ErrorInternal+`","message":"Failed to encode error"}}`)
+		return
+	}
+	writeJSON(w, httpStatus(status), string(response))
+}
+
+//writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, response string) {
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, status, response)
+}
+
+//writeResponse writes a response, as plain text unless a Content-Type was set before.
+//Headers have to be set before the status is written
 func writeResponse(w http.ResponseWriter, status int, response string) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
 	w.WriteHeader(status)
-	fmt.Fprintf(w, response)
+	io.WriteString(w, response)
 }
@@ -0,0 +1,449 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"subframe/server/settings"
+	"subframe/server/storage"
+	. "subframe/status"
+	"subframe/structs/message"
+	"testing"
+	"time"
+)
+
+func TestMessageParties(t *testing.T) {
+	created := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		target        string
+		headers       map[string]string
+		identity      string
+		privileged    bool
+		wantSender    string
+		wantRecipient string
+		wantCreatedAt *time.Time
+		wantStatus    int
+	}{
+		{"identity is the sender", "/storage/put/abc", map[string]string{"X-Recipient": "bob"}, "alice", false, "alice", "bob", nil, http.StatusOK},
+		{"X-Sender matching the identity", "/storage/put/abc", map[string]string{"X-Sender": "alice"}, "alice", false, "alice", "", nil, http.StatusOK},
+		{"X-Sender naming another identity", "/storage/put/abc", map[string]string{"X-Sender": "mallory"}, "alice", false, "", "", nil, http.StatusForbidden},
+		{"X-Sender without identity", "/storage/put/abc", map[string]string{"X-Sender": "alice"}, "", false, "", "", nil, http.StatusForbidden},
+		{"no sender without identity", "/storage/put/abc", nil, "", false, "", "", nil, http.StatusOK},
+		{"unprintable recipient", "/storage/put/abc", map[string]string{"X-Recipient": "bo\x01b"}, "alice", false, "", "", nil, http.StatusBadRequest},
+		{"sender parameter of a client", "/storage/put/abc?sender=mallory&createdAt=2026-10-14T12:00:00Z", nil, "", false, "", "", nil, http.StatusOK},
+		{"copy of another Node", "/storage/put/abc?sender=alice&recipient=bob&createdAt=2026-10-14T12:00:00Z", nil, "", true, "alice", "bob", &created, http.StatusOK},
+		{"copy with invalid createdAt", "/storage/put/abc?createdAt=yesterday", nil, "", true, "", "", nil, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, test.target, nil)
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+			r := storageRequest{req: req, identity: test.identity, privileged: test.privileged}
+
+			sender, recipient, createdAt, status := r.messageParties()
+			if status != test.wantStatus {
+				t.Fatalf("status = %d, want %d", status, test.wantStatus)
+			}
+			if sender != test.wantSender || recipient != test.wantRecipient {
+				t.Errorf("sender, recipient = %q, %q, want %q, %q", sender, recipient, test.wantSender, test.wantRecipient)
+			}
+			if (createdAt == nil) != (test.wantCreatedAt == nil) || createdAt != nil && !createdAt.Equal(*test.wantCreatedAt) {
+				t.Errorf("createdAt = %v, want %v", createdAt, test.wantCreatedAt)
+			}
+		})
+	}
+}
+
+func TestReplicaPutQueryKeepsParties(t *testing.T) {
+	created := time.Date(2026, 10, 14, 12, 0, 0, 123, time.UTC)
+	msg := message.Message{ID: "abc", Sender: "alice", Recipient: "bob", CreatedAt: &created}
+
+	req := httptest.NewRequest(http.MethodPost, "/storage"+replicaPutQuery(msg), nil)
+	r := storageRequest{req: req, privileged: true}
+	sender, recipient, createdAt, status := r.messageParties()
+	if status != http.StatusOK || sender != "alice" || recipient != "bob" || createdAt == nil || !createdAt.Equal(created) {
+		t.Errorf("messageParties() = %q, %q, %v, %d, want the parties of the pushed copy", sender, recipient, createdAt, status)
+	}
+}
+
+func TestMessageExpiry(t *testing.T) {
+	expires := time.Date(2099, 10, 14, 12, 0, 0, 123, time.UTC)
+
+	tests := []struct {
+		name       string
+		target     string
+		headers    map[string]string
+		privileged bool
+		want       *time.Time
+		wantValid  bool
+	}{
+		{"no expiry", "/storage/put/abc", nil, false, nil, true},
+		{"expiresAt parameter of a client", "/storage/put/abc?expiresAt=2099-10-14T12:00:00.000000123Z", nil, false, nil, true},
+		{"copy of another Node", "/storage/put/abc?expiresAt=2099-10-14T12:00:00.000000123Z", nil, true, &expires, true},
+		{"copy keeps the expiry over headers", "/storage/put/abc?expiresAt=2099-10-14T12:00:00.000000123Z", map[string]string{"X-Expires-In": "60"}, true, &expires, true},
+		{"copy with invalid expiresAt", "/storage/put/abc?expiresAt=tomorrow", nil, true, nil, false},
+		{"invalid X-Expires-In", "/storage/put/abc", map[string]string{"X-Expires-In": "soon"}, false, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, test.target, nil)
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+			r := storageRequest{req: req, privileged: test.privileged}
+
+			expiresAt, valid := r.messageExpiry()
+			if valid != test.wantValid {
+				t.Fatalf("valid = %v, want %v", valid, test.wantValid)
+			}
+			if (expiresAt == nil) != (test.want == nil) || expiresAt != nil && !expiresAt.Equal(*test.want) {
+				t.Errorf("expiresAt = %v, want %v", expiresAt, test.want)
+			}
+		})
+	}
+}
+
+func TestCopiesKeepExpiry(t *testing.T) {
+	setupNode(t)
+	withClusterSecret(t, "secret")
+	expires := time.Now().Add(time.Hour).UTC()
+	msg := message.Message{ID: "expiring", Content: "content", ExpiresAt: &expires}
+
+	//Copies pushed by redistribution, read repair and draining expire with the original
+	req := newSignedRequest(t, http.MethodPost, "/storage"+replicaPutQuery(msg), []byte(msg.Content))
+	recorder := httptest.NewRecorder()
+	handleRequest(recorder, req)
+	expectStatus(t, recorder, http.StatusOK)
+	stat, status := storage.Stat(msg.ID)
+	if status != http.StatusOK || stat.ExpiresAt == nil || !stat.ExpiresAt.Equal(expires) {
+		t.Errorf("expiry of the copy = %v (%d), want %v", stat.ExpiresAt, status, expires)
+	}
+
+	//Unsigned puts cannot pass an expiry as copy
+	expectStatus(t, serve(http.MethodPost, "/storage/put/forged?expiresAt=2000-01-01T00:00:00Z", strings.NewReader("content"), nil), http.StatusOK)
+	if stat, _ := storage.Stat("forged"); stat.ExpiresAt != nil {
+		t.Errorf("expiry of the unsigned put = %v, want none", stat.ExpiresAt)
+	}
+}
+
+func TestGetReturnsContentVerbatim(t *testing.T) {
+	setupNode(t)
+
+	//Content and IDs used to be passed as format string
+	content := "100%s of %d bytes, %v%%"
+	expectStatus(t, serve(http.MethodPost, "/storage/put/verbatim", strings.NewReader(content), nil), http.StatusOK)
+
+	recorder := serve(http.MethodGet, "/storage/get/verbatim", nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	var msg message.Message
+	if err := json.Unmarshal(recorder.Body.Bytes(), &msg); err != nil {
+		t.Fatalf("invalid JSON wrapper %q: %v", recorder.Body.String(), err)
+	}
+	if msg.Content != content {
+		t.Errorf("content = %q, want %q", msg.Content, content)
+	}
+
+	raw := serve(http.MethodGet, "/storage/get/verbatim", nil, map[string]string{"Accept": "application/octet-stream"})
+	expectStatus(t, raw, http.StatusOK)
+	if !bytes.Equal(raw.Body.Bytes(), []byte(content)) {
+		t.Errorf("raw content = %q, want %q", raw.Body.String(), content)
+	}
+}
+
+func TestWriteResponseIsNoFormatString(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writeResponse(recorder, http.StatusNotFound, "Message %s%d%v not found")
+	if got := recorder.Body.String(); got != "Message %s%d%v not found" {
+		t.Errorf("body = %q, want it unformatted", got)
+	}
+}
+
+func TestChecksumMismatchResponse(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writeError(recorder, StorageChecksumMismatch, errorCodeForStatus(StorageChecksumMismatch), "Error getting message with ID abc")
+	if recorder.Code != http.StatusInternalServerError || !strings.Contains(recorder.Body.String(), `"code":"CHECKSUM_MISMATCH"`) {
+		t.Errorf("response = %d %s, want 500 with CHECKSUM_MISMATCH", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestWriteExportOfUnmarshalableValue(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	r := storageRequest{res: &statusRecorder{ResponseWriter: recorder, status: http.StatusOK}, req: httptest.NewRequest(http.MethodGet, "/storage/storagenodes", nil)}
+	r.writeExport("StorageNodes", make(chan int))
+
+	expectStatus(t, recorder, http.StatusInternalServerError)
+	var response errorResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.Error.Code != ErrorInternal {
+		t.Errorf("body = %q, want only the error response", recorder.Body.String())
+	}
+}
+
+func TestStatusRecorderDiscardsSecondResponse(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	res := &statusRecorder{ResponseWriter: recorder, status: http.StatusOK}
+	writeError(res, http.StatusInternalServerError, ErrorInternal, "Failed")
+	writeJSON(res, http.StatusOK, `{"late":true}`)
+
+	if res.status != http.StatusInternalServerError || recorder.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, recorded %d, want %d", recorder.Code, res.status, http.StatusInternalServerError)
+	}
+	var response errorResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Errorf("body = %q, want only the error response", recorder.Body.String())
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantAction string
+		wantSlug   string
+	}{
+		{"/storage", http.StatusBadRequest, "", ""},
+		{"/storage/", http.StatusBadRequest, "", ""},
+		{"/storage/get", http.StatusOK, "get", ""},
+		{"/storage/get/", http.StatusOK, "get", ""},
+		{"/storage/get/id", http.StatusOK, "get", "id"},
+		{"/storage/get/id/extra", http.StatusBadRequest, "", ""},
+		{"/storage//id", http.StatusBadRequest, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			r := storageRequest{req: httptest.NewRequest(http.MethodGet, test.path, nil)}
+			status := r.parsePath()
+			if status != test.wantStatus {
+				t.Fatalf("parsePath() = %d, want %d", status, test.wantStatus)
+			}
+			if status == http.StatusOK && (r.action != test.wantAction || r.slug != test.wantSlug) {
+				t.Errorf("action, slug = %q, %q, want %q, %q", r.action, r.slug, test.wantAction, test.wantSlug)
+			}
+		})
+	}
+}
+
+func TestMalformedPathsAreRejected(t *testing.T) {
+	setupNode(t)
+
+	//Paths parsed without a message ID are still rejected by get
+	for _, path := range []string{"/storage", "/storage/", "/storage/get", "/storage/get/", "/storage/get/id/extra"} {
+		if recorder := serve(http.MethodGet, path, nil, nil); recorder.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want %d", path, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestIDsAreRejectedInsteadOfRewritten(t *testing.T) {
+	setupNode(t)
+
+	expectStatus(t, serve(http.MethodPost, "/storage/put/msg-a", strings.NewReader("dash"), nil), http.StatusOK)
+	expectStatus(t, serve(http.MethodPost, "/storage/put/msg_a", strings.NewReader("underscore"), nil), http.StatusOK)
+	//IDs with characters that used to be replaced by dashes would overwrite msg-a
+	for _, id := range []string{"msg.a", "msg%20a", "msg+a", "msg%2Fa"} {
+		if recorder := serve(http.MethodPost, "/storage/put/"+id, strings.NewReader("overwritten"), nil); recorder.Code != http.StatusBadRequest {
+			t.Errorf("put of %s = %d, want %d", id, recorder.Code, http.StatusBadRequest)
+		}
+	}
+
+	for id, content := range map[string]string{"msg-a": "dash", "msg_a": "underscore"} {
+		raw := serve(http.MethodGet, "/storage/get/"+id, nil, map[string]string{"Accept": "application/octet-stream"})
+		expectStatus(t, raw, http.StatusOK)
+		if raw.Body.String() != content {
+			t.Errorf("content of %s = %q, want %q", id, raw.Body.String(), content)
+		}
+	}
+}
+
+func BenchmarkParsePath(b *testing.B) {
+	req := httptest.NewRequest(http.MethodGet, "/storage/get/"+strings.Repeat("a1_-", 16), nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r := storageRequest{req: req}
+		if r.parsePath() != http.StatusOK {
+			b.Fatal("path rejected")
+		}
+	}
+}
+
+//unreadBody fails the test if the body of a request is read
+type unreadBody struct {
+	t *testing.T
+}
+
+func (b unreadBody) Read(p []byte) (int, error) {
+	b.t.Error("body of a request exceeding the declared size was read")
+	return 0, io.EOF
+}
+
+//truncatedBody ends like the body of a client closing the connection before sending the declared Content-Length
+type truncatedBody struct {
+	content io.Reader
+}
+
+func (b truncatedBody) Read(p []byte) (int, error) {
+	n, err := b.content.Read(p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+//withMessageMaxSize sets settings.MessageMaxSize in MB for the duration of the test
+func withMessageMaxSize(t *testing.T, size int) {
+	previous := settings.Current().MessageMaxSize
+	settings.Current().MessageMaxSize = size
+	t.Cleanup(func() { settings.Current().MessageMaxSize = previous })
+}
+
+func TestPutContentLength(t *testing.T) {
+	setupNode(t)
+	withMessageMaxSize(t, 1)
+	limit := 1024 * 1024
+
+	tests := []struct {
+		name          string
+		body          io.Reader
+		contentLength int64
+		wantStatus    int
+	}{
+		{"declared size too large", unreadBody{t}, int64(limit) + 1, http.StatusRequestEntityTooLarge},
+		{"declared size within the limit, more sent", strings.NewReader(strings.Repeat("a", limit+1)), 16, http.StatusRequestEntityTooLarge},
+		{"unknown size, more sent", strings.NewReader(strings.Repeat("a", limit+1)), -1, http.StatusRequestEntityTooLarge},
+		{"declared size larger than sent", truncatedBody{strings.NewReader("short")}, 1024, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/storage/put/lying", test.body)
+			req.ContentLength = test.contentLength
+			recorder := httptest.NewRecorder()
+			handleRequest(recorder, req)
+
+			expectStatus(t, recorder, test.wantStatus)
+			if _, status := storage.Get("lying"); status != http.StatusNotFound {
+				t.Errorf("get after rejected put = %d, want %d", status, http.StatusNotFound)
+			}
+		})
+	}
+}
+
+func TestResponseContentTypes(t *testing.T) {
+	setupNode(t)
+	expectStatus(t, serve(http.MethodPost, "/storage/put/text", strings.NewReader("plain text"), nil), http.StatusOK)
+	expectStatus(t, serve(http.MethodPost, "/storage/put/binary", bytes.NewReader([]byte{0, 1, 2, 3}), nil), http.StatusOK)
+
+	raw := map[string]string{"Accept": "application/octet-stream"}
+	tests := []struct {
+		name            string
+		method          string
+		target          string
+		headers         map[string]string
+		wantStatus      int
+		wantContentType string
+	}{
+		{"put", http.MethodPost, "/storage/put/other", nil, http.StatusOK, "text/plain; charset=utf-8"},
+		{"get", http.MethodGet, "/storage/get/text", nil, http.StatusOK, "application/json"},
+		{"raw get of text", http.MethodGet, "/storage/get/text", raw, http.StatusOK, "text/plain; charset=utf-8"},
+		{"raw get of binary content", http.MethodGet, "/storage/get/binary", raw, http.StatusOK, "application/octet-stream"},
+		{"get of a missing message", http.MethodGet, "/storage/get/missing", nil, http.StatusNotFound, "application/json"},
+		{"raw get of a missing message", http.MethodGet, "/storage/get/missing", raw, http.StatusNotFound, "application/json"},
+		{"delete", http.MethodDelete, "/storage/delete/text", nil, http.StatusOK, "text/plain; charset=utf-8"},
+		{"StorageNodes", http.MethodGet, "/storage/control/get-storage-nodes", nil, http.StatusOK, "application/json"},
+		{"version", http.MethodGet, "/storage/control/version", nil, http.StatusOK, "application/json"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := strings.NewReader("content")
+			recorder := serve(test.method, test.target, body, test.headers)
+			expectStatus(t, recorder, test.wantStatus)
+			if contentType := recorder.Header().Get("Content-Type"); contentType != test.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", contentType, test.wantContentType)
+			}
+		})
+	}
+}
+
+func TestValidMessageID(t *testing.T) {
+	current := settings.Current()
+	previousMin, previousMax := current.MessageIDMinLength, current.MessageIDMaxLength
+	current.MessageIDMinLength, current.MessageIDMaxLength = 3, 16
+	t.Cleanup(func() { current.MessageIDMinLength, current.MessageIDMaxLength = previousMin, previousMax })
+
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"", false},
+		{"ab", false},
+		{"abc", true},
+		{strings.Repeat("a", 16), true},
+		{strings.Repeat("a", 17), false},
+		{"Msg_0-9", true},
+		{"../etc", false},
+		{"msg/abc", false},
+		{"msg\\abc", false},
+		{"msg.abc", false},
+		{".abc", false},
+		{"msg abc", false},
+		{"msg\x00abc", false},
+		{"mség", false},
+		{"NUL", false},
+		{"CON", false},
+		{"com1", false},
+		{"Lpt9", false},
+		{"console", true},
+	}
+	for _, test := range tests {
+		if got := validMessageID(test.id); got != test.want {
+			t.Errorf("validMessageID(%q) = %v, want %v", test.id, got, test.want)
+		}
+	}
+}
+
+func TestUnknownControlAction(t *testing.T) {
+	setupNode(t)
+
+	recorder := serve(http.MethodGet, "/storage/control/bogus", nil, nil)
+	expectStatus(t, recorder, http.StatusBadRequest)
+	var response errorResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid error response %q: %v", recorder.Body.String(), err)
+	}
+	if response.Error.Code != ErrorInvalidRequest || !strings.Contains(response.Error.Message, "bogus") {
+		t.Errorf("error = %+v, want %s naming the action", response.Error, ErrorInvalidRequest)
+	}
+	for _, action := range storageControlActions {
+		if !strings.Contains(response.Error.Message, action) {
+			t.Errorf("error message %q does not list %s", response.Error.Message, action)
+		}
+	}
+}
+
+func TestBucketListsSizes(t *testing.T) {
+	setupNode(t)
+	expectStatus(t, serve(http.MethodPost, "/storage/put/abc", strings.NewReader("hello"), nil), http.StatusOK)
+
+	for bucket := 0; bucket < storage.DigestBuckets; bucket++ {
+		recorder := serve(http.MethodGet, "/storage/control/bucket?bucket="+strconv.Itoa(bucket), nil, nil)
+		expectStatus(t, recorder, http.StatusOK)
+		var list messageList
+		if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+			t.Fatalf("invalid bucket %q: %v", recorder.Body.String(), err)
+		}
+		if len(list.IDs) == 0 {
+			continue
+		}
+		if size, ok := list.Sizes["abc"]; !ok || size != 5 {
+			t.Errorf("sizes = %v, want 5 bytes for abc", list.Sizes)
+		}
+		return
+	}
+	t.Fatal("abc is not listed in any bucket")
+}
@@ -0,0 +1,42 @@
+package networking
+
+import (
+	"context"
+	"subframe/server/storage"
+	. "subframe/status"
+	"time"
+)
+
+//withStorageTimeout runs the storage operation with a deadline of seconds after the start. Once the deadline passes,
+//StorageTimeout is returned without waiting for the operation, whose context is canceled, so it gives up at its next
+//checkpoint instead of tying up the request. A put is abandoned with it, see storage.AbandonablePut, unless it already
+//commits its content. Then the put is awaited and its status returned, so a put answered with StorageTimeout is never
+//stored. Without a timeout, or if parent is done first, the status of the operation is returned as usual
+func withStorageTimeout(parent context.Context, name string, seconds int, operation func(ctx context.Context) int) int {
+	if seconds <= 0 {
+		return operation(parent)
+	}
+	ctx, cancel := context.WithTimeout(parent, time.Duration(seconds)*time.Second)
+	defer cancel()
+	ctx, abandon := storage.AbandonablePut(ctx)
+	done := make(chan int, 1)
+	go func() {
+		done <- operation(ctx)
+	}()
+
+	select {
+	case status := <-done:
+		if status != StorageRequestCanceled || parent.Err() != nil || ctx.Err() != context.DeadlineExceeded {
+			return status
+		}
+	case <-ctx.Done():
+		if !abandon() {
+			return <-done
+		}
+		if parent.Err() != nil {
+			return StorageRequestCanceled
+		}
+	}
+	storageTimeouts.Inc(name)
+	return StorageTimeout
+}
@@ -0,0 +1,53 @@
+package networking
+
+import (
+	"crypto/tls"
+	"strings"
+	"subframe/server/settings"
+	. "subframe/status"
+	"subframe/structs/node"
+)
+
+//loadTLSConfig loads the configured certificate, or returns nil if plaintext is explicitly allowed.
+//Any misconfiguration is fatal, so the Node never silently falls back to plaintext
+func loadTLSConfig() *tls.Config {
+	if settings.Current().TLSCertFile == "" && settings.Current().TLSKeyFile == "" {
+		if !settings.Current().AllowPlaintext {
+			mlog.Fatal(NetworkingTLSConfigError, "No TLS certificate configured. Set tls-cert and tls-key, or explicitly set allow-plaintext.")
+		}
+		return nil
+	}
+
+	if settings.Current().TLSCertFile == "" || settings.Current().TLSKeyFile == "" {
+		mlog.Fatal(NetworkingTLSConfigError, "Both tls-cert and tls-key have to be set to enable TLS.")
+	}
+
+	mlog.Info(InProgress, "Loading TLS certificate "+settings.Current().TLSCertFile+"...")
+	certificate, err := tls.LoadX509KeyPair(settings.Current().TLSCertFile, settings.Current().TLSKeyFile)
+	if err != nil {
+		mlog.Fatal(NetworkingTLSConfigError, "Failed to load TLS certificate: "+err.Error())
+	}
+	mlog.Info(OK, "Loaded TLS certificate.")
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+//nodeURL prefixes address with a scheme, unless it already contains one, and brackets IPv6 addresses.
+//Peers are assumed to use TLS if this Node does
+func nodeURL(address string) string {
+	//Malformed addresses are passed on, for the request to fail with a descriptive error
+	if normalized, err := node.NormalizeAddress(address); err == nil {
+		//Zones of IPv6 addresses have to be escaped in URLs
+		address = strings.Replace(normalized, "%", "%25", 1)
+	}
+	if strings.Contains(address, "://") {
+		return address
+	}
+	if settings.Current().TLSCertFile == "" {
+		return "http://" + address
+	}
+	return "https://" + address
+}
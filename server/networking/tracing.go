@@ -0,0 +1,31 @@
+package networking
+
+import (
+	"net/http"
+	"strconv"
+	"subframe/server/logger"
+	"subframe/server/tracing"
+)
+
+//startRequestSpan starts the Span of handling req, continuing the trace of the Node which sent it.
+//Returns req with the Span in its context, so Spans of the work done for it become its children
+func startRequestSpan(req *http.Request) (*http.Request, *tracing.Span) {
+	ctx, span := tracing.Start(tracing.Extract(req.Context(), req.Header), req.Method+" "+req.URL.Path, tracing.KindServer)
+	return req.WithContext(ctx), span
+}
+
+//endRequestSpan names the Span of a handled request after its action, and finishes it with the response status
+func endRequestSpan(span *tracing.Span, req *http.Request, name string, messageID string, recorder *statusRecorder) {
+	span.SetName(name)
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.target", req.URL.Path)
+	span.SetAttribute("http.status_code", strconv.Itoa(recorder.status))
+	span.SetAttribute("subframe.request_id", logger.RequestID(req.Context()))
+	if messageID != "" {
+		span.SetAttribute("subframe.message_id", messageID)
+	}
+	if recorder.status >= 500 {
+		span.SetError(http.StatusText(recorder.status))
+	}
+	span.End()
+}
@@ -0,0 +1,231 @@
+package networking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"subframe/server/database"
+	"subframe/server/jobqueue"
+	"subframe/server/logger"
+	"subframe/server/storage"
+	. "subframe/status"
+	"sync"
+	"time"
+)
+
+//verifyJob is the name of the Task scanning all local messages. It is persisted, so it must not change
+const verifyJob = "verify"
+
+//verifyPageSize is the number of local message IDs listed at once while verifying
+const verifyPageSize = 100
+
+//maxReportedCorrupt bounds the IDs of corrupt messages listed in the verification progress
+const maxReportedCorrupt = 100
+
+var vlog = logger.Logger{Prefix: "networking/Verify"}
+
+func init() {
+	jobqueue.Register(verifyJob, verifyMessages, decodeVerifyOptions, nil)
+}
+
+//verifyOptions is the data of a verifyJob
+type verifyOptions struct {
+	Quarantine bool
+}
+
+func decodeVerifyOptions(raw []byte) (interface{}, error) {
+	options := &verifyOptions{}
+	err := json.Unmarshal(raw, options)
+	return options, err
+}
+
+//verifyProgress is the response of /control/verify and /control/verify-status. Counts cover the messages scanned so far
+type verifyProgress struct {
+	Running         bool       `json:"running"`
+	Done            bool       `json:"done"`
+	Quarantine      bool       `json:"quarantine"`
+	StartedAt       *time.Time `json:"startedAt,omitempty"`
+	FinishedAt      *time.Time `json:"finishedAt,omitempty"`
+	Total           int        `json:"total"`
+	Scanned         int        `json:"scanned"`
+	OK              int        `json:"ok"`
+	Corrupt         int        `json:"corrupt"`
+	MissingMetadata int        `json:"missingMetadata"`
+	Quarantined     int        `json:"quarantined"`
+	CorruptIDs      []string   `json:"corruptIds,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+var verifyMutex sync.Mutex
+var verification verifyProgress
+var verifyStop = make(chan bool)
+
+func currentVerifyProgress() verifyProgress {
+	verifyMutex.Lock()
+	defer verifyMutex.Unlock()
+	progress := verification
+	progress.CorruptIDs = append([]string(nil), verification.CorruptIDs...)
+	return progress
+}
+
+//startVerification queues a scan of all local messages, logged with the request ID of ctx.
+//Returns false if a scan is in progress already, or the Job Queue does not accept it
+func startVerification(ctx context.Context, quarantine bool) bool {
+	verifyMutex.Lock()
+	if verification.Running {
+		verifyMutex.Unlock()
+		return false
+	}
+	now := time.Now().UTC()
+	previous := verification
+	verification = verifyProgress{Running: true, Quarantine: quarantine, StartedAt: &now}
+	verifyMutex.Unlock()
+
+	//Enqueueing may wait for space in the Queue, which must not block reading the progress
+	if jobqueue.Enqueue(jobqueue.NewJobContext(ctx, verifyJob, &verifyOptions{Quarantine: quarantine})) != OK {
+		verifyMutex.Lock()
+		verification = previous
+		verifyMutex.Unlock()
+		return false
+	}
+	return true
+}
+
+//stopVerification aborts a scan in progress
+func stopVerification() {
+	close(verifyStop)
+}
+
+//verifyMessages recomputes the checksum of every local message, and quarantines corrupt ones if requested
+func verifyMessages(ctx context.Context, data interface{}) error {
+	options, ok := data.(*verifyOptions)
+	if !ok {
+		vlog.Error(GenericInternalError, "Error starting Verify Thread")
+		return errors.New("verify job without options")
+	}
+	log := vlog.WithContext(ctx)
+	//Jobs recovered from the journal were not started by startVerification
+	verifyMutex.Lock()
+	if !verification.Running {
+		now := time.Now().UTC()
+		verification = verifyProgress{Running: true, Quarantine: options.Quarantine, StartedAt: &now}
+	}
+	verifyMutex.Unlock()
+
+	log.Info(InProgress, "Verifying all Messages...")
+	err := scanMessages(ctx, options.Quarantine)
+
+	verifyMutex.Lock()
+	now := time.Now().UTC()
+	verification.Running = false
+	verification.Done = err == nil
+	verification.FinishedAt = &now
+	if err != nil {
+		verification.Error = err.Error()
+	}
+	progress := verification
+	verifyMutex.Unlock()
+	if err != nil {
+		log.Error(GenericInternalError, "Verification aborted after "+strconv.Itoa(progress.Scanned)+" Messages: "+err.Error())
+		return err
+	}
+	log.Info(OK, "Verified "+strconv.Itoa(progress.Scanned)+" Messages: "+strconv.Itoa(progress.OK)+" ok, "+strconv.Itoa(progress.Corrupt)+" corrupt, "+
+		strconv.Itoa(progress.MissingMetadata)+" without metadata, "+strconv.Itoa(progress.Quarantined)+" quarantined.")
+	return nil
+}
+
+func scanMessages(ctx context.Context, quarantine bool) error {
+	var ids []string
+	for offset := 0; ; {
+		page, next, status := storage.List(offset, verifyPageSize)
+		if status != http.StatusOK {
+			return errors.New("error listing messages: " + strconv.Itoa(status))
+		}
+		ids = append(ids, page...)
+		if next == 0 {
+			break
+		}
+		offset = next
+	}
+
+	verifyMutex.Lock()
+	verification.Total = len(ids)
+	verifyMutex.Unlock()
+
+	for _, id := range ids {
+		select {
+		case <-verifyStop:
+			return errors.New("node is shutting down")
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		status := storage.Verify(id)
+		quarantined := false
+		if status == StorageChecksumMismatch && quarantine {
+			quarantined = quarantineMessage(ctx, id)
+		}
+
+		verifyMutex.Lock()
+		switch status {
+		case http.StatusOK:
+			verification.OK++
+		case StorageChecksumMismatch:
+			verification.Corrupt++
+			if len(verification.CorruptIDs) < maxReportedCorrupt {
+				verification.CorruptIDs = append(verification.CorruptIDs, id)
+			}
+		case StorageMetadataError:
+			verification.MissingMetadata++
+		}
+		//Messages deleted since listing them are skipped, but counted as scanned
+		verification.Scanned++
+		if quarantined {
+			verification.Quarantined++
+		}
+		verifyMutex.Unlock()
+	}
+	return nil
+}
+
+//quarantineMessage moves the corrupt message id to the quarantine and deannounces it, without remembering it as
+//deleted, so an intact copy from another replica can be stored on this Node again
+func quarantineMessage(ctx context.Context, id string) bool {
+	if status := storage.Quarantine(id); status != http.StatusOK {
+		vlog.WithContext(ctx).Error(status, "Error quarantining Message "+id)
+		return false
+	}
+	if database.ForgetMessage(id) != OK {
+		return false
+	}
+	enqueueDeannounce(ctx, id)
+	return true
+}
+
+//handleVerify starts scanning all local messages, with ?quarantine=true quarantining corrupt ones,
+//and responds with the verification progress
+func (r storageRequest) handleVerify() {
+	quarantine, _ := strconv.ParseBool(r.req.URL.Query().Get("quarantine"))
+	status := http.StatusOK
+	if startVerification(r.req.Context(), quarantine) {
+		r.log.Info(InProgress, "Verifying all Messages.")
+		status = http.StatusAccepted
+	} else if !currentVerifyProgress().Running {
+		writeError(r.res, http.StatusServiceUnavailable, ErrorOverloaded, "Job queue does not accept the verification")
+		return
+	}
+	r.writeVerifyProgress(status)
+}
+
+func (r storageRequest) writeVerifyProgress(status int) {
+	response, err := json.Marshal(currentVerifyProgress())
+	if err != nil {
+		r.log.Error(GenericInternalError, "Failed to export verification progress: "+err.Error())
+		writeError(r.res, http.StatusInternalServerError, ErrorInternal, "Error getting verification progress")
+		return
+	}
+	writeJSON(r.res, status, string(response))
+}
@@ -0,0 +1,55 @@
+package networking
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"subframe/server/database"
+	"subframe/server/storage"
+	. "subframe/status"
+	"subframe/structs/message"
+)
+
+//versionHeader returns the version of a message when streaming its raw content
+const versionHeader = "X-Message-Version"
+
+//copyVersion returns the version passed in the version parameter by other Nodes pushing a copy of a message, or 0
+//for messages put by clients, which get a new version. Only requests signed by Nodes pass it, clients cannot pick the
+//version winning over other copies. It returns false if the parameter is invalid
+func (r storageRequest) copyVersion() (version int64, valid bool) {
+	raw := r.req.URL.Query().Get("version")
+	if raw == "" || !r.privileged {
+		return 0, true
+	}
+	version, err := strconv.ParseInt(raw, 10, 64)
+	return version, err == nil && version > 0
+}
+
+//putVersioned stores msg, replacing an older copy if msg is a copy of another Node, see storage.PutReplica.
+//Messages without version are new, and get one from storage.NextVersion
+func putVersioned(ctx context.Context, msg message.Message) (status int) {
+	if msg.Version == 0 {
+		msg.Version = storage.NextVersion()
+		return recordStored(msg, storage.PutContext(ctx, msg))
+	}
+	return recordStored(msg, storage.PutReplica(ctx, msg))
+}
+
+//recordStored logs msg to the database if storing it returned status http.StatusOK, or updates its version if it
+//replaced an older copy. Returns http.StatusOK in both cases, and the error status otherwise
+func recordStored(msg message.Message, status int) int {
+	switch status {
+	case http.StatusOK:
+		if database.LogMessageStorage(msg.ID, msg.Version) != OK {
+			return http.StatusInternalServerError
+		}
+	case StorageMessageReplaced:
+		if database.UpdateMessageVersion(msg.ID, msg.Version) != OK {
+			return http.StatusInternalServerError
+		}
+	default:
+		return status
+	}
+	notFound.invalidate(msg.ID)
+	return http.StatusOK
+}
@@ -0,0 +1,41 @@
+package networking
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"subframe/server/settings"
+	"testing"
+)
+
+func TestCopyParametersRequireSignature(t *testing.T) {
+	//Without auth token, unsigned requests used to be trusted like other Nodes
+	previous := settings.Current().AuthToken
+	settings.Current().AuthToken = ""
+	t.Cleanup(func() { settings.Current().AuthToken = previous })
+
+	target := "/storage/put/abc?version=99&meta-category=forged&contentType=text%2Fhtml"
+	tests := []struct {
+		name        string
+		privileged  bool
+		wantVersion int64
+		wantHeaders int
+		wantCopy    bool
+	}{
+		{"unsigned client put", false, 0, 0, false},
+		{"put signed by a Node", true, 99, 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := storageRequest{req: httptest.NewRequest(http.MethodPost, target, nil), privileged: test.privileged}
+			if version, valid := r.copyVersion(); !valid || version != test.wantVersion {
+				t.Errorf("copyVersion() = %d, %v, want %d, true", version, valid, test.wantVersion)
+			}
+			if headers, valid := r.messageHeaders(); !valid || len(headers) != test.wantHeaders {
+				t.Errorf("messageHeaders() = %v, %v, want %d headers", headers, valid, test.wantHeaders)
+			}
+			if copied := r.isCopy(); copied != test.wantCopy {
+				t.Errorf("isCopy() = %v, want %v", copied, test.wantCopy)
+			}
+		})
+	}
+}
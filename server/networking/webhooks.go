@@ -0,0 +1,173 @@
+package networking
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"subframe/server/jobqueue"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	. "subframe/status"
+	"sync"
+	"time"
+)
+
+//webhookJob is the name of the Task delivering an event to one webhook. It is persisted, so it must not change
+const webhookJob = "webhook"
+
+//Events sent to settings.WebhookURLs
+const (
+	eventStored  = "stored"
+	eventDeleted = "deleted"
+	eventExpired = "expired"
+)
+
+//webhookEventHeader names the event of a webhook request, which is signed like inter-node requests
+const webhookEventHeader = "X-Subframe-Event"
+
+//webhookRetryPolicy retries deliveries for about an hour, so webhooks survive short outages
+var webhookRetryPolicy = &jobqueue.RetryPolicy{MaxAttempts: 8, Backoff: 30 * time.Second}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+var wlog = logger.Logger{Prefix: "networking/Webhooks"}
+
+func init() {
+	jobqueue.Register(webhookJob, deliverWebhook, decodeWebhookDelivery, webhookRetryPolicy)
+}
+
+//webhookEvent is the body of a webhook request
+type webhookEvent struct {
+	Event     string    `json:"event"`
+	ID        string    `json:"id"`
+	Size      int64     `json:"size"`
+	Timestamp time.Time `json:"timestamp"`
+	Node      string    `json:"node"`
+}
+
+//webhookDelivery is an event to be sent to URL. Attempts counts the failed deliveries
+type webhookDelivery struct {
+	URL      string
+	Event    webhookEvent
+	Attempts int
+}
+
+func decodeWebhookDelivery(raw []byte) (interface{}, error) {
+	delivery := &webhookDelivery{}
+	err := json.Unmarshal(raw, delivery)
+	return delivery, err
+}
+
+//emitEvent queues delivering event for the message id of size bytes to every webhook, logged with the request ID of ctx.
+//Every webhook is retried on its own, so a failing webhook does not delay the others
+func emitEvent(ctx context.Context, event string, id string, size int64) {
+	for _, endpoint := range settings.Current().WebhookEndpoints() {
+		delivery := &webhookDelivery{
+			URL:   endpoint,
+			Event: webhookEvent{Event: event, ID: id, Size: size, Timestamp: time.Now().UTC(), Node: settings.Current().RemoteAddress},
+		}
+		jobqueue.Enqueue(jobqueue.NewJobContext(ctx, webhookJob, delivery))
+	}
+}
+
+//deliverWebhook POSTs the event of a webhookDelivery to its URL, signed with settings.WebhookSecret.
+//Deliveries failing on their final attempt are appended to the dead-letter log
+func deliverWebhook(ctx context.Context, data interface{}) error {
+	delivery, ok := data.(*webhookDelivery)
+	if !ok {
+		wlog.Error(GenericInternalError, "Error starting Webhook Thread")
+		return errors.New("webhook job without delivery")
+	}
+	log := wlog.WithContext(ctx)
+
+	err := postWebhook(ctx, delivery)
+	if err == nil {
+		log.Info(OK, "Delivered "+delivery.Event.Event+" event of Message "+delivery.Event.ID+" to "+delivery.URL)
+		return nil
+	}
+	delivery.Attempts++
+	if delivery.Attempts >= webhookRetryPolicy.MaxAttempts {
+		log.Error(SNWebhookFailed, "Giving up delivering "+delivery.Event.Event+" event of Message "+delivery.Event.ID+" to "+delivery.URL+": "+err.Error())
+		recordFailedWebhook(delivery, err)
+	}
+	return err
+}
+
+func postWebhook(ctx context.Context, delivery *webhookDelivery) error {
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, delivery.Event.Event)
+	req.Header.Set(timestampHeader, timestamp)
+	if settings.Current().WebhookSecret != "" {
+		req.Header.Set(signatureHeader, webhookSignature(timestamp, body))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("webhook responded with " + resp.Status)
+	}
+	return nil
+}
+
+//webhookSignature returns the hex-encoded HMAC-SHA256 with settings.WebhookSecret over timestamp and body
+func webhookSignature(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(settings.Current().WebhookSecret))
+	mac.Write([]byte(timestamp + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//failedWebhook is a line of the dead-letter log
+type failedWebhook struct {
+	URL      string       `json:"url"`
+	Event    webhookEvent `json:"event"`
+	Error    string       `json:"error"`
+	FailedAt time.Time    `json:"failedAt"`
+}
+
+var failedWebhooksMutex sync.Mutex
+
+func failedWebhooksPath() string {
+	return settings.Current().DataPath + "/webhooks-failed.log"
+}
+
+//recordFailedWebhook appends a delivery which exhausted its retries to the dead-letter log, so it can be replayed by hand
+func recordFailedWebhook(delivery *webhookDelivery, cause error) {
+	line, err := json.Marshal(failedWebhook{URL: delivery.URL, Event: delivery.Event, Error: cause.Error(), FailedAt: time.Now().UTC()})
+	if err != nil {
+		wlog.Error(GenericInternalError, "Error encoding failed webhook: "+err.Error())
+		return
+	}
+
+	failedWebhooksMutex.Lock()
+	defer failedWebhooksMutex.Unlock()
+	file, err := os.OpenFile(failedWebhooksPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = file.Write(append(line, '\n'))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		wlog.Error(GenericInternalError, "Error writing failed webhook to "+failedWebhooksPath()+": "+err.Error())
+	}
+}
@@ -0,0 +1,80 @@
+package settings
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	. "subframe/status"
+	"sync"
+)
+
+//commandLine holds the command line arguments by flag name, which are reapplied over the settings file on reload
+var commandLine = make(map[string]string)
+
+//restartOnly lists the settings which are only used during startup. Changing them requires a restart,
+//e.g. the listen address cannot change while the HTTP server is running
+var restartOnly = []string{
+	"RemoteAddress", "LocalAddress", "GRPCAddress", "TLSCertFile", "TLSKeyFile", "AllowPlaintext",
+	"StorageBackend", "DatabaseBackend", "StorageShardDepth", "EncryptAtRest", "EncryptionKeyFile", "PlacementHash", "PlacementRebalance",
+	"JobWorkers", "QueueMaxLength", "PersistJobs", "MetricsEnabled",
+	"NodeRequestTimeout", "NodeConnectTimeout", "NodeMaxIdleConnections",
+	"CollectorInterval", "HealthCheckInterval", "AntiEntropyInterval", "MembershipInterval", "CompactionInterval",
+}
+
+var reloadMutex sync.Mutex
+
+//Reload re-reads the settings file and applies the new values without restarting. Command line arguments still
+//take precedence, and settings in restartOnly keep their current value. The new settings are built and validated
+//apart from the current ones, which stay in effect until the new ones replace them at once, see Current.
+//If the new settings are invalid, the current ones are kept and false is returned
+func Reload() bool {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	log.Info(InProgress, "Reloading Settings...")
+	previous := Current()
+	jsonstring, err := ioutil.ReadFile(previous.DataPath + "/settings.json")
+	data := make(map[string]interface{})
+	if err == nil {
+		err = json.Unmarshal(jsonstring, &data)
+	}
+	if err != nil {
+		log.Error(SettingsReadError, "Failed to read settings from file ("+err.Error()+"). Keeping current settings.")
+		return false
+	}
+
+	reloaded := *previous
+	apply(&reloaded, data)
+	flags := flag.NewFlagSet("reload", flag.ContinueOnError)
+	registerFlags(flags, &reloaded)
+	for name, value := range commandLine {
+		flags.Set(name, value)
+	}
+	keepRestartOnly(&reloaded, previous)
+
+	if problems := reloaded.Validate(); len(problems) > 0 {
+		for _, problem := range problems {
+			log.Error(SettingsReadError, problem)
+		}
+		log.Error(SettingsReadError, "Rejected reloaded settings. Keeping current settings.")
+		return false
+	}
+	publish(&reloaded)
+	log.Info(OK, "Successfully reloaded Settings.")
+	return true
+}
+
+//keepRestartOnly resets the settings in restartOnly of reloaded to their value in previous, warning about each one
+//the reload would have changed
+func keepRestartOnly(reloaded *Settings, previous *Settings) {
+	reloadedValue, previousValue := reflect.ValueOf(reloaded).Elem(), reflect.ValueOf(previous).Elem()
+	for _, name := range restartOnly {
+		field, kept := reloadedValue.FieldByName(name), previousValue.FieldByName(name)
+		if field.Interface() != kept.Interface() {
+			log.Warn(SettingsReadError, name+" cannot be changed without a restart. Keeping "+fmt.Sprint(kept.Interface()))
+			field.Set(kept)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package settings
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+//withSettings publishes s for the duration of the test, with DataPath set to a temporary directory
+func withSettings(t *testing.T, s *Settings) {
+	previous := Current()
+	s.DataPath = t.TempDir()
+	current.Store(s)
+	t.Cleanup(func() { current.Store(previous) })
+}
+
+//writeSettingsFile writes s to the settings file in DataPath, as Write does
+func writeSettingsFile(t *testing.T, s *Settings) {
+	jsonstring, err := json.Marshal(values(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(Current().DataPath+"/settings.json", jsonstring, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestartOnlyListsSettings(t *testing.T) {
+	settingsType := reflect.TypeOf(Settings{})
+	for _, name := range restartOnly {
+		if _, ok := settingsType.FieldByName(name); !ok {
+			t.Errorf("restartOnly lists %s, which is not a setting", name)
+		}
+	}
+}
+
+func TestReloadKeepsRestartOnlySettings(t *testing.T) {
+	withSettings(t, defaults())
+	changed := *Current()
+	changed.MessageMaxSize = 7
+	changed.LocalAddress = "0.0.0.0:9999"
+	changed.StorageShardDepth = 1
+	writeSettingsFile(t, &changed)
+
+	if !Reload() {
+		t.Fatal("Reload() = false, want true")
+	}
+	reloaded := Current()
+	if reloaded.MessageMaxSize != 7 {
+		t.Errorf("MessageMaxSize = %d, want the reloaded 7", reloaded.MessageMaxSize)
+	}
+	if reloaded.LocalAddress != defaults().LocalAddress || reloaded.StorageShardDepth != defaults().StorageShardDepth {
+		t.Errorf("LocalAddress, StorageShardDepth = %q, %d, want them unchanged", reloaded.LocalAddress, reloaded.StorageShardDepth)
+	}
+}
+
+func TestReloadRejectsInvalidSettings(t *testing.T) {
+	withSettings(t, defaults())
+	previous := Current()
+	invalid := *previous
+	invalid.MessageMaxSize = 7
+	invalid.ReplicationFactor = 0
+	writeSettingsFile(t, &invalid)
+
+	if Reload() {
+		t.Fatal("Reload() = true, want false")
+	}
+	if Current() != previous || previous.MessageMaxSize != defaults().MessageMaxSize {
+		t.Errorf("the invalid settings were published")
+	}
+}
+
+//TestReloadPublishesAtOnce reloads settings changing AuthToken and AdminToken together while they are read, which
+//have to be read from the same settings
+func TestReloadPublishesAtOnce(t *testing.T) {
+	withSettings(t, defaults())
+	var files [2]Settings
+	for index, token := range []string{"first", "second"} {
+		files[index] = *Current()
+		files[index].AuthToken, files[index].AdminToken = token, token+"-admin"
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			s := Current()
+			if s.AdminToken != "" && s.AdminToken != s.AuthToken+"-admin" {
+				t.Errorf("read AuthToken %q with AdminToken %q", s.AuthToken, s.AdminToken)
+				return
+			}
+		}
+	}()
+	for index := 0; index < 50; index++ {
+		writeSettingsFile(t, &files[index%2])
+		if !Reload() {
+			t.Fatal("Reload() = false, want true")
+		}
+	}
+	close(done)
+	wg.Wait()
+}
@@ -5,137 +5,1070 @@ import (
 	"flag"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"subframe/server/logger"
 	. "subframe/status"
+	"subframe/structs/node"
+	"sync/atomic"
 )
 
 var log = logger.Logger{Prefix: "settings/Main"}
 
-//BootstrapNode is used for Bootstrapping the local instance
-var BootstrapNode = ""
+//Settings holds all settings of the Node. The settings in effect are published as a whole by Read and Reload,
+//see Current
+type Settings struct {
+	//BootstrapNode is used for Bootstrapping the local instance
+	BootstrapNode string
 
-//DataPath is used to store message and database files
-var DataPath = "./data"
+	//DataPath is used to store message and database files
+	DataPath string
 
-//RemoteAddress is used to access the local instance remotely
-var RemoteAddress = "localhost:9123"
+	//RemoteAddress is used to access the local instance remotely
+	RemoteAddress string
 
-//LocalAddress is the IP and Port the StorageNode instance listens on
-var LocalAddress = "0.0.0.0:9123"
+	//LocalAddress is the IP and Port the StorageNode instance listens on
+	LocalAddress string
 
-//DiskSpace is the maximum space used for message storage
-var DiskSpace = 5000
+	//GRPCAddress is the IP and Port the gRPC interface of the StorageNode listens on. Disabled if empty
+	GRPCAddress string
 
-//MaxWorkers is the maximum number of workers to spawn
-var MaxWorkers = 10
+	//DiskSpace is the maximum space used for message storage
+	DiskSpace int
 
-//QueueMaxLength is the maximum length of a queue before a new worker is spawned, if the current worker count does not exceed MaxWorkers
-var QueueMaxLength = 10
+	//JobWorkers is the number of workers executing queued jobs
+	JobWorkers int
 
-//MessageMaxSize defines the maximum size of an individual message file
-var MessageMaxSize = 100
+	//QueueMaxLength is the number of jobs the queue buffers before enqueueing blocks
+	QueueMaxLength int
 
-//MessageMinCheckDelay defines the minimum time in hours between individual checks of the message status
-var MessageMinCheckDelay = 12
+	//MessageMaxSize defines the maximum size of an individual message file
+	MessageMaxSize int
 
-//MessageMaxStoreTime defines the maximum time a message is stored locally, in days
-var MessageMaxStoreTime = 7
+	//MessageMinCheckDelay defines the minimum time in hours between individual checks of the message status
+	MessageMinCheckDelay int
 
-//ColorizedOutput defines whether realtime logs should be colorized
-var ColorizedLogs = false
+	//MessageMaxStoreTime defines the maximum time a message is stored locally, in days
+	MessageMaxStoreTime int
 
-//Read reads settings from local storage and overwrites them with command-line-arguments
-func Read() {
-	log.Info(InProgress, "Reading Settings...")
-	jsonstring, err := ioutil.ReadFile(DataPath + "/settings.json")
-	if err == nil {
-		data := make(map[string]interface{})
-		err := json.Unmarshal(jsonstring, &data)
-		if err == nil {
-			RemoteAddress, _ = data["RemoteAddress"].(string)
+	//AllowedContentTypes is a comma-separated list of the media types messages may be put with, e.g. text/plain,image/*.
+	//All content types are allowed if empty
+	AllowedContentTypes string
 
-			LocalAddress, _ = data["LocalAddress"].(string)
+	//ColorizedOutput defines whether realtime logs should be colorized
+	ColorizedLogs bool
 
-			tmp, ok := data["DiskSpace"].(float64)
-			if ok {
-				DiskSpace = int(tmp)
-			}
+	//TLSCertFile is the path to the certificate used for serving the Node Interface via TLS
+	TLSCertFile string
 
-			tmp, ok = data["MaxWorkers"].(float64)
-			if ok {
-				MaxWorkers = int(tmp)
-			}
+	//TLSKeyFile is the path to the private key belonging to TLSCertFile
+	TLSKeyFile string
 
-			tmp, ok = data["QueueMaxLength"].(float64)
-			if ok {
-				QueueMaxLength = int(tmp)
-			}
+	//AllowPlaintext allows serving the Node Interface without TLS, if no certificate is configured
+	AllowPlaintext bool
 
-			tmp, ok = data["MessageMaxSize"].(float64)
-			if ok {
-				MessageMaxSize = int(tmp)
-			}
+	//AuthToken is the shared secret required as Bearer token for write and control requests. Authentication is disabled if empty
+	AuthToken string
 
-			tmp, ok = data["MessageMinCheckDelay"].(float64)
-			if ok {
-				MessageMinCheckDelay = int(tmp)
-			}
+	//AdminToken is the Bearer token required for control actions administering the Node instead of AuthToken, so
+	//clients knowing AuthToken cannot use them. Control actions other Nodes send keep accepting AuthToken. Disabled if empty
+	AdminToken string
+
+	//AuthenticateReads defines whether get requests also require AuthToken
+	AuthenticateReads bool
+
+	//RateLimitRequests is the number of requests per second a single IP may send. Rate limiting is disabled if 0
+	RateLimitRequests int
+
+	//RateLimitBurst is the number of requests a single IP may send at once, before RateLimitRequests applies
+	RateLimitBurst int
+
+	//ReplicationFactor is the number of other StorageNodes a message is redistributed to
+	ReplicationFactor int
+
+	//ReplicationTiers is a comma-separated list of size:factor pairs, sizes in KB. Messages smaller than the size of a
+	//tier are redistributed to its factor of other StorageNodes instead of ReplicationFactor, e.g. "1024:5"
+	ReplicationTiers string
+
+	//PlacementHash is the hash function placing messages on the hash ring of StorageNodes: "xxhash", or "sha256" as used before
+	//it was configurable. All Nodes have to use the same function. Changing it moves most messages, see PlacementRebalance
+	PlacementHash string
+
+	//PlacementRebalance confirms a change of PlacementHash. Nodes refuse to start with another PlacementHash than recorded
+	//in their database, unless it is set
+	PlacementRebalance bool
+
+	//IntegrityHash is the hash function checksums of newly stored messages are computed with: "sha256" or "sha512".
+	//Every checksum records its function, so messages stored before a change are still verified
+	IntegrityHash string
+
+	//CoordinatorAnnounceCount is the number of CoordinatorNodes a message is announced to
+	CoordinatorAnnounceCount int
+
+	//NodeRequestTimeout is the maximum time in seconds an outgoing request to another Node may take
+	NodeRequestTimeout int
+
+	//NodeConnectTimeout is the maximum time in seconds to establish a connection to another Node
+	NodeConnectTimeout int
+
+	//NodeMaxIdleConnections is the maximum number of idle connections kept open per Node
+	NodeMaxIdleConnections int
+
+	//NodeRequestAttempts is the maximum number of attempts for an outgoing request to another Node
+	NodeRequestAttempts int
+
+	//NodeRequestRetryDelay is the delay in milliseconds before retrying a failed request to another Node, doubled with every attempt
+	NodeRequestRetryDelay int
+
+	//CompressionThreshold is the minimum size in bytes of a message to be compressed when stored. Compression is disabled if 0
+	CompressionThreshold int
+
+	//CompressionLevel is the gzip compression level (1-9) used for storing messages
+	CompressionLevel int
+
+	//EncryptAtRest defines whether message content is encrypted when stored
+	EncryptAtRest bool
+
+	//EncryptionKeyFile is the path to the hex encoded 32 byte AES key used for EncryptAtRest. If empty, the key is read from the SUBFRAME_ENCRYPTION_KEY environment variable
+	EncryptionKeyFile string
+
+	//CollectorInterval is the time in minutes between runs of the garbage collector removing expired and orphaned messages
+	CollectorInterval int
+
+	//CollectorRate is the maximum number of messages the garbage collector removes per second
+	CollectorRate int
+
+	//StorageBackend selects where message content and metadata are stored: "filesystem" or "memory". The memory backend loses all messages on restart
+	StorageBackend string
+
+	//DatabaseBackend selects where the node lists and message index are kept: "sqlite" in DataPath/databases, or
+	//"memory", which loses them on close and is meant for tests
+	DatabaseBackend string
+
+	//HealthCheckInterval is the time in seconds between health checks of all known Nodes. Disables health checks if 0
+	HealthCheckInterval int
+
+	//PersistJobs writes queued jobs to a journal in DataPath, so pending work is recovered after a restart
+	PersistJobs bool
+
+	//ShutdownTimeout is the time in seconds to wait for in-flight requests, and again for queued jobs, when shutting down
+	ShutdownTimeout int
+
+	//StorageGetTimeout is the maximum time in seconds reading a message from the storage backend may take for a request.
+	//Slower reads are abandoned and answered with 504. Disabled if 0
+	StorageGetTimeout int
+
+	//StoragePutTimeout is the maximum time in seconds storing a message in the storage backend may take for a request.
+	//Slower writes are abandoned and answered with 504. Disabled if 0
+	StoragePutTimeout int
+
+	//MetricsEnabled serves Prometheus metrics at /metrics
+	MetricsEnabled bool
+
+	//LogLevel is the lowest level of logs written, one of "debug", "info", "warn" or "error"
+	LogLevel string
+
+	//LogFormat is the format of log output, either "text" or "json" for JSON lines
+	LogFormat string
+
+	//UploadExpiry is the time in hours after which unfinished chunked uploads are removed
+	UploadExpiry int
+
+	//AntiEntropyInterval is the time in minutes between comparing the stored messages with other StorageNodes. Disabled if 0
+	AntiEntropyInterval int
+
+	//AntiEntropyPeers is the number of random StorageNodes compared with per anti-entropy run
+	AntiEntropyPeers int
+
+	//ReadQuorum is the number of replicas which have to return the same content for a quorum read. A majority of ReplicationFactor if 0
+	ReadQuorum int
+
+	//ClusterSecret is the shared secret inter-node requests are signed with, so Nodes can reject requests of hosts posing as peers. Signing is disabled if empty
+	ClusterSecret string
+
+	//SignatureMaxAge is the time in seconds a signed inter-node request is accepted after it was sent
+	SignatureMaxAge int
+
+	//BatchGetMaxSize is the maximum number of message IDs requested in one batch-get request
+	BatchGetMaxSize int
+
+	//ResponseCompressionThreshold is the minimum size in bytes of a response to be gzipped for clients accepting it. Responses are not compressed if 0
+	ResponseCompressionThreshold int
+
+	//AccessLogFormat is the format of the access log line written per request: "common" for the Common Log Format, "json" for JSON lines, or "off"
+	AccessLogFormat string
+
+	//DeduplicateContent stores identical message content only once, referenced by its checksum. Messages stored before keep their own copy
+	DeduplicateContent bool
+
+	//CORSAllowedOrigins is a comma-separated list of origins browsers may send requests from, e.g. https://app.example.com, or * for any origin. CORS is disabled if empty
+	CORSAllowedOrigins string
+
+	//StorageNodeSelection selects how StorageNodes are chosen for clients and peers: "random", or "weighted" to favour StorageNodes reporting more free storage
+	StorageNodeSelection string
+
+	//TracingEndpoint is the URL of an OpenTelemetry collector receiving traces over OTLP/HTTP, e.g. http://localhost:4318/v1/traces. Tracing is disabled if empty
+	TracingEndpoint string
+
+	//TracingSampleRate is the percentage of requests traced, unless the caller already decided whether to trace a request
+	TracingSampleRate int
+
+	//BulkAnnounceMaxSize is the maximum number of message IDs announced in one bulk-announce request
+	BulkAnnounceMaxSize int
+
+	//MaxConcurrentRequests is the number of requests handled at once, further requests are rejected with 503. Unlimited if 0
+	MaxConcurrentRequests int
+
+	//MessageIDMinLength is the minimum length of message IDs
+	MessageIDMinLength int
+
+	//MessageIDMaxLength is the maximum length of message IDs, which are used as file names
+	MessageIDMaxLength int
+
+	//SeedNodes is a comma-separated list of Nodes a new Node joins the network through, if its database does not know any Nodes yet
+	SeedNodes string
+
+	//MembershipInterval is the time in minutes between refreshing the known Nodes from random StorageNodes. Disabled if 0
+	MembershipInterval int
+
+	//MembershipPeers is the number of random StorageNodes the known Nodes are refreshed from
+	MembershipPeers int
+
+	//AnnounceJitter is the window in seconds the Jobs recovered on startup, mostly announcements, are spread over, and
+	//the maximum random delay of each Membership refresh. Nodes restarting together thus do not send their requests at
+	//once. Disabled if 0
+	AnnounceJitter int
+
+	//NodeExpiry is the time in hours after which Nodes which did not pass a health check are removed. Disabled if 0
+	NodeExpiry int
+
+	//AccessTokens is a comma-separated list of identity:token pairs of clients. Requests presenting one of the tokens as
+	//Bearer token are authenticated as its identity, which owns the messages it puts
+	AccessTokens string
+
+	//WebhookURLs is a comma-separated list of URLs which receive a POST for every message stored, deleted or expired on this Node. Disabled if empty
+	WebhookURLs string
+
+	//WebhookSecret is the key webhook requests are signed with using HMAC-SHA256. Requests are unsigned if empty
+	WebhookSecret string
+
+	//CompactionInterval is the time in minutes between compactions of the filesystem backend, packing small messages into segment files. Disabled if 0
+	CompactionInterval int
+
+	//CompactionBlobSize is the size in KiB up to which blobs are packed into segment files by compactions
+	CompactionBlobSize int
+
+	//OverloadQueueUsage is the percentage of settings.QueueMaxLength queued jobs at which the StorageNode rejects new messages. 0 disables the check
+	OverloadQueueUsage int
+
+	//OverloadDiskUsage is the percentage of the storage used at which the StorageNode rejects new messages. 0 disables the check
+	OverloadDiskUsage int
+
+	//OverloadErrorRate is the percentage of requests failing with server errors within a minute at which the StorageNode rejects new messages. 0 disables the check
+	OverloadErrorRate int
+
+	//StorageShardDepth is the number of subdirectory levels the filesystem backend places files in, named by the hashed key. Existing files are moved on start
+	StorageShardDepth int
 
-			tmp, ok = data["MessageMaxStoreTime"].(float64)
-			if ok {
-				MessageMaxStoreTime = int(tmp)
+	//NotFoundCacheSize is the number of message IDs not found locally which are remembered, so repeated gets of them are answered with 404 without reading storage. Disabled if 0
+	NotFoundCacheSize int
+
+	//NotFoundCacheTTL is the time in seconds a message ID not found locally is remembered
+	NotFoundCacheTTL int
+
+	//MessageCacheSize is the size in KB of decoded message content kept in memory, so repeated gets of popular messages
+	//do not read storage. Disabled if 0
+	MessageCacheSize int
+
+	//MessageCacheMaxSize is the size in KB of the largest message kept in the message cache
+	MessageCacheMaxSize int
+
+	//ResponseMaxSize is the maximum size in KB of batch-get and list-messages responses. Unlimited if 0
+	ResponseMaxSize int
+}
+
+//defaults returns the settings used unless the settings file or command line arguments set them
+func defaults() *Settings {
+	return &Settings{
+		DataPath:                     "./data",
+		RemoteAddress:                "localhost:9123",
+		LocalAddress:                 "0.0.0.0:9123",
+		DiskSpace:                    5000,
+		JobWorkers:                   10,
+		QueueMaxLength:               100,
+		MessageMaxSize:               100,
+		MessageMinCheckDelay:         12,
+		MessageMaxStoreTime:          7,
+		RateLimitBurst:               20,
+		ReplicationFactor:            2,
+		PlacementHash:                "xxhash",
+		IntegrityHash:                "sha256",
+		CoordinatorAnnounceCount:     3,
+		NodeRequestTimeout:           30,
+		NodeConnectTimeout:           10,
+		NodeMaxIdleConnections:       10,
+		NodeRequestAttempts:          3,
+		NodeRequestRetryDelay:        200,
+		CompressionThreshold:         4096,
+		CompressionLevel:             6,
+		CollectorInterval:            60,
+		CollectorRate:                10,
+		StorageBackend:               "filesystem",
+		DatabaseBackend:              "sqlite",
+		HealthCheckInterval:          60,
+		ShutdownTimeout:              30,
+		LogLevel:                     "info",
+		LogFormat:                    "text",
+		UploadExpiry:                 24,
+		AntiEntropyInterval:          30,
+		AntiEntropyPeers:             1,
+		SignatureMaxAge:              300,
+		BatchGetMaxSize:              100,
+		ResponseCompressionThreshold: 1024,
+		AccessLogFormat:              "common",
+		StorageNodeSelection:         "random",
+		TracingSampleRate:            100,
+		BulkAnnounceMaxSize:          1000,
+		MessageIDMinLength:           1,
+		MessageIDMaxLength:           128,
+		MembershipInterval:           10,
+		MembershipPeers:              3,
+		AnnounceJitter:               30,
+		NodeExpiry:                   72,
+		CompactionBlobSize:           64,
+		OverloadQueueUsage:           90,
+		OverloadDiskUsage:            95,
+		OverloadErrorRate:            50,
+		StorageShardDepth:            2,
+		NotFoundCacheSize:            10000,
+		NotFoundCacheTTL:             5,
+		MessageCacheMaxSize:          64,
+		ResponseMaxSize:              65536,
+	}
+}
+
+//current holds the *Settings in effect
+var current atomic.Value
+
+func init() {
+	current.Store(defaults())
+}
+
+//Current returns the settings in effect. They are replaced as a whole when settings are read or reloaded, so the
+//settings returned are consistent, and must not be modified except by tests
+func Current() *Settings {
+	return current.Load().(*Settings)
+}
+
+//publish replaces the settings in effect with s at once, and configures the logger from them
+func publish(s *Settings) {
+	current.Store(s)
+	applyLogger(s)
+}
+
+//ReplicationTier is a pair listed in ReplicationTiers
+type ReplicationTier struct {
+	MaxSize int64
+	Factor  int
+}
+
+//MessageIDLengthLimit bounds MessageIDMaxLength. File names are limited to 255 bytes, which have to fit the ID and
+//the suffixes of temporary files
+const MessageIDLengthLimit = 200
+
+//ContentTypes returns the media types listed in AllowedContentTypes, in lower case
+func (s *Settings) ContentTypes() (types []string) {
+	for _, contentType := range strings.Split(s.AllowedContentTypes, ",") {
+		if contentType = strings.ToLower(strings.TrimSpace(contentType)); contentType != "" {
+			types = append(types, contentType)
+		}
+	}
+	return types
+}
+
+//ParseReplicationTiers returns the tiers listed in ReplicationTiers, smallest size first. Malformed pairs are returned
+//with a size and factor of 0, see Validate
+func (s *Settings) ParseReplicationTiers() (tiers []ReplicationTier) {
+	for _, pair := range strings.Split(s.ReplicationTiers, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		var tier ReplicationTier
+		if separator := strings.Index(pair, ":"); separator >= 0 {
+			size, sizeErr := strconv.ParseInt(strings.TrimSpace(pair[:separator]), 10, 64)
+			factor, factorErr := strconv.Atoi(strings.TrimSpace(pair[separator+1:]))
+			if sizeErr == nil && factorErr == nil {
+				tier = ReplicationTier{MaxSize: size * 1024, Factor: factor}
 			}
+		}
+		tiers = append(tiers, tier)
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MaxSize < tiers[j].MaxSize })
+	return tiers
+}
+
+//ReplicationFactorFor returns the number of other StorageNodes a message of size bytes is redistributed to:
+//the factor of the smallest tier in ReplicationTiers the message is smaller than, or ReplicationFactor
+func (s *Settings) ReplicationFactorFor(size int64) int {
+	for _, tier := range s.ParseReplicationTiers() {
+		if tier.Factor >= 1 && size < tier.MaxSize {
+			return tier.Factor
+		}
+	}
+	return s.ReplicationFactor
+}
+
+//MaxReplicationFactor returns the largest replication factor of ReplicationFactor and ReplicationTiers, for messages
+//of unknown size
+func (s *Settings) MaxReplicationFactor() int {
+	factor := s.ReplicationFactor
+	for _, tier := range s.ParseReplicationTiers() {
+		if tier.Factor > factor {
+			factor = tier.Factor
+		}
+	}
+	return factor
+}
+
+//MaxStorageBytes returns DiskSpace in bytes
+func (s *Settings) MaxStorageBytes() int64 {
+	return int64(s.DiskSpace) * 1024 * 1024
+}
 
-			ColorizedLogs, _ = data["ColorizedLogs"].(bool)
+//ReadQuorumSize returns ReadQuorum, or a majority of ReplicationFactor if it is not set
+func (s *Settings) ReadQuorumSize() int {
+	if s.ReadQuorum > 0 {
+		return s.ReadQuorum
+	}
+	return s.ReplicationFactor/2 + 1
+}
+
+//AllowedOrigins returns the origins listed in CORSAllowedOrigins
+func (s *Settings) AllowedOrigins() (origins []string) {
+	for _, origin := range strings.Split(s.CORSAllowedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+//SeedNodeAddresses returns the normalized addresses listed in SeedNodes
+func (s *Settings) SeedNodeAddresses() (addresses []string) {
+	for _, address := range strings.Split(s.SeedNodes, ",") {
+		if address = strings.TrimSpace(address); address == "" {
+			continue
+		}
+		if normalized, err := node.NormalizeAddress(address); err == nil {
+			addresses = append(addresses, normalized)
+		}
+	}
+	return addresses
+}
+
+//AccessTokenIdentities returns the identities listed in AccessTokens by token
+func (s *Settings) AccessTokenIdentities() map[string]string {
+	identities := make(map[string]string)
+	for _, pair := range strings.Split(s.AccessTokens, ",") {
+		separator := strings.Index(pair, ":")
+		if separator < 0 {
+			continue
+		}
+		identity, token := strings.TrimSpace(pair[:separator]), strings.TrimSpace(pair[separator+1:])
+		if identity != "" && token != "" {
+			identities[token] = identity
+		}
+	}
+	return identities
+}
+
+//WebhookEndpoints returns the URLs listed in WebhookURLs
+func (s *Settings) WebhookEndpoints() (endpoints []string) {
+	for _, endpoint := range strings.Split(s.WebhookURLs, ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints
+}
+
+//Read reads settings from local storage and overwrites them with command-line-arguments, then publishes them
+func Read() {
+	log.Info(InProgress, "Reading Settings...")
+	s := *Current()
+	jsonstring, err := ioutil.ReadFile(s.DataPath + "/settings.json")
+	if err == nil {
+		data := make(map[string]interface{})
+		err := json.Unmarshal(jsonstring, &data)
+		if err == nil {
+			apply(&s, data)
 		} else {
 			log.Warn(SettingsReadError, "Failed to read settings from file ("+err.Error()+"). Falling back to defaults or using command line arguments...")
 		}
 	}
 
-	parseCommandLineArgs()
-	logger.ColorizedLogs = ColorizedLogs
+	parseCommandLineArgs(&s)
+	if problems := s.Validate(); len(problems) > 0 {
+		for _, problem := range problems {
+			log.Error(SettingsReadError, problem)
+		}
+		log.Fatal(SettingsReadError, "Invalid settings, found "+strconv.Itoa(len(problems))+" problems.")
+	}
+	//Addresses of the local Node are compared with the normalized addresses stored by other Nodes
+	s.RemoteAddress, _ = node.NormalizeAddress(s.RemoteAddress)
+	if s.BootstrapNode != "" {
+		s.BootstrapNode, _ = node.NormalizeAddress(s.BootstrapNode)
+	}
+	publish(&s)
 	log.Info(OK, "Successfully read Settings.")
 	Write()
 }
 
-//Write writes settings to local storage
+//Write writes the current settings to local storage
 func Write() {
 	//Write settings to disk
 	log.Info(InProgress, "Writing settings...")
-	data := make(map[string]interface{})
-	data["RemoteAddress"] = RemoteAddress
-	data["LocalAddress"] = LocalAddress
-	data["DiskSpace"] = DiskSpace
-	data["MaxWorkers"] = MaxWorkers
-	data["QueueMaxLength"] = QueueMaxLength
-	data["MessageMaxSize"] = MessageMaxSize
-	data["MessageMinCheckDelay"] = MessageMinCheckDelay
-	data["MessageMaxStoreTime"] = MessageMaxStoreTime
-	data["ColorizedLogs"] = ColorizedLogs
-
+	s := Current()
+	data := values(s)
 	jsonstring, err := json.MarshalIndent(data, "", "\t")
-	f, err := os.Create(DataPath + "/settings.json")
+	f, err := os.Create(s.DataPath + "/settings.json")
 	if err != nil {
 		log.Fatal(SettingsWriteError, err.Error())
 	}
 	f.Write(jsonstring)
 	f.Close()
-	log.Info(OK, "Wrote settings to "+DataPath+"/settings.json")
+	log.Info(OK, "Wrote settings to "+s.DataPath+"/settings.json")
 }
 
-func parseCommandLineArgs() {
+//apply overwrites the settings s with the values in data, as decoded from the settings file
+func apply(s *Settings, data map[string]interface{}) {
+	s.RemoteAddress, _ = data["RemoteAddress"].(string)
+
+	s.LocalAddress, _ = data["LocalAddress"].(string)
+
+	s.GRPCAddress, _ = data["GRPCAddress"].(string)
+
+	tmp, ok := data["DiskSpace"].(float64)
+	if ok {
+		s.DiskSpace = int(tmp)
+	}
+
+	tmp, ok = data["JobWorkers"].(float64)
+	if ok {
+		s.JobWorkers = int(tmp)
+	}
+
+	tmp, ok = data["QueueMaxLength"].(float64)
+	if ok {
+		s.QueueMaxLength = int(tmp)
+	}
+
+	tmp, ok = data["MessageMaxSize"].(float64)
+	if ok {
+		s.MessageMaxSize = int(tmp)
+	}
+
+	tmp, ok = data["MessageMinCheckDelay"].(float64)
+	if ok {
+		s.MessageMinCheckDelay = int(tmp)
+	}
+
+	tmp, ok = data["MessageMaxStoreTime"].(float64)
+	if ok {
+		s.MessageMaxStoreTime = int(tmp)
+	}
+
+	s.AllowedContentTypes, _ = data["AllowedContentTypes"].(string)
+
+	s.ColorizedLogs, _ = data["ColorizedLogs"].(bool)
+
+	s.TLSCertFile, _ = data["TLSCertFile"].(string)
+
+	s.TLSKeyFile, _ = data["TLSKeyFile"].(string)
+
+	s.AllowPlaintext, _ = data["AllowPlaintext"].(bool)
+
+	s.AuthToken, _ = data["AuthToken"].(string)
+
+	s.AuthenticateReads, _ = data["AuthenticateReads"].(bool)
+
+	tmp, ok = data["RateLimitRequests"].(float64)
+	if ok {
+		s.RateLimitRequests = int(tmp)
+	}
+
+	tmp, ok = data["RateLimitBurst"].(float64)
+	if ok {
+		s.RateLimitBurst = int(tmp)
+	}
+
+	tmp, ok = data["ReplicationFactor"].(float64)
+	if ok {
+		s.ReplicationFactor = int(tmp)
+	}
+
+	tmp, ok = data["CoordinatorAnnounceCount"].(float64)
+	if ok {
+		s.CoordinatorAnnounceCount = int(tmp)
+	}
+
+	tmp, ok = data["NodeRequestTimeout"].(float64)
+	if ok {
+		s.NodeRequestTimeout = int(tmp)
+	}
+
+	tmp, ok = data["NodeConnectTimeout"].(float64)
+	if ok {
+		s.NodeConnectTimeout = int(tmp)
+	}
+
+	tmp, ok = data["NodeMaxIdleConnections"].(float64)
+	if ok {
+		s.NodeMaxIdleConnections = int(tmp)
+	}
+
+	tmp, ok = data["NodeRequestAttempts"].(float64)
+	if ok {
+		s.NodeRequestAttempts = int(tmp)
+	}
+
+	tmp, ok = data["NodeRequestRetryDelay"].(float64)
+	if ok {
+		s.NodeRequestRetryDelay = int(tmp)
+	}
+
+	tmp, ok = data["CompressionThreshold"].(float64)
+	if ok {
+		s.CompressionThreshold = int(tmp)
+	}
+
+	tmp, ok = data["CompressionLevel"].(float64)
+	if ok {
+		s.CompressionLevel = int(tmp)
+	}
+
+	s.EncryptAtRest, _ = data["EncryptAtRest"].(bool)
+
+	s.EncryptionKeyFile, _ = data["EncryptionKeyFile"].(string)
+
+	tmp, ok = data["CollectorInterval"].(float64)
+	if ok {
+		s.CollectorInterval = int(tmp)
+	}
+
+	tmp, ok = data["CollectorRate"].(float64)
+	if ok {
+		s.CollectorRate = int(tmp)
+	}
+
+	if backend, ok := data["StorageBackend"].(string); ok && backend != "" {
+		s.StorageBackend = backend
+	}
+
+	if backend, ok := data["DatabaseBackend"].(string); ok && backend != "" {
+		s.DatabaseBackend = backend
+	}
+
+	tmp, ok = data["HealthCheckInterval"].(float64)
+	if ok {
+		s.HealthCheckInterval = int(tmp)
+	}
+
+	s.PersistJobs, _ = data["PersistJobs"].(bool)
+
+	tmp, ok = data["ShutdownTimeout"].(float64)
+	if ok {
+		s.ShutdownTimeout = int(tmp)
+	}
+
+	tmp, ok = data["StorageGetTimeout"].(float64)
+	if ok {
+		s.StorageGetTimeout = int(tmp)
+	}
+
+	tmp, ok = data["StoragePutTimeout"].(float64)
+	if ok {
+		s.StoragePutTimeout = int(tmp)
+	}
+
+	s.MetricsEnabled, _ = data["MetricsEnabled"].(bool)
+
+	if v, ok := data["LogLevel"].(string); ok && v != "" {
+		s.LogLevel = v
+	}
+
+	if v, ok := data["LogFormat"].(string); ok && v != "" {
+		s.LogFormat = v
+	}
+
+	tmp, ok = data["UploadExpiry"].(float64)
+	if ok {
+		s.UploadExpiry = int(tmp)
+	}
+
+	tmp, ok = data["AntiEntropyInterval"].(float64)
+	if ok {
+		s.AntiEntropyInterval = int(tmp)
+	}
+
+	tmp, ok = data["AntiEntropyPeers"].(float64)
+	if ok {
+		s.AntiEntropyPeers = int(tmp)
+	}
+
+	tmp, ok = data["ReadQuorum"].(float64)
+	if ok {
+		s.ReadQuorum = int(tmp)
+	}
+
+	s.ClusterSecret, _ = data["ClusterSecret"].(string)
+
+	tmp, ok = data["SignatureMaxAge"].(float64)
+	if ok {
+		s.SignatureMaxAge = int(tmp)
+	}
+
+	tmp, ok = data["BatchGetMaxSize"].(float64)
+	if ok {
+		s.BatchGetMaxSize = int(tmp)
+	}
+
+	tmp, ok = data["ResponseCompressionThreshold"].(float64)
+	if ok {
+		s.ResponseCompressionThreshold = int(tmp)
+	}
+
+	if v, ok := data["AccessLogFormat"].(string); ok && v != "" {
+		s.AccessLogFormat = v
+	}
+
+	s.DeduplicateContent, _ = data["DeduplicateContent"].(bool)
+
+	s.CORSAllowedOrigins, _ = data["CORSAllowedOrigins"].(string)
+
+	if v, ok := data["StorageNodeSelection"].(string); ok && v != "" {
+		s.StorageNodeSelection = v
+	}
+
+	s.TracingEndpoint, _ = data["TracingEndpoint"].(string)
+
+	tmp, ok = data["TracingSampleRate"].(float64)
+	if ok {
+		s.TracingSampleRate = int(tmp)
+	}
+
+	tmp, ok = data["BulkAnnounceMaxSize"].(float64)
+	if ok {
+		s.BulkAnnounceMaxSize = int(tmp)
+	}
+
+	tmp, ok = data["MaxConcurrentRequests"].(float64)
+	if ok {
+		s.MaxConcurrentRequests = int(tmp)
+	}
+
+	tmp, ok = data["MessageIDMinLength"].(float64)
+	if ok {
+		s.MessageIDMinLength = int(tmp)
+	}
+
+	tmp, ok = data["MessageIDMaxLength"].(float64)
+	if ok {
+		s.MessageIDMaxLength = int(tmp)
+	}
+
+	s.SeedNodes, _ = data["SeedNodes"].(string)
+
+	tmp, ok = data["MembershipInterval"].(float64)
+	if ok {
+		s.MembershipInterval = int(tmp)
+	}
+
+	tmp, ok = data["MembershipPeers"].(float64)
+	if ok {
+		s.MembershipPeers = int(tmp)
+	}
+
+	tmp, ok = data["AnnounceJitter"].(float64)
+	if ok {
+		s.AnnounceJitter = int(tmp)
+	}
+
+	tmp, ok = data["NodeExpiry"].(float64)
+	if ok {
+		s.NodeExpiry = int(tmp)
+	}
+
+	s.AccessTokens, _ = data["AccessTokens"].(string)
+
+	s.WebhookURLs, _ = data["WebhookURLs"].(string)
+
+	s.WebhookSecret, _ = data["WebhookSecret"].(string)
+
+	tmp, ok = data["CompactionInterval"].(float64)
+	if ok {
+		s.CompactionInterval = int(tmp)
+	}
+
+	tmp, ok = data["CompactionBlobSize"].(float64)
+	if ok {
+		s.CompactionBlobSize = int(tmp)
+	}
+
+	tmp, ok = data["OverloadQueueUsage"].(float64)
+	if ok {
+		s.OverloadQueueUsage = int(tmp)
+	}
+
+	tmp, ok = data["OverloadDiskUsage"].(float64)
+	if ok {
+		s.OverloadDiskUsage = int(tmp)
+	}
+
+	tmp, ok = data["OverloadErrorRate"].(float64)
+	if ok {
+		s.OverloadErrorRate = int(tmp)
+	}
+
+	tmp, ok = data["StorageShardDepth"].(float64)
+	if ok {
+		s.StorageShardDepth = int(tmp)
+	}
+
+	s.ReplicationTiers, _ = data["ReplicationTiers"].(string)
+
+	if v, ok := data["PlacementHash"].(string); ok && v != "" {
+		s.PlacementHash = v
+	}
+
+	s.PlacementRebalance, _ = data["PlacementRebalance"].(bool)
+
+	if v, ok := data["IntegrityHash"].(string); ok && v != "" {
+		s.IntegrityHash = v
+	}
+
+	tmp, ok = data["NotFoundCacheSize"].(float64)
+	if ok {
+		s.NotFoundCacheSize = int(tmp)
+	}
+
+	tmp, ok = data["NotFoundCacheTTL"].(float64)
+	if ok {
+		s.NotFoundCacheTTL = int(tmp)
+	}
+
+	tmp, ok = data["MessageCacheSize"].(float64)
+	if ok {
+		s.MessageCacheSize = int(tmp)
+	}
+
+	tmp, ok = data["MessageCacheMaxSize"].(float64)
+	if ok {
+		s.MessageCacheMaxSize = int(tmp)
+	}
+
+	s.AdminToken, _ = data["AdminToken"].(string)
+
+	tmp, ok = data["ResponseMaxSize"].(float64)
+	if ok {
+		s.ResponseMaxSize = int(tmp)
+	}
+}
+
+//values returns all settings of s stored in the settings file by name
+func values(s *Settings) map[string]interface{} {
+	data := make(map[string]interface{})
+	data["RemoteAddress"] = s.RemoteAddress
+	data["LocalAddress"] = s.LocalAddress
+	data["GRPCAddress"] = s.GRPCAddress
+	data["DiskSpace"] = s.DiskSpace
+	data["JobWorkers"] = s.JobWorkers
+	data["QueueMaxLength"] = s.QueueMaxLength
+	data["MessageMaxSize"] = s.MessageMaxSize
+	data["MessageMinCheckDelay"] = s.MessageMinCheckDelay
+	data["MessageMaxStoreTime"] = s.MessageMaxStoreTime
+	data["AllowedContentTypes"] = s.AllowedContentTypes
+	data["ColorizedLogs"] = s.ColorizedLogs
+	data["TLSCertFile"] = s.TLSCertFile
+	data["TLSKeyFile"] = s.TLSKeyFile
+	data["AllowPlaintext"] = s.AllowPlaintext
+	data["AuthToken"] = s.AuthToken
+	data["AuthenticateReads"] = s.AuthenticateReads
+	data["RateLimitRequests"] = s.RateLimitRequests
+	data["RateLimitBurst"] = s.RateLimitBurst
+	data["ReplicationFactor"] = s.ReplicationFactor
+	data["CoordinatorAnnounceCount"] = s.CoordinatorAnnounceCount
+	data["NodeRequestTimeout"] = s.NodeRequestTimeout
+	data["NodeConnectTimeout"] = s.NodeConnectTimeout
+	data["NodeMaxIdleConnections"] = s.NodeMaxIdleConnections
+	data["NodeRequestAttempts"] = s.NodeRequestAttempts
+	data["NodeRequestRetryDelay"] = s.NodeRequestRetryDelay
+	data["CompressionThreshold"] = s.CompressionThreshold
+	data["CompressionLevel"] = s.CompressionLevel
+	data["EncryptAtRest"] = s.EncryptAtRest
+	data["EncryptionKeyFile"] = s.EncryptionKeyFile
+	data["CollectorInterval"] = s.CollectorInterval
+	data["CollectorRate"] = s.CollectorRate
+	data["StorageBackend"] = s.StorageBackend
+	data["DatabaseBackend"] = s.DatabaseBackend
+	data["HealthCheckInterval"] = s.HealthCheckInterval
+	data["PersistJobs"] = s.PersistJobs
+	data["ShutdownTimeout"] = s.ShutdownTimeout
+	data["StorageGetTimeout"] = s.StorageGetTimeout
+	data["StoragePutTimeout"] = s.StoragePutTimeout
+	data["MetricsEnabled"] = s.MetricsEnabled
+	data["LogLevel"] = s.LogLevel
+	data["LogFormat"] = s.LogFormat
+	data["UploadExpiry"] = s.UploadExpiry
+	data["AntiEntropyInterval"] = s.AntiEntropyInterval
+	data["AntiEntropyPeers"] = s.AntiEntropyPeers
+	data["ReadQuorum"] = s.ReadQuorum
+	data["ClusterSecret"] = s.ClusterSecret
+	data["SignatureMaxAge"] = s.SignatureMaxAge
+	data["BatchGetMaxSize"] = s.BatchGetMaxSize
+	data["ResponseCompressionThreshold"] = s.ResponseCompressionThreshold
+	data["AccessLogFormat"] = s.AccessLogFormat
+	data["DeduplicateContent"] = s.DeduplicateContent
+	data["CORSAllowedOrigins"] = s.CORSAllowedOrigins
+	data["StorageNodeSelection"] = s.StorageNodeSelection
+	data["TracingEndpoint"] = s.TracingEndpoint
+	data["TracingSampleRate"] = s.TracingSampleRate
+	data["BulkAnnounceMaxSize"] = s.BulkAnnounceMaxSize
+	data["MaxConcurrentRequests"] = s.MaxConcurrentRequests
+	data["MessageIDMinLength"] = s.MessageIDMinLength
+	data["MessageIDMaxLength"] = s.MessageIDMaxLength
+	data["SeedNodes"] = s.SeedNodes
+	data["MembershipInterval"] = s.MembershipInterval
+	data["MembershipPeers"] = s.MembershipPeers
+	data["AnnounceJitter"] = s.AnnounceJitter
+	data["NodeExpiry"] = s.NodeExpiry
+	data["AccessTokens"] = s.AccessTokens
+	data["WebhookURLs"] = s.WebhookURLs
+	data["WebhookSecret"] = s.WebhookSecret
+	data["CompactionInterval"] = s.CompactionInterval
+	data["CompactionBlobSize"] = s.CompactionBlobSize
+	data["OverloadQueueUsage"] = s.OverloadQueueUsage
+	data["OverloadDiskUsage"] = s.OverloadDiskUsage
+	data["OverloadErrorRate"] = s.OverloadErrorRate
+	data["StorageShardDepth"] = s.StorageShardDepth
+	data["ReplicationTiers"] = s.ReplicationTiers
+	data["PlacementHash"] = s.PlacementHash
+	data["PlacementRebalance"] = s.PlacementRebalance
+	data["IntegrityHash"] = s.IntegrityHash
+	data["NotFoundCacheSize"] = s.NotFoundCacheSize
+	data["NotFoundCacheTTL"] = s.NotFoundCacheTTL
+	data["MessageCacheSize"] = s.MessageCacheSize
+	data["MessageCacheMaxSize"] = s.MessageCacheMaxSize
+	data["AdminToken"] = s.AdminToken
+	data["ResponseMaxSize"] = s.ResponseMaxSize
+	return data
+}
+
+//applyLogger configures the logger from the log settings of s
+func applyLogger(s *Settings) {
+	level, _ := logger.ParseLevel(s.LogLevel)
+	logger.ColorizedLogs = s.ColorizedLogs
+	logger.Level = level
+	logger.JSONLogs = s.LogFormat == "json"
+}
+
+func parseCommandLineArgs(s *Settings) {
 	log.Info(InProgress, "Parsing Commandline Arguments...")
-	flag.StringVar(&BootstrapNode, "bootstrap-node", BootstrapNode, "If set, SuBFraMe will reinitialize the local Node Database and sync it with the BootstrapNode")
-	flag.StringVar(&DataPath, "data-dir", DataPath, "The SuBFraMe data directory, messages, databases and settings will be stored here")
-	flag.StringVar(&RemoteAddress, "remote-address", RemoteAddress, "The remote address of this SuBFraMe Instance")
-	flag.StringVar(&LocalAddress, "local-address", LocalAddress, "The IP and Port the Node Interface will listen on")
-	flag.IntVar(&DiskSpace, "disk-space", DiskSpace, "The maximum space SuBFraMe will use to store Messages in MB")
-	flag.IntVar(&MaxWorkers, "max-workers", MaxWorkers, "The maximum number of worker threads")
-	flag.IntVar(&QueueMaxLength, "max-queue-length", QueueMaxLength, "The maximum size a queue can have before a new worker is spawned, before exceeding max-workers")
-	flag.IntVar(&MessageMaxSize, "message-max-size", MessageMaxSize, "The maximum size of an individual message file, in MB")
-	flag.IntVar(&MessageMinCheckDelay, "message-min-check-delay", MessageMinCheckDelay, "The minimum time in hours between individual checks of the same message against the coordinator network")
-	flag.IntVar(&MessageMaxStoreTime, "message-max-store-time", MessageMaxStoreTime, "The maximum time a message is stored locally, in days")
-	flag.BoolVar(&ColorizedLogs, "colorized-output", ColorizedLogs, "Turns on or off colorized realtime logs")
+	registerFlags(flag.CommandLine, s)
 	flag.Parse()
+	//Arguments keep overriding the settings file when it is reloaded
+	flag.Visit(func(f *flag.Flag) {
+		commandLine[f.Name] = f.Value.String()
+	})
 	log.Info(OK, "Parsed Commandline Arguments.")
 }
+
+//registerFlags defines the command line arguments of all settings on flags, which set the settings of s
+func registerFlags(flags *flag.FlagSet, s *Settings) {
+	flags.StringVar(&s.BootstrapNode, "bootstrap-node", s.BootstrapNode, "If set, SuBFraMe will reinitialize the local Node Database and sync it with the BootstrapNode")
+	flags.StringVar(&s.DataPath, "data-dir", s.DataPath, "The SuBFraMe data directory, messages, databases and settings will be stored here")
+	flags.StringVar(&s.RemoteAddress, "remote-address", s.RemoteAddress, "The remote address of this SuBFraMe Instance")
+	flags.StringVar(&s.LocalAddress, "local-address", s.LocalAddress, "The IP and Port the Node Interface will listen on")
+	flags.StringVar(&s.GRPCAddress, "grpc-address", s.GRPCAddress, "The IP and Port the gRPC Interface will listen on (empty disables it)")
+	flags.IntVar(&s.DiskSpace, "disk-space", s.DiskSpace, "The maximum space SuBFraMe will use to store Messages in MB")
+	flags.IntVar(&s.JobWorkers, "job-workers", s.JobWorkers, "The number of worker threads executing queued jobs")
+	flags.IntVar(&s.QueueMaxLength, "max-queue-length", s.QueueMaxLength, "The number of jobs the queue buffers before enqueueing blocks")
+	flags.IntVar(&s.MessageMaxSize, "message-max-size", s.MessageMaxSize, "The maximum size of an individual message file, in MB")
+	flags.IntVar(&s.MessageMinCheckDelay, "message-min-check-delay", s.MessageMinCheckDelay, "The minimum time in hours between individual checks of the same message against the coordinator network")
+	flags.IntVar(&s.MessageMaxStoreTime, "message-max-store-time", s.MessageMaxStoreTime, "The maximum time a message is stored locally, in days")
+	flags.StringVar(&s.AllowedContentTypes, "allowed-content-types", s.AllowedContentTypes, "Comma-separated media types messages may be put with, e.g. text/plain,image/* (empty allows all)")
+	flags.BoolVar(&s.ColorizedLogs, "colorized-output", s.ColorizedLogs, "Turns on or off colorized realtime logs")
+	flags.StringVar(&s.TLSCertFile, "tls-cert", s.TLSCertFile, "The certificate file used to serve the Node Interface via TLS")
+	flags.StringVar(&s.TLSKeyFile, "tls-key", s.TLSKeyFile, "The private key file belonging to tls-cert")
+	flags.BoolVar(&s.AllowPlaintext, "allow-plaintext", s.AllowPlaintext, "Allows serving the Node Interface without TLS if no certificate is configured")
+	flags.StringVar(&s.AuthToken, "auth-token", s.AuthToken, "The Bearer token required for put, delete, update and control requests. Disables authentication if empty")
+	flags.BoolVar(&s.AuthenticateReads, "authenticate-reads", s.AuthenticateReads, "Requires auth-token for get requests as well")
+	flags.IntVar(&s.RateLimitRequests, "rate-limit", s.RateLimitRequests, "The number of requests per second a single IP may send. Disables rate limiting if 0")
+	flags.IntVar(&s.RateLimitBurst, "rate-limit-burst", s.RateLimitBurst, "The number of requests a single IP may send at once before rate-limit applies")
+	flags.IntVar(&s.ReplicationFactor, "replication-factor", s.ReplicationFactor, "The number of other StorageNodes a message is redistributed to")
+	flags.IntVar(&s.CoordinatorAnnounceCount, "coordinator-announce-count", s.CoordinatorAnnounceCount, "The number of CoordinatorNodes a message is announced to")
+	flags.IntVar(&s.NodeRequestTimeout, "node-request-timeout", s.NodeRequestTimeout, "The maximum time in seconds an outgoing request to another Node may take")
+	flags.IntVar(&s.NodeConnectTimeout, "node-connect-timeout", s.NodeConnectTimeout, "The maximum time in seconds to establish a connection to another Node")
+	flags.IntVar(&s.NodeMaxIdleConnections, "node-max-idle-connections", s.NodeMaxIdleConnections, "The maximum number of idle connections kept open per Node")
+	flags.IntVar(&s.NodeRequestAttempts, "node-request-attempts", s.NodeRequestAttempts, "The maximum number of attempts for an outgoing request to another Node")
+	flags.IntVar(&s.NodeRequestRetryDelay, "node-request-retry-delay", s.NodeRequestRetryDelay, "The delay in milliseconds before retrying a failed request to another Node, doubled with every attempt")
+	flags.IntVar(&s.CompressionThreshold, "compression-threshold", s.CompressionThreshold, "The minimum size in bytes of a message to be compressed when stored. Disables compression if 0")
+	flags.IntVar(&s.CompressionLevel, "compression-level", s.CompressionLevel, "The gzip compression level (1-9) used for storing messages")
+	flags.BoolVar(&s.EncryptAtRest, "encrypt-at-rest", s.EncryptAtRest, "Encrypts message content when stored, using the key from encryption-key-file or SUBFRAME_ENCRYPTION_KEY")
+	flags.StringVar(&s.EncryptionKeyFile, "encryption-key-file", s.EncryptionKeyFile, "The file containing the hex encoded 32 byte AES key used for encrypt-at-rest")
+	flags.IntVar(&s.CollectorInterval, "collector-interval", s.CollectorInterval, "The time in minutes between runs of the garbage collector removing expired and orphaned messages. Disables the collector if 0")
+	flags.IntVar(&s.CollectorRate, "collector-rate", s.CollectorRate, "The maximum number of messages the garbage collector removes per second")
+	flags.StringVar(&s.StorageBackend, "storage-backend", s.StorageBackend, "Storage backend for messages, \"filesystem\" or \"memory\"")
+	flags.StringVar(&s.DatabaseBackend, "database-backend", s.DatabaseBackend, "Database backend for node lists and the message index, \"sqlite\" or \"memory\"")
+	flags.IntVar(&s.HealthCheckInterval, "health-check-interval", s.HealthCheckInterval, "The time in seconds between health checks of all known Nodes. Disables health checks if 0")
+	flags.BoolVar(&s.PersistJobs, "persist-jobs", s.PersistJobs, "Persist queued jobs to disk and recover them after a restart")
+	flags.IntVar(&s.ShutdownTimeout, "shutdown-timeout", s.ShutdownTimeout, "The time in seconds to wait for in-flight requests, and again for queued jobs, when shutting down")
+	flags.IntVar(&s.StorageGetTimeout, "storage-get-timeout", s.StorageGetTimeout, "The maximum time in seconds reading a message from storage may take before answering 504 (0 disables)")
+	flags.IntVar(&s.StoragePutTimeout, "storage-put-timeout", s.StoragePutTimeout, "The maximum time in seconds storing a message may take before answering 504 (0 disables)")
+	flags.BoolVar(&s.MetricsEnabled, "enable-metrics", s.MetricsEnabled, "Serve Prometheus metrics at /metrics")
+	flags.StringVar(&s.LogLevel, "log-level", s.LogLevel, "Lowest level of logs written (debug, info, warn, error)")
+	flags.StringVar(&s.LogFormat, "log-format", s.LogFormat, "Format of log output (text, json)")
+	flags.IntVar(&s.UploadExpiry, "upload-expiry", s.UploadExpiry, "Hours after which unfinished chunked uploads are removed")
+	flags.IntVar(&s.AntiEntropyInterval, "anti-entropy-interval", s.AntiEntropyInterval, "Minutes between syncing stored messages with other StorageNodes (0 disables)")
+	flags.IntVar(&s.AntiEntropyPeers, "anti-entropy-peers", s.AntiEntropyPeers, "Number of StorageNodes compared with per anti-entropy run")
+	flags.IntVar(&s.ReadQuorum, "read-quorum", s.ReadQuorum, "Replicas agreeing for a quorum read (0 for a majority of the replication factor)")
+	flags.StringVar(&s.ClusterSecret, "cluster-secret", s.ClusterSecret, "Shared secret for signing inter-node requests")
+	flags.IntVar(&s.SignatureMaxAge, "signature-max-age", s.SignatureMaxAge, "Seconds a signed inter-node request stays valid")
+	flags.IntVar(&s.BatchGetMaxSize, "batch-get-max-size", s.BatchGetMaxSize, "Maximum number of message IDs per batch-get request")
+	flags.IntVar(&s.ResponseCompressionThreshold, "response-compression-threshold", s.ResponseCompressionThreshold, "Minimum response size in bytes for gzip compression (0 disables)")
+	flags.StringVar(&s.AccessLogFormat, "access-log-format", s.AccessLogFormat, "Format of access logs (common, json, off)")
+	flags.BoolVar(&s.DeduplicateContent, "deduplicate-content", s.DeduplicateContent, "Store identical message content only once")
+	flags.StringVar(&s.CORSAllowedOrigins, "cors-allowed-origins", s.CORSAllowedOrigins, "Comma-separated origins allowed for cross-origin requests, * for any (empty disables CORS)")
+	flags.StringVar(&s.StorageNodeSelection, "storage-node-selection", s.StorageNodeSelection, "Selection of StorageNodes, \"random\" or \"weighted\" by free storage")
+	flags.StringVar(&s.TracingEndpoint, "tracing-endpoint", s.TracingEndpoint, "OTLP/HTTP endpoint for traces, e.g. http://localhost:4318/v1/traces (empty disables tracing)")
+	flags.IntVar(&s.TracingSampleRate, "tracing-sample-rate", s.TracingSampleRate, "Percentage of requests traced (0-100)")
+	flags.IntVar(&s.BulkAnnounceMaxSize, "bulk-announce-max-size", s.BulkAnnounceMaxSize, "Maximum number of message IDs per bulk-announce request")
+	flags.IntVar(&s.MaxConcurrentRequests, "max-concurrent-requests", s.MaxConcurrentRequests, "Maximum number of requests handled at once (0 for unlimited)")
+	flags.IntVar(&s.MessageIDMinLength, "message-id-min-length", s.MessageIDMinLength, "Minimum length of message IDs")
+	flags.IntVar(&s.MessageIDMaxLength, "message-id-max-length", s.MessageIDMaxLength, "Maximum length of message IDs (at most 200)")
+	flags.StringVar(&s.SeedNodes, "seed-nodes", s.SeedNodes, "Comma-separated Nodes to join the network through on first start")
+	flags.IntVar(&s.MembershipInterval, "membership-interval", s.MembershipInterval, "Minutes between refreshing the known Nodes from peers (0 disables)")
+	flags.IntVar(&s.MembershipPeers, "membership-peers", s.MembershipPeers, "Number of peers the known Nodes are refreshed from")
+	flags.IntVar(&s.AnnounceJitter, "announce-jitter", s.AnnounceJitter, "Seconds recovered announcements are spread over on startup, and maximum random delay of membership refreshes (0 disables)")
+	flags.IntVar(&s.NodeExpiry, "node-expiry", s.NodeExpiry, "Hours after which Nodes unseen by health checks are removed (0 disables)")
+	flags.StringVar(&s.AccessTokens, "access-tokens", s.AccessTokens, "Comma-separated identity:token pairs of clients owning their messages")
+	flags.StringVar(&s.WebhookURLs, "webhook-urls", s.WebhookURLs, "Comma-separated URLs notified of stored, deleted and expired messages")
+	flags.StringVar(&s.WebhookSecret, "webhook-secret", s.WebhookSecret, "Key webhook requests are signed with")
+	flags.IntVar(&s.CompactionInterval, "compaction-interval", s.CompactionInterval, "Minutes between compactions packing small messages into segment files (0 disables)")
+	flags.IntVar(&s.CompactionBlobSize, "compaction-blob-size", s.CompactionBlobSize, "Size in KiB up to which blobs are packed into segment files")
+	flags.IntVar(&s.OverloadQueueUsage, "overload-queue-usage", s.OverloadQueueUsage, "Percentage of max-queue-length queued jobs at which new messages are rejected (0 disables)")
+	flags.IntVar(&s.OverloadDiskUsage, "overload-disk-usage", s.OverloadDiskUsage, "Percentage of storage used at which new messages are rejected (0 disables)")
+	flags.IntVar(&s.OverloadErrorRate, "overload-error-rate", s.OverloadErrorRate, "Percentage of requests failing with server errors within a minute at which new messages are rejected (0 disables)")
+	flags.IntVar(&s.StorageShardDepth, "storage-shard-depth", s.StorageShardDepth, "Levels of subdirectories the filesystem backend places files in (0 stores them flat)")
+	flags.StringVar(&s.ReplicationTiers, "replication-tiers", s.ReplicationTiers, "Comma-separated size:factor pairs overriding replication-factor for messages smaller than size KB, e.g. 1024:5")
+	flags.StringVar(&s.PlacementHash, "placement-hash", s.PlacementHash, "Hash function placing messages on the hash ring, \"xxhash\" or \"sha256\"")
+	flags.BoolVar(&s.PlacementRebalance, "placement-rebalance", s.PlacementRebalance, "Confirm a change of placement-hash, moving messages to their new StorageNodes")
+	flags.StringVar(&s.IntegrityHash, "integrity-hash", s.IntegrityHash, "Hash function of message checksums, \"sha256\" or \"sha512\"")
+	flags.IntVar(&s.NotFoundCacheSize, "not-found-cache-size", s.NotFoundCacheSize, "Message IDs recently not found remembered to answer repeated gets (0 disables)")
+	flags.IntVar(&s.NotFoundCacheTTL, "not-found-cache-ttl", s.NotFoundCacheTTL, "Seconds a message ID not found is remembered")
+	flags.IntVar(&s.MessageCacheSize, "message-cache-size", s.MessageCacheSize, "KB of message content cached in memory for repeated gets (0 disables)")
+	flags.IntVar(&s.MessageCacheMaxSize, "message-cache-max-size", s.MessageCacheMaxSize, "KB of the largest message cached in memory")
+	flags.StringVar(&s.AdminToken, "admin-token", s.AdminToken, "Bearer token required for control actions administering the node, instead of auth-token (empty disables)")
+	flags.IntVar(&s.ResponseMaxSize, "response-max-size", s.ResponseMaxSize, "Maximum size in KB of batch-get and list-messages responses (0 disables)")
+}
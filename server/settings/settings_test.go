@@ -0,0 +1,74 @@
+package settings
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReplicationFactorFor(t *testing.T) {
+	//Listed out of order, the smallest tier a message fits applies
+	s := &Settings{ReplicationTiers: "1024:3, 64:5", ReplicationFactor: 2}
+
+	tests := []struct {
+		size int64
+		want int
+	}{
+		{0, 5},
+		{1, 5},
+		{64*1024 - 1, 5},
+		{64 * 1024, 3},
+		{64*1024 + 1, 3},
+		{1024*1024 - 1, 3},
+		{1024 * 1024, 2},
+		{1 << 40, 2},
+	}
+	for _, test := range tests {
+		if got := s.ReplicationFactorFor(test.size); got != test.want {
+			t.Errorf("ReplicationFactorFor(%d) = %d, want %d", test.size, got, test.want)
+		}
+	}
+}
+
+func TestReplicationFactorForWithoutTiers(t *testing.T) {
+	for _, tiers := range []string{"", " , ", "big:3", "64:x", "64:0"} {
+		s := &Settings{ReplicationTiers: tiers, ReplicationFactor: 4}
+		if got := s.ReplicationFactorFor(1024); got != 4 {
+			t.Errorf("ReplicationFactorFor() with tiers %q = %d, want ReplicationFactor", tiers, got)
+		}
+	}
+}
+
+func TestMaxReplicationFactor(t *testing.T) {
+	tests := []struct {
+		tiers string
+		want  int
+	}{
+		{"", 2},
+		{"1024:1", 2},
+		{"1024:3, 64:5", 5},
+	}
+	for _, test := range tests {
+		s := &Settings{ReplicationTiers: test.tiers, ReplicationFactor: 2}
+		if got := s.MaxReplicationFactor(); got != test.want {
+			t.Errorf("MaxReplicationFactor() with tiers %q = %d, want %d", test.tiers, got, test.want)
+		}
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	tests := []struct {
+		allowed string
+		want    []string
+	}{
+		{"", nil},
+		{" , ", nil},
+		{"text/plain", []string{"text/plain"}},
+		{" Text/Plain, ,IMAGE/* ", []string{"text/plain", "image/*"}},
+	}
+	for _, test := range tests {
+		s := &Settings{AllowedContentTypes: test.allowed}
+		if got := s.ContentTypes(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ContentTypes() of %q = %q, want %q", test.allowed, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package settings
+
+import (
+	"mime"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"subframe/server/logger"
+	"subframe/structs/node"
+)
+
+//Validate checks the settings s and returns a description of every problem found, so all of them
+//can be reported at once instead of failing deep in startup
+func (s *Settings) Validate() (problems []string) {
+	check := func(valid bool, problem string) {
+		if !valid {
+			problems = append(problems, problem)
+		}
+	}
+
+	check(s.DataPath != "", "data-dir must not be empty")
+	check(validAddress(s.LocalAddress, false), "local-address has to be a host:port, got \""+s.LocalAddress+"\"")
+	check(s.GRPCAddress == "" || validAddress(s.GRPCAddress, false), "grpc-address has to be a host:port, got \""+s.GRPCAddress+"\"")
+	check(s.GRPCAddress == "" || s.GRPCAddress != s.LocalAddress, "grpc-address has to differ from local-address")
+	check(validNodeAddress(s.RemoteAddress), "remote-address has to be a host:port with IPv6 addresses in brackets, got \""+s.RemoteAddress+"\"")
+	check(s.BootstrapNode == "" || validNodeAddress(s.BootstrapNode), "bootstrap-node has to be a host:port with IPv6 addresses in brackets, got \""+s.BootstrapNode+"\"")
+	check((s.TLSCertFile == "") == (s.TLSKeyFile == ""), "tls-cert and tls-key have to be set together")
+	check(s.StorageBackend == "filesystem" || s.StorageBackend == "memory", "storage-backend has to be either filesystem or memory")
+	check(s.DatabaseBackend == "sqlite" || s.DatabaseBackend == "memory", "database-backend has to be either sqlite or memory")
+	check(s.StorageNodeSelection == "random" || s.StorageNodeSelection == "weighted", "storage-node-selection has to be either random or weighted")
+	check(s.PlacementHash == "xxhash" || s.PlacementHash == "sha256", "placement-hash has to be either xxhash or sha256")
+	check(s.IntegrityHash == "sha256" || s.IntegrityHash == "sha512", "integrity-hash has to be either sha256 or sha512")
+
+	check(s.DiskSpace > 0, "disk-space has to be positive")
+	check(s.MessageMaxSize > 0, "message-max-size has to be positive")
+	check(s.MessageIDMinLength >= 1, "message-id-min-length has to be at least 1")
+	check(s.MessageIDMaxLength >= s.MessageIDMinLength && s.MessageIDMaxLength <= MessageIDLengthLimit, "message-id-max-length has to be between message-id-min-length and "+strconv.Itoa(MessageIDLengthLimit))
+	check(s.MessageMaxStoreTime > 0, "message-max-store-time has to be positive")
+	check(s.CompactionInterval >= 0, "compaction-interval must not be negative")
+	check(s.CompactionBlobSize > 0, "compaction-blob-size has to be positive")
+	check(s.MessageMinCheckDelay >= 0, "message-min-check-delay must not be negative")
+	check(s.ReplicationFactor >= 1, "replication-factor has to be at least 1")
+	check(s.CoordinatorAnnounceCount >= 1, "coordinator-announce-count has to be at least 1")
+	check(s.ReadQuorum >= 0 && s.ReadQuorum <= s.ReplicationFactor, "read-quorum has to be between 0 and replication-factor")
+	sizes := make(map[int64]bool)
+	for _, tier := range s.ParseReplicationTiers() {
+		check(tier.MaxSize > 0 && tier.Factor >= 1, "replication-tiers has to list size:factor pairs of a positive size in KB and a factor of at least 1")
+		check(tier.MaxSize <= 0 || !sizes[tier.MaxSize], "replication-tiers must not repeat a size")
+		sizes[tier.MaxSize] = true
+	}
+	check(s.JobWorkers >= 1, "job-workers has to be at least 1")
+	check(s.QueueMaxLength >= 0, "max-queue-length must not be negative")
+	check(s.CompressionLevel >= 1 && s.CompressionLevel <= 9, "compression-level has to be between 1 and 9")
+	check(s.CompressionThreshold >= 0, "compression-threshold must not be negative")
+	check(s.ResponseCompressionThreshold >= 0, "response-compression-threshold must not be negative")
+	check(s.BatchGetMaxSize >= 1, "batch-get-max-size has to be at least 1")
+	check(s.ResponseMaxSize >= 0, "response-max-size must not be negative")
+	check(s.BulkAnnounceMaxSize >= 1, "bulk-announce-max-size has to be at least 1")
+	check(s.AntiEntropyPeers >= 1, "anti-entropy-peers has to be at least 1")
+	check(s.MembershipInterval >= 0, "membership-interval must not be negative")
+	check(s.MembershipPeers >= 1, "membership-peers has to be at least 1")
+	check(s.AnnounceJitter >= 0, "announce-jitter must not be negative")
+	check(s.NodeExpiry >= 0, "node-expiry must not be negative")
+	check(s.RateLimitRequests >= 0, "rate-limit must not be negative")
+	check(s.MaxConcurrentRequests >= 0, "max-concurrent-requests must not be negative")
+	check(s.RateLimitRequests == 0 || s.RateLimitBurst >= 1, "rate-limit-burst has to be at least 1")
+	check(s.NodeRequestTimeout > 0, "node-request-timeout has to be positive")
+	check(s.NodeConnectTimeout > 0, "node-connect-timeout has to be positive")
+	check(s.NodeRequestAttempts >= 1, "node-request-attempts has to be at least 1")
+	check(s.NodeRequestRetryDelay >= 0, "node-request-retry-delay must not be negative")
+	check(s.NodeMaxIdleConnections >= 0, "node-max-idle-connections must not be negative")
+	check(s.SignatureMaxAge > 0, "signature-max-age has to be positive")
+	check(s.ShutdownTimeout >= 0, "shutdown-timeout must not be negative")
+	check(s.StorageGetTimeout >= 0, "storage-get-timeout must not be negative")
+	check(s.StoragePutTimeout >= 0, "storage-put-timeout must not be negative")
+
+	level, validLevel := logger.ParseLevel(s.LogLevel)
+	check(validLevel && level != logger.LogtypeFatal, "log-level has to be one of debug, info, warn or error")
+	check(s.LogFormat == "text" || s.LogFormat == "json", "log-format has to be either text or json")
+	check(s.AccessLogFormat == "common" || s.AccessLogFormat == "json" || s.AccessLogFormat == "off", "access-log-format has to be one of common, json or off")
+	check(s.TracingEndpoint == "" || validURL(s.TracingEndpoint), "tracing-endpoint has to be an http or https URL, got \""+s.TracingEndpoint+"\"")
+	check(s.TracingSampleRate >= 0 && s.TracingSampleRate <= 100, "tracing-sample-rate has to be between 0 and 100")
+	for _, address := range strings.Split(s.SeedNodes, ",") {
+		address = strings.TrimSpace(address)
+		check(address == "" || validNodeAddress(address), "seed-nodes has to list host:port addresses with IPv6 addresses in brackets, got \""+address+"\"")
+	}
+	tokens := make(map[string]bool)
+	for _, pair := range strings.Split(s.AccessTokens, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		separator := strings.Index(pair, ":")
+		identity, token := "", ""
+		if separator >= 0 {
+			identity, token = strings.TrimSpace(pair[:separator]), strings.TrimSpace(pair[separator+1:])
+		}
+		check(ValidIdentity(identity) && token != "", "access-tokens has to list identity:token pairs with identities of letters, digits and ._@-, got \""+identity+"\"")
+		check(!tokens[token] && token != s.AuthToken, "access-tokens must not repeat a token or reuse auth-token")
+		tokens[token] = true
+	}
+	check(s.AccessTokens == "" || s.AuthToken != "", "access-tokens requires auth-token, which manages the Node")
+	check(s.AccessTokens == "" || s.ClusterSecret != "", "access-tokens requires cluster-secret, which Nodes sign requests with to replicate private messages")
+	check(s.AdminToken == "" || (s.AdminToken != s.AuthToken && !tokens[s.AdminToken]), "admin-token must not reuse auth-token or one of access-tokens")
+	for _, endpoint := range s.WebhookEndpoints() {
+		check(validURL(endpoint), "webhook-urls has to list http or https URLs, got \""+endpoint+"\"")
+	}
+	check(s.StorageShardDepth >= 0 && s.StorageShardDepth <= 3, "storage-shard-depth has to be between 0 and 3")
+	check(s.OverloadQueueUsage >= 0 && s.OverloadQueueUsage <= 100, "overload-queue-usage has to be between 0 and 100")
+	check(s.OverloadDiskUsage >= 0 && s.OverloadDiskUsage <= 100, "overload-disk-usage has to be between 0 and 100")
+	check(s.OverloadErrorRate >= 0 && s.OverloadErrorRate <= 100, "overload-error-rate has to be between 0 and 100")
+	check(s.NotFoundCacheSize >= 0, "not-found-cache-size must not be negative")
+	check(s.NotFoundCacheTTL > 0, "not-found-cache-ttl has to be positive")
+	check(s.MessageCacheSize >= 0, "message-cache-size must not be negative")
+	check(s.MessageCacheMaxSize > 0, "message-cache-max-size has to be positive")
+	for _, origin := range s.AllowedOrigins() {
+		check(validOrigin(origin), "cors-allowed-origins has to list * or origins like https://example.com, got \""+origin+"\"")
+	}
+	for _, contentType := range s.ContentTypes() {
+		check(validContentType(contentType), "allowed-content-types has to list media types like text/plain or image/*, got \""+contentType+"\"")
+	}
+	return problems
+}
+
+//validContentType returns whether contentType is a media type without parameters, whose subtype may be *
+func validContentType(contentType string) bool {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	return err == nil && len(params) == 0 && strings.Count(mediaType, "/") == 1 && !strings.HasPrefix(mediaType, "*/") &&
+		!strings.HasSuffix(mediaType, "/")
+}
+
+//ValidIdentity returns whether identity may be listed in AccessTokens and the readers of a message
+func ValidIdentity(identity string) bool {
+	if identity == "" || len(identity) > 128 {
+		return false
+	}
+	for _, c := range identity {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("._@-", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+//validAddress returns whether address is a host:port with a valid port. The host may only be empty if requireHost is false
+func validAddress(address string, requireHost bool) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || (requireHost && host == "") {
+		return false
+	}
+	number, err := strconv.Atoi(port)
+	return err == nil && number >= 0 && number <= 65535
+}
+
+//validNodeAddress returns whether address is a valid address of a Node, see node.NormalizeAddress
+func validNodeAddress(address string) bool {
+	_, err := node.NormalizeAddress(address)
+	return err == nil
+}
+
+//validOrigin returns whether origin is * or a scheme and host without path, as browsers send it in the Origin header
+func validOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "" &&
+		parsed.Path == "" && parsed.RawQuery == "" && parsed.Fragment == "" && parsed.User == nil
+}
+
+//validURL returns whether address is an absolute http or https URL
+func validURL(address string) bool {
+	parsed, err := url.Parse(address)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
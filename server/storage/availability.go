@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	. "subframe/status"
+	"sync"
+	"time"
+)
+
+//unavailableWindow is how long Check reports the storage unavailable after a backend operation failed
+const unavailableWindow = 30 * time.Second
+
+//unavailableError wraps errors of backend operations other than missing blobs, e.g. an unmounted or unreadable
+//data directory, as opposed to errors decoding what the backend returned
+type unavailableError struct {
+	err error
+}
+
+func (e unavailableError) Error() string {
+	return "storage backend unavailable: " + e.err.Error()
+}
+
+func (e unavailableError) Unwrap() error {
+	return e.err
+}
+
+var failureMutex sync.Mutex
+var lastFailure error
+var lastFailureAt time.Time
+
+//checkBackend wraps err as unavailableError and records it for Check, unless it is nil, reports a missing blob or an
+//abandoned put
+func checkBackend(err error) error {
+	if err == nil || os.IsNotExist(err) || err == errPutAbandoned {
+		return err
+	}
+	if _, wrapped := err.(unavailableError); wrapped {
+		return err
+	}
+	failureMutex.Lock()
+	lastFailure, lastFailureAt = err, time.Now()
+	failureMutex.Unlock()
+	return unavailableError{err: err}
+}
+
+//forgetFailures clears the failure recorded by checkBackend, as the backends are initialized anew
+func forgetFailures() {
+	failureMutex.Lock()
+	lastFailure = nil
+	failureMutex.Unlock()
+}
+
+//recentFailure returns the last error of a backend operation if it occurred within unavailableWindow
+func recentFailure() error {
+	failureMutex.Lock()
+	defer failureMutex.Unlock()
+	if lastFailure == nil || time.Since(lastFailureAt) > unavailableWindow {
+		return nil
+	}
+	return unavailableError{err: lastFailure}
+}
+
+//errorStatus returns the status for a failed operation on a message: http.StatusNotFound if a blob is missing,
+//StorageUnavailable if the backend failed, and fallback for all other errors
+func errorStatus(err error, fallback int) int {
+	if os.IsNotExist(err) {
+		return http.StatusNotFound
+	}
+	var unavailable unavailableError
+	if errors.As(err, &unavailable) {
+		return StorageUnavailable
+	}
+	return fallback
+}
+
+//monitoredBackend passes every operation on to backend, and records its failures with checkBackend
+type monitoredBackend struct {
+	backend Backend
+}
+
+func (b monitoredBackend) Get(key string) ([]byte, error) {
+	content, err := b.backend.Get(key)
+	return content, checkBackend(err)
+}
+
+func (b monitoredBackend) Open(key string) (io.ReadCloser, error) {
+	content, err := b.backend.Open(key)
+	return content, checkBackend(err)
+}
+
+func (b monitoredBackend) Stat(key string) (BlobInfo, error) {
+	info, err := b.backend.Stat(key)
+	return info, checkBackend(err)
+}
+
+func (b monitoredBackend) Put(key string, content []byte) error {
+	return checkBackend(b.backend.Put(key, content))
+}
+
+func (b monitoredBackend) PutContext(ctx context.Context, key string, content []byte) error {
+	return checkBackend(putContext(ctx, b.backend, key, content))
+}
+
+func (b monitoredBackend) Delete(key string) error {
+	return checkBackend(b.backend.Delete(key))
+}
+
+func (b monitoredBackend) List() ([]BlobInfo, error) {
+	blobs, err := b.backend.List()
+	return blobs, checkBackend(err)
+}
+
+func (b monitoredBackend) Check() error {
+	return checkBackend(b.backend.Check())
+}
+
+//Compact compacts backend if it supports it
+func (b monitoredBackend) Compact(maxBlobSize int64) (CompactionResult, error) {
+	backend, ok := b.backend.(compactor)
+	if !ok {
+		return CompactionResult{}, nil
+	}
+	result, err := backend.Compact(maxBlobSize)
+	return result, checkBackend(err)
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	. "subframe/status"
+	"subframe/structs/message"
+	"syscall"
+	"testing"
+)
+
+//failingBackend fails every operation with an I/O error, like a backend on a failing disk
+type failingBackend struct{}
+
+func (failingBackend) Get(key string) ([]byte, error) {
+	return nil, syscall.EIO
+}
+
+func (failingBackend) Open(key string) (io.ReadCloser, error) {
+	return nil, syscall.EIO
+}
+
+func (failingBackend) Stat(key string) (BlobInfo, error) {
+	return BlobInfo{}, syscall.EIO
+}
+
+func (failingBackend) Put(key string, content []byte) error {
+	return syscall.EIO
+}
+
+func (failingBackend) Delete(key string) error {
+	return syscall.EIO
+}
+
+func (failingBackend) List() ([]BlobInfo, error) {
+	return nil, syscall.EIO
+}
+
+func (failingBackend) Check() error {
+	return nil
+}
+
+func TestFailingBackendIsUnavailable(t *testing.T) {
+	setupStorage(t, "memory")
+	if status := putLogged(t, message.Message{ID: "stored", Content: "content"}, false); status != http.StatusOK {
+		t.Fatalf("put = %d", status)
+	}
+	if err := Check(); err != nil {
+		t.Fatalf("Check() before the failure = %v", err)
+	}
+
+	messages, metadata, contents = monitoredBackend{backend: failingBackend{}}, monitoredBackend{backend: failingBackend{}}, monitoredBackend{backend: failingBackend{}}
+	t.Cleanup(forgetFailures)
+
+	if _, status := GetContext(context.Background(), "stored"); status != StorageUnavailable {
+		t.Errorf("Get() = %d, want %d", status, StorageUnavailable)
+	}
+	if _, _, status := Open("stored"); status != StorageUnavailable {
+		t.Errorf("Open() = %d, want %d", status, StorageUnavailable)
+	}
+	if status := PutContext(context.Background(), message.Message{ID: "new", Content: "content"}); status != StorageUnavailable {
+		t.Errorf("Put() = %d, want %d", status, StorageUnavailable)
+	}
+	//The backend itself passes its check, the failed operations report the storage unavailable
+	if err := Check(); err == nil {
+		t.Error("Check() after failed operations reports the storage available")
+	}
+
+	Init()
+	if err := Check(); err != nil {
+		t.Errorf("Check() after initializing the storage anew = %v", err)
+	}
+}
+
+func TestMissingBlobsAreNoFailure(t *testing.T) {
+	setupStorage(t, "memory")
+	if _, status := GetContext(context.Background(), "missing"); status != http.StatusNotFound {
+		t.Errorf("Get() of a missing message = %d, want %d", status, http.StatusNotFound)
+	}
+	if err := Check(); err != nil {
+		t.Errorf("Check() after a missing message = %v", err)
+	}
+}
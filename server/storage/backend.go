@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"subframe/server/settings"
+	. "subframe/status"
+	"time"
+)
+
+//ErrNotExist is returned by backends for keys that are not stored
+var ErrNotExist = os.ErrNotExist
+
+//BlobInfo describes a blob stored in a Backend
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+//Backend stores blobs of bytes by key. Implementations have to be safe for concurrent use
+type Backend interface {
+	//Get returns the content stored at key
+	Get(key string) ([]byte, error)
+	//Open opens the content stored at key for streaming. The caller has to close it. The content should implement
+	//io.Seeker, so ranges of it can be read without reading the content before
+	Open(key string) (io.ReadCloser, error)
+	//Stat returns information about the blob stored at key
+	Stat(key string) (BlobInfo, error)
+	//Put stores content at key, replacing any existing blob. Readers never see partially written content
+	Put(key string, content []byte) error
+	//Delete removes the blob stored at key
+	Delete(key string) error
+	//List returns all stored blobs sorted by key
+	List() ([]BlobInfo, error)
+	//Check returns an error if the backend cannot currently store blobs
+	Check() error
+}
+
+//compactor is implemented by backends which can pack small blobs to use fewer files
+type compactor interface {
+	//Compact packs blobs of at most maxBlobSize bytes, and reclaims the space of deleted packed blobs
+	Compact(maxBlobSize int64) (CompactionResult, error)
+}
+
+//messages holds the (possibly compressed and encrypted) content of stored messages
+var messages Backend
+
+//metadata holds the Metadata of stored messages
+var metadata Backend
+
+//newBackend creates the backend selected in settings.StorageBackend. name separates the blobs of different backends.
+//Failures of the backend other than missing blobs are returned as unavailableError
+func newBackend(backend string, name string) Backend {
+	switch backend {
+	case "filesystem":
+		dir := settings.Current().DataPath + "/" + name
+		createDirIfNotExist(dir)
+		layout := shardLayout{dir: dir, depth: settings.Current().StorageShardDepth}
+		if err := migrateLayout(layout); err != nil {
+			log.Fatal(GenericInternalError, "Error moving files of "+dir+" to a shard depth of "+strconv.Itoa(layout.depth)+": "+err.Error())
+		}
+		segments, err := openSegments(layout)
+		if err != nil {
+			log.Fatal(GenericInternalError, "Error opening segments of "+dir+": "+err.Error())
+		}
+		log.Info(OK, "Initialized "+dir)
+		return monitoredBackend{backend: &filesystemBackend{layout: layout, segments: segments}}
+	case "memory":
+		return monitoredBackend{backend: newMemoryBackend()}
+	}
+	log.Fatal(GenericInputError, "Unknown storage backend \""+backend+"\"")
+	return nil
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+//TestBackendContract runs the same checks against every Backend implementation
+func TestBackendContract(t *testing.T) {
+	for _, name := range []string{"filesystem", "memory"} {
+		t.Run(name, func(t *testing.T) {
+			setupStorage(t, name)
+			backend := newBackend(name, "contract")
+
+			if _, err := backend.Get("missing"); !os.IsNotExist(err) {
+				t.Errorf("Get() of a missing key = %v, want ErrNotExist", err)
+			}
+			if _, err := backend.Stat("missing"); !os.IsNotExist(err) {
+				t.Errorf("Stat() of a missing key = %v, want ErrNotExist", err)
+			}
+			if err := backend.Delete("missing"); !os.IsNotExist(err) {
+				t.Errorf("Delete() of a missing key = %v, want ErrNotExist", err)
+			}
+
+			for _, key := range []string{"b", "a", "c"} {
+				if err := backend.Put(key, []byte("content of "+key)); err != nil {
+					t.Fatalf("Put(%s) = %v", key, err)
+				}
+			}
+			if err := backend.Put("b", []byte("replaced")); err != nil {
+				t.Fatalf("Put() replacing a blob = %v", err)
+			}
+
+			if content, err := backend.Get("b"); err != nil || string(content) != "replaced" {
+				t.Errorf("Get() = %q, %v, want the replacing content", content, err)
+			}
+			reader, err := backend.Open("a")
+			if err != nil {
+				t.Fatalf("Open() = %v", err)
+			}
+			content, err := ioutil.ReadAll(reader)
+			reader.Close()
+			if err != nil || string(content) != "content of a" {
+				t.Errorf("Open() read %q, %v, want %q", content, err, "content of a")
+			}
+			if info, err := backend.Stat("b"); err != nil || info.Key != "b" || info.Size != int64(len("replaced")) {
+				t.Errorf("Stat() = %+v, %v, want key b of %d bytes", info, err, len("replaced"))
+			}
+
+			if err := backend.Delete("c"); err != nil {
+				t.Errorf("Delete() = %v", err)
+			}
+			if _, err := backend.Get("c"); !os.IsNotExist(err) {
+				t.Errorf("Get() of a deleted key = %v, want ErrNotExist", err)
+			}
+
+			blobs, err := backend.List()
+			if err != nil {
+				t.Fatalf("List() = %v", err)
+			}
+			if len(blobs) != 2 || blobs[0].Key != "a" || blobs[1].Key != "b" {
+				t.Errorf("List() = %+v, want a and b sorted by key", blobs)
+			}
+			if err := backend.Check(); err != nil {
+				t.Errorf("Check() = %v", err)
+			}
+		})
+	}
+}
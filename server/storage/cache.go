@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"container/list"
+	"subframe/server/metrics"
+	"subframe/server/settings"
+	"subframe/structs/message"
+	"sync"
+	"time"
+)
+
+var cacheHits = metrics.NewCounterVec("subframe_message_cache_hits_total", "Gets answered from the message cache.")
+
+var cacheMisses = metrics.NewCounterVec("subframe_message_cache_misses_total", "Gets of messages not in the message cache, while it is enabled.")
+
+//messageCache holds recently read messages with their decoded content, so repeated gets do not read and decode them
+//again. The least recently read messages are evicted beyond settings.MessageCacheSize KB of content
+type messageCache struct {
+	mutex   sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	size    int64
+	//changes counts invalidations, so messages read before they were replaced or deleted are not cached after it
+	changes uint64
+}
+
+var cache = messageCache{order: list.New(), entries: make(map[string]*list.Element)}
+
+//generation returns the state to pass to add for a read of storage starting now
+func (c *messageCache) generation() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.changes
+}
+
+//get returns the cached message id. Expired messages are dropped, so they are reported by storage
+func (c *messageCache) get(id string) (msg message.Message, ok bool) {
+	if settings.Current().MessageCacheSize <= 0 {
+		return message.Message{}, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[id]
+	if ok {
+		msg = *element.Value.(*message.Message)
+		if msg.ExpiresAt != nil && time.Now().After(*msg.ExpiresAt) {
+			c.remove(element)
+			ok = false
+		}
+	}
+	if !ok {
+		cacheMisses.Inc()
+		return message.Message{}, false
+	}
+	cacheHits.Inc()
+	c.order.MoveToFront(element)
+	return msg, true
+}
+
+//add caches msg read from storage, unless it exceeds settings.MessageCacheMaxSize, or a message was replaced or
+//deleted after generation returned since
+func (c *messageCache) add(msg message.Message, since uint64) {
+	if int64(len(msg.Content)) > int64(settings.Current().MessageCacheMaxSize)*1024 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	limit := int64(settings.Current().MessageCacheSize) * 1024
+	if limit <= 0 || c.changes != since {
+		return
+	}
+	if element, ok := c.entries[msg.ID]; ok {
+		c.remove(element)
+	}
+	c.entries[msg.ID] = c.order.PushFront(&msg)
+	c.size += int64(len(msg.Content))
+	//The size may have been lowered by a reload
+	for c.size > limit {
+		c.remove(c.order.Back())
+	}
+}
+
+//invalidate drops id from the cache. Must be called once its stored content changed, so reads can no longer find
+//the previous content
+func (c *messageCache) invalidate(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.changes++
+	if element, ok := c.entries[id]; ok {
+		c.remove(element)
+	}
+}
+
+//remove drops element from the cache. Must be called with the mutex held
+func (c *messageCache) remove(element *list.Element) {
+	msg := c.order.Remove(element).(*message.Message)
+	delete(c.entries, msg.ID)
+	c.size -= int64(len(msg.Content))
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+//errPutAbandoned is returned by backends which did not commit the content of a put, as the put was abandoned
+var errPutAbandoned = errors.New("put abandoned before its content was committed")
+
+//putCommit decides between committing the content of a put and abandoning the put, whichever happens first
+type putCommit struct {
+	mutex     sync.Mutex
+	committed bool
+	abandoned bool
+}
+
+type putCommitKey struct{}
+
+//AbandonablePut returns a context for PutContext and PutReplica, whose put can be abandoned with abandon, e.g. once
+//it timed out. abandon returns true if the content of the put is not committed and never will be, and false if it
+//already is, so the put has to be awaited
+func AbandonablePut(ctx context.Context) (putCtx context.Context, abandon func() bool) {
+	commit := &putCommit{}
+	return context.WithValue(ctx, putCommitKey{}, commit), func() bool {
+		commit.mutex.Lock()
+		defer commit.mutex.Unlock()
+		commit.abandoned = !commit.committed
+		return commit.abandoned
+	}
+}
+
+//commitPut returns whether the put of ctx may commit its content, which cannot be abandoned afterwards. Puts are not
+//committed once ctx is done
+func commitPut(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	commit, abandonable := ctx.Value(putCommitKey{}).(*putCommit)
+	if !abandonable {
+		return true
+	}
+	commit.mutex.Lock()
+	defer commit.mutex.Unlock()
+	commit.committed = !commit.abandoned
+	return commit.committed
+}
+
+//contextPutter is implemented by backends which check commitPut right before committing content, so slow writes can
+//still be abandoned
+type contextPutter interface {
+	//PutContext is Put, but returns errPutAbandoned without replacing the blob of key if commitPut refuses to commit
+	PutContext(ctx context.Context, key string, content []byte) error
+}
+
+//putContext stores content at key of backend, unless the put of ctx is abandoned before its content is committed
+func putContext(ctx context.Context, backend Backend, key string, content []byte) error {
+	if putter, ok := backend.(contextPutter); ok {
+		return putter.PutContext(ctx, key, content)
+	}
+	if !commitPut(ctx) {
+		return errPutAbandoned
+	}
+	return backend.Put(key, content)
+}
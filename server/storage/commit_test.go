@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"subframe/server/database"
+	. "subframe/status"
+	"subframe/structs/message"
+	"testing"
+	"time"
+)
+
+//slowBackend blocks puts of messages until release is closed, signalling on entered once they block. With
+//commitFirst, the content is committed before blocking, like a backend syncing its files after renaming them
+type slowBackend struct {
+	Backend
+	entered     chan bool
+	release     chan bool
+	commitFirst bool
+}
+
+func (b slowBackend) PutContext(ctx context.Context, key string, content []byte) error {
+	if b.commitFirst && !commitPut(ctx) {
+		return errPutAbandoned
+	}
+	b.entered <- true
+	<-b.release
+	if b.commitFirst {
+		return b.Backend.Put(key, content)
+	}
+	return putContext(ctx, b.Backend, key, content)
+}
+
+//slowPut puts msg into a slowBackend with a deadline of timeout, abandoning the put on the deadline like
+//withStorageTimeout does. Returns whether the put was abandoned, and the status of the put once it is released.
+//Stored puts are logged to the database like putLogged does
+func slowPut(t *testing.T, msg message.Message, timeout time.Duration, commitFirst bool) (abandoned bool, status int) {
+	t.Helper()
+	slow := slowBackend{Backend: messages, entered: make(chan bool, 1), release: make(chan bool), commitFirst: commitFirst}
+	previous := messages
+	messages = slow
+	t.Cleanup(func() { messages = previous })
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx, abandon := AbandonablePut(ctx)
+	result := make(chan int, 1)
+	go func() { result <- PutReplica(ctx, msg) }()
+
+	<-slow.entered
+	<-ctx.Done()
+	abandoned = abandon()
+	close(slow.release)
+	if status = <-result; status == http.StatusOK {
+		database.LogMessageStorage(msg.ID, msg.Version)
+	}
+	return abandoned, status
+}
+
+func TestAbandonedPutIsNotStored(t *testing.T) {
+	for _, backend := range []string{"memory", "filesystem"} {
+		t.Run(backend, func(t *testing.T) {
+			setupStorage(t, backend)
+
+			abandoned, status := slowPut(t, message.Message{ID: "abc", Content: "content", Version: 1}, 10*time.Millisecond, false)
+			if !abandoned || status != StorageRequestCanceled {
+				t.Fatalf("slow put = %d, abandoned %v, want %d", status, abandoned, StorageRequestCanceled)
+			}
+			if _, err := messages.Stat("abc"); !os.IsNotExist(err) {
+				t.Errorf("content of the abandoned message is stored: %v", err)
+			}
+			if _, exists, _ := readMetadata("abc"); exists {
+				t.Error("metadata of the abandoned message is stored")
+			}
+			if err := Check(); err != nil {
+				t.Errorf("Check() after an abandoned put = %v, want the backend available", err)
+			}
+		})
+	}
+}
+
+func TestAbandonedReplacementKeepsMessage(t *testing.T) {
+	setupStorage(t, "memory")
+	if status := putLogged(t, message.Message{ID: "abc", Content: "first", Version: 1}, true); status != http.StatusOK {
+		t.Fatalf("put = %d", status)
+	}
+
+	abandoned, status := slowPut(t, message.Message{ID: "abc", Content: "second", Version: 2}, 10*time.Millisecond, false)
+	if !abandoned || status != StorageRequestCanceled {
+		t.Fatalf("slow replacement = %d, abandoned %v, want %d", status, abandoned, StorageRequestCanceled)
+	}
+	if msg, status := Get("abc"); status != http.StatusOK || msg.Content != "first" || msg.Version != 1 {
+		t.Errorf("Get() = %q version %d (%d), want the replaced message", msg.Content, msg.Version, status)
+	}
+}
+
+func TestCommittedPutIsNotAbandoned(t *testing.T) {
+	setupStorage(t, "memory")
+
+	abandoned, status := slowPut(t, message.Message{ID: "abc", Content: "content", Version: 1}, 10*time.Millisecond, true)
+	if abandoned || status != http.StatusOK {
+		t.Fatalf("slow put = %d, abandoned %v, want it committed", status, abandoned)
+	}
+	if msg, status := Get("abc"); status != http.StatusOK || msg.Content != "content" {
+		t.Errorf("Get() = %q (%d), want the committed message", msg.Content, status)
+	}
+}
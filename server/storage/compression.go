@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"subframe/server/settings"
+)
+
+//compress gzips content if it exceeds settings.CompressionThreshold and compression actually saves space
+func compress(content []byte) (stored []byte, compressed bool, err error) {
+	if settings.Current().CompressionThreshold <= 0 || len(content) < settings.Current().CompressionThreshold {
+		return content, false, nil
+	}
+
+	var buffer bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buffer, settings.Current().CompressionLevel)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err = writer.Write(content); err != nil {
+		return nil, false, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, false, err
+	}
+
+	if buffer.Len() >= len(content) {
+		return content, false, nil
+	}
+	return buffer.Bytes(), true, nil
+}
+
+//decompress reverses compress for content stored with meta
+func decompress(stored []byte, meta Metadata) ([]byte, error) {
+	if !meta.Compressed {
+		return stored, nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+//gzipReadCloser closes both the gzip stream and the underlying file
+type gzipReadCloser struct {
+	*gzip.Reader
+	file io.Closer
+}
+
+func (g gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+//decompressStream wraps a stored file in a decompressing reader if needed
+func decompressStream(file io.ReadCloser, meta Metadata) (io.ReadCloser, error) {
+	if !meta.Compressed {
+		return file, nil
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	return gzipReadCloser{reader, file}, nil
+}
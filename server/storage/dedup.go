@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	. "subframe/status"
+	"sync"
+	"sync/atomic"
+)
+
+//contents holds message content shared by identical messages, keyed by its checksum
+var contents Backend
+
+//references holds the contentReference of every blob in contents, keyed by the same checksum
+var references Backend
+
+//contentMutex serializes changes of reference counts
+var contentMutex sync.Mutex
+
+//contentReference counts the messages sharing a blob in contents
+type contentReference struct {
+	Count      int   `json:"count"`
+	Compressed bool  `json:"compressed"`
+	Encrypted  bool  `json:"encrypted"`
+	StoredSize int64 `json:"storedSize"`
+}
+
+//readReference loads the reference of the content with hash
+func readReference(hash string) (ref contentReference, exists bool, err error) {
+	data, err := references.Get(hash)
+	if os.IsNotExist(err) {
+		return contentReference{}, false, nil
+	}
+	if err != nil {
+		return contentReference{}, false, err
+	}
+	err = json.Unmarshal(data, &ref)
+	return ref, err == nil, err
+}
+
+//writeReference persists the reference of the content with hash
+func writeReference(hash string, ref contentReference) error {
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	return references.Put(hash, data)
+}
+
+//putContent stores content in contents, or references the stored copy of identical content.
+//deduplicated is false if different content with the same checksum is already stored, which has to be stored separately
+func putContent(content []byte) (meta Metadata, deduplicated bool, err error) {
+	hash := checksum(content)
+
+	contentMutex.Lock()
+	defer contentMutex.Unlock()
+
+	ref, exists, err := readReference(hash)
+	if err != nil {
+		return Metadata{}, false, err
+	}
+	if exists {
+		stored, err := contents.Get(hash)
+		if err != nil {
+			return Metadata{}, false, err
+		}
+		existing, err := decode(stored, Metadata{Compressed: ref.Compressed, Encrypted: ref.Encrypted})
+		if err != nil {
+			return Metadata{}, false, err
+		}
+		if !bytes.Equal(existing, content) {
+			log.Warn(GenericInternalError, "Checksum collision of content "+hash+", storing it separately")
+			return Metadata{}, false, nil
+		}
+		ref.Count++
+	} else {
+		var stored []byte
+		stored, meta, err = encode(content)
+		if err == nil {
+			err = contents.Put(hash, stored)
+		}
+		if err != nil {
+			return Metadata{}, false, err
+		}
+		atomic.AddInt64(&usedBytes, int64(len(stored)))
+		ref = contentReference{Count: 1, Compressed: meta.Compressed, Encrypted: meta.Encrypted, StoredSize: meta.StoredSize}
+	}
+
+	if err := writeReference(hash, ref); err != nil {
+		if !exists {
+			contents.Delete(hash)
+			atomic.AddInt64(&usedBytes, -ref.StoredSize)
+		}
+		return Metadata{}, false, err
+	}
+	return Metadata{
+		Checksum:    hash,
+		Size:        int64(len(content)),
+		StoredSize:  ref.StoredSize,
+		Compressed:  ref.Compressed,
+		Encrypted:   ref.Encrypted,
+		ContentHash: hash,
+	}, true, nil
+}
+
+//releaseContent drops a reference to the content with hash, and removes the content once it is no longer referenced
+func releaseContent(hash string) error {
+	contentMutex.Lock()
+	defer contentMutex.Unlock()
+
+	ref, exists, err := readReference(hash)
+	if err != nil || !exists {
+		return err
+	}
+	ref.Count--
+	if ref.Count > 0 {
+		return writeReference(hash, ref)
+	}
+
+	if err := contents.Delete(hash); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	atomic.AddInt64(&usedBytes, -ref.StoredSize)
+	return references.Delete(hash)
+}
+
+//dedupSavings returns the stored bytes saved by sharing content between messages
+func dedupSavings() (saved int64, err error) {
+	blobs, err := references.List()
+	if err != nil {
+		return 0, err
+	}
+	for _, blob := range blobs {
+		if ref, exists, err := readReference(blob.Key); err == nil && exists && ref.Count > 1 {
+			saved += int64(ref.Count-1) * ref.StoredSize
+		}
+	}
+	return saved, nil
+}
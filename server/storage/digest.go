@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	. "subframe/status"
+)
+
+//DigestBuckets is the number of buckets message IDs are hashed into, for comparing the messages of two StorageNodes
+//without transferring all their IDs
+const DigestBuckets = 256
+
+//bucketOf returns the digest bucket of id
+func bucketOf(id string) int {
+	sum := sha256.Sum256([]byte(id))
+	return int(sum[0]) % DigestBuckets
+}
+
+//bucketIDs groups the IDs of locally stored messages by bucket. Backends list blobs sorted, so every bucket is sorted
+func bucketIDs() (buckets [][]string, err error) {
+	blobs, err := messages.List()
+	if err != nil {
+		return nil, err
+	}
+
+	buckets = make([][]string, DigestBuckets)
+	for _, blob := range blobs {
+		bucket := bucketOf(blob.Key)
+		buckets[bucket] = append(buckets[bucket], blob.Key)
+	}
+	return buckets, nil
+}
+
+//Digest returns the hex-encoded SHA-256 hash of the IDs and versions of locally stored messages in every bucket.
+//Empty buckets have an empty hash. Two Nodes storing the same versions of messages in a bucket have the same hash for it
+func Digest() (digest []string, status int) {
+	buckets, err := bucketIDs()
+	if err != nil {
+		log.Error(GenericInternalError, "Error calculating digest: "+err.Error())
+		return nil, http.StatusInternalServerError
+	}
+	versions, status := messageVersions()
+	if status != http.StatusOK {
+		return nil, status
+	}
+
+	digest = make([]string, DigestBuckets)
+	for index, ids := range buckets {
+		if len(ids) == 0 {
+			continue
+		}
+		hash := sha256.New()
+		for _, id := range ids {
+			//IDs never contain newlines or spaces, so the separators keep different ID lists from hashing the same.
+			//Messages without version hash like before they were versioned, so Nodes not versioning them yet agree
+			entry := id
+			if version := versions[id]; version != 0 {
+				entry += " " + strconv.FormatInt(version, 10)
+			}
+			hash.Write([]byte(entry + "\n"))
+		}
+		digest[index] = hex.EncodeToString(hash.Sum(nil))
+	}
+	return digest, http.StatusOK
+}
+
+//BucketIDs returns the sorted IDs of locally stored messages in bucket, and the versions of those which have one
+func BucketIDs(bucket int) (ids []string, versions map[string]int64, status int) {
+	if bucket < 0 || bucket >= DigestBuckets {
+		return nil, nil, http.StatusBadRequest
+	}
+
+	buckets, err := bucketIDs()
+	if err != nil {
+		log.Error(GenericInternalError, "Error listing bucket: "+err.Error())
+		return nil, nil, http.StatusInternalServerError
+	}
+	all, status := messageVersions()
+	if status != http.StatusOK {
+		return nil, nil, status
+	}
+	ids = buckets[bucket]
+	if ids == nil {
+		ids = []string{}
+	}
+	versions = make(map[string]int64)
+	for _, id := range ids {
+		if version, ok := all[id]; ok {
+			versions[id] = version
+		}
+	}
+	return ids, versions, http.StatusOK
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"subframe/server/settings"
+	. "subframe/status"
+)
+
+//encryptionKeyEnv is the environment variable the key is read from, if settings.EncryptionKeyFile is not set
+const encryptionKeyEnv = "SUBFRAME_ENCRYPTION_KEY"
+
+//Stored blobs are prefixed with a format version, so the format can change without breaking existing messages
+const formatAESGCM byte = 1
+
+var aead cipher.AEAD
+
+//loadEncryptionKey initializes encryption at rest, refusing to start if it is enabled without a valid key
+func loadEncryptionKey() {
+	if !settings.Current().EncryptAtRest {
+		return
+	}
+	log.Info(InProgress, "Loading encryption key...")
+
+	var encoded string
+	if settings.Current().EncryptionKeyFile != "" {
+		data, err := ioutil.ReadFile(settings.Current().EncryptionKeyFile)
+		if err != nil {
+			log.Fatal(StorageEncryptionError, "Failed to read encryption key: "+err.Error())
+		}
+		encoded = string(data)
+	} else {
+		encoded = os.Getenv(encryptionKeyEnv)
+	}
+	if encoded == "" {
+		log.Fatal(StorageEncryptionError, "Encryption at rest is enabled, but no key is configured. Set encryption-key-file or "+encryptionKeyEnv+".")
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != 32 {
+		log.Fatal(StorageEncryptionError, "Encryption key has to be 32 hex encoded bytes.")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err == nil {
+		aead, err = cipher.NewGCM(block)
+	}
+	if err != nil {
+		log.Fatal(StorageEncryptionError, "Failed to initialize encryption: "+err.Error())
+	}
+	log.Info(OK, "Loaded encryption key.")
+}
+
+//encrypt seals data as <format version><nonce><ciphertext>
+func encrypt(data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	blob := append([]byte{formatAESGCM}, nonce...)
+	return aead.Seal(blob, nonce, data, nil), nil
+}
+
+//decrypt opens a blob created by encrypt
+func decrypt(blob []byte) ([]byte, error) {
+	if aead == nil {
+		return nil, errors.New("message is encrypted, but encryption is not configured")
+	}
+	if len(blob) < 1+aead.NonceSize() || blob[0] != formatAESGCM {
+		return nil, errors.New("unknown encrypted message format")
+	}
+	nonce := blob[1 : 1+aead.NonceSize()]
+	return aead.Open(nil, nonce, blob[1+aead.NonceSize():], nil)
+}
+
+//encode prepares content for storage, compressing and encrypting it as configured
+func encode(content []byte) (stored []byte, meta Metadata, err error) {
+	meta = Metadata{
+		Checksum: checksum(content),
+		Size:     int64(len(content)),
+	}
+	stored, meta.Compressed, err = compress(content)
+	if err == nil && settings.Current().EncryptAtRest {
+		stored, err = encrypt(stored)
+		meta.Encrypted = true
+	}
+	meta.StoredSize = int64(len(stored))
+	return stored, meta, err
+}
+
+//decode reverses encode for content stored with meta
+func decode(stored []byte, meta Metadata) ([]byte, error) {
+	var err error
+	if meta.Encrypted {
+		stored, err = decrypt(stored)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return decompress(stored, meta)
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"subframe/server/settings"
+	"subframe/structs/message"
+	"testing"
+)
+
+const testEncryptionKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+//withEncryption enables encryption at rest with key for the duration of the test. An empty key configures none
+func withEncryption(t *testing.T, key string) {
+	previousEnabled, previousFile, previousAEAD := settings.Current().EncryptAtRest, settings.Current().EncryptionKeyFile, aead
+	t.Cleanup(func() {
+		settings.Current().EncryptAtRest, settings.Current().EncryptionKeyFile, aead = previousEnabled, previousFile, previousAEAD
+	})
+
+	settings.Current().EncryptAtRest = true
+	settings.Current().EncryptionKeyFile = ""
+	t.Setenv(encryptionKeyEnv, "")
+	if key != "" {
+		settings.Current().EncryptionKeyFile = filepath.Join(t.TempDir(), "key")
+		if err := ioutil.WriteFile(settings.Current().EncryptionKeyFile, []byte(key+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	setupStorage(t, "filesystem")
+	withEncryption(t, testEncryptionKey)
+	loadEncryptionKey()
+
+	content := "confidential content of the message"
+	if status := putLogged(t, message.Message{ID: "encrypted", Content: content}, false); status != http.StatusOK {
+		t.Fatalf("Put() = %d, want %d", status, http.StatusOK)
+	}
+	stored, err := messages.Get("encrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored[0] != formatAESGCM || bytes.Contains(stored, []byte(content)) {
+		t.Errorf("stored blob %q is not encrypted", stored)
+	}
+	if msg, status := Get("encrypted"); status != http.StatusOK || msg.Content != content {
+		t.Errorf("Get() = %q, %d, want the decrypted content", msg.Content, status)
+	}
+}
+
+func TestLegacyBlobsAreReadWithEncryption(t *testing.T) {
+	setupStorage(t, "filesystem")
+	content := "content stored before encryption was enabled"
+	if status := putLogged(t, message.Message{ID: "legacy", Content: content}, false); status != http.StatusOK {
+		t.Fatalf("Put() = %d, want %d", status, http.StatusOK)
+	}
+
+	withEncryption(t, testEncryptionKey)
+	loadEncryptionKey()
+	if msg, status := Get("legacy"); status != http.StatusOK || msg.Content != content {
+		t.Errorf("Get() = %q, %d, want the unencrypted content", msg.Content, status)
+	}
+}
+
+func TestDecryptRejectsUnknownFormat(t *testing.T) {
+	withEncryption(t, testEncryptionKey)
+	loadEncryptionKey()
+
+	blob, err := encrypt([]byte("content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob[0] = formatAESGCM + 1
+	if _, err := decrypt(blob); err == nil {
+		t.Error("decrypt() of an unknown format succeeded")
+	}
+	if _, err := decrypt([]byte{formatAESGCM}); err == nil {
+		t.Error("decrypt() of a truncated blob succeeded")
+	}
+}
+
+func TestEncryptionRequiresKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{"no key", "", "no key is configured"},
+		{"malformed key", "not hex", "32 hex encoded bytes"},
+		{"short key", testEncryptionKey[:32], "32 hex encoded bytes"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withEncryption(t, test.key)
+			defer func() {
+				err, _ := recover().(error)
+				if err == nil || !strings.Contains(err.Error(), test.want) {
+					t.Errorf("loadEncryptionKey() = %v, want refusing to start with %q", err, test.want)
+				}
+			}()
+			loadEncryptionKey()
+		})
+	}
+}
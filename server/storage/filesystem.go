@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	. "subframe/status"
+)
+
+//filesystemBackend stores every blob as a file placed by layout, until Compact packs small files into segments
+type filesystemBackend struct {
+	layout   shardLayout
+	segments *segmentStore
+}
+
+func (b *filesystemBackend) path(key string) string {
+	return b.layout.path(key)
+}
+
+//Get reads the file of key, or its packed blob
+func (b *filesystemBackend) Get(key string) ([]byte, error) {
+	content, err := ioutil.ReadFile(b.path(key))
+	if !os.IsNotExist(err) {
+		return content, err
+	}
+	packed, _, err := b.segments.open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer packed.Close()
+	return ioutil.ReadAll(packed)
+}
+
+//Open opens the file of key, or its packed blob
+func (b *filesystemBackend) Open(key string) (io.ReadCloser, error) {
+	file, err := os.Open(b.path(key))
+	if !os.IsNotExist(err) {
+		return file, err
+	}
+	packed, _, err := b.segments.open(key)
+	if err != nil {
+		return nil, err
+	}
+	return packed, nil
+}
+
+//Stat returns size and modification time of the file of key, or its packed blob
+func (b *filesystemBackend) Stat(key string) (BlobInfo, error) {
+	info, err := os.Stat(b.path(key))
+	if os.IsNotExist(err) {
+		if location, packed := b.segments.stat(key); packed {
+			return BlobInfo{Key: key, Size: location.Size, ModTime: location.ModTime}, nil
+		}
+	}
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+//Put atomically writes content to the file of key, which replaces a packed blob of key
+func (b *filesystemBackend) Put(key string, content []byte) error {
+	return b.PutContext(context.Background(), key, content)
+}
+
+//PutContext is Put, but the written file only replaces the one of key if commitPut allows it
+func (b *filesystemBackend) PutContext(ctx context.Context, key string, content []byte) error {
+	b.segments.mutex.RLock()
+	defer b.segments.mutex.RUnlock()
+	if err := b.layout.ensureDir(b.path(key)); err != nil {
+		return err
+	}
+	return writeFileAtomicContext(ctx, b.path(key), content)
+}
+
+//Delete removes the file of key and its packed blob
+func (b *filesystemBackend) Delete(key string) error {
+	b.segments.mutex.Lock()
+	defer b.segments.mutex.Unlock()
+	err := os.Remove(b.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	removed, indexErr := b.segments.remove(key)
+	if indexErr != nil {
+		return indexErr
+	}
+	if err != nil && !removed {
+		return err
+	}
+	return nil
+}
+
+//Compact packs the files of at most maxBlobSize bytes into segments and rewrites segments mostly holding deleted blobs
+func (b *filesystemBackend) Compact(maxBlobSize int64) (CompactionResult, error) {
+	return b.segments.compact(maxBlobSize)
+}
+
+//Check writes and removes a probe file in the directory of the backend. Probe files start with a dot, so they are never listed as blobs
+func (b *filesystemBackend) Check() error {
+	probe, err := ioutil.TempFile(b.layout.dir, ".check-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(probe.Name())
+	_, err = probe.WriteString("ok")
+	if closeErr := probe.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+//List returns all files and packed blobs sorted by key, keeping pagination stable.
+//Compaction cannot move blobs while they are listed
+func (b *filesystemBackend) List() ([]BlobInfo, error) {
+	b.segments.mutex.RLock()
+	defer b.segments.mutex.RUnlock()
+	files, err := b.layout.files()
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := make([]BlobInfo, 0, len(files))
+	names := make(map[string]bool, len(files))
+	for _, file := range files {
+		blobs = append(blobs, BlobInfo{Key: file.key, Size: file.info.Size(), ModTime: file.info.ModTime()})
+		names[file.key] = true
+	}
+	blobs = b.segments.appendPacked(blobs, names)
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Key < blobs[j].Key })
+	return blobs, nil
+}
+
+//writeFileAtomic writes content to a temporary file and renames it to path once it is synced to disk,
+//so path either holds the complete content or does not exist
+func writeFileAtomic(path string, content []byte) error {
+	return writeFileAtomicContext(context.Background(), path, content)
+}
+
+//writeFileAtomicContext is writeFileAtomic, but returns errPutAbandoned instead of replacing the file at path if
+//commitPut refuses to commit the written content
+func writeFileAtomicContext(ctx context.Context, path string, content []byte) error {
+	tmp, err := ioutil.TempFile(tmpPath, filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if !commitPut(ctx) {
+		return errPutAbandoned
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+//syncDir flushes directory entries, making a preceding rename durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	//Syncing directories is not supported on every platform
+	if err = d.Sync(); err != nil && !os.IsPermission(err) {
+		log.Warn(GenericInternalError, "Could not sync directory "+dir+": "+err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"subframe/structs/message"
+	"testing"
+)
+
+//crashedWrite leaves a truncated temporary file of key behind, as a crash during writeFileAtomic would
+func crashedWrite(t *testing.T, key string, content string) {
+	tmp, err := ioutil.TempFile(tmpPath, key+"-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.WriteString(content[:len(content)/2])
+	tmp.Close()
+}
+
+func TestTruncatedWriteIsNeverServed(t *testing.T) {
+	setupStorage(t, "filesystem")
+	content := "complete content of the message"
+	if status := putLogged(t, message.Message{ID: "stored", Content: content}, false); status != http.StatusOK {
+		t.Fatalf("Put() = %d, want %d", status, http.StatusOK)
+	}
+	crashedWrite(t, "stored", "replacement of the message")
+	crashedWrite(t, "crashed", content)
+
+	if msg, status := Get("stored"); status != http.StatusOK || msg.Content != content {
+		t.Errorf("Get() of the stored message = %q, %d, want its complete content", msg.Content, status)
+	}
+	if msg, status := Get("crashed"); status != http.StatusNotFound {
+		t.Errorf("Get() of the crashed write = %q, %d, want %d", msg.Content, status, http.StatusNotFound)
+	}
+}
+
+func TestTruncatedWritesAreRemovedOnStart(t *testing.T) {
+	setupStorage(t, "filesystem")
+	crashedWrite(t, "crashed", "content of the message")
+
+	Init()
+	leftovers, err := ioutil.ReadDir(tmpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leftover := range leftovers {
+		t.Errorf("temporary file %s was kept after restart", leftover.Name())
+	}
+}
+
+func TestFailedWriteLeavesNoFile(t *testing.T) {
+	setupStorage(t, "filesystem")
+	path := filepath.Join(t.TempDir(), "missing", "blob")
+
+	if err := writeFileAtomic(path, []byte("content")); err == nil {
+		t.Fatal("writeFileAtomic() into a missing directory succeeded")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("failed write left %s behind", path)
+	}
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryBlob struct {
+	content []byte
+	modTime time.Time
+}
+
+//memoryBackend keeps all blobs in memory. Nothing survives a restart
+type memoryBackend struct {
+	mutex sync.RWMutex
+	blobs map[string]memoryBlob
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{blobs: make(map[string]memoryBlob)}
+}
+
+//Get returns a copy of the content of key
+func (b *memoryBackend) Get(key string) ([]byte, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	blob, ok := b.blobs[key]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return append([]byte(nil), blob.content...), nil
+}
+
+//Open returns a reader over the content of key. Blobs are never modified in place, so no copy is needed
+func (b *memoryBackend) Open(key string) (io.ReadCloser, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	blob, ok := b.blobs[key]
+	if !ok {
+		return nil, ErrNotExist
+	}
+	return seekableContent{bytes.NewReader(blob.content)}, nil
+}
+
+//Stat returns size and modification time of key
+func (b *memoryBackend) Stat(key string) (BlobInfo, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	blob, ok := b.blobs[key]
+	if !ok {
+		return BlobInfo{}, ErrNotExist
+	}
+	return BlobInfo{Key: key, Size: int64(len(blob.content)), ModTime: blob.modTime}, nil
+}
+
+//Put stores a copy of content at key
+func (b *memoryBackend) Put(key string, content []byte) error {
+	return b.PutContext(context.Background(), key, content)
+}
+
+//PutContext is Put, but only stores the copy if commitPut allows it
+func (b *memoryBackend) PutContext(ctx context.Context, key string, content []byte) error {
+	blob := memoryBlob{content: append([]byte(nil), content...), modTime: time.Now()}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !commitPut(ctx) {
+		return errPutAbandoned
+	}
+	b.blobs[key] = blob
+	return nil
+}
+
+//Delete removes key
+func (b *memoryBackend) Delete(key string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.blobs[key]; !ok {
+		return ErrNotExist
+	}
+	delete(b.blobs, key)
+	return nil
+}
+
+//Check always succeeds, memory is available as long as the process runs
+func (b *memoryBackend) Check() error {
+	return nil
+}
+
+//List returns all blobs sorted by key
+func (b *memoryBackend) List() ([]BlobInfo, error) {
+	b.mutex.RLock()
+	blobs := make([]BlobInfo, 0, len(b.blobs))
+	for key, blob := range b.blobs {
+		blobs = append(blobs, BlobInfo{Key: key, Size: int64(len(blob.content)), ModTime: blob.modTime})
+	}
+	b.mutex.RUnlock()
+
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Key < blobs[j].Key })
+	return blobs, nil
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"subframe/server/settings"
+	"time"
+)
+
+//Metadata holds information about a stored message, persisted alongside its content
+type Metadata struct {
+	Checksum   string `json:"checksum"`
+	Size       int64  `json:"size"`
+	StoredSize int64  `json:"storedSize"`
+	Compressed bool   `json:"compressed"`
+	Encrypted  bool   `json:"encrypted"`
+	//ExpiresAt is nil for messages that are kept until settings.MessageMaxStoreTime
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	//StoredAt is nil for messages stored before it was recorded
+	StoredAt *time.Time `json:"storedAt,omitempty"`
+	//CreatedAt, Sender and Recipient are the provenance of the message, empty for messages stored before they were recorded
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	Sender    string     `json:"sender,omitempty"`
+	Recipient string     `json:"recipient,omitempty"`
+	//ContentHash is set for messages whose content is shared in contents under this checksum
+	ContentHash string `json:"contentHash,omitempty"`
+	//Owner and Readers are the identities allowed to read the message. Both are empty for public messages
+	Owner   string   `json:"owner,omitempty"`
+	Readers []string `json:"readers,omitempty"`
+	//Headers are the key/value metadata the message was put with
+	Headers map[string]string `json:"headers,omitempty"`
+	//ContentType is the media type of the content, declared or sniffed when it was put. Empty for messages stored before
+	ContentType string `json:"contentType,omitempty"`
+	//Version is set for messages stored since they were versioned, see NextVersion
+	Version int64 `json:"version,omitempty"`
+}
+
+//expired returns whether the message's expiry has passed
+func (m Metadata) expired() bool {
+	return m.ExpiresAt != nil && time.Now().After(*m.ExpiresAt)
+}
+
+//legacyChecksumFunction is the hash function of checksums without prefix, the only one before settings.IntegrityHash
+const legacyChecksumFunction = "sha256"
+
+//checksum returns the checksum of content computed with settings.IntegrityHash
+func checksum(content []byte) string {
+	return checksumWith(settings.Current().IntegrityHash, content)
+}
+
+//checksumWith returns the hex encoded hash of content computed with function. Checksums of other functions than
+//legacyChecksumFunction are prefixed with its name and a dash, e.g. sha512-<hex>
+func checksumWith(function string, content []byte) string {
+	if function == "sha512" {
+		sum := sha512.Sum512(content)
+		return JoinChecksum(function, hex.EncodeToString(sum[:]))
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+//checksumLike returns the checksum of content computed with the function of expected, so checksums stored before a
+//change of settings.IntegrityHash are still compared correctly. An empty expected uses settings.IntegrityHash
+func checksumLike(expected string, content []byte) string {
+	if expected == "" {
+		return checksum(content)
+	}
+	function, _ := SplitChecksum(expected)
+	return checksumWith(function, content)
+}
+
+//SplitChecksum returns the hash function and the hex encoded hash of a stored checksum
+func SplitChecksum(sum string) (function string, hash string) {
+	if index := strings.IndexByte(sum, '-'); index > 0 {
+		return sum[:index], sum[index+1:]
+	}
+	return legacyChecksumFunction, sum
+}
+
+//JoinChecksum returns the stored checksum of the hex encoded hash computed with function, see SplitChecksum
+func JoinChecksum(function string, hash string) string {
+	if function == legacyChecksumFunction {
+		return hash
+	}
+	return function + "-" + hash
+}
+
+//readMetadata loads the metadata of a message. Messages stored before metadata was introduced have none
+func readMetadata(id string) (meta Metadata, exists bool, err error) {
+	data, err := metadata.Get(id)
+	if os.IsNotExist(err) {
+		return Metadata{}, false, nil
+	}
+	if err != nil {
+		return Metadata{}, false, err
+	}
+	err = json.Unmarshal(data, &meta)
+	return meta, err == nil, err
+}
+
+//writeMetadata atomically persists the metadata of a message
+func writeMetadata(id string, meta Metadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return metadata.Put(id, data)
+}
+
+//deleteMetadata removes the metadata of a message, if present
+func deleteMetadata(id string) error {
+	err := metadata.Delete(id)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"net/http"
+	. "subframe/status"
+	"subframe/structs/message"
+	"testing"
+)
+
+//corrupt flips the last byte of the stored blob of id, as bit rot would
+func corrupt(t *testing.T, id string) {
+	stored, err := messages.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored[len(stored)-1] ^= 0xff
+	if err := messages.Put(id, stored); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetDetectsCorruption(t *testing.T) {
+	for _, backend := range []string{"filesystem", "memory"} {
+		t.Run(backend, func(t *testing.T) {
+			setupStorage(t, backend)
+			if status := putLogged(t, message.Message{ID: "corrupted", Content: "content of the message"}, false); status != http.StatusOK {
+				t.Fatalf("Put() = %d, want %d", status, http.StatusOK)
+			}
+			corrupt(t, "corrupted")
+
+			msg, status := Get("corrupted")
+			if status != StorageChecksumMismatch {
+				t.Errorf("Get() = %d, want %d", status, StorageChecksumMismatch)
+			}
+			if msg.Content != "" {
+				t.Errorf("Get() returned the corrupted content %q", msg.Content)
+			}
+			if status := Verify("corrupted"); status != StorageChecksumMismatch {
+				t.Errorf("Verify() = %d, want %d", status, StorageChecksumMismatch)
+			}
+		})
+	}
+}
@@ -0,0 +1,518 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	. "subframe/status"
+	"sync"
+	"time"
+)
+
+//segmentDirName is the directory of a filesystemBackend holding segment files and their index.
+//It starts with a dot, so it is never listed as blob
+const segmentDirName = ".segments"
+
+//maxSegmentSize is the size after which compaction starts a new segment file
+const maxSegmentSize = 64 * 1024 * 1024
+
+//commitBatchSize bounds the packed blobs committed at once, so puts and deletes are not blocked for long
+const commitBatchSize = 1000
+
+//segmentLocation is where a packed blob is stored in a segment file
+type segmentLocation struct {
+	Segment int       `json:"segment"`
+	Offset  int64     `json:"offset"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+//indexRecord is one line of the segment index. Records without Location remove the packed blob of Key
+type indexRecord struct {
+	Key      string           `json:"key"`
+	Location *segmentLocation `json:"location,omitempty"`
+}
+
+//segmentStore holds the blobs of a filesystemBackend which compaction packed into segment files, indexed by an
+//append-only journal. A file of the same key is newer than its packed blob, as puts always write files.
+//Puts hold mutex for reading, deletes and commits of compaction for writing, so compaction never removes a file
+//replaced while it was packed
+type segmentStore struct {
+	//files places the files of the backend, dir is the directory of the segments
+	files shardLayout
+	dir   string
+	mutex sync.RWMutex
+	index map[string]segmentLocation
+	//live is the number of bytes of every segment referenced by the index, sizes the size of every segment file
+	live        map[int]int64
+	sizes       map[int]int64
+	nextSegment int
+	journal     *os.File
+	//compacting serializes compactions
+	compacting sync.Mutex
+}
+
+//CompactionResult reports the work of Compact
+type CompactionResult struct {
+	//Packed is the number of blobs moved from files into segments
+	Packed int `json:"packed"`
+	//Rewritten is the number of segments rewritten or removed because they mostly held deleted blobs
+	Rewritten int `json:"rewritten"`
+	//Reclaimed is the number of bytes freed by rewriting segments
+	Reclaimed int64 `json:"reclaimed"`
+}
+
+func (s *segmentStore) segmentPath(segment int) string {
+	return s.dir + "/segment-" + strconv.Itoa(segment)
+}
+
+func (s *segmentStore) journalPath() string {
+	return s.dir + "/index.log"
+}
+
+//openSegments loads the segment index of the files placed by files, creating it if it does not exist
+func openSegments(files shardLayout) (*segmentStore, error) {
+	dir := files.dir + "/" + segmentDirName
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &segmentStore{files: files, dir: dir, index: make(map[string]segmentLocation), live: make(map[int]int64), sizes: make(map[int]int64), nextSegment: 1}
+
+	segments, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range segments {
+		segment, err := strconv.Atoi(strings.TrimPrefix(file.Name(), "segment-"))
+		if err != nil || !strings.HasPrefix(file.Name(), "segment-") {
+			continue
+		}
+		s.sizes[segment] = file.Size()
+		if segment >= s.nextSegment {
+			s.nextSegment = segment + 1
+		}
+	}
+
+	file, err := os.Open(s.journalPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var record indexRecord
+			//A truncated last line is left by an interrupted write, the blob is still stored as file then
+			if json.Unmarshal(scanner.Bytes(), &record) != nil {
+				continue
+			}
+			s.apply(record)
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.journal, err = os.OpenFile(s.journalPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//apply updates the index with record. The mutex has to be held for writing
+func (s *segmentStore) apply(record indexRecord) {
+	if old, ok := s.index[record.Key]; ok {
+		s.live[old.Segment] -= old.Size
+		delete(s.index, record.Key)
+	}
+	if record.Location != nil {
+		s.index[record.Key] = *record.Location
+		s.live[record.Location.Segment] += record.Location.Size
+	}
+}
+
+//record appends records to the journal, syncs it and applies them. The mutex has to be held for writing
+func (s *segmentStore) record(records []indexRecord) error {
+	var lines []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if _, err := s.journal.Write(lines); err != nil {
+		return err
+	}
+	if err := s.journal.Sync(); err != nil {
+		return err
+	}
+	for _, record := range records {
+		s.apply(record)
+	}
+	return nil
+}
+
+//segmentContent reads a packed blob from its segment file
+type segmentContent struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (c segmentContent) Close() error {
+	return c.file.Close()
+}
+
+//open opens the packed blob of key. The segment file is opened while holding the mutex,
+//so it is not removed by a concurrent compaction before
+func (s *segmentStore) open(key string) (content segmentContent, location segmentLocation, err error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	location, ok := s.index[key]
+	if !ok {
+		return segmentContent{}, location, ErrNotExist
+	}
+	file, err := os.Open(s.segmentPath(location.Segment))
+	if err != nil {
+		return segmentContent{}, location, err
+	}
+	return segmentContent{SectionReader: io.NewSectionReader(file, location.Offset, location.Size), file: file}, location, nil
+}
+
+//stat returns the location of the packed blob of key
+func (s *segmentStore) stat(key string) (location segmentLocation, ok bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	location, ok = s.index[key]
+	return location, ok
+}
+
+//remove removes the packed blob of key from the index, returning whether there was one. The mutex has to be held for writing
+func (s *segmentStore) remove(key string) (removed bool, err error) {
+	if _, ok := s.index[key]; !ok {
+		return false, nil
+	}
+	return true, s.record([]indexRecord{{Key: key}})
+}
+
+//packedBlob is a blob written to a segment by compaction, which is not committed to the index yet
+type packedBlob struct {
+	key      string
+	location segmentLocation
+	//file is the file the blob was read from, old the location it was relocated from, if it was packed already
+	file os.FileInfo
+	old  *segmentLocation
+}
+
+//segmentWriter writes the blobs of one compaction into new segment files
+type segmentWriter struct {
+	store   *segmentStore
+	file    *os.File
+	segment int
+	size    int64
+	pending []packedBlob
+	written []int
+}
+
+//add writes content to the current segment, starting a new one if it is full. Full segments are committed
+func (w *segmentWriter) add(blob packedBlob, content []byte) error {
+	if w.file != nil && w.size+int64(len(content)) > maxSegmentSize {
+		if err := w.commit(); err != nil {
+			return err
+		}
+	}
+	if w.file == nil {
+		w.store.mutex.Lock()
+		w.segment = w.store.nextSegment
+		w.store.nextSegment++
+		w.store.mutex.Unlock()
+		file, err := os.OpenFile(w.store.segmentPath(w.segment), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		w.file, w.size = file, 0
+		w.written = append(w.written, w.segment)
+	}
+	if _, err := w.file.Write(content); err != nil {
+		return err
+	}
+	blob.location.Segment, blob.location.Offset = w.segment, w.size
+	w.size += int64(len(content))
+	w.pending = append(w.pending, blob)
+	return nil
+}
+
+//commit syncs the current segment and adds its blobs to the index, in batches of commitBatchSize.
+//Blobs whose file was replaced or deleted, or which were deleted or replaced since they were relocated, are skipped.
+//Files of committed blobs are removed
+func (w *segmentWriter) commit() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Sync()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	if err != nil {
+		return err
+	}
+
+	s := w.store
+	s.mutex.Lock()
+	s.sizes[w.segment] = w.size
+	s.mutex.Unlock()
+	for start := 0; start < len(w.pending); start += commitBatchSize {
+		end := start + commitBatchSize
+		if end > len(w.pending) {
+			end = len(w.pending)
+		}
+		if err := s.commitBatch(w.pending[start:end]); err != nil {
+			return err
+		}
+	}
+	w.pending = nil
+	return nil
+}
+
+func (s *segmentStore) commitBatch(batch []packedBlob) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var records []indexRecord
+	var files []string
+	for index := range batch {
+		blob := &batch[index]
+		if blob.file != nil {
+			path := s.files.path(blob.key)
+			current, err := os.Stat(path)
+			if err != nil || !os.SameFile(current, blob.file) {
+				continue
+			}
+			files = append(files, path)
+		} else if current, ok := s.index[blob.key]; !ok || current != *blob.old {
+			continue
+		}
+		records = append(records, indexRecord{Key: blob.key, Location: &blob.location})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	if err := s.record(records); err != nil {
+		return err
+	}
+	//Files are removed once the index is durable, a crash before leaves both, the file taking precedence
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Warn(GenericInternalError, "Error removing packed file "+file+": "+err.Error())
+		}
+	}
+	return nil
+}
+
+//compact packs the files of at most maxBlobSize bytes into new segments, and rewrites segments of which less
+//than half is referenced. It can run concurrently with all other operations of the backend
+func (s *segmentStore) compact(maxBlobSize int64) (result CompactionResult, err error) {
+	s.compacting.Lock()
+	defer s.compacting.Unlock()
+
+	files, err := s.files.files()
+	if err != nil {
+		return result, err
+	}
+	writer := &segmentWriter{store: s}
+	var shadowed []string
+	for _, file := range files {
+		if file.info.Size() > maxBlobSize {
+			if _, packed := s.stat(file.key); packed {
+				shadowed = append(shadowed, file.key)
+			}
+			continue
+		}
+		packed, err := packFile(writer, file.path, file.key)
+		if err != nil {
+			return result, err
+		}
+		if packed {
+			result.Packed++
+		}
+	}
+	if err := writer.commit(); err != nil {
+		return result, err
+	}
+	if err := s.dropShadowed(shadowed); err != nil {
+		return result, err
+	}
+
+	rewritten, reclaimed, err := s.rewriteSparse(writer)
+	result.Rewritten, result.Reclaimed = rewritten, reclaimed
+	if err != nil {
+		return result, err
+	}
+	return result, s.compactJournal()
+}
+
+//packFile writes the file at path to the segment of writer. Files deleted in the meantime are skipped
+func packFile(writer *segmentWriter, path string, key string) (packed bool, err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	//Content and identity of the file are taken from the same descriptor, so a concurrent put is detected on commit
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return false, err
+	}
+	blob := packedBlob{key: key, location: segmentLocation{Size: int64(len(content)), ModTime: info.ModTime()}, file: info}
+	return true, writer.add(blob, content)
+}
+
+//dropShadowed removes the packed blobs of keys from the index which were replaced by files of the same key too large to be packed
+func (s *segmentStore) dropShadowed(keys []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var records []indexRecord
+	for _, key := range keys {
+		if _, err := os.Stat(s.files.path(key)); err == nil {
+			records = append(records, indexRecord{Key: key})
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	return s.record(records)
+}
+
+//rewriteSparse relocates the blobs of segments of which less than half is referenced into the segments of writer,
+//and removes the old segments once nothing references them
+func (s *segmentStore) rewriteSparse(writer *segmentWriter) (rewritten int, reclaimed int64, err error) {
+	fresh := make(map[int]bool)
+	for _, segment := range writer.written {
+		fresh[segment] = true
+	}
+	s.mutex.RLock()
+	sparse := make(map[int]bool)
+	for segment, size := range s.sizes {
+		if !fresh[segment] && (s.live[segment] == 0 || s.live[segment]*2 < size) {
+			sparse[segment] = true
+		}
+	}
+	var relocated []packedBlob
+	for key, location := range s.index {
+		if sparse[location.Segment] {
+			old := location
+			relocated = append(relocated, packedBlob{key: key, location: location, old: &old})
+		}
+	}
+	s.mutex.RUnlock()
+	if len(sparse) == 0 {
+		return 0, 0, nil
+	}
+
+	//Blobs are copied in the order they are stored, reading the old segments sequentially
+	sort.Slice(relocated, func(i, j int) bool {
+		if relocated[i].old.Segment != relocated[j].old.Segment {
+			return relocated[i].old.Segment < relocated[j].old.Segment
+		}
+		return relocated[i].old.Offset < relocated[j].old.Offset
+	})
+	var moved int64
+	for _, blob := range relocated {
+		content, _, err := s.open(blob.key)
+		if err == ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return rewritten, reclaimed, err
+		}
+		data, err := ioutil.ReadAll(content)
+		content.Close()
+		if err != nil {
+			return rewritten, reclaimed, err
+		}
+		if err := writer.add(blob, data); err != nil {
+			return rewritten, reclaimed, err
+		}
+		moved += int64(len(data))
+	}
+	if err := writer.commit(); err != nil {
+		return rewritten, reclaimed, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var freed int64
+	for segment := range sparse {
+		//Only compaction adds blobs to segments, so nothing references a relocated segment anymore unless a commit failed
+		if s.live[segment] > 0 {
+			continue
+		}
+		if err := os.Remove(s.segmentPath(segment)); err != nil && !os.IsNotExist(err) {
+			return rewritten, reclaimed, err
+		}
+		freed += s.sizes[segment]
+		delete(s.sizes, segment)
+		delete(s.live, segment)
+		rewritten++
+	}
+	reclaimed = freed - moved
+	if reclaimed < 0 {
+		reclaimed = 0
+	}
+	return rewritten, reclaimed, nil
+}
+
+//compactJournal rewrites the journal to only hold the current index
+func (s *segmentStore) compactJournal() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := make([]string, 0, len(s.index))
+	for key := range s.index {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var lines []byte
+	for _, key := range keys {
+		location := s.index[key]
+		line, err := json.Marshal(indexRecord{Key: key, Location: &location})
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if err := writeFileAtomic(s.journalPath(), lines); err != nil {
+		return err
+	}
+	journal, err := os.OpenFile(s.journalPath(), os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	s.journal.Close()
+	s.journal = journal
+	return nil
+}
+
+//appendPacked appends the packed blobs whose key is not in files to blobs. The mutex has to be held
+func (s *segmentStore) appendPacked(blobs []BlobInfo, files map[string]bool) []BlobInfo {
+	for key, location := range s.index {
+		if !files[key] {
+			blobs = append(blobs, BlobInfo{Key: key, Size: location.Size, ModTime: location.ModTime})
+		}
+	}
+	return blobs
+}
@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	. "subframe/status"
+)
+
+//maxShardDepth is the deepest supported shard depth, see settings.StorageShardDepth
+const maxShardDepth = 3
+
+//migratingDirName is the directory of a filesystemBackend holding files while they are moved to another shard depth
+const migratingDirName = ".migrating"
+
+//layoutFileName records the shard depth the files of a filesystemBackend are placed with.
+//It starts with a dot, so it is never listed as blob
+const layoutFileName = ".layout"
+
+//shardLayout places the files of a filesystemBackend in depth levels of subdirectories of dir, named by two hex
+//digits of the SHA-256 of their key each. A depth of 0 places all files in dir itself
+type shardLayout struct {
+	dir   string
+	depth int
+}
+
+//looseFile is a file of a filesystemBackend, which compaction did not pack into a segment
+type looseFile struct {
+	key  string
+	path string
+	info os.FileInfo
+}
+
+//shardDirs returns the subdirectories of the file of key, e.g. ["ab", "cd"] for a depth of 2
+func (l shardLayout) shardDirs(key string) []string {
+	sum := sha256.Sum256([]byte(key))
+	prefix := hex.EncodeToString(sum[:l.depth])
+	dirs := make([]string, l.depth)
+	for level := range dirs {
+		dirs[level] = prefix[2*level : 2*level+2]
+	}
+	return dirs
+}
+
+func (l shardLayout) path(key string) string {
+	return strings.Join(append(append([]string{l.dir}, l.shardDirs(key)...), key), "/")
+}
+
+//ensureDir creates the subdirectories of the file at path, syncing their parents so they survive a crash
+func (l shardLayout) ensureDir(path string) error {
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for ; dir != l.dir && strings.HasPrefix(dir, l.dir); dir = filepath.Dir(dir) {
+		if err := syncDir(filepath.Dir(dir)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//isShardDir returns whether name can be a subdirectory of a shardLayout
+func isShardDir(name string) bool {
+	_, err := hex.DecodeString(name)
+	return len(name) == 2 && err == nil && strings.ToLower(name) == name
+}
+
+//files returns all files placed in the layout. Directories and files starting with a dot are skipped
+func (l shardLayout) files() (files []looseFile, err error) {
+	return files, l.walk(l.dir, l.depth, func(file looseFile) {
+		files = append(files, file)
+	})
+}
+
+func (l shardLayout) walk(dir string, depth int, visit func(looseFile)) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := dir + "/" + name
+		switch {
+		case depth > 0 && entry.IsDir() && isShardDir(name):
+			if err := l.walk(path, depth-1, visit); err != nil {
+				return err
+			}
+		case depth == 0 && !entry.IsDir():
+			visit(looseFile{key: name, path: path, info: entry})
+		}
+	}
+	return nil
+}
+
+//migrateLayout moves the files of dir placed with another shard depth into the subdirectories of layout, and removes
+//the shard directories left empty. Files are moved to migratingDirName first, as a file may have the name of a shard
+//directory it has to be moved into. All moves are renames within dir, so an interrupted migration continues on the next start
+func migrateLayout(layout shardLayout) error {
+	recorded, err := ioutil.ReadFile(layout.dir + "/" + layoutFileName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil && strings.TrimSpace(string(recorded)) == strconv.Itoa(layout.depth) {
+		return nil
+	}
+
+	log.Info(InProgress, "Moving files of "+layout.dir+" to a shard depth of "+strconv.Itoa(layout.depth)+"...")
+	staging := shardLayout{dir: layout.dir + "/" + migratingDirName}
+	if err := os.MkdirAll(staging.dir, 0755); err != nil {
+		return err
+	}
+	//Any depth up to the deepest supported one may have been used before
+	for depth := 0; depth <= maxShardDepth; depth++ {
+		if depth == layout.depth {
+			continue
+		}
+		var misplaced []looseFile
+		if err := layout.walk(layout.dir, depth, func(file looseFile) { misplaced = append(misplaced, file) }); err != nil {
+			return err
+		}
+		for _, file := range misplaced {
+			if err := os.Rename(file.path, staging.path(file.key)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := syncDir(staging.dir); err != nil {
+		return err
+	}
+
+	staged, err := staging.files()
+	if err != nil {
+		return err
+	}
+	for _, file := range staged {
+		target := layout.path(file.key)
+		if err := layout.ensureDir(target); err != nil {
+			return err
+		}
+		if err := os.Rename(file.path, target); err != nil {
+			return err
+		}
+	}
+	if err := removeEmptyShardDirs(layout.dir, layout.depth, 0); err != nil {
+		return err
+	}
+	if err := os.Remove(staging.dir); err != nil {
+		return err
+	}
+	if err := syncDir(layout.dir); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(layout.dir+"/"+layoutFileName, []byte(strconv.Itoa(layout.depth)+"\n")); err != nil {
+		return err
+	}
+	log.Info(OK, "Moved "+strconv.Itoa(len(staged))+" files of "+layout.dir+".")
+	return nil
+}
+
+//removeEmptyShardDirs removes the shard directories below dir which hold no files, except those of a layout of depth
+func removeEmptyShardDirs(dir string, depth int, level int) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !isShardDir(entry.Name()) {
+			continue
+		}
+		path := dir + "/" + entry.Name()
+		if err := removeEmptyShardDirs(path, depth, level+1); err != nil {
+			return err
+		}
+		if level >= depth {
+			//Fails for directories still holding files, which are kept
+			os.Remove(path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"subframe/server/settings"
+	"subframe/structs/message"
+	"testing"
+)
+
+//similarIDs differ only in case, separators and length, which sanitizing IDs used to collapse to the same key
+var similarIDs = []string{"msg-a", "msg_a", "MSG-A", "msga", "msg-a-", "msg--a", "Msg-a"}
+
+func TestShardPathsOfDistinctKeysAreDistinct(t *testing.T) {
+	for depth := 0; depth <= maxShardDepth; depth++ {
+		layout := shardLayout{dir: "/data", depth: depth}
+		paths := make(map[string]string)
+		for _, id := range similarIDs {
+			path := layout.path(id)
+			if other, ok := paths[path]; ok {
+				t.Errorf("depth %d: %s and %s are both placed at %s", depth, id, other, path)
+			}
+			paths[path] = id
+		}
+	}
+}
+
+func TestSimilarIDsDoNotCollide(t *testing.T) {
+	for _, backend := range []string{"filesystem", "memory"} {
+		t.Run(backend, func(t *testing.T) {
+			previousDepth := settings.Current().StorageShardDepth
+			settings.Current().StorageShardDepth = 2
+			t.Cleanup(func() { settings.Current().StorageShardDepth = previousDepth })
+			setupStorage(t, backend)
+
+			for _, id := range similarIDs {
+				if status := putLogged(t, message.Message{ID: id, Content: "content of " + id}, false); status != http.StatusOK {
+					t.Fatalf("put of %s = %d", id, status)
+				}
+			}
+			for _, id := range similarIDs {
+				msg, status := GetContext(context.Background(), id)
+				if status != http.StatusOK || msg.Content != "content of "+id {
+					t.Errorf("get of %s = %q, %d, want its own content", id, msg.Content, status)
+				}
+			}
+		})
+	}
+}
+
+//withShardDepth sets settings.StorageShardDepth for the duration of the test
+func withShardDepth(t *testing.T, depth int) {
+	previous := settings.Current().StorageShardDepth
+	settings.Current().StorageShardDepth = depth
+	t.Cleanup(func() { settings.Current().StorageShardDepth = previous })
+}
+
+//writeFlat creates the files of keys in dir itself, as a shard depth of 0 places them
+func writeFlat(t *testing.T, dir string, keys []string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err := ioutil.WriteFile(dir+"/"+key, []byte("content of "+key), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//expectPlaced fails the test unless the files of keys are placed by layout, with their content intact, and no other
+//files are left in its directory
+func expectPlaced(t *testing.T, layout shardLayout, keys []string) {
+	t.Helper()
+	for _, key := range keys {
+		content, err := ioutil.ReadFile(layout.path(key))
+		if err != nil || string(content) != "content of "+key {
+			t.Errorf("depth %d: file of %s = %q, %v, want its content at %s", layout.depth, key, content, err, layout.path(key))
+		}
+	}
+	files := 0
+	filepath.Walk(layout.dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && filepath.Base(path) != layoutFileName {
+			files++
+		}
+		return nil
+	})
+	if files != len(keys) {
+		t.Errorf("depth %d: %d files in %s, want %d", layout.depth, files, layout.dir, len(keys))
+	}
+}
+
+func TestMigrateLayout(t *testing.T) {
+	//Layouts are recorded through the temporary directory of the storage
+	setupStorage(t, "memory")
+	dir := settings.Current().DataPath + "/sharded"
+	//"ab" is also the name of a shard directory the files may be moved into
+	keys := []string{"ab", "msg-a", "msg-b", "00", "ff"}
+	writeFlat(t, dir, keys)
+
+	for _, depth := range []int{2, 3, 1, 0} {
+		layout := shardLayout{dir: dir, depth: depth}
+		if err := migrateLayout(layout); err != nil {
+			t.Fatalf("migrateLayout() to depth %d = %v", depth, err)
+		}
+		expectPlaced(t, layout, keys)
+		if _, err := os.Stat(dir + "/" + migratingDirName); !os.IsNotExist(err) {
+			t.Errorf("depth %d: %s left after the migration", depth, migratingDirName)
+		}
+		if recorded, _ := ioutil.ReadFile(dir + "/" + layoutFileName); string(recorded) != strconv.Itoa(depth)+"\n" {
+			t.Errorf("recorded layout = %q, want depth %d", recorded, depth)
+		}
+	}
+
+	//Shard directories are removed once the files are flat again
+	entries, _ := ioutil.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			t.Errorf("directory %s left in a flat layout", entry.Name())
+		}
+	}
+}
+
+func TestMigrateLayoutContinuesInterruptedMigration(t *testing.T) {
+	setupStorage(t, "memory")
+	dir := settings.Current().DataPath + "/sharded"
+	writeFlat(t, dir, []string{"msg-a"})
+	//A file was staged before the Node stopped
+	writeFlat(t, dir+"/"+migratingDirName, []string{"msg-b"})
+
+	layout := shardLayout{dir: dir, depth: 2}
+	if err := migrateLayout(layout); err != nil {
+		t.Fatalf("migrateLayout() = %v", err)
+	}
+	expectPlaced(t, layout, []string{"msg-a", "msg-b"})
+}
+
+func TestShardedStorage(t *testing.T) {
+	for depth := 0; depth <= maxShardDepth; depth++ {
+		t.Run("depth "+strconv.Itoa(depth), func(t *testing.T) {
+			withShardDepth(t, depth)
+			setupStorage(t, "filesystem")
+
+			for _, id := range similarIDs {
+				if status := putLogged(t, message.Message{ID: id, Content: "content of " + id}, false); status != http.StatusOK {
+					t.Fatalf("put of %s = %d", id, status)
+				}
+				if _, err := os.Stat(shardLayout{dir: settings.Current().DataPath + "/messages", depth: depth}.path(id)); err != nil {
+					t.Errorf("message %s is not placed at depth %d: %v", id, depth, err)
+				}
+			}
+			if status := Delete("msg-a"); status != http.StatusOK {
+				t.Fatalf("Delete() = %d", status)
+			}
+			if _, status := Get("msg-a"); status != http.StatusNotFound {
+				t.Errorf("Get() of a deleted message = %d, want %d", status, http.StatusNotFound)
+			}
+			if msg, status := Get("msg_a"); status != http.StatusOK || msg.Content != "content of msg_a" {
+				t.Errorf("Get() = %q, %d, want the stored content", msg.Content, status)
+			}
+		})
+	}
+}
+
+func TestStoredMessagesAreMigrated(t *testing.T) {
+	withShardDepth(t, 0)
+	setupStorage(t, "filesystem")
+	for _, id := range similarIDs {
+		putLogged(t, message.Message{ID: id, Content: "content of " + id}, false)
+	}
+
+	settings.Current().StorageShardDepth = 2
+	Init()
+	for _, id := range similarIDs {
+		if msg, status := Get(id); status != http.StatusOK || msg.Content != "content of "+id {
+			t.Errorf("Get(%s) after migrating = %q, %d, want its content", id, msg.Content, status)
+		}
+		if _, err := os.Stat(shardLayout{dir: settings.Current().DataPath + "/messages", depth: 2}.path(id)); err != nil {
+			t.Errorf("message %s was not moved into its shard directory: %v", id, err)
+		}
+	}
+}
@@ -1,41 +1,72 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
 	"subframe/server/database"
 	"subframe/server/logger"
 	"subframe/server/settings"
+	"subframe/server/tracing"
 	. "subframe/status"
 	"subframe/structs/message"
+	"sync/atomic"
+	"time"
 )
 
-var messagesPath string
 var databasePath string
 var logPath string
+var tmpPath string
 var log = logger.Logger{Prefix: "storage/Main"}
 
 //Init initializes the data directory
 func Init() {
 	log.Info(InProgress, "Initializing Storage Directories...")
-	createDirIfNotExist(settings.DataPath)
-	log.Info(OK, "Initialized "+settings.DataPath)
+	createDirIfNotExist(settings.Current().DataPath)
+	log.Info(OK, "Initialized "+settings.Current().DataPath)
 
-	messagesPath = settings.DataPath + "/messages"
-	createDirIfNotExist(messagesPath)
-	log.Info(OK, "Initialized "+messagesPath)
-
-	databasePath = settings.DataPath + "/databases"
+	databasePath = settings.Current().DataPath + "/databases"
 	createDirIfNotExist(databasePath)
 	log.Info(OK, "Initialized "+databasePath)
 
-	logPath = settings.DataPath + "/logs"
+	//Temporary files are written here and renamed into place by the filesystem backend, leftovers are incomplete writes
+	tmpPath = settings.Current().DataPath + "/tmp"
+	os.RemoveAll(tmpPath)
+	createDirIfNotExist(tmpPath)
+	log.Info(OK, "Initialized "+tmpPath)
+
+	uploadPath = settings.Current().DataPath + "/uploads"
+	createDirIfNotExist(uploadPath)
+	log.Info(OK, "Initialized "+uploadPath)
+
+	logPath = settings.Current().DataPath + "/logs"
 	createDirIfNotExist(logPath)
 	logger.LogPath = logPath
 
 	log.Info(OK, "Initialized "+logPath)
+
+	log.Info(InProgress, "Initializing "+settings.Current().StorageBackend+" Storage Backend...")
+	messages = newBackend(settings.Current().StorageBackend, "messages")
+	metadata = newBackend(settings.Current().StorageBackend, "metadata")
+	contents = newBackend(settings.Current().StorageBackend, "contents")
+	references = newBackend(settings.Current().StorageBackend, "references")
+	quarantine = newBackend(settings.Current().StorageBackend, "quarantine")
+	forgetFailures()
+	log.Info(OK, "Initialized "+settings.Current().StorageBackend+" Storage Backend.")
+
+	used, err := usedSize()
+	if err != nil {
+		log.Warn(GenericInternalError, "Could not determine used storage: "+err.Error())
+	}
+	atomic.StoreInt64(&usedBytes, used)
+	log.Info(OK, "Using "+strconv.FormatInt(used/1024/1024, 10)+" of "+strconv.Itoa(settings.Current().DiskSpace)+" MB for Messages")
+
+	loadEncryptionKey()
 }
 
 //Finish might do something soon
@@ -45,97 +76,678 @@ func Finish() {
 	log.Info(OK, "Finished Storage.")
 }
 
-//Get loads a message from local disk
+//Get loads a message from the storage backend and verifies its checksum
 func Get(id string) (msg message.Message, status int) {
+	return GetContext(context.Background(), id)
+}
+
+//GetContext is Get, but returns StorageRequestCanceled without decoding the message once ctx is done
+func GetContext(ctx context.Context, id string) (msg message.Message, status int) {
+	ctx, span := tracing.Start(ctx, "storage.Get", tracing.KindInternal)
+	defer endSpan(span, id, &status)
 	//Read message from disk and return
-	log.Info("Getting Message " + id + "...")
+	log := log.WithContext(ctx)
+	log.Info(InProgress, "Getting Message "+id+"...")
+	if cached, ok := cache.get(id); ok {
+		log.Info(OK, "Got Message "+id+" from cache")
+		return cached, http.StatusOK
+	}
+	since := cache.generation()
 
-	if !database.CheckMessageStorage(id) {
-		log.Warn("Error getting Message " + id + ": Not in database")
+	if _, stored := database.CheckMessageStorage(id); !stored {
+		log.Warn(GenericInputError, "Error getting Message "+id+": Not in database")
 		return message.Message{}, http.StatusNotFound
 	}
 
-	dat, err := ioutil.ReadFile(messagesPath + "/" + id)
+	lock := messageLock(id)
+	lock.RLock()
+	defer lock.RUnlock()
+	dat, err := messages.Get(id)
 	if err != nil {
-		log.Warn("Error getting Message " + id + ": " + err.Error())
-		return message.Message{}, http.StatusNotFound
+		log.Warn(GenericInputError, "Error getting Message "+id+": "+err.Error())
+		return message.Message{}, errorStatus(err, http.StatusNotFound)
+	}
+
+	meta, hasMetadata, err := readMetadata(id)
+	if err != nil {
+		log.Error(StorageMetadataError, "Error reading metadata of Message "+id+": "+err.Error())
+		return message.Message{}, errorStatus(err, http.StatusInternalServerError)
+	}
+	if meta.expired() {
+		log.Warn(StorageMessageExpired, "Error getting Message "+id+": Expired")
+		return message.Message{}, StorageMessageExpired
+	}
+	if meta.ContentHash != "" {
+		dat, err = contents.Get(meta.ContentHash)
+		if err != nil {
+			log.Error(GenericInternalError, "Error getting content of Message "+id+": "+err.Error())
+			return message.Message{}, errorStatus(err, http.StatusInternalServerError)
+		}
+	}
+	//Decryption and decompression are the expensive part, and wasted if nobody waits for the message anymore
+	if ctx.Err() != nil {
+		log.Warn(StorageRequestCanceled, "Getting Message "+id+" canceled: "+ctx.Err().Error())
+		return message.Message{}, StorageRequestCanceled
+	}
+	dat, err = decode(dat, meta)
+	if err != nil {
+		log.Error(StorageChecksumMismatch, "Error decoding Message "+id+": "+err.Error())
+		return message.Message{}, StorageChecksumMismatch
+	}
+	sum := checksumLike(meta.Checksum, dat)
+	if hasMetadata && meta.Checksum != sum {
+		log.Error(StorageChecksumMismatch, "Checksum of Message "+id+" does not match. Expected "+meta.Checksum+", got "+sum)
+		return message.Message{}, StorageChecksumMismatch
+	}
+
+	log.Info(OK, "Got Message "+id)
+	msg = message.Message{
+		ID:          id,
+		Content:     string(dat),
+		Checksum:    sum,
+		ExpiresAt:   meta.ExpiresAt,
+		CreatedAt:   meta.CreatedAt,
+		Sender:      meta.Sender,
+		Recipient:   meta.Recipient,
+		Owner:       meta.Owner,
+		Readers:     meta.Readers,
+		Headers:     meta.Headers,
+		Version:     meta.Version,
+		ContentType: meta.ContentType,
+	}
+	cache.add(msg, since)
+	return msg, http.StatusOK
+}
+
+//endSpan finishes the Span of an operation on the message id, which returned status
+func endSpan(span *tracing.Span, id string, status *int) {
+	span.SetAttribute("subframe.message_id", id)
+	span.SetAttribute("subframe.status", strconv.Itoa(*status))
+	if *status != http.StatusOK && *status != StorageMessageReplaced {
+		span.SetError("Operation failed with status " + strconv.Itoa(*status))
+	}
+	span.End()
+}
+
+//Checksum returns the stored checksum of a message, or an empty string for messages stored without one
+func Checksum(id string) string {
+	meta, _, _ := readMetadata(id)
+	return meta.Checksum
+}
+
+//Headers returns the headers a message was put with, or nil for messages stored without any
+func Headers(id string) map[string]string {
+	meta, _, _ := readMetadata(id)
+	return meta.Headers
+}
+
+//ContentType returns the content type recorded for a message, or an empty string for messages stored without one
+func ContentType(id string) string {
+	meta, _, _ := readMetadata(id)
+	return meta.ContentType
+}
+
+//ACL returns the owner and readers of a locally stored message, which are empty for public and missing messages
+func ACL(id string) (owner string, readers []string, status int) {
+	meta, _, err := readMetadata(id)
+	if err != nil {
+		log.Error(GenericInternalError, "Error reading metadata of Message "+id+": "+err.Error())
+		return "", nil, errorStatus(err, http.StatusInternalServerError)
+	}
+	return meta.Owner, meta.Readers, http.StatusOK
+}
+
+//Open opens a locally stored message for streaming its content. The caller has to close content
+func Open(id string) (content io.ReadCloser, size int64, status int) {
+	log.Info(InProgress, "Opening Message "+id+"...")
+	if cached, ok := cache.get(id); ok {
+		log.Info(OK, "Opened Message "+id+" from cache")
+		return seekableContent{bytes.NewReader([]byte(cached.Content))}, int64(len(cached.Content)), http.StatusOK
+	}
+
+	if _, stored := database.CheckMessageStorage(id); !stored {
+		log.Warn(GenericInputError, "Error opening Message "+id+": Not in database")
+		return nil, 0, http.StatusNotFound
+	}
+
+	lock := messageLock(id)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	info, err := messages.Stat(id)
+	if err != nil {
+		log.Warn(GenericInputError, "Error opening Message "+id+": "+err.Error())
+		return nil, 0, errorStatus(err, http.StatusNotFound)
+	}
+	size = info.Size
+
+	file, err := messages.Open(id)
+	if err != nil {
+		log.Warn(GenericInputError, "Error opening Message "+id+": "+err.Error())
+		return nil, 0, errorStatus(err, http.StatusNotFound)
+	}
+
+	meta, hasMetadata, err := readMetadata(id)
+	if meta.expired() {
+		file.Close()
+		log.Warn(StorageMessageExpired, "Error opening Message "+id+": Expired")
+		return nil, 0, StorageMessageExpired
+	}
+	if err == nil && meta.ContentHash != "" {
+		file.Close()
+		file, err = contents.Open(meta.ContentHash)
+		if err != nil {
+			log.Error(GenericInternalError, "Error opening content of Message "+id+": "+err.Error())
+			return nil, 0, errorStatus(err, http.StatusInternalServerError)
+		}
+	}
+	if err == nil && hasMetadata && meta.Encrypted {
+		//Authenticated decryption requires the whole blob
+		var dat []byte
+		dat, err = ioutil.ReadAll(file)
+		file.Close()
+		if err == nil {
+			dat, err = decode(dat, meta)
+		}
+		size = int64(len(dat))
+		content = seekableContent{bytes.NewReader(dat)}
+		if err != nil {
+			log.Error(StorageEncryptionError, "Error decoding Message "+id+": "+err.Error())
+			return nil, 0, http.StatusInternalServerError
+		}
+	} else if err == nil && hasMetadata {
+		size = meta.Size
+		content, err = decompressStream(file, meta)
+	} else {
+		content = file
+	}
+	if err != nil {
+		file.Close()
+		log.Error(StorageMetadataError, "Error opening Message "+id+": "+err.Error())
+		return nil, 0, errorStatus(err, http.StatusInternalServerError)
+	}
+
+	log.Info(OK, "Opened Message "+id)
+	return content, size, http.StatusOK
+}
+
+//seekableContent is content held in memory, which can be read from any offset
+type seekableContent struct {
+	*bytes.Reader
+}
+
+func (seekableContent) Close() error {
+	return nil
+}
+
+//Skip advances content opened with Open by offset bytes. Content stored uncompressed is seeked, compressed content
+//has to be decompressed up to offset
+func Skip(content io.Reader, offset int64) error {
+	if seeker, ok := content.(io.Seeker); ok {
+		_, err := seeker.Seek(offset, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, content, offset)
+	return err
+}
+
+//Size returns the size of a locally stored message without reading its content.
+//Expired messages are reported with StorageMessageExpired and their size
+func Size(id string) (size int64, status int) {
+	if _, stored := database.CheckMessageStorage(id); !stored {
+		return 0, http.StatusNotFound
+	}
+
+	info, err := messages.Stat(id)
+	if err != nil {
+		log.Warn(GenericInputError, "Error getting size of Message "+id+": "+err.Error())
+		return 0, errorStatus(err, http.StatusNotFound)
+	}
+	if meta, hasMetadata, err := readMetadata(id); err == nil && hasMetadata {
+		if meta.expired() {
+			return meta.Size, StorageMessageExpired
+		}
+		return meta.Size, http.StatusOK
+	}
+	return info.Size, http.StatusOK
+}
+
+//MessageStat describes a locally stored message without its content
+type MessageStat struct {
+	ID          string            `json:"id"`
+	Size        int64             `json:"size"`
+	StoredSize  int64             `json:"storedSize"`
+	Checksum    string            `json:"checksum,omitempty"`
+	Compressed  bool              `json:"compressed"`
+	StoredAt    time.Time         `json:"storedAt"`
+	ExpiresAt   *time.Time        `json:"expiresAt,omitempty"`
+	Owner       string            `json:"owner,omitempty"`
+	Readers     []string          `json:"readers,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Version     int64             `json:"version,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+}
+
+//Stat returns the metadata of a locally stored message without reading its content.
+//For messages stored without metadata, sizes and the stored-at time are taken from the stored blob
+func Stat(id string) (stat MessageStat, status int) {
+	if _, stored := database.CheckMessageStorage(id); !stored {
+		return MessageStat{}, http.StatusNotFound
+	}
+
+	info, err := messages.Stat(id)
+	if err != nil {
+		log.Warn(GenericInputError, "Error getting stat of Message "+id+": "+err.Error())
+		return MessageStat{}, errorStatus(err, http.StatusNotFound)
+	}
+	stat = MessageStat{ID: id, Size: info.Size, StoredSize: info.Size, StoredAt: info.ModTime.UTC()}
+
+	meta, hasMetadata, err := readMetadata(id)
+	if err != nil {
+		log.Error(GenericInternalError, "Error reading metadata of Message "+id+": "+err.Error())
+		return MessageStat{}, errorStatus(err, http.StatusInternalServerError)
+	}
+	if !hasMetadata {
+		return stat, http.StatusOK
+	}
+	if meta.expired() {
+		return MessageStat{}, StorageMessageExpired
 	}
-	log.Info("Got Message " + id)
-	return message.Message{
-		ID:      id,
-		Content: string(dat),
-	}, http.StatusOK
+	stat.Size = meta.Size
+	stat.StoredSize = meta.StoredSize
+	stat.Checksum = meta.Checksum
+	stat.Compressed = meta.Compressed
+	stat.ExpiresAt = meta.ExpiresAt
+	stat.Owner = meta.Owner
+	stat.Readers = meta.Readers
+	stat.Headers = meta.Headers
+	stat.Version = meta.Version
+	stat.ContentType = meta.ContentType
+	if meta.StoredAt != nil {
+		stat.StoredAt = *meta.StoredAt
+	}
+	return stat, http.StatusOK
+}
+
+//List returns up to limit IDs of locally stored messages in sorted order, starting at offset.
+//next is the offset of the following page, or 0 if there are no more messages
+func List(offset int, limit int) (ids []string, next int, status int) {
+	log.Info(InProgress, "Listing Messages (offset "+strconv.Itoa(offset)+", limit "+strconv.Itoa(limit)+")...")
+
+	//Backends list blobs sorted by key, keeping pagination stable
+	blobs, err := messages.List()
+	if err != nil {
+		log.Error(GenericInternalError, "Error listing Messages: "+err.Error())
+		return nil, 0, errorStatus(err, http.StatusInternalServerError)
+	}
+
+	ids = []string{}
+	for index, blob := range blobs {
+		if index < offset {
+			continue
+		}
+		if len(ids) >= limit {
+			next = index
+			break
+		}
+		ids = append(ids, blob.Key)
+	}
+
+	log.Info(OK, "Listed "+strconv.Itoa(len(ids))+" Messages.")
+	return ids, next, http.StatusOK
 }
 
-//Put writes a message to local disk
+//Put writes a message to the storage backend
 func Put(msg message.Message) (status int) {
+	return PutContext(context.Background(), msg)
+}
+
+//PutContext is Put, but returns StorageRequestCanceled without storing the message if ctx is done before it is written
+func PutContext(ctx context.Context, msg message.Message) (status int) {
+	return putMessage(ctx, msg, false)
+}
+
+//putMessage stores msg. If replace is set, a stored message is replaced by msg if msg has a newer version,
+//see PutReplica
+func putMessage(ctx context.Context, msg message.Message, replace bool) (status int) {
 	id := msg.ID
 	content := []byte(msg.Content)
+	ctx, span := tracing.Start(ctx, "storage.Put", tracing.KindInternal)
+	defer endSpan(span, id, &status)
 
-	log.Info("Putting Message " + id)
+	log := log.WithContext(ctx)
+	log.Info(InProgress, "Putting Message "+id)
+	if ctx.Err() != nil {
+		log.Warn(StorageRequestCanceled, "Putting Message "+id+" canceled: "+ctx.Err().Error())
+		return StorageRequestCanceled
+	}
 
-	if database.CheckMessageStorage(id) {
-		log.Error("Error storing Message " + id + ": Already in database")
+	//A stored message is either replaced by msg, or msg is rejected
+	_, replaced := database.CheckMessageStorage(id)
+	if replaced && !replace {
+		log.Error(GenericInputError, "Error storing Message "+id+": Already in database")
 		return http.StatusConflict
 	}
 
-	if !checkStorageSpace(len(content)) {
-		log.Warn("Could not store Message " + id + ": Insufficient Storage.")
+	//The content of a completed chunked put is already charged, and released once the upload is discarded
+	if !checkStorageSpace(len(content) - int(uploadCredit(id))) {
+		log.Warn(GenericInputError, "Could not store Message "+id+": Insufficient Storage.")
 		return http.StatusInsufficientStorage
 	}
+	//A replaced message is kept until msg is written over it, and only released afterwards
+	var replacedMeta Metadata
+	var replacedSize int64
+	if replaced {
+		lock := messageLock(id)
+		lock.Lock()
+		defer lock.Unlock()
+		if replacedMeta, replacedSize, status = checkReplacement(ctx, msg); status != http.StatusOK {
+			return status
+		}
+	}
 
-	if _, err := os.Stat(messagesPath + "/" + id); os.IsNotExist(err) {
-		err = ioutil.WriteFile(messagesPath+"/"+id, content, 0600)
+	_, err := messages.Stat(id)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error(StorageUnavailable, "Error storing Message "+id+": "+err.Error())
+		return errorStatus(err, http.StatusInternalServerError)
+	}
+	if os.IsNotExist(err) || replaced {
+		var stored []byte
+		var meta Metadata
+		deduplicated := false
+		err = nil
+		if settings.Current().DeduplicateContent {
+			//The message blob stays empty, but keeps the message listed like any other
+			meta, deduplicated, err = putContent(content)
+		}
+		if err == nil && !deduplicated {
+			stored, meta, err = encode(content)
+		}
+		//Nothing but shared content is written yet, so a canceled put leaves no trace
+		if err == nil && ctx.Err() != nil {
+			if deduplicated {
+				releaseContent(meta.ContentHash)
+			}
+			log.Warn(StorageRequestCanceled, "Putting Message "+id+" canceled: "+ctx.Err().Error())
+			return StorageRequestCanceled
+		}
+		meta.ExpiresAt = msg.ExpiresAt
+		storedAt := time.Now().UTC()
+		meta.StoredAt = &storedAt
+		//Copies pulled from other Nodes keep the original creation time
+		meta.CreatedAt = msg.CreatedAt
+		if meta.CreatedAt == nil {
+			meta.CreatedAt = &storedAt
+		}
+		meta.Sender = msg.Sender
+		meta.Recipient = msg.Recipient
+		meta.Owner = msg.Owner
+		meta.Readers = msg.Readers
+		meta.Headers = msg.Headers
+		meta.Version = msg.Version
+		meta.ContentType = msg.ContentType
+		//Metadata is written first, as metadata without content is treated as absent message. Both replace the ones
+		//of a replaced message atomically
+		metaWritten := false
+		if err == nil {
+			err = writeMetadata(id, meta)
+			metaWritten = err == nil
+		}
+		//Writing the content commits the put, unless it is abandoned first, see AbandonablePut
+		if err == nil {
+			err = putContext(ctx, messages, id, stored)
+		}
 		if err != nil {
-			log.Error("Error storing Message " + id + ": " + err.Error())
-			return http.StatusInternalServerError
+			if deduplicated {
+				releaseContent(meta.ContentHash)
+			}
+			//The content of the replaced message is still stored, so it is restored with its metadata
+			if replaced && metaWritten {
+				if restoreErr := writeMetadata(id, replacedMeta); restoreErr != nil {
+					log.Error(StorageMetadataError, "Error restoring metadata of Message "+id+": "+restoreErr.Error())
+				}
+			}
+			if errors.Is(err, errPutAbandoned) {
+				//An abandoned put leaves no trace, as if it was canceled before writing
+				if !replaced && metaWritten {
+					deleteMetadata(id)
+				}
+				log.Warn(StorageRequestCanceled, "Putting Message "+id+" abandoned before it was written")
+				return StorageRequestCanceled
+			}
+			log.Error(GenericInternalError, "Error storing Message "+id+": "+err.Error())
+			return errorStatus(err, http.StatusInternalServerError)
+		}
+		atomic.AddInt64(&usedBytes, int64(len(stored))-replacedSize)
+		if replacedMeta.ContentHash != "" {
+			if refErr := releaseContent(replacedMeta.ContentHash); refErr != nil {
+				log.Warn(GenericInternalError, "Error releasing content of replaced Message "+id+": "+refErr.Error())
+			}
 		}
+		observeVersion(msg.Version)
+		cache.invalidate(id)
 
-		log.Info("Successfully stored Message " + id)
+		log.Info(OK, "Successfully stored Message "+id)
+		if replaced {
+			return StorageMessageReplaced
+		}
 		return http.StatusOK
 	}
-	log.Error("Error storing Message " + id + ": File exists")
+	log.Error(GenericInputError, "Error storing Message "+id+": File exists")
 	return http.StatusConflict
 }
 
-//Delete removes a message from local disk
+//Delete removes a message from the storage backend
 func Delete(id string) (status int) {
-	//Delete message from disk
-	log.Fatal("Method DELETE not yet implemented")
+	log.Info(InProgress, "Deleting Message "+id+"...")
+	//Reads started before the message is removed must not cache it
+	defer cache.invalidate(id)
+
+	info, statErr := messages.Stat(id)
+	err := messages.Delete(id)
+	if err == nil && statErr == nil {
+		atomic.AddInt64(&usedBytes, -info.Size)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Error(GenericInternalError, "Error deleting Message "+id+": "+err.Error())
+		return errorStatus(err, http.StatusInternalServerError)
+	}
+
+	if meta, _, metaErr := readMetadata(id); metaErr == nil && meta.ContentHash != "" {
+		if refErr := releaseContent(meta.ContentHash); refErr != nil {
+			log.Warn(GenericInternalError, "Error releasing content of Message "+id+": "+refErr.Error())
+		}
+	}
+	if metaErr := deleteMetadata(id); metaErr != nil {
+		log.Warn(StorageMetadataError, "Error deleting metadata of Message "+id+": "+metaErr.Error())
+	}
+
+	if os.IsNotExist(err) {
+		log.Warn(GenericInputError, "Error deleting Message "+id+": File does not exist")
+		return http.StatusNotFound
+	}
+
+	log.Info(OK, "Successfully deleted Message "+id)
 	return http.StatusOK
 }
 
+//FindExpired returns the IDs of all locally stored messages whose expiry has passed
+func FindExpired() (ids []string, status int) {
+	blobs, err := metadata.List()
+	if err != nil {
+		log.Error(StorageMetadataError, "Error finding expired Messages: "+err.Error())
+		return nil, http.StatusInternalServerError
+	}
+
+	for _, blob := range blobs {
+		if meta, hasMetadata, err := readMetadata(blob.Key); err == nil && hasMetadata && meta.expired() {
+			ids = append(ids, blob.Key)
+		}
+	}
+	return ids, http.StatusOK
+}
+
+//orphanGracePeriod protects messages of puts still in progress from being considered orphaned
+const orphanGracePeriod = time.Hour
+
+//Compact packs the blobs of at most settings.CompactionBlobSize KiB of all backends supporting it into segment files,
+//and reclaims the space of deleted packed blobs. Reads and writes continue while compacting
+func Compact() (result CompactionResult, status int) {
+	log.Info(InProgress, "Compacting Storage...")
+	maxBlobSize := int64(settings.Current().CompactionBlobSize) * 1024
+	for _, backend := range []Backend{messages, metadata, contents, references} {
+		backend, ok := backend.(compactor)
+		if !ok {
+			continue
+		}
+		compacted, err := backend.Compact(maxBlobSize)
+		result.Packed += compacted.Packed
+		result.Rewritten += compacted.Rewritten
+		result.Reclaimed += compacted.Reclaimed
+		if err != nil {
+			log.Error(GenericInternalError, "Error compacting Storage: "+err.Error())
+			return result, http.StatusInternalServerError
+		}
+	}
+	log.Info(OK, "Compacted Storage. Packed "+strconv.Itoa(result.Packed)+" Blobs, rewrote "+strconv.Itoa(result.Rewritten)+" Segments and reclaimed "+strconv.FormatInt(result.Reclaimed, 10)+" Bytes.")
+	return result, http.StatusOK
+}
+
+//FindOrphans returns the IDs of messages whose content or metadata is stored, but which are not in the database
+func FindOrphans() (ids []string, status int) {
+	seen := make(map[string]bool)
+	for _, backend := range []Backend{messages, metadata} {
+		blobs, err := backend.List()
+		if err != nil {
+			log.Error(GenericInternalError, "Error finding orphaned Messages: "+err.Error())
+			return nil, http.StatusInternalServerError
+		}
+
+		for _, blob := range blobs {
+			id := blob.Key
+			if seen[id] || time.Since(blob.ModTime) < orphanGracePeriod {
+				continue
+			}
+			seen[id] = true
+			if _, stored := database.CheckMessageStorage(id); !stored {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, http.StatusOK
+}
+
+//Stats holds statistics about locally stored messages
+type Stats struct {
+	Messages         int     `json:"messages"`
+	Size             int64   `json:"size"`
+	StoredSize       int64   `json:"storedSize"`
+	CompressionRatio float64 `json:"compressionRatio"`
+	//DedupSavedSize is the stored size saved by sharing identical content between messages
+	DedupSavedSize int64 `json:"dedupSavedSize"`
+}
+
+//GetStats calculates statistics about locally stored messages
+func GetStats() (stats Stats, status int) {
+	log.Info(InProgress, "Calculating Storage Stats...")
+	blobs, err := messages.List()
+	if err != nil {
+		log.Error(GenericInternalError, "Error calculating Storage Stats: "+err.Error())
+		return Stats{}, http.StatusInternalServerError
+	}
+
+	for _, blob := range blobs {
+		stats.Messages++
+		stats.StoredSize += blob.Size
+		if meta, hasMetadata, err := readMetadata(blob.Key); err == nil && hasMetadata {
+			stats.Size += meta.Size
+			if meta.ContentHash != "" {
+				stats.StoredSize += meta.StoredSize
+			}
+		} else {
+			stats.Size += blob.Size
+		}
+	}
+
+	//Shared content is counted once per message above
+	saved, err := dedupSavings()
+	if err != nil {
+		log.Error(GenericInternalError, "Error calculating Storage Stats: "+err.Error())
+		return Stats{}, http.StatusInternalServerError
+	}
+	stats.DedupSavedSize = saved
+	stats.StoredSize -= saved
+
+	stats.CompressionRatio = 1
+	if stats.StoredSize > 0 {
+		stats.CompressionRatio = float64(stats.Size) / float64(stats.StoredSize)
+	}
+	log.Info(OK, "Calculated Storage Stats.")
+	return stats, http.StatusOK
+}
+
+//Check returns an error if messages can currently not be stored, because a backend fails or failed an operation
+//within unavailableWindow, or settings.DiskSpace is used up
+func Check() error {
+	for _, backend := range []Backend{messages, metadata, contents, references} {
+		if err := backend.Check(); err != nil {
+			return err
+		}
+	}
+	if err := recentFailure(); err != nil {
+		return err
+	}
+	if !HasSpace(0) {
+		return errors.New("storage is full")
+	}
+	return nil
+}
+
 //Creates Directory if it does not yet exist
 func createDirIfNotExist(dir string) {
 	//TODO: Fix error on windows reporting directories exists when they do not
 	_, err := os.Stat(dir)
 	if os.IsNotExist(err) {
-		log.Warn("Directory " + dir + " does not exist. Creating...")
+		log.Warn(InProgress, "Directory "+dir+" does not exist. Creating...")
 		err = os.MkdirAll(dir, 0755)
 		if err != nil {
-			log.Fatal("Directory " + dir + " could not be created: " + err.Error())
+			log.Fatal(GenericInternalError, "Directory "+dir+" could not be created: "+err.Error())
 		}
 	}
 }
 
-//Check whether Size of Data Directory exceeds size limit set in settings.DiskSpace
+//usedBytes is the running total of bytes used by stored messages, initialized from disk in Init
+var usedBytes int64
+
+//Check whether storing size more bytes would exceed the size limit set in settings.DiskSpace
 func checkStorageSpace(size int) bool {
-	dirsize, _ := dirSize(messagesPath)
-	dirsize += int64(size)
-	return dirsize/1024/1024 < int64(settings.DiskSpace)
+	return HasSpace(int64(size))
+}
+
+//HasSpace returns whether size more bytes can be stored without exceeding settings.DiskSpace
+func HasSpace(size int64) bool {
+	return atomic.LoadInt64(&usedBytes)+size <= settings.Current().MaxStorageBytes()
+}
+
+//Usage returns the bytes used by stored messages and the maximum bytes available
+func Usage() (used int64, total int64) {
+	return atomic.LoadInt64(&usedBytes), settings.Current().MaxStorageBytes()
 }
 
-//Get Size of Directory
-func dirSize(path string) (int64, error) {
-	var size int64
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+//usedSize sums up the size of all stored messages, their shared content and received chunks of uploads
+func usedSize() (int64, error) {
+	size, err := uploadedSize()
+	if err != nil {
+		return 0, err
+	}
+	for _, backend := range []Backend{messages, contents} {
+		blobs, err := backend.List()
 		if err != nil {
-			return err
+			return 0, err
 		}
-		if !info.IsDir() {
-			size += info.Size()
+		for _, blob := range blobs {
+			size += blob.Size
 		}
-		return err
-	})
-	return size, err
+	}
+	return size, nil
 }
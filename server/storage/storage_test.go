@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"os"
+	"subframe/server/database"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"subframe/structs/message"
+	"testing"
+)
+
+//TestMain silences the logs of the tests, which corrupt and reject messages on purpose
+func TestMain(m *testing.M) {
+	logger.Level = logger.LogtypeFatal
+	os.Exit(m.Run())
+}
+
+//setupStorage initializes the storage with backend and the database in a temporary data directory, which are closed
+//and restored after the test
+func setupStorage(t *testing.T, backend string) {
+	previousPath, previousBackend := settings.Current().DataPath, settings.Current().StorageBackend
+	settings.Current().DataPath = t.TempDir()
+	settings.Current().StorageBackend = backend
+	cache = messageCache{order: list.New(), entries: make(map[string]*list.Element)}
+
+	Init()
+	database.Init()
+	t.Cleanup(func() {
+		database.Close()
+		settings.Current().DataPath, settings.Current().StorageBackend = previousPath, previousBackend
+	})
+}
+
+//putLogged stores msg like a StorageNode does, logging new messages to the database
+func putLogged(t *testing.T, msg message.Message, replica bool) int {
+	t.Helper()
+	put := PutContext
+	if replica {
+		put = PutReplica
+	}
+	status := put(context.Background(), msg)
+	if status == http.StatusOK {
+		database.LogMessageStorage(msg.ID, msg.Version)
+	}
+	return status
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	. "subframe/status"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//uploadPath holds messages of chunked puts until they are completed. Unlike tmpPath it is kept across restarts,
+//so uploads can be resumed. Uploads are always stored on disk, independent of settings.StorageBackend
+var uploadPath string
+
+//uploadLock serializes chunks written concurrently to the same upload. refs counts its holders and waiters
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+var uploadLocksMutex sync.Mutex
+var uploadLocks = make(map[string]*uploadLock)
+
+//lockUpload serializes access to the upload of id, and returns the function releasing it
+func lockUpload(id string) (unlock func()) {
+	uploadLocksMutex.Lock()
+	lock, ok := uploadLocks[id]
+	if !ok {
+		lock = &uploadLock{}
+		uploadLocks[id] = lock
+	}
+	lock.refs++
+	uploadLocksMutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		uploadLocksMutex.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(uploadLocks, id)
+		}
+		uploadLocksMutex.Unlock()
+	}
+}
+
+func uploadFile(id string) string {
+	return uploadPath + "/" + id
+}
+
+//UploadLength returns the number of bytes received so far for the chunked put of id
+func UploadLength(id string) (length int64, status int) {
+	unlock := lockUpload(id)
+	defer unlock()
+
+	info, err := os.Stat(uploadFile(id))
+	if os.IsNotExist(err) {
+		return 0, http.StatusNotFound
+	}
+	if err != nil {
+		log.Error(GenericInternalError, "Error reading upload of Message "+id+": "+err.Error())
+		return 0, http.StatusInternalServerError
+	}
+	return info.Size(), http.StatusOK
+}
+
+//AppendUpload appends content to the chunked put of id, which has to have received exactly offset bytes so far.
+//Content received before a dropped connection is kept, so the client can resume from the returned length.
+//Received content is charged to the used storage until the upload is discarded, so uploads cannot exceed
+//settings.DiskSpace. Uploads exceeding maxSize are rejected with 413, the available storage with 507, mismatching
+//offsets with 409
+func AppendUpload(id string, offset int64, content io.Reader, maxSize int64) (length int64, status int) {
+	unlock := lockUpload(id)
+	defer unlock()
+
+	path := uploadFile(id)
+	if info, err := os.Stat(path); err == nil {
+		length = info.Size()
+	} else if !os.IsNotExist(err) {
+		log.Error(GenericInternalError, "Error reading upload of Message "+id+": "+err.Error())
+		return 0, http.StatusInternalServerError
+	}
+	if offset != length {
+		log.Warn(GenericInputError, "Chunk of Message "+id+" starts at "+strconv.FormatInt(offset, 10)+", but "+strconv.FormatInt(length, 10)+" bytes were received.")
+		return length, http.StatusConflict
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Error(GenericInternalError, "Error opening upload of Message "+id+": "+err.Error())
+		return length, http.StatusInternalServerError
+	}
+	defer file.Close()
+
+	//One byte more than allowed is read to detect oversized uploads
+	written, copyErr := io.Copy(file, io.LimitReader(content, maxSize-length+1))
+	atomic.AddInt64(&usedBytes, written)
+	if length+written > maxSize {
+		truncateUpload(file, length, written)
+		log.Warn(GenericInputError, "Upload of Message "+id+" exceeds settings.MessageMaxSize.")
+		return length, http.StatusRequestEntityTooLarge
+	}
+	if !HasSpace(0) {
+		truncateUpload(file, length, written)
+		log.Warn(GenericInputError, "Insufficient storage for chunk of Message "+id+".")
+		return length, http.StatusInsufficientStorage
+	}
+	length += written
+	if err = file.Sync(); err != nil {
+		log.Error(GenericInternalError, "Error syncing upload of Message "+id+": "+err.Error())
+		return length, http.StatusInternalServerError
+	}
+	if copyErr != nil {
+		log.Warn(GenericInputError, "Chunk of Message "+id+" was interrupted after "+strconv.FormatInt(written, 10)+" bytes: "+copyErr.Error())
+		return length, http.StatusBadRequest
+	}
+	return length, http.StatusOK
+}
+
+//truncateUpload discards the written bytes of a rejected chunk appended to file, which had length bytes before
+func truncateUpload(file *os.File, length int64, written int64) {
+	file.Truncate(length)
+	atomic.AddInt64(&usedBytes, -written)
+}
+
+//ReadUpload returns the content of the chunked put of id, if exactly size bytes were received.
+//The upload is kept until DiscardUpload is called, so completing it can be retried if storing fails
+func ReadUpload(id string, size int64) (content []byte, status int) {
+	unlock := lockUpload(id)
+	defer unlock()
+
+	content, err := ioutil.ReadFile(uploadFile(id))
+	if os.IsNotExist(err) {
+		return nil, http.StatusNotFound
+	}
+	if err != nil {
+		log.Error(GenericInternalError, "Error reading upload of Message "+id+": "+err.Error())
+		return nil, http.StatusInternalServerError
+	}
+	if int64(len(content)) != size {
+		log.Warn(GenericInputError, "Upload of Message "+id+" has "+strconv.Itoa(len(content))+" bytes, expected "+strconv.FormatInt(size, 10))
+		return nil, http.StatusConflict
+	}
+	return content, http.StatusOK
+}
+
+//DiscardUpload removes the chunked put of id, releasing the storage charged for it
+func DiscardUpload(id string) {
+	unlock := lockUpload(id)
+	defer unlock()
+	path := uploadFile(id)
+	info, err := os.Stat(path)
+	if err == nil && os.Remove(path) == nil {
+		atomic.AddInt64(&usedBytes, -info.Size())
+	}
+}
+
+//uploadedSize returns the size of the content received for chunked puts, which is charged to the used storage
+func uploadedSize() (size int64, err error) {
+	files, err := ioutil.ReadDir(uploadPath)
+	for _, file := range files {
+		if !file.IsDir() {
+			size += file.Size()
+		}
+	}
+	return size, err
+}
+
+//uploadCredit returns the size of the chunked put of id, whose content is already charged to the used storage when it
+//is completed
+func uploadCredit(id string) int64 {
+	unlock := lockUpload(id)
+	defer unlock()
+	info, err := os.Stat(uploadFile(id))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+//ExpireUploads removes chunked puts which have not received data for maxAge, and returns how many were removed
+func ExpireUploads(maxAge time.Duration) (removed int) {
+	files, err := ioutil.ReadDir(uploadPath)
+	if err != nil {
+		log.Error(GenericInternalError, "Error listing uploads: "+err.Error())
+		return 0
+	}
+
+	for _, file := range files {
+		if file.IsDir() || time.Since(file.ModTime()) < maxAge {
+			continue
+		}
+		log.Info(InProgress, "Removing abandoned upload of Message "+file.Name()+"...")
+		DiscardUpload(file.Name())
+		removed++
+	}
+	return removed
+}
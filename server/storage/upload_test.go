@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"subframe/server/settings"
+	"subframe/structs/message"
+	"sync/atomic"
+	"testing"
+)
+
+//withDiskSpace sets settings.DiskSpace in MB for the duration of the test
+func withDiskSpace(t *testing.T, space int) {
+	previous := settings.Current().DiskSpace
+	settings.Current().DiskSpace = space
+	t.Cleanup(func() { settings.Current().DiskSpace = previous })
+}
+
+//expectUsed fails the test if the used storage is not used bytes
+func expectUsed(t *testing.T, used int64) {
+	t.Helper()
+	if current, _ := Usage(); current != used {
+		t.Errorf("used storage = %d bytes, want %d", current, used)
+	}
+}
+
+func TestUploadsAreChargedToUsedStorage(t *testing.T) {
+	setupStorage(t, "filesystem")
+	withDiskSpace(t, 1)
+	chunk := bytes.Repeat([]byte("a"), 600*1024)
+	maxSize := int64(2 * len(chunk))
+
+	if length, status := AppendUpload("abc", 0, bytes.NewReader(chunk), maxSize); status != http.StatusOK || length != int64(len(chunk)) {
+		t.Fatalf("AppendUpload() = %d, %d, want all bytes received", length, status)
+	}
+	expectUsed(t, int64(len(chunk)))
+
+	//Neither a second chunk nor another message fit next to the upload
+	if length, status := AppendUpload("abc", int64(len(chunk)), bytes.NewReader(chunk), maxSize); status != http.StatusInsufficientStorage || length != int64(len(chunk)) {
+		t.Errorf("AppendUpload() exceeding the disk space = %d, %d, want %d, %d", length, status, len(chunk), http.StatusInsufficientStorage)
+	}
+	if status := Put(message.Message{ID: "other", Content: string(chunk)}); status != http.StatusInsufficientStorage {
+		t.Errorf("Put() next to the upload = %d, want %d", status, http.StatusInsufficientStorage)
+	}
+	expectUsed(t, int64(len(chunk)))
+	if length, _ := UploadLength("abc"); length != int64(len(chunk)) {
+		t.Errorf("upload has %d bytes after the rejected chunk, want %d", length, len(chunk))
+	}
+
+	//Uploads are charged again after a restart
+	Init()
+	expectUsed(t, int64(len(chunk)))
+
+	if removed := ExpireUploads(0); removed != 1 {
+		t.Fatalf("ExpireUploads() = %d, want 1", removed)
+	}
+	expectUsed(t, 0)
+}
+
+func TestOversizedChunkIsNotCharged(t *testing.T) {
+	setupStorage(t, "filesystem")
+
+	if _, status := AppendUpload("abc", 0, bytes.NewReader(make([]byte, 11)), 10); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("AppendUpload() = %d, want %d", status, http.StatusRequestEntityTooLarge)
+	}
+	expectUsed(t, 0)
+}
+
+func TestCompletedUploadIsChargedOnce(t *testing.T) {
+	setupStorage(t, "filesystem")
+	withDiskSpace(t, 1)
+	//The content of the upload alone fits, but not twice
+	content := bytes.Repeat([]byte("a"), 600*1024)
+
+	AppendUpload("abc", 0, bytes.NewReader(content), int64(len(content)))
+	uploaded, status := ReadUpload("abc", int64(len(content)))
+	if status != http.StatusOK {
+		t.Fatalf("ReadUpload() = %d", status)
+	}
+	if status := PutContext(context.Background(), message.Message{ID: "abc", Content: string(uploaded)}); status != http.StatusOK {
+		t.Fatalf("PutContext() of the completed upload = %d, want %d", status, http.StatusOK)
+	}
+	DiscardUpload("abc")
+
+	used, err := usedSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current := atomic.LoadInt64(&usedBytes); current != used {
+		t.Errorf("usedBytes = %d after completing the upload, want the %d bytes stored", current, used)
+	}
+}
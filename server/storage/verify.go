@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"net/http"
+	"os"
+	. "subframe/status"
+)
+
+//quarantine holds the stored content and metadata of messages which failed verification, for operators to inspect.
+//Quarantined messages do not count towards the used storage
+var quarantine Backend
+
+//quarantineMetadataSuffix is appended to the ID of a quarantined message for the key of its metadata
+const quarantineMetadataSuffix = ".meta"
+
+//Verify recomputes the checksum of the locally stored message id. Returns http.StatusOK if it matches,
+//StorageChecksumMismatch if it does not or the content cannot be read or decoded, StorageMetadataError if the
+//metadata is missing or unreadable, and http.StatusNotFound if the message is no longer stored.
+//Expired messages are verified as well, as they are still stored until collected
+func Verify(id string) (status int) {
+	lock := messageLock(id)
+	lock.RLock()
+	defer lock.RUnlock()
+	stored, err := messages.Get(id)
+	if os.IsNotExist(err) {
+		return http.StatusNotFound
+	}
+	if err != nil {
+		log.Error(StorageChecksumMismatch, "Error reading Message "+id+": "+err.Error())
+		return StorageChecksumMismatch
+	}
+
+	meta, hasMetadata, err := readMetadata(id)
+	if err != nil || !hasMetadata {
+		log.Warn(StorageMetadataError, "Metadata of Message "+id+" is missing or unreadable")
+		return StorageMetadataError
+	}
+	if meta.ContentHash != "" {
+		stored, err = contents.Get(meta.ContentHash)
+		if err != nil {
+			log.Error(StorageChecksumMismatch, "Error reading content of Message "+id+": "+err.Error())
+			return StorageChecksumMismatch
+		}
+	}
+	content, err := decode(stored, meta)
+	if err != nil {
+		log.Error(StorageChecksumMismatch, "Error decoding Message "+id+": "+err.Error())
+		return StorageChecksumMismatch
+	}
+	if sum := checksumLike(meta.Checksum, content); sum != meta.Checksum {
+		log.Error(StorageChecksumMismatch, "Checksum of Message "+id+" does not match. Expected "+meta.Checksum+", got "+sum)
+		return StorageChecksumMismatch
+	}
+	return http.StatusOK
+}
+
+//Quarantine moves the stored content and metadata of the message id to the quarantine, and deletes it from storage.
+//The stored content is kept as it is, compressed or encrypted, shared content is copied
+func Quarantine(id string) (status int) {
+	log.Info(InProgress, "Quarantining Message "+id+"...")
+	stored, err := messages.Get(id)
+	if os.IsNotExist(err) {
+		return http.StatusNotFound
+	}
+	if meta, _, metaErr := readMetadata(id); metaErr == nil && meta.ContentHash != "" {
+		if shared, sharedErr := contents.Get(meta.ContentHash); sharedErr == nil {
+			stored, err = shared, nil
+		}
+	}
+	//Unreadable content cannot be kept, but the message is removed all the same
+	if err == nil {
+		err = quarantine.Put(id, stored)
+	}
+	if err != nil {
+		log.Warn(GenericInternalError, "Error copying Message "+id+" to the quarantine: "+err.Error())
+	}
+	if rawMetadata, metaErr := metadata.Get(id); metaErr == nil {
+		if metaErr = quarantine.Put(id+quarantineMetadataSuffix, rawMetadata); metaErr != nil {
+			log.Warn(StorageMetadataError, "Error copying metadata of Message "+id+" to the quarantine: "+metaErr.Error())
+		}
+	}
+
+	status = Delete(id)
+	if status == http.StatusOK {
+		log.Warn(StorageChecksumMismatch, "Quarantined Message "+id)
+	}
+	return status
+}
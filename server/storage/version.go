@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"strconv"
+	"subframe/server/database"
+	"subframe/server/settings"
+	. "subframe/status"
+	"subframe/structs/message"
+	"sync"
+	"sync/atomic"
+)
+
+//lastVersion is the highest version assigned to or seen on a message by this Node
+var lastVersion int64
+
+//versionNodeBits is the number of low bits of a version identifying the Node which assigned it
+const versionNodeBits = 16
+
+//NextVersion returns the version of a message put to this Node, a logical clock higher than all versions assigned or
+//seen before, independent of the wall clock. Its low versionNodeBits bits identify this Node, so concurrent puts on
+//different Nodes get distinct versions, and all Nodes let the same copy win
+func NextVersion() int64 {
+	node := versionNode(settings.Current().RemoteAddress)
+	for {
+		last := atomic.LoadInt64(&lastVersion)
+		next := (last>>versionNodeBits+1)<<versionNodeBits | node
+		if atomic.CompareAndSwapInt64(&lastVersion, last, next) {
+			return next
+		}
+	}
+}
+
+//versionNode returns the low bits of versions assigned by the Node at address
+func versionNode(address string) int64 {
+	hash := fnv.New32a()
+	hash.Write([]byte(address))
+	return int64(hash.Sum32() & (1<<versionNodeBits - 1))
+}
+
+//observeVersion raises lastVersion to the version of a stored copy, so later puts get higher versions
+func observeVersion(version int64) {
+	for {
+		last := atomic.LoadInt64(&lastVersion)
+		if version <= last || atomic.CompareAndSwapInt64(&lastVersion, last, version) {
+			return
+		}
+	}
+}
+
+//newerVersion returns whether a copy of version and checksum sum wins over a copy of otherVersion and otherSum.
+//The higher version wins, equal versions are decided by the higher checksum, so all Nodes keep the same copy
+func newerVersion(version int64, sum string, otherVersion int64, otherSum string) bool {
+	if version != otherVersion {
+		return version > otherVersion
+	}
+	return sum > otherSum
+}
+
+//PutReplica stores a copy of a message pushed or pulled from another Node, like Put. If the message is stored
+//already, the copy replaces it if it wins by newerVersion, and StorageMessageReplaced is returned. Otherwise
+//http.StatusConflict is returned
+func PutReplica(ctx context.Context, msg message.Message) (status int) {
+	return putMessage(ctx, msg, true)
+}
+
+//checkReplacement returns the metadata and stored size of the locally stored message id if msg wins over it by
+//newerVersion, so msg can be written over it. Returns http.StatusConflict if the stored message wins.
+//The caller has to hold the write lock of messageLock(id)
+func checkReplacement(ctx context.Context, msg message.Message) (replaced Metadata, size int64, status int) {
+	log := log.WithContext(ctx)
+	meta, _, err := readMetadata(msg.ID)
+	if err != nil {
+		log.Error(StorageMetadataError, "Error reading metadata of Message "+msg.ID+": "+err.Error())
+		return Metadata{}, 0, errorStatus(err, http.StatusInternalServerError)
+	}
+	if !newerVersion(msg.Version, checksumLike(meta.Checksum, []byte(msg.Content)), meta.Version, meta.Checksum) {
+		log.Info(OK, "Keeping version "+strconv.FormatInt(meta.Version, 10)+" of Message "+msg.ID+" over version "+strconv.FormatInt(msg.Version, 10))
+		return Metadata{}, 0, http.StatusConflict
+	}
+	info, err := messages.Stat(msg.ID)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error(StorageUnavailable, "Error replacing Message "+msg.ID+": "+err.Error())
+		return Metadata{}, 0, errorStatus(err, http.StatusInternalServerError)
+	}
+
+	log.Info(InProgress, "Replacing version "+strconv.FormatInt(meta.Version, 10)+" of Message "+msg.ID+" with version "+strconv.FormatInt(msg.Version, 10)+"...")
+	return meta, info.Size, http.StatusOK
+}
+
+//messageLocks serialize replacing a message, whose metadata and content are written one after another, with reading
+//both. Messages are spread over the locks by ID, see messageLock
+var messageLocks [64]sync.RWMutex
+
+//messageLock returns the lock of the message id
+func messageLock(id string) *sync.RWMutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	return &messageLocks[hash.Sum32()%uint32(len(messageLocks))]
+}
+
+//Version returns the version of a locally stored message, or 0 for messages stored before they were versioned
+func Version(id string) int64 {
+	meta, _, _ := readMetadata(id)
+	return meta.Version
+}
+
+//messageVersions returns the versions of locally stored messages by ID, see database.GetMessageVersions
+func messageVersions() (versions map[string]int64, status int) {
+	status, versions = database.GetMessageVersions()
+	if status != OK {
+		return nil, http.StatusInternalServerError
+	}
+	return versions, http.StatusOK
+}
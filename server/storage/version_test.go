@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"subframe/server/settings"
+	. "subframe/status"
+	"subframe/structs/message"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNextVersionIsLogical(t *testing.T) {
+	previousAddress, previousVersion := settings.Current().RemoteAddress, atomic.LoadInt64(&lastVersion)
+	t.Cleanup(func() {
+		settings.Current().RemoteAddress = previousAddress
+		atomic.StoreInt64(&lastVersion, previousVersion)
+	})
+	settings.Current().RemoteAddress = "node-a:9123"
+	atomic.StoreInt64(&lastVersion, 0)
+
+	first := NextVersion()
+	second := NextVersion()
+	if second <= first {
+		t.Errorf("NextVersion() = %d after %d, want increasing versions", second, first)
+	}
+	if first&(1<<versionNodeBits-1) != versionNode("node-a:9123") {
+		t.Errorf("NextVersion() = %d does not carry the node bits %d", first, versionNode("node-a:9123"))
+	}
+
+	//A copy seen from another Node is followed by a higher version, whatever the clocks say
+	observeVersion(second + 1000<<versionNodeBits)
+	if next := NextVersion(); next <= second+1000<<versionNodeBits {
+		t.Errorf("NextVersion() = %d, want more than the seen version %d", next, second+1000<<versionNodeBits)
+	}
+}
+
+func TestNextVersionTiebreaksNodes(t *testing.T) {
+	previousAddress, previousVersion := settings.Current().RemoteAddress, atomic.LoadInt64(&lastVersion)
+	t.Cleanup(func() {
+		settings.Current().RemoteAddress = previousAddress
+		atomic.StoreInt64(&lastVersion, previousVersion)
+	})
+
+	//Two Nodes which have seen the same versions assign distinct ones to concurrent puts
+	versions := make(map[int64]bool)
+	for _, address := range []string{"node-a:9123", "node-b:9123"} {
+		settings.Current().RemoteAddress = address
+		atomic.StoreInt64(&lastVersion, 42<<versionNodeBits)
+		versions[NextVersion()] = true
+	}
+	if len(versions) != 2 {
+		t.Errorf("concurrent puts on two Nodes got versions %v, want distinct ones", versions)
+	}
+}
+
+//replicaCopies returns count copies of the message id with increasing versions and distinct content
+func replicaCopies(id string, count int) []message.Message {
+	copies := make([]message.Message, count)
+	for index := range copies {
+		copies[index] = message.Message{ID: id, Content: "copy " + strconv.Itoa(index), Version: int64(index+2) << versionNodeBits}
+	}
+	return copies
+}
+
+//pushConcurrently stores copies with PutReplica at once, and returns the content stored afterwards
+func pushConcurrently(t *testing.T, copies []message.Message) message.Message {
+	var wg sync.WaitGroup
+	for _, copy := range copies {
+		wg.Add(1)
+		go func(copy message.Message) {
+			defer wg.Done()
+			if status := PutReplica(context.Background(), copy); status != StorageMessageReplaced && status != http.StatusConflict {
+				t.Errorf("PutReplica() of version %d = %d", copy.Version, status)
+			}
+		}(copy)
+	}
+	wg.Wait()
+
+	msg, status := Get(copies[0].ID)
+	if status != http.StatusOK {
+		t.Fatalf("Get() = %d, want %d", status, http.StatusOK)
+	}
+	return msg
+}
+
+func TestConcurrentPutsConverge(t *testing.T) {
+	copies := replicaCopies("abc", 16)
+	reversed := make([]message.Message, len(copies))
+	for index, copy := range copies {
+		reversed[len(copies)-1-index] = copy
+	}
+
+	//Two Nodes receive the same concurrent puts in different order, and have to keep the same copy
+	var kept []message.Message
+	for _, order := range [][]message.Message{copies, reversed} {
+		setupStorage(t, "memory")
+		if status := putLogged(t, message.Message{ID: "abc", Content: "original", Version: 1 << versionNodeBits}, false); status != http.StatusOK {
+			t.Fatalf("Put() = %d, want %d", status, http.StatusOK)
+		}
+		kept = append(kept, pushConcurrently(t, order))
+	}
+
+	newest := copies[len(copies)-1]
+	for _, msg := range kept {
+		if msg.Version != newest.Version || msg.Content != newest.Content {
+			t.Errorf("kept version %d with %q, want version %d with %q", msg.Version, msg.Content, newest.Version, newest.Content)
+		}
+	}
+}
+
+func TestReplacementKeepsStoredCopyOnConflict(t *testing.T) {
+	setupStorage(t, "filesystem")
+	if status := putLogged(t, message.Message{ID: "abc", Content: "newer", Version: 5 << versionNodeBits}, false); status != http.StatusOK {
+		t.Fatalf("Put() = %d, want %d", status, http.StatusOK)
+	}
+	if status := PutReplica(context.Background(), message.Message{ID: "abc", Content: "older", Version: 4 << versionNodeBits}); status != http.StatusConflict {
+		t.Errorf("PutReplica() of an older copy = %d, want %d", status, http.StatusConflict)
+	}
+	if msg, status := Get("abc"); status != http.StatusOK || msg.Content != "newer" {
+		t.Errorf("Get() = %q, %d, want the stored copy", msg.Content, status)
+	}
+}
+
+func TestReplacementAccountsUsedBytes(t *testing.T) {
+	setupStorage(t, "filesystem")
+	putLogged(t, message.Message{ID: "abc", Content: "short", Version: 1 << versionNodeBits}, false)
+	if status := PutReplica(context.Background(), message.Message{ID: "abc", Content: "a longer copy", Version: 2 << versionNodeBits}); status != StorageMessageReplaced {
+		t.Fatalf("PutReplica() = %d, want %d", status, StorageMessageReplaced)
+	}
+	used, err := usedSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current := atomic.LoadInt64(&usedBytes); current != used {
+		t.Errorf("usedBytes = %d after replacing, want the %d bytes stored", current, used)
+	}
+}
@@ -0,0 +1,185 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"subframe/server/settings"
+	. "subframe/status"
+	"time"
+)
+
+//exportBatchSize is the number of Spans sent to the collector at once
+const exportBatchSize = 512
+
+//exportInterval is the longest time a finished Span waits for export
+const exportInterval = 5 * time.Second
+
+//exportQueueLength bounds the Spans waiting for export. Spans are dropped while it is full, e.g. if the collector is down
+const exportQueueLength = 4096
+
+var spans = make(chan *Span, exportQueueLength)
+
+var exportStop = make(chan bool)
+var exportDone = make(chan bool)
+
+var exportClient = &http.Client{Timeout: 10 * time.Second}
+
+//export queues span for export, dropping it if the queue is full
+func export(span *Span) {
+	select {
+	case spans <- span:
+	default:
+	}
+}
+
+//Init starts exporting finished Spans to settings.TracingEndpoint until Stop is called
+func Init() {
+	if settings.Current().TracingEndpoint == "" {
+		log.Info(OK, "Tracing is disabled.")
+	} else {
+		log.Info(OK, "Exporting traces to "+settings.Current().TracingEndpoint)
+	}
+	go func() {
+		defer close(exportDone)
+		ticker := time.NewTicker(exportInterval)
+		defer ticker.Stop()
+		var batch []*Span
+		for {
+			select {
+			case span := <-spans:
+				batch = append(batch, span)
+				if len(batch) < exportBatchSize {
+					continue
+				}
+			case <-ticker.C:
+			case <-exportStop:
+				//Spans ended during shutdown are exported as well
+				for len(spans) > 0 {
+					batch = append(batch, <-spans)
+				}
+				send(batch)
+				return
+			}
+			send(batch)
+			batch = nil
+		}
+	}()
+}
+
+//Stop exports the remaining Spans and stops exporting
+func Stop() {
+	close(exportStop)
+	<-exportDone
+}
+
+//send posts batch to settings.TracingEndpoint in the OTLP/HTTP JSON encoding
+func send(batch []*Span) {
+	if len(batch) == 0 || settings.Current().TracingEndpoint == "" {
+		return
+	}
+
+	body, err := json.Marshal(encode(batch))
+	if err != nil {
+		log.Error(GenericInternalError, "Error encoding Spans: "+err.Error())
+		return
+	}
+	resp, err := exportClient.Post(settings.Current().TracingEndpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Warn(GenericInternalError, "Error exporting "+strconv.Itoa(len(batch))+" Spans: "+err.Error())
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Warn(GenericInternalError, "Collector rejected "+strconv.Itoa(len(batch))+" Spans: "+resp.Status)
+	}
+}
+
+//The types below mirror the OTLP JSON encoding of traces
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+//otlpStatus codes are 0 for unset and 2 for error
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+//encode converts batch to OTLP, with the local Node as resource
+func encode(batch []*Span) otlpTraces {
+	resource := otlpResource{Attributes: []otlpAttribute{
+		{Key: "service.name", Value: otlpValue{StringValue: "subframe"}},
+		{Key: "service.instance.id", Value: otlpValue{StringValue: settings.Current().RemoteAddress}},
+	}}
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "subframe"}}
+	for _, span := range batch {
+		encoded := otlpSpan{
+			TraceID: hex.EncodeToString(span.context.traceID[:]),
+			SpanID:  hex.EncodeToString(span.context.spanID[:]),
+			Name:    span.name,
+			Kind:    span.kind,
+			Start:   strconv.FormatInt(span.start.UnixNano(), 10),
+			End:     strconv.FormatInt(span.end.UnixNano(), 10),
+		}
+		if span.parentID != [8]byte{} {
+			encoded.ParentSpanID = hex.EncodeToString(span.parentID[:])
+		}
+		keys := make([]string, 0, len(span.attributes))
+		for key := range span.attributes {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			encoded.Attributes = append(encoded.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: span.attributes[key]}})
+		}
+		if span.failed {
+			encoded.Status = otlpStatus{Code: 2, Message: span.message}
+		}
+		scope.Spans = append(scope.Spans, encoded)
+	}
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{{Resource: resource, ScopeSpans: []otlpScopeSpans{scope}}}}
+}
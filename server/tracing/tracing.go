@@ -0,0 +1,197 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"subframe/server/logger"
+	"subframe/server/settings"
+	"sync"
+	"time"
+)
+
+var log = logger.Logger{Prefix: "tracing/Main"}
+
+//Kinds of Spans, as defined by OpenTelemetry
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+)
+
+//traceParentHeader carries the trace context between Nodes in the W3C Trace Context format
+const traceParentHeader = "traceparent"
+
+//spanContext identifies a Span within its trace
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+//traceParent formats c as W3C traceparent header value
+func (c spanContext) traceParent() string {
+	flags := "00"
+	if c.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(c.traceID[:]) + "-" + hex.EncodeToString(c.spanID[:]) + "-" + flags
+}
+
+//parseTraceParent parses a W3C traceparent header value. Unknown versions are parsed as version 00
+func parseTraceParent(value string) (c spanContext, ok bool) {
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' || value[:2] == "ff" {
+		return spanContext{}, false
+	}
+	if _, err := hex.Decode(c.traceID[:], []byte(value[3:35])); err != nil {
+		return spanContext{}, false
+	}
+	if _, err := hex.Decode(c.spanID[:], []byte(value[36:52])); err != nil {
+		return spanContext{}, false
+	}
+	flags, err := strconv.ParseUint(value[53:55], 16, 8)
+	if err != nil || c.traceID == [16]byte{} || c.spanID == [8]byte{} {
+		return spanContext{}, false
+	}
+	c.sampled = flags&1 == 1
+	return c, true
+}
+
+//Span is an operation within a trace. Methods of a nil Span do nothing, so callers need not check whether tracing
+//is enabled. A Span is not safe for concurrent use
+type Span struct {
+	context    spanContext
+	parentID   [8]byte
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	failed     bool
+	message    string
+	ended      sync.Once
+}
+
+type contextKey int
+
+const spanKey contextKey = 0
+
+const remoteParentKey contextKey = 1
+
+//Extract returns a copy of ctx carrying the trace context sent by another Node in header, if it sent a valid one
+func Extract(ctx context.Context, header http.Header) context.Context {
+	parent, ok := parseTraceParent(header.Get(traceParentHeader))
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteParentKey, parent)
+}
+
+//Inject adds the trace context of the Span carried by ctx to header, so the receiving Node continues the trace
+func Inject(ctx context.Context, header http.Header) {
+	if parent, ok := parentContext(ctx); ok {
+		header.Set(traceParentHeader, parent.traceParent())
+	}
+}
+
+//TraceParent returns the trace context carried by ctx as traceparent header value, or an empty string.
+//It is kept by Jobs, which are executed after the context of their request is gone
+func TraceParent(ctx context.Context) string {
+	if parent, ok := parentContext(ctx); ok {
+		return parent.traceParent()
+	}
+	return ""
+}
+
+//WithTraceParent returns a copy of ctx carrying the trace context formatted by TraceParent
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	parent, ok := parseTraceParent(traceParent)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteParentKey, parent)
+}
+
+//parentContext returns the context of the Span carried by ctx, or of the remote parent extracted into ctx
+func parentContext(ctx context.Context) (parent spanContext, ok bool) {
+	if span, isSpan := ctx.Value(spanKey).(*Span); isSpan && span != nil {
+		return span.context, true
+	}
+	parent, ok = ctx.Value(remoteParentKey).(spanContext)
+	return parent, ok
+}
+
+//Start starts a Span named name as child of the Span or trace context carried by ctx, and returns a copy of ctx
+//carrying it. Traces are started for settings.TracingSampleRate percent of root Spans, and continued if the parent was
+//sampled. Returns a nil Span if tracing is disabled or the trace is not sampled
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if settings.Current().TracingEndpoint == "" {
+		return ctx, nil
+	}
+
+	span := &Span{name: name, kind: kind, start: time.Now()}
+	if parent, ok := parentContext(ctx); ok {
+		if !parent.sampled {
+			return ctx, nil
+		}
+		span.context.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		if !sample() {
+			return ctx, nil
+		}
+		rand.Read(span.context.traceID[:])
+	}
+	rand.Read(span.context.spanID[:])
+	span.context.sampled = true
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+//sample decides whether a new trace is recorded, for settings.TracingSampleRate percent of traces
+func sample() bool {
+	if settings.Current().TracingSampleRate >= 100 {
+		return true
+	}
+	var random [1]byte
+	rand.Read(random[:])
+	return int(random[0])*100/256 < settings.Current().TracingSampleRate
+}
+
+//SetName renames the Span, e.g. once the action of a request is known
+func (s *Span) SetName(name string) {
+	if s != nil {
+		s.name = name
+	}
+}
+
+//SetAttribute adds an attribute describing the operation to the Span
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+	if s.attributes == nil {
+		s.attributes = make(map[string]string)
+	}
+	s.attributes[key] = value
+}
+
+//SetError marks the operation of the Span as failed
+func (s *Span) SetError(message string) {
+	if s != nil {
+		s.failed = true
+		s.message = message
+	}
+}
+
+//End finishes the Span and queues it for export. Only the first call has an effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.ended.Do(func() {
+		s.end = time.Now()
+		export(s)
+	})
+}
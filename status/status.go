@@ -43,6 +43,15 @@ const GenericInternalError int = 4000
 const SettingsReadError int = 4100
 const SettingsWriteError int = 4101
 
+const StorageMetadataError int = 4110
+const StorageChecksumMismatch int = 4111
+const StorageEncryptionError int = 4112
+const StorageMessageExpired int = 4113
+const StorageRequestCanceled int = 4114
+const StorageUnavailable int = 4115
+const StorageMessageReplaced int = 4116
+const StorageTimeout int = 4117
+
 const DBPrepareError int = 4200
 const DBWriteError int = 4201
 const DBReadError int = 4202
@@ -50,6 +59,7 @@ const DBIdConflict int = 4203
 const DBOpenError int = 4204
 const DBCloseError int = 4205
 const DBStructureError int = 4206
+const DBPlacementChanged int = 4207
 
 const SNDBPrepareError int = 4300
 const SNDBWriteError int = 4301
@@ -62,12 +72,36 @@ const CNDBReadError int = 4402
 const CNDBIdConflict int = 4410
 
 const NetworkingBadNodeType int = 4501
+const NetworkingTLSConfigError int = 4502
 
 const SNNetworkingOutgoingRequestError int = 4601
 const SNNetworkingReadingResponseError int = 4602
+const SNNetworkingTimeout int = 4603
+const SNNetworkingConnectionRefused int = 4604
+const SNNetworkingBadResponse int = 4605
+const SNNetworkingServerError int = 4606
+const SNWebhookFailed int = 4607
 
 const CNNetworkingOutgoingRequestError int = 4701
 const CNNetworkingReadingResponseError int = 4702
+const CNNetworkingTimeout int = 4703
+const CNNetworkingConnectionRefused int = 4704
+const CNNetworkingBadResponse int = 4705
+const CNNetworkingServerError int = 4706
+const CNNetworkingMessageNotFound int = 4707
+const CNNetworkingUnreachable int = 4708
 
 const JQTooManyWorkers int = 4800
 const JQQueueTooLong int = 4801
+const JQJobFailed int = 4802
+const JQUnknownTask int = 4803
+const JQJournalError int = 4804
+const JQStopped int = 4805
+
+const SNRateLimited int = 3601
+
+const SNAuthMissingToken int = 5601
+const SNAuthInvalidToken int = 5602
+const SNAuthInvalidSignature int = 5603
+const SNAccessDenied int = 5604
+const SNAuthAdminRequired int = 5605
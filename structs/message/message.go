@@ -1,5 +1,24 @@
 package message
 
+import "time"
+
 type Message struct {
 	ID, Content string
+	Checksum    string     `json:",omitempty"`
+	ExpiresAt   *time.Time `json:",omitempty"`
+	//CreatedAt is set by the StorageNode the message was put to. It is nil for messages stored before it was recorded
+	CreatedAt *time.Time `json:",omitempty"`
+	//Sender and Recipient are optional, as declared by the client putting the message
+	Sender    string `json:",omitempty"`
+	Recipient string `json:",omitempty"`
+	//Owner and Readers restrict reading the message to these identities. Messages without Owner are public
+	Owner   string   `json:",omitempty"`
+	Readers []string `json:",omitempty"`
+	//Headers are key/value metadata attached by the client putting the message
+	Headers map[string]string `json:",omitempty"`
+	//ContentType is the media type of Content, as declared by the client putting the message or sniffed from it
+	ContentType string `json:",omitempty"`
+	//Version orders the copies of a message put to several Nodes, the highest one is kept. It is 0 for messages
+	//stored before they were versioned
+	Version int64 `json:",omitempty"`
 }
@@ -0,0 +1,50 @@
+package node
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+//NormalizeAddress validates a Node address of the form host:port, optionally prefixed with a scheme like https://,
+//and returns it in canonical form, so equal addresses compare equal: hostnames are lowercased, IP addresses are
+//shortened and IPv6 addresses are enclosed in brackets. IPv6 addresses have to be bracketed, like [::1]:8080,
+//as the port could not be told apart otherwise
+func NormalizeAddress(address string) (normalized string, err error) {
+	scheme := ""
+	if index := strings.Index(address, "://"); index >= 0 {
+		scheme = strings.ToLower(address[:index])
+		if scheme != "http" && scheme != "https" {
+			return "", errors.New("unsupported scheme " + scheme)
+		}
+		scheme += "://"
+		address = strings.TrimSuffix(address[index+3:], "/")
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return "", errors.New("missing host in address " + address)
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		return "", errors.New("invalid port in address " + address)
+	}
+
+	//Zones of link-local IPv6 addresses, like %eth0, are kept as they are
+	ip, zone := host, ""
+	if index := strings.Index(host, "%"); index >= 0 {
+		ip, zone = host[:index], host[index:]
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		host = parsed.String() + zone
+	} else if strings.ContainsAny(host, "/?#@[]%: ") {
+		return "", errors.New("invalid host in address " + address)
+	} else {
+		host = strings.ToLower(host)
+	}
+	return scheme + net.JoinHostPort(host, strconv.Itoa(number)), nil
+}
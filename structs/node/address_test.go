@@ -0,0 +1,41 @@
+package node
+
+import "testing"
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+		wantErr bool
+	}{
+		{"127.0.0.1:8080", "127.0.0.1:8080", false},
+		{"http://127.0.0.1:8080/", "http://127.0.0.1:8080", false},
+		{"HTTPS://10.0.0.1:443", "https://10.0.0.1:443", false},
+		{"[::1]:8080", "[::1]:8080", false},
+		{"[2001:DB8:0:0::1]:8080", "[2001:db8::1]:8080", false},
+		{"[fe80::1%eth0]:8080", "[fe80::1%eth0]:8080", false},
+		{"[::ffff:10.0.0.1]:8080", "10.0.0.1:8080", false},
+		{"::1:8080", "", true},
+		{"2001:db8::1", "", true},
+		{"Node.Example.COM:9123", "node.example.com:9123", false},
+		{"node.example.com:09123", "node.example.com:9123", false},
+		{"node.example.com", "", true},
+		{"node.example.com:0", "", true},
+		{"node.example.com:65536", "", true},
+		{"node.example.com:http", "", true},
+		{":8080", "", true},
+		{"user@node.example.com:8080", "", true},
+		{"ftp://node.example.com:21", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			normalized, err := NormalizeAddress(test.address)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("NormalizeAddress() error = %v, want error %v", err, test.wantErr)
+			}
+			if normalized != test.want {
+				t.Errorf("NormalizeAddress() = %q, want %q", normalized, test.want)
+			}
+		})
+	}
+}
@@ -2,8 +2,16 @@ package node
 
 import "time"
 
+//Liveness values of a Node, as last determined by a health check
+const (
+	LivenessUnknown = 0
+	LivenessAlive   = 1
+	LivenessDead    = 2
+)
+
 type Node struct {
 	Address  string    `json:"address"`
 	LastPing time.Time `json:"lastPing"`
 	Ping     int       `json:"ping"`
+	Liveness int       `json:"liveness"`
 }